@@ -2,11 +2,14 @@ package main
 
 import (
 	"RendIm/rendim"
-	"encoding/json"
+	"cmp"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,12 +20,47 @@ var (
 	height = 800
 )
 
+// upgrader accepts viewers offering rendim.TileProtocol as well as old ones
+// offering no subprotocol, which are sent JSON pixels.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    []string{rendim.TileProtocol},
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve()
+			return
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		case "worker":
+			os.Exit(runWorker(os.Args[2:]))
+		case "help", "-h", "-help", "--help":
+			usage()
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+			usage()
+			os.Exit(2)
+		}
+	}
+
+	serve()
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  rendim [serve]          start the websocket viewer on :3000
+  rendim render [flags]   render a scene to an image file
+  rendim worker [flags]   render buckets for "rendim render -remote"
+
+Run "rendim render -h" or "rendim worker -h" for their flags.`)
+}
+
+func serve() {
 	indexFile, err := os.Open("html/index.html")
 	if err != nil {
 		fmt.Println(err)
@@ -31,6 +69,12 @@ func main() {
 	if err != nil {
 		fmt.Println(err)
 	}
+
+	jobs := rendim.NewJobQueue(runningJobs, waitingJobs)
+	jobServer := rendim.NewJobServer(jobs, "scenes")
+	http.Handle("/jobs", jobServer)
+	http.Handle("/jobs/", jobServer)
+
 	http.HandleFunc("/websocket", func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -38,84 +82,33 @@ func main() {
 			return
 		}
 
-		sceneType := r.URL.Query().Get("scene")
-		if sceneType == "" {
-			sceneType = "final"
-		}
-
-		samples := 10000
-		if s := r.URL.Query().Get("samples"); s != "" {
-			_, _ = fmt.Sscanf(s, "%d", &samples)
-		}
-
-		bucketSize := 32
-		if bs := r.URL.Query().Get("bucketSize"); bs != "" {
-			_, _ = fmt.Sscanf(bs, "%d", &bucketSize)
-		}
-
-		workers := 4
-		if w := r.URL.Query().Get("workers"); w != "" {
-			_, _ = fmt.Sscanf(w, "%d", &workers)
-		}
-
-		fmt.Printf("Client initiated a render (scene: %s, samples: %d, bucketSize: %d, workers: %d)...\n",
-			sceneType, samples, bucketSize, workers)
-
-		pixels := make(chan rendim.Pixel)
-
-		go func() {
-			rendim.RenderScene(width, height, sceneType, samples, bucketSize, workers, pixels)
-			close(pixels)
-		}()
-
-		batch := make([]rendim.Pixel, 0, 1000)
-		ticker := time.NewTicker(50 * time.Millisecond)
-		defer ticker.Stop()
-
-		sendBatch := func() error {
-			if len(batch) == 0 {
-				return nil
+		// A viewer either attaches to a job by ID, which goes on when the
+		// viewer leaves, or starts a job of its own, which stops then.
+		var job *rendim.Job
+		if id := r.URL.Query().Get("job"); id != "" {
+			if job = jobs.Get(id); job == nil {
+				closeWebsocket(conn, websocket.ClosePolicyViolation, fmt.Sprintf("no job %s", id))
+				return
 			}
-			data, err := json.Marshal(batch)
-			if err != nil {
-				return err
+			fmt.Printf("Client attached to job %s.\n", id)
+		} else if job, err = submitViewerJob(jobs, r.URL.Query()); err != nil {
+			code := websocket.CloseInternalServerErr
+			if errors.Is(err, rendim.ErrJobQueueFull) {
+				code = websocket.CloseTryAgainLater
 			}
-			err = conn.WriteMessage(websocket.TextMessage, data)
-			if err != nil {
-				return err
-			}
-			batch = make([]rendim.Pixel, 0, 1000)
-			return nil
+			closeWebsocket(conn, code, err.Error())
+			return
 		}
-
-		for {
-			select {
-			case p, ok := <-pixels:
-				if !ok {
-					if err := sendBatch(); err != nil {
-						fmt.Println(err)
-					}
-					fmt.Println("Render complete, closing connection.")
-					time.Sleep(100 * time.Millisecond)
-					if err := conn.Close(); err != nil {
-						fmt.Println(err)
-					}
-					return
-				}
-				batch = append(batch, p)
-				if len(batch) >= 1000 {
-					if err := sendBatch(); err != nil {
-						fmt.Println(err)
-						return
-					}
-				}
-			case <-ticker.C:
-				if err := sendBatch(); err != nil {
-					fmt.Println(err)
-					return
-				}
+		send := sendPixels
+		if conn.Subprotocol() == rendim.TileProtocol {
+			encoding, err := rendim.ParseTileEncoding(cmp.Or(r.URL.Query().Get("tiles"), "raw"))
+			if err != nil {
+				closeWebsocket(conn, websocket.ClosePolicyViolation, err.Error())
+				return
 			}
+			send = sendTiles(encoding)
 		}
+		streamJob(conn, job, r.URL.Query().Get("job") == "", send)
 	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if _, err := fmt.Fprintf(w, "%s", string(index)); err != nil {
@@ -135,3 +128,74 @@ func main() {
 		fmt.Printf("Server failed: %v\n", err)
 	}
 }
+
+// checkpointDir holds the checkpoints of renders started from the viewer.
+const checkpointDir = "checkpoints"
+
+var checkpointNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// checkpointPath returns the file of the viewer checkpoint with the given
+// name, creating checkpointDir if needed.
+func checkpointPath(name string) (string, error) {
+	if !checkpointNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid checkpoint name %q", name)
+	}
+	if err := os.MkdirAll(checkpointDir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(checkpointDir, name+".ckpt"), nil
+}
+
+// loadNamedCheckpoint reads the viewer checkpoint with the given name. Its
+// scene must be a built-in one.
+func loadNamedCheckpoint(name string) (*rendim.Checkpoint, error) {
+	path, err := checkpointPath(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := rendim.LoadCheckpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Dir(c.Scene) != "scenes" {
+		return nil, fmt.Errorf("checkpoint %s is not of a built-in scene", name)
+	}
+	return c, nil
+}
+
+// resumeSettings returns the settings saved in checkpoint c with those of
+// requested that do not change the image applied on top.
+func resumeSettings(c *rendim.Checkpoint, requested rendim.RenderSettings) rendim.RenderSettings {
+	return c.Settings.Merge(requested.Only(rendim.SettingBucketSize, rendim.SettingWorkers, rendim.SettingProgressive,
+		rendim.SettingToneMap, rendim.SettingExposure, rendim.SettingWhitePoint))
+}
+
+// loadScene builds a scene from a scene file. Settings from the scene file
+// override the defaults and requested settings that are non-zero or marked
+// with WithSet override both.
+func loadScene(path string, requested rendim.RenderSettings) (rendim.Scene, rendim.RenderSettings, error) {
+	sf, settings, err := loadSceneFile(path, requested)
+	if err != nil {
+		return rendim.Scene{}, rendim.RenderSettings{}, err
+	}
+	scene, err := sf.Build(settings)
+	if err != nil {
+		return rendim.Scene{}, rendim.RenderSettings{}, err
+	}
+	return scene, settings, nil
+}
+
+// loadSceneFile parses a scene file and merges its settings like loadScene,
+// without building the scene.
+func loadSceneFile(path string, requested rendim.RenderSettings) (*rendim.SceneFile, rendim.RenderSettings, error) {
+	sf, err := rendim.LoadSceneFile(path)
+	if err != nil {
+		return nil, rendim.RenderSettings{}, err
+	}
+
+	settings := rendim.DefaultRenderSettings.Merge(sf.Settings).Merge(requested)
+	if err := settings.Validate(); err != nil {
+		return nil, rendim.RenderSettings{}, err
+	}
+	return sf, settings, nil
+}
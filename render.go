@@ -0,0 +1,301 @@
+package main
+
+import (
+	"RendIm/rendim"
+	"context"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// settingFlags maps the flags of the render command that set RenderSettings
+// fields to the fields.
+var settingFlags = map[string]rendim.Setting{
+	"width":              rendim.SettingWidth,
+	"height":             rendim.SettingHeight,
+	"samples":            rendim.SettingSamples,
+	"min-samples":        rendim.SettingMinSamples,
+	"adaptive-threshold": rendim.SettingAdaptiveThreshold,
+	"bucket-size":        rendim.SettingBucketSize,
+	"workers":            rendim.SettingWorkers,
+	"seed":               rendim.SettingSeed,
+	"bvh":                rendim.SettingBVH,
+	"integrator":         rendim.SettingIntegrator,
+	"sampler":            rendim.SettingSampler,
+	"tonemap":            rendim.SettingToneMap,
+	"exposure":           rendim.SettingExposure,
+	"white-point":        rendim.SettingWhitePoint,
+	"progressive":        rendim.SettingProgressive,
+}
+
+// runRender implements the render command and returns the process exit code.
+func runRender(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	sceneArg := fs.String("scene", "final", "built-in scene name or path to a scene `file`")
+	output := fs.String("o", "out.png", "output `file`; the extension selects PNG, PFM, Radiance HDR (.hdr) or OpenEXR (.exr)")
+	exrCompression := fs.String("exr-compression", "zip", "OpenEXR compression, zip or none")
+	sampleMapOutput := fs.String("spp-map", "", "also write a PNG heatmap of the samples taken per pixel to `file`")
+	var requested rendim.RenderSettings
+	fs.IntVar(&requested.Width, "width", 0, "image width in pixels (default from scene file)")
+	fs.IntVar(&requested.Height, "height", 0, "image height in pixels (default from scene file)")
+	fs.IntVar(&requested.Samples, "samples", 0, "samples per pixel, the most a pixel takes with adaptive sampling (default from scene file)")
+	fs.IntVar(&requested.MinSamples, "min-samples", 0, "samples every pixel takes before adaptive sampling may stop it (default from scene file)")
+	fs.Float64Var(&requested.AdaptiveThreshold, "adaptive-threshold", 0, "stop sampling a pixel once its relative error is below this, 0 to take every sample (default from scene file)")
+	fs.IntVar(&requested.BucketSize, "bucket-size", 0, "bucket edge length in pixels (default from scene file)")
+	fs.IntVar(&requested.Workers, "workers", 0, "number of render workers (default from scene file)")
+	fs.Int64Var(&requested.Seed, "seed", 0, "random seed")
+	fs.StringVar(&requested.BVH, "bvh", "", "BVH builder, median or sah (default from scene file)")
+	fs.StringVar(&requested.Integrator, "integrator", "", "light transport, mis or path (default from scene file)")
+	fs.StringVar(&requested.Sampler, "sampler", "", "sample distribution, sobol, halton, stratified or independent (default from scene file)")
+	fs.StringVar(&requested.ToneMap, "tonemap", "", "tone mapping for PNG output, linear, reinhard, reinhardExtended, aces or hable (default from scene file)")
+	fs.Float64Var(&requested.Exposure, "exposure", 0, "exposure adjustment in stops for PNG output (default from scene file)")
+	fs.Float64Var(&requested.WhitePoint, "white-point", 0, "luminance mapped to white by reinhardExtended (default from scene file)")
+	fs.BoolVar(&requested.Progressive, "progressive", false, "render the whole image in passes of doubling samples, rewriting the output after each")
+	checkpointPath := fs.String("checkpoint", "", "save the state of the render to `file` periodically and when it stops, for -resume")
+	checkpointInterval := fs.Duration("checkpoint-interval", 5*time.Minute, "time between checkpoints")
+	resumePath := fs.String("resume", "", "carry on from the checkpoint `file`, with the scene and image settings saved in it")
+	remote := fs.String("remote", "", "render on the comma-separated `addresses` (host:port) of \"rendim worker\" processes instead of locally")
+	timeout := fs.Duration("timeout", 0, "stop the render after this long and write what was rendered (0 for no limit)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// Flags given on the command line apply even when they are zero.
+	fs.Visit(func(f *flag.Flag) {
+		if setting, ok := settingFlags[f.Name]; ok {
+			requested = requested.WithSet(setting)
+		}
+	})
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+	encode, err := imageEncoder(*output, *exrCompression)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *remote != "" && (*checkpointPath != "" || *resumePath != "" || requested.Progressive) {
+		fmt.Fprintln(os.Stderr, "-remote does not support -checkpoint, -resume or -progressive")
+		return 2
+	}
+
+	path := *sceneArg
+	var resume *rendim.Checkpoint
+	if *resumePath != "" {
+		if resume, err = rendim.LoadCheckpoint(*resumePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		path = resume.Scene
+		requested = resumeSettings(resume, requested)
+		if *checkpointPath == "" {
+			*checkpointPath = *resumePath
+		}
+	} else if !strings.HasSuffix(path, ".json") {
+		if path, err = rendim.BuiltinScenePath(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	var settings rendim.RenderSettings
+	var render func(context.Context, rendim.RenderOptions) (*rendim.Framebuffer, rendim.Stats, error)
+	if *remote != "" {
+		// The workers build the scene from the bundle of the scene file and
+		// the files it refers to.
+		var sf *rendim.SceneFile
+		sf, settings, err = loadSceneFile(path, requested)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		settings.Progressive = false
+		bundle, err := sf.Bundle()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		addrs := strings.Split(*remote, ",")
+		fmt.Printf("Distributing buckets to %d workers: %s\n", len(addrs), strings.Join(addrs, ", "))
+		render = func(ctx context.Context, opts rendim.RenderOptions) (*rendim.Framebuffer, rendim.Stats, error) {
+			return rendim.RenderRemote(ctx, bundle, settings, addrs, opts)
+		}
+	} else {
+		var scene rendim.Scene
+		scene, settings, err = loadScene(path, requested)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		render = func(ctx context.Context, opts rendim.RenderOptions) (*rendim.Framebuffer, rendim.Stats, error) {
+			return rendim.RenderScene(ctx, scene, settings, opts)
+		}
+	}
+
+	fmt.Printf("Rendering %s (%dx%d, samples: %d, minSamples: %d, adaptiveThreshold: %g, bucketSize: %d, workers: %d, seed: %d, bvh: %s, integrator: %s, sampler: %s, toneMap: %s, exposure: %g)...\n",
+		path, settings.Width, settings.Height, settings.Samples, settings.MinSamples, settings.AdaptiveThreshold, settings.BucketSize, settings.Workers, settings.Seed, settings.BVH, settings.Integrator, settings.Sampler,
+		settings.ToneMap, settings.Exposure)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	opts := rendim.RenderOptions{Progress: showProgress, Resume: resume}
+	if *checkpointPath != "" {
+		opts.CheckpointInterval = *checkpointInterval
+		opts.Checkpoint = func(c *rendim.Checkpoint) {
+			c.Scene = path
+			if err := rendim.SaveCheckpoint(*checkpointPath, c); err != nil {
+				fmt.Fprintf(os.Stderr, "\n%v\n", err)
+			}
+		}
+	}
+	if *sampleMapOutput != "" {
+		opts.SampleMap = rendim.NewSampleMap(settings.Width, settings.Height)
+	}
+	if settings.Progressive {
+		opts.Pass = func(samples int, fb *rendim.Framebuffer) {
+			if err := writeImage(*output, encode, fb, settings.ToneMapping()); err != nil {
+				fmt.Fprintf(os.Stderr, "\n%v\n", err)
+			}
+		}
+	}
+	fb, stats, renderErr := render(ctx, opts)
+	fmt.Println()
+	if fb == nil {
+		fmt.Fprintln(os.Stderr, renderErr)
+		return 1
+	}
+	printStats(stats)
+	if renderErr != nil {
+		fmt.Fprintln(os.Stderr, "render stopped:", renderErr)
+	}
+
+	if err := writeImage(*output, encode, fb, settings.ToneMapping()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if opts.SampleMap != nil {
+		if err := writeSampleMap(*sampleMapOutput, opts.SampleMap, settings.Samples); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("Samples per pixel written to", *sampleMapOutput)
+	}
+
+	if renderErr != nil {
+		if *checkpointPath != "" {
+			fmt.Printf("Checkpoint written to %s, continue with -resume %s\n", *checkpointPath, *checkpointPath)
+		}
+		fmt.Println("Partial image written to", *output)
+		return 1
+	}
+	fmt.Println("Image written to", *output)
+	return 0
+}
+
+// writeImage writes fb to the file at path with encode.
+func writeImage(path string, encode imageWriter, fb *rendim.Framebuffer, tm rendim.ToneMap) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(f, fb, tm); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSampleMap writes the heatmap of m, scaled to maxSamples, as a PNG file.
+func writeSampleMap(path string, m *rendim.SampleMap, maxSamples int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m.Heatmap(maxSamples)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// showProgress redraws a progress bar on the current terminal line.
+func showProgress(s rendim.Stats) {
+	percent := int(100.0 * s.Progress())
+	bar := strings.Repeat("=", percent/2) + ">"
+	if s.TotalPasses > 0 {
+		fmt.Printf("\r[%-51s] %3d %% pass %d/%d %v", bar, percent, s.Passes, s.TotalPasses, s.Elapsed.Truncate(time.Second))
+		return
+	}
+	fmt.Printf("\r[%-51s] %3d %% %v", bar, percent, s.Elapsed.Truncate(time.Second))
+}
+
+// printStats prints a summary of a finished or stopped render.
+func printStats(s rendim.Stats) {
+	fmt.Printf("Rendered %d/%d pixels in %v\n", s.Pixels, s.TotalPixels, s.Elapsed.Truncate(time.Millisecond))
+
+	seconds := s.Elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	fmt.Printf("  samples: %d, rays: %d (%.0f rays/s)\n", s.Samples, s.Rays, float64(s.Rays)/seconds)
+	if s.Pixels > 0 {
+		fmt.Printf("  mean samples per pixel: %.1f\n", float64(s.Samples)/float64(s.Pixels))
+	}
+	fmt.Printf("  BVH node visits: %d, primitive tests: %d\n", s.NodeVisits, s.PrimitiveTests)
+
+	if len(s.Buckets) > 0 {
+		var total, slowest time.Duration
+		for _, b := range s.Buckets {
+			total += b.Duration
+			slowest = max(slowest, b.Duration)
+		}
+		fmt.Printf("  buckets: %d, mean %v, slowest %v\n", len(s.Buckets),
+			(total / time.Duration(len(s.Buckets))).Truncate(time.Microsecond), slowest.Truncate(time.Microsecond))
+	}
+}
+
+// imageWriter writes a framebuffer to w. The tone map only applies to formats
+// that store display colours; the HDR formats keep the linear radiance.
+type imageWriter func(w io.Writer, fb *rendim.Framebuffer, tm rendim.ToneMap) error
+
+// imageEncoder returns the function that writes a framebuffer in the format
+// given by the extension of path.
+func imageEncoder(path, exrCompression string) (imageWriter, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png":
+		return func(w io.Writer, fb *rendim.Framebuffer, tm rendim.ToneMap) error { return png.Encode(w, fb.Image(tm)) }, nil
+	case ".pfm":
+		return func(w io.Writer, fb *rendim.Framebuffer, _ rendim.ToneMap) error { return rendim.WritePFM(w, fb) }, nil
+	case ".hdr":
+		return func(w io.Writer, fb *rendim.Framebuffer, _ rendim.ToneMap) error { return rendim.WriteHDR(w, fb) }, nil
+	case ".exr":
+		var compression rendim.EXRCompression
+		switch exrCompression {
+		case "zip":
+			compression = rendim.EXRZIP
+		case "none":
+			compression = rendim.EXRNone
+		default:
+			return nil, fmt.Errorf("unknown EXR compression %q (want zip or none)", exrCompression)
+		}
+		return func(w io.Writer, fb *rendim.Framebuffer, _ rendim.ToneMap) error {
+			return rendim.WriteEXR(w, fb, compression)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format %q (want .png, .pfm, .hdr or .exr)", ext)
+	}
+}
@@ -5,6 +5,7 @@ type Box struct {
 	faces      HitableList
 }
 
+// NewBox creates an axis-aligned box with opposite corners p0 and p1.
 func NewBox(p0, p1 Vec3d, mat Material) Box {
 	box := Box{}
 	box.pMin = p0
@@ -20,8 +21,8 @@ func NewBox(p0, p1 Vec3d, mat Material) Box {
 	return box
 }
 
-func (b Box) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
-	return b.faces.Hit(r, tMin, tMax)
+func (b Box) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	return b.faces.Hit(r, tMin, tMax, tc)
 }
 
 func (b Box) BoundingBox(t0, t1 float64, box *AABB) bool {
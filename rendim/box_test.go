@@ -59,7 +59,7 @@ func TestBoxHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, _ := box.Hit(tt.ray, 0.001, 10.0)
+			hit, _ := box.Hit(tt.ray, 0.001, 10.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
@@ -10,6 +10,8 @@ type BVHNode struct {
 	rng         *RNG
 }
 
+// NewBVHNode builds a bounding volume hierarchy over l, which it reorders.
+// Every object must have a bounding box for the interval [time0, time1].
 func NewBVHNode(l HitableList, time0, time1 float64, rng *RNG) Hitable {
 	bvh := BVHNode{rng: rng}
 
@@ -81,10 +83,11 @@ func (n BVHNode) BoundingBox(t0, t1 float64, box *AABB) bool {
 	return true
 }
 
-func (n BVHNode) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (n BVHNode) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countNodeVisits(1)
 	if n.box.hit(r, tMin, tMax) {
-		hitLeft, leftRec := (*n.left).Hit(r, tMin, tMax)
-		hitRight, rightRec := (*n.right).Hit(r, tMin, tMax)
+		hitLeft, leftRec := (*n.left).Hit(r, tMin, tMax, tc)
+		hitRight, rightRec := (*n.right).Hit(r, tMin, tMax, tc)
 
 		rec := HitRecord{}
 		if hitLeft && hitRight { //nolint:gocritic // ifElseChain: boolean conditions better as if-else than switch
@@ -94,7 +94,7 @@ func TestBVHNodeHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, _ := bvh.Hit(tt.ray, 0.0, 100.0)
+			hit, _ := bvh.Hit(tt.ray, 0.0, 100.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
@@ -111,7 +111,7 @@ func TestBVHNodeHitClosest(t *testing.T) {
 	bvh := NewBVHNode(list, 0.0, 1.0, NewRNG(0))
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, rec := bvh.Hit(ray, 0.0, 100.0)
+	hit, rec := bvh.Hit(ray, 0.0, 100.0, nil)
 	
 	if !hit {
 		t.Error("Should hit one of the spheres")
@@ -155,7 +155,7 @@ func TestBVHNodeHitBothChildren(t *testing.T) {
 	bvh := NewBVHNode(list, 0.0, 1.0, NewRNG(0))
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, _ := bvh.Hit(ray, 0.0, 100.0)
+	hit, _ := bvh.Hit(ray, 0.0, 100.0, nil)
 	
 	if !hit {
 		t.Error("Should hit when ray passes through overlapping spheres")
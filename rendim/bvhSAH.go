@@ -0,0 +1,162 @@
+package rendim
+
+import "math"
+
+// Names of the BVH builders that can be selected in RenderSettings.
+const (
+	BVHMedian = "median"
+	BVHSAH    = "sah"
+)
+
+const (
+	sahBins          = 16
+	sahMaxLeafSize   = 4
+	sahTraversalCost = 0.125 // relative to one primitive test
+)
+
+type sahPrimitive struct {
+	hitable  Hitable
+	box      AABB
+	centroid Vec3d
+}
+
+type sahBin struct {
+	count int
+	box   AABB
+}
+
+// NewSAHBVH builds a bounding volume hierarchy over l using the surface area
+// heuristic. Each node is split along the largest extent of its centroids at
+// the cheapest of a fixed number of bins, and nodes with only a few objects
+// become leaves when splitting them does not pay off. Every object must have a
+// bounding box for the interval [time0, time1].
+func NewSAHBVH(l HitableList, time0, time1 float64) Hitable {
+	prims := make([]sahPrimitive, len(l))
+	for i, h := range l {
+		box := AABB{}
+		if !h.BoundingBox(time0, time1, &box) {
+			panic("No bounding box in NewSAHBVH.\n")
+		}
+		prims[i] = sahPrimitive{hitable: h, box: box, centroid: box.Min.Add(box.Max).MultiplyScalar(0.5)}
+	}
+	return buildSAHNode(prims)
+}
+
+func buildSAHNode(prims []sahPrimitive) Hitable {
+	if len(prims) == 1 {
+		return prims[0].hitable
+	}
+
+	box := prims[0].box
+	centroidMin, centroidMax := prims[0].centroid, prims[0].centroid
+	for _, p := range prims[1:] {
+		box = surroundingBox(box, p.box)
+		centroidMin, centroidMax = minVec(centroidMin, p.centroid), maxVec(centroidMax, p.centroid)
+	}
+
+	axis := longestAxis(centroidMax.Subtract(centroidMin))
+	lo, hi := centroidMin.e[axis], centroidMax.e[axis]
+
+	mid := len(prims) / 2
+	if hi > lo {
+		split, cost := sahSplit(prims, axis, lo, hi, box)
+		if len(prims) <= sahMaxLeafSize && float64(len(prims)) <= cost {
+			return sahLeaf(prims)
+		}
+		mid = partitionSAH(prims, func(p sahPrimitive) bool { return sahBinIndex(p.centroid.e[axis], lo, hi) <= split })
+	} else if len(prims) <= sahMaxLeafSize {
+		// Every centroid is in the same place, so no split can separate them.
+		return sahLeaf(prims)
+	}
+	if mid == 0 || mid == len(prims) {
+		mid = len(prims) / 2
+	}
+
+	left := buildSAHNode(prims[:mid])
+	right := buildSAHNode(prims[mid:])
+	return BVHNode{left: &left, right: &right, box: box}
+}
+
+// sahSplit evaluates the cost of splitting after each bin and returns the
+// cheapest bin together with its cost in units of primitive tests.
+func sahSplit(prims []sahPrimitive, axis int, lo, hi float64, box AABB) (int, float64) {
+	var bins [sahBins]sahBin
+	for _, p := range prims {
+		b := &bins[sahBinIndex(p.centroid.e[axis], lo, hi)]
+		if b.count == 0 {
+			b.box = p.box
+		} else {
+			b.box = surroundingBox(b.box, p.box)
+		}
+		b.count++
+	}
+
+	// Sweep from the right so that each split can read the area and count of
+	// everything above it.
+	var rightArea [sahBins]float64
+	var rightCount [sahBins]int
+	var acc sahBin
+	for i := sahBins - 1; i > 0; i-- {
+		acc = mergeBins(acc, bins[i])
+		rightArea[i], rightCount[i] = surfaceArea(acc.box), acc.count
+	}
+
+	bestSplit, bestCost := 0, math.MaxFloat64
+	acc = sahBin{}
+	for i := 0; i < sahBins-1; i++ {
+		acc = mergeBins(acc, bins[i])
+		if acc.count == 0 || rightCount[i+1] == 0 {
+			continue
+		}
+		cost := float64(acc.count)*surfaceArea(acc.box) + float64(rightCount[i+1])*rightArea[i+1]
+		if cost < bestCost {
+			bestSplit, bestCost = i, cost
+		}
+	}
+
+	return bestSplit, sahTraversalCost + bestCost/surfaceArea(box)
+}
+
+func sahBinIndex(c, lo, hi float64) int {
+	i := int(sahBins * (c - lo) / (hi - lo))
+	if i >= sahBins {
+		i = sahBins - 1
+	}
+	return i
+}
+
+func mergeBins(a, b sahBin) sahBin {
+	switch {
+	case b.count == 0:
+		return a
+	case a.count == 0:
+		return b
+	}
+	return sahBin{count: a.count + b.count, box: surroundingBox(a.box, b.box)}
+}
+
+// partitionSAH moves the primitives for which left returns true to the front
+// and returns how many there are.
+func partitionSAH(prims []sahPrimitive, left func(sahPrimitive) bool) int {
+	n := 0
+	for i := range prims {
+		if left(prims[i]) {
+			prims[i], prims[n] = prims[n], prims[i]
+			n++
+		}
+	}
+	return n
+}
+
+func sahLeaf(prims []sahPrimitive) Hitable {
+	l := make(HitableList, len(prims))
+	for i, p := range prims {
+		l[i] = p.hitable
+	}
+	return l
+}
+
+func surfaceArea(box AABB) float64 {
+	d := box.Max.Subtract(box.Min)
+	return 2.0 * (d.X()*d.Y() + d.Y()*d.Z() + d.Z()*d.X())
+}
@@ -0,0 +1,117 @@
+package rendim
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func randomSpheres(n int, rng *RNG) HitableList {
+	mat := mockMaterial{}
+	list := HitableList{}
+	for i := 0; i < n; i++ {
+		center := NewVec3d(10*rng.Float64()-5, 10*rng.Float64()-5, 10*rng.Float64()-5)
+		list = append(list, NewSphere(center, 0.1+0.3*rng.Float64(), mat))
+	}
+	return list
+}
+
+func TestSAHBVHMatchesList(t *testing.T) {
+	rng := NewRNG(7)
+	spheres := randomSpheres(200, rng)
+	reference := append(HitableList{}, spheres...)
+	bvh := NewSAHBVH(spheres, 0.0, 1.0)
+
+	for i := 0; i < 500; i++ {
+		origin := NewVec3d(20*rng.Float64()-10, 20*rng.Float64()-10, -20)
+		target := NewVec3d(10*rng.Float64()-5, 10*rng.Float64()-5, 0)
+		ray := NewRay(origin, target.Subtract(origin), 0.0)
+
+		wantHit, wantRec := reference.Hit(ray, 0.001, math.MaxFloat64, nil)
+		gotHit, gotRec := bvh.Hit(ray, 0.001, math.MaxFloat64, nil)
+		if gotHit != wantHit || gotRec.t != wantRec.t {
+			t.Fatalf("ray %d: Hit() = %v, t=%v, want %v, t=%v", i, gotHit, gotRec.t, wantHit, wantRec.t)
+		}
+	}
+}
+
+func TestSAHBVHBoundingBox(t *testing.T) {
+	spheres := randomSpheres(50, NewRNG(3))
+	var want AABB
+	if !spheres.BoundingBox(0.0, 1.0, &want) {
+		t.Fatal("HitableList should have a bounding box")
+	}
+
+	var got AABB
+	if !NewSAHBVH(spheres, 0.0, 1.0).BoundingBox(0.0, 1.0, &got) {
+		t.Fatal("SAH BVH should have a bounding box")
+	}
+	if got != want {
+		t.Errorf("BoundingBox() = %v, want %v", got, want)
+	}
+}
+
+func TestSAHBVHSmallListIsLeaf(t *testing.T) {
+	mat := mockMaterial{}
+	single := NewSphere(NewVec3d(0.0, 0.0, 0.0), 1.0, mat)
+	if _, ok := NewSAHBVH(HitableList{single}, 0.0, 1.0).(Sphere); !ok {
+		t.Error("NewSAHBVH() of one object should return the object")
+	}
+
+	// Objects at the same place cannot be separated by any split.
+	same := HitableList{single, single, single}
+	if _, ok := NewSAHBVH(same, 0.0, 1.0).(HitableList); !ok {
+		t.Error("NewSAHBVH() of coincident objects should return a leaf list")
+	}
+}
+
+var benchScenes = []string{"simpleLight", "cornell", "final"}
+
+// BenchmarkBVHTraversal traces the primary rays of a 64x64 image and reports
+// the traversal cost per ray.
+func BenchmarkBVHTraversal(b *testing.B) {
+	const size = 64
+	for _, name := range benchScenes {
+		for _, builder := range []string{BVHMedian, BVHSAH} {
+			b.Run(fmt.Sprintf("%s/%s", name, builder), func(b *testing.B) {
+				scene := buildTestScene(b, name, RenderSettings{Width: size, Height: size, BVH: builder})
+				rng := NewRNG(1)
+				rays := make([]Ray, 0, size*size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						rays = append(rays, scene.camera.GetRay((float64(x)+0.5)/size, (float64(y)+0.5)/size, rng))
+					}
+				}
+
+				tc := &TraceContext{}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for _, r := range rays {
+						scene.world.Hit(r, 0.001, math.MaxFloat64, tc)
+					}
+				}
+				traced := float64(b.N * len(rays))
+				b.ReportMetric(float64(tc.nodeVisits)/traced, "nodes/ray")
+				b.ReportMetric(float64(tc.primitiveTests)/traced, "tests/ray")
+			})
+		}
+	}
+}
+
+func BenchmarkBVHRender(b *testing.B) {
+	for _, name := range benchScenes {
+		for _, builder := range []string{BVHMedian, BVHSAH} {
+			b.Run(fmt.Sprintf("%s/%s", name, builder), func(b *testing.B) {
+				settings := RenderSettings{Width: 32, Height: 32, Samples: 4, BucketSize: 16, Workers: 1, BVH: builder}
+				scene := buildTestScene(b, name, settings)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -14,6 +14,9 @@ type Camera struct {
 	time0, time1    float64
 }
 
+// NewCamera creates a camera at lookFrom looking at lookAt with a vertical
+// field of view of vFov degrees. aperture and focusDist control depth of field
+// and the shutter is open from t0 to t1.
 func NewCamera(lookFrom, lookAt, vUp Vec3d, vFov, aspect, aperture, focusDist, t0, t1 float64) Camera {
 	theta := vFov * math.Pi / 180.0
 	halfHeight := math.Tan(theta / 2.0)
@@ -42,9 +45,8 @@ func (c Camera) GetRay(s, t float64, rng *RNG) Ray {
 }
 
 func randomInUnitDisk(rng *RNG) Vec3d {
-	p := NewVec3d(rng.Float64(), rng.Float64(), 0.0).MultiplyScalar(2.0).Subtract(NewVec3d(1.0, 1.0, 0.0))
-	for p.Dot(p) >= 1.0 {
-		p = NewVec3d(rng.Float64(), rng.Float64(), 0.0).MultiplyScalar(2.0).Subtract(NewVec3d(1.0, 1.0, 0.0))
-	}
-	return p
+	u1, u2 := rng.Float64Pair()
+	r := math.Sqrt(u1)
+	phi := 2.0 * math.Pi * u2
+	return NewVec3d(r*math.Cos(phi), r*math.Sin(phi), 0.0)
 }
@@ -0,0 +1,208 @@
+package rendim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint is the state of an unfinished render: the settings, the passes
+// finished and the samples summed so far in every pixel. Samplers draw the
+// numbers of every sample from the seed, the pixel and the sample index
+// alone, so a render resumed from a checkpoint comes out exactly the same as
+// one that was never stopped.
+type Checkpoint struct {
+	// Scene names the scene that was rendered, for the caller to load it
+	// again. RenderScene leaves it empty.
+	Scene    string
+	Settings RenderSettings
+	Passes   int
+
+	estimates []pixelEstimate
+}
+
+// checkpointMagic starts every checkpoint file.
+const checkpointMagic = "RendImCP"
+
+const checkpointVersion = 1
+
+// maxCheckpointHeader bounds the JSON header read from a checkpoint file.
+const maxCheckpointHeader = 1 << 20
+
+// maxCheckpointSide bounds the width and height of a checkpoint.
+const maxCheckpointSide = 1 << 16
+
+// checkpointChunk is the number of pixels ReadCheckpoint reads at a time, so
+// that a corrupt header cannot make it allocate much more than the file
+// holds.
+const checkpointChunk = 1 << 12
+
+type checkpointHeader struct {
+	Scene    string         `json:"scene"`
+	Settings RenderSettings `json:"settings"`
+	Passes   int            `json:"passes"`
+}
+
+// checkpointPixel is the stored form of a pixelEstimate.
+type checkpointPixel struct {
+	Sum      [3]float64
+	Mean, M2 float64
+	Samples  uint32
+	Done     uint8
+	_        [3]byte
+}
+
+// compatible reports whether a render with settings can carry on from c,
+// which needs every setting that changes the image to be the same.
+func (c *Checkpoint) compatible(settings RenderSettings) error {
+	imageSettings := func(s RenderSettings) RenderSettings {
+		s.BucketSize, s.Workers, s.Progressive = 0, 0, false
+		s.ToneMap, s.Exposure, s.WhitePoint = "", 0.0, 0.0
+		return s
+	}
+	if imageSettings(c.Settings) != imageSettings(settings) {
+		return fmt.Errorf("checkpoint of a %dx%d render with %d samples was taken with other settings", c.Settings.Width, c.Settings.Height, c.Settings.Samples)
+	}
+	if len(c.estimates) != settings.Width*settings.Height {
+		return errors.New("checkpoint does not cover the image")
+	}
+	return nil
+}
+
+// WriteCheckpoint writes c to w: a header with the settings as JSON, then the
+// state of every pixel in little-endian binary.
+func WriteCheckpoint(w io.Writer, c *Checkpoint) error {
+	header, err := json.Marshal(checkpointHeader{Scene: c.Scene, Settings: c.Settings, Passes: c.Passes})
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(checkpointMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, [2]uint32{checkpointVersion, uint32(len(header))}); err != nil { //nolint:gosec // G115: the header is small
+		return err
+	}
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	pixels := make([]checkpointPixel, len(c.estimates))
+	for i, e := range c.estimates {
+		pixels[i] = checkpointPixel{
+			Sum:     [3]float64{e.sum.R, e.sum.G, e.sum.B},
+			Mean:    e.mean,
+			M2:      e.m2,
+			Samples: uint32(e.samples), //nolint:gosec // G115: samples is bounded by the settings
+		}
+		if e.done {
+			pixels[i].Done = 1
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, pixels); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadCheckpoint reads a checkpoint written by WriteCheckpoint.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, []byte(checkpointMagic)) {
+		return nil, errors.New("not a checkpoint file")
+	}
+	var head [2]uint32
+	if err := binary.Read(br, binary.LittleEndian, &head); err != nil {
+		return nil, err
+	}
+	if head[0] != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", head[0])
+	}
+	if head[1] > maxCheckpointHeader {
+		return nil, fmt.Errorf("checkpoint header of %d bytes is too large", head[1])
+	}
+	header := make([]byte, head[1])
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	var h checkpointHeader
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, fmt.Errorf("checkpoint header: %w", err)
+	}
+	if err := h.Settings.validate("settings"); err != nil {
+		var se *SceneError
+		if errors.As(err, &se) {
+			se.File = "checkpoint"
+		}
+		return nil, err
+	}
+	if h.Settings.Width <= 0 || h.Settings.Height <= 0 || h.Settings.Samples <= 0 ||
+		h.Settings.Width > maxCheckpointSide || h.Settings.Height > maxCheckpointSide {
+		return nil, fmt.Errorf("checkpoint of a %dx%d render with %d samples", h.Settings.Width, h.Settings.Height, h.Settings.Samples)
+	}
+
+	n := h.Settings.Width * h.Settings.Height
+	c := &Checkpoint{Scene: h.Scene, Settings: h.Settings, Passes: h.Passes}
+	chunk := make([]checkpointPixel, min(n, checkpointChunk))
+	for len(c.estimates) < n {
+		pixels := chunk[:min(n-len(c.estimates), len(chunk))]
+		if err := binary.Read(br, binary.LittleEndian, pixels); err != nil {
+			return nil, fmt.Errorf("checkpoint pixels: %w", err)
+		}
+		for _, p := range pixels {
+			if int(p.Samples) > h.Settings.Samples {
+				return nil, fmt.Errorf("checkpoint pixel %d has %d samples, more than %d", len(c.estimates), p.Samples, h.Settings.Samples)
+			}
+			c.estimates = append(c.estimates, pixelEstimate{
+				sum:     Color{R: p.Sum[0], G: p.Sum[1], B: p.Sum[2]},
+				samples: int(p.Samples),
+				mean:    p.Mean,
+				m2:      p.M2,
+				done:    p.Done != 0,
+			})
+		}
+	}
+	return c, nil
+}
+
+// SaveCheckpoint writes c to the file at path. It writes a temporary file
+// first and renames it, so that a crash while saving leaves the previous
+// checkpoint intact.
+func SaveCheckpoint(path string, c *Checkpoint) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := WriteCheckpoint(f, c); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadCheckpoint reads the checkpoint file at path.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCheckpoint(f)
+}
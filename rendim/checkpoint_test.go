@@ -0,0 +1,276 @@
+package rendim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testCheckpoint() *Checkpoint {
+	settings := DefaultRenderSettings.Merge(RenderSettings{Width: 3, Height: 2, Samples: 8, Seed: 4})
+	c := &Checkpoint{Scene: "scenes/cornell.json", Settings: settings, Passes: 2, estimates: make([]pixelEstimate, 6)}
+	c.estimates[1] = pixelEstimate{sum: Color{R: 1.5, G: 0.25, B: 1e-9}, samples: 8, mean: 0.3, m2: 0.01, done: true}
+	c.estimates[4] = pixelEstimate{sum: Color{R: 0.1, G: 0.2, B: 0.3}, samples: 3, mean: 0.07, m2: 0.002}
+	return c
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	want := testCheckpoint()
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadCheckpoint(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scene != want.Scene || got.Settings != want.Settings || got.Passes != want.Passes || !slices.Equal(got.estimates, want.estimates) {
+		t.Errorf("ReadCheckpoint() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "render.ckpt")
+	want := testCheckpoint()
+	if err := SaveCheckpoint(path, want); err != nil {
+		t.Fatal(err)
+	}
+	want.Passes = 3
+	if err := SaveCheckpoint(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Passes != 3 {
+		t.Errorf("Passes = %d, want the last saved 3", got.Passes)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestReadCheckpointErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, testCheckpoint()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tests := map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("NotRendI"), data[8:]...),
+		"truncated": data[:len(data)-10],
+		"version":   append(append([]byte(checkpointMagic), 9, 0, 0, 0), data[12:]...),
+		"huge":      hugeCheckpoint(t, maxCheckpointSide, maxCheckpointSide),
+		"too large": hugeCheckpoint(t, maxCheckpointSide+1, 1),
+	}
+	for name, d := range tests {
+		if _, err := ReadCheckpoint(bytes.NewReader(d)); err == nil {
+			t.Errorf("%s: ReadCheckpoint() should fail", name)
+		}
+	}
+}
+
+// hugeCheckpoint returns the start of a checkpoint file whose header claims
+// a width x height image but which holds a single pixel.
+func hugeCheckpoint(t *testing.T, width, height int) []byte {
+	t.Helper()
+	c := testCheckpoint()
+	c.Settings.Width, c.Settings.Height = width, height
+	c.estimates = c.estimates[:1]
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// interruptedRender renders until stop says so and returns the checkpoint
+// the render hands over when it is cancelled, after a trip through a file.
+func interruptedRender(t *testing.T, scene Scene, settings RenderSettings, stop func(cancel func()) RenderOptions) *Checkpoint {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var last *Checkpoint
+	opts := stop(cancel)
+	opts.Checkpoint = func(c *Checkpoint) { last = c }
+	if _, _, err := RenderScene(ctx, scene, settings, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if last == nil {
+		t.Fatal("no checkpoint was taken when the render stopped")
+	}
+
+	path := filepath.Join(t.TempDir(), "render.ckpt")
+	if err := SaveCheckpoint(path, last); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestResumeMatchesUninterruptedRender(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	for _, settings := range []RenderSettings{
+		{Width: 16, Height: 16, Samples: 6, BucketSize: 4, Workers: 2, Seed: 3},
+		{Width: 16, Height: 16, Samples: 24, MinSamples: 4, AdaptiveThreshold: 0.2, BucketSize: 8, Workers: 3, Seed: 3, Sampler: SamplerSobol},
+	} {
+		want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Stop after a few pixels, with other pixels part way through.
+		c := interruptedRender(t, scene, settings, func(cancel func()) RenderOptions {
+			pixels := make(chan Pixel)
+			go func() {
+				for n := 0; ; n++ {
+					if _, ok := <-pixels; !ok {
+						return
+					}
+					if n == 40 {
+						cancel()
+					}
+				}
+			}()
+			t.Cleanup(func() { close(pixels) })
+			return RenderOptions{Pixels: pixels}
+		})
+		finished := 0
+		for _, e := range c.estimates {
+			if e.done {
+				finished++
+			}
+		}
+		if finished == 0 || finished == len(c.estimates) {
+			t.Fatalf("%d of %d pixels were finished when the render stopped, want some", finished, len(c.estimates))
+		}
+
+		resumed := settings
+		resumed.Workers, resumed.BucketSize = 1, 5
+		got, stats, err := RenderScene(context.Background(), scene, resumed, RenderOptions{Resume: c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.pix, want.pix) {
+			t.Errorf("%d samples: the resumed render differs from the uninterrupted one", settings.Samples)
+		}
+		if stats.Pixels != 16*16 {
+			t.Errorf("Pixels = %d, want 256", stats.Pixels)
+		}
+	}
+}
+
+func TestResumeProgressive(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 16, BucketSize: 8, Workers: 2, Seed: 9, Progressive: true}
+	want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := interruptedRender(t, scene, settings, func(cancel func()) RenderOptions {
+		return RenderOptions{Pass: func(samples int, fb *Framebuffer) {
+			if samples == 4 {
+				cancel()
+			}
+		}}
+	})
+	if c.Passes != 3 {
+		t.Fatalf("Passes = %d, want 3", c.Passes)
+	}
+
+	var passes []int
+	got, stats, err := RenderScene(context.Background(), scene, settings, RenderOptions{
+		Resume: c,
+		Pass:   func(samples int, fb *Framebuffer) { passes = append(passes, samples) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(passes, []int{8, 16}) {
+		t.Errorf("resumed passes = %v, want [8 16]", passes)
+	}
+	if stats.Passes != 5 || stats.Samples != 16*16*(16-4) {
+		t.Errorf("stats = %d passes, %d samples, want 5 and the %d samples left", stats.Passes, stats.Samples, 16*16*12)
+	}
+	if !slices.Equal(got.pix, want.pix) {
+		t.Error("the resumed render differs from the uninterrupted one")
+	}
+}
+
+func TestResumeRejectsOtherSettings(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 4, 4)
+	settings := RenderSettings{Width: 4, Height: 4, Samples: 2, BucketSize: 4, Workers: 1}
+	c := &Checkpoint{Settings: settings, estimates: make([]pixelEstimate, 16)}
+
+	other := settings
+	other.Seed = 1
+	if _, _, err := RenderScene(context.Background(), scene, other, RenderOptions{Resume: c}); err == nil {
+		t.Error("RenderScene() should refuse a checkpoint with another seed")
+	}
+	other = settings
+	other.Workers, other.ToneMap = 4, ToneMapACES
+	if _, _, err := RenderScene(context.Background(), scene, other, RenderOptions{Resume: c}); err != nil {
+		t.Errorf("RenderScene() with other workers and tone map: %v", err)
+	}
+}
+
+func TestRenderCheckpointsPeriodically(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 64, BucketSize: 8, Workers: 2}
+	var calls atomic.Int32
+	_, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{
+		Checkpoint:         func(c *Checkpoint) { calls.Add(1) },
+		CheckpointInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() == 0 {
+		t.Error("no checkpoint was taken")
+	}
+}
+
+func TestCheckpointDoesNotWaitForPixels(t *testing.T) {
+	settings := DefaultRenderSettings.Merge(RenderSettings{Width: 2, Height: 1, Samples: 1 << 30, BucketSize: 1, Workers: 2})
+	scene := buildTestScene(t, "cornell", settings)
+	job, err := newRenderJob(&scene, settings, image.Rect(0, 0, 2, 1), RenderOptions{Checkpoint: func(*Checkpoint) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rendered := make(chan struct{})
+	go func() {
+		job.renderPass(ctx, settings.Samples)
+		close(rendered)
+	}()
+	defer func() {
+		cancel()
+		<-rendered
+	}()
+
+	// Both pixels take far longer than the test to finish.
+	time.Sleep(50 * time.Millisecond)
+	taken := make(chan *Checkpoint)
+	go func() { taken <- job.checkpoint() }()
+	select {
+	case c := <-taken:
+		if len(c.estimates) != 2 {
+			t.Errorf("checkpoint has %d pixels, want 2", len(c.estimates))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkpoint waited for the pixels being rendered")
+	}
+}
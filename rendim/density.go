@@ -0,0 +1,157 @@
+package rendim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// DensityField gives the density of a HeterogeneousMedium at each point, by
+// which its absorption and scattering coefficients are scaled.
+type DensityField interface {
+	Density(p Vec3d) float64
+	// MaxDensity returns an upper bound of Density inside box.
+	MaxDensity(box AABB) float64
+}
+
+// UniformDensity is the same everywhere.
+type UniformDensity struct {
+	density float64
+}
+
+// NewUniformDensity creates a density field of constant value d.
+func NewUniformDensity(d float64) UniformDensity {
+	return UniformDensity{density: d}
+}
+
+func (u UniformDensity) Density(p Vec3d) float64 {
+	return u.density
+}
+
+func (u UniformDensity) MaxDensity(box AABB) float64 {
+	return u.density
+}
+
+// GridDensity interpolates trilinearly between values on a regular grid of
+// points spanning a box. Outside the box the density is zero.
+type GridDensity struct {
+	nx, ny, nz int
+	values     []float64
+	bounds     AABB
+	max        float64
+}
+
+// NewGridDensity creates a density field from nx*ny*nz values, x varying
+// fastest and z slowest, with the first value at min and the last at max.
+func NewGridDensity(nx, ny, nz int, values []float64, min, max Vec3d) GridDensity {
+	if nx < 1 || ny < 1 || nz < 1 || len(values) != nx*ny*nz {
+		panic("Grid size does not match the values in NewGridDensity.\n")
+	}
+	g := GridDensity{nx: nx, ny: ny, nz: nz, values: values, bounds: AABB{Min: min, Max: max}}
+	for _, v := range values {
+		g.max = math.Max(g.max, v)
+	}
+	return g
+}
+
+func (g GridDensity) Density(p Vec3d) float64 {
+	x0, fx, ok := gridCoord(p.X(), g.bounds.Min.X(), g.bounds.Max.X(), g.nx)
+	if !ok {
+		return 0.0
+	}
+	y0, fy, ok := gridCoord(p.Y(), g.bounds.Min.Y(), g.bounds.Max.Y(), g.ny)
+	if !ok {
+		return 0.0
+	}
+	z0, fz, ok := gridCoord(p.Z(), g.bounds.Min.Z(), g.bounds.Max.Z(), g.nz)
+	if !ok {
+		return 0.0
+	}
+
+	x1, y1, z1 := min(x0+1, g.nx-1), min(y0+1, g.ny-1), min(z0+1, g.nz-1)
+	at := func(x, y, z int) float64 { return g.values[(z*g.ny+y)*g.nx+x] }
+	lerp := func(a, b, t float64) float64 { return a + (b-a)*t }
+	c00 := lerp(at(x0, y0, z0), at(x1, y0, z0), fx)
+	c10 := lerp(at(x0, y1, z0), at(x1, y1, z0), fx)
+	c01 := lerp(at(x0, y0, z1), at(x1, y0, z1), fx)
+	c11 := lerp(at(x0, y1, z1), at(x1, y1, z1), fx)
+	return lerp(lerp(c00, c10, fy), lerp(c01, c11, fy), fz)
+}
+
+// LoadGridDensity reads nx*ny*nz little-endian 32-bit floats in the order of
+// NewGridDensity from a raw file and creates a density field from them.
+func LoadGridDensity(path string, nx, ny, nz int, min, max Vec3d) (GridDensity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return GridDensity{}, err
+	}
+	if nx < 1 || ny < 1 || nz < 1 || len(data) != 4*nx*ny*nz {
+		return GridDensity{}, fmt.Errorf("%s has %d bytes, want %d for a %dx%dx%d grid", path, len(data), 4*nx*ny*nz, nx, ny, nz)
+	}
+	values := make([]float64, nx*ny*nz)
+	for i := range values {
+		values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+	}
+	return NewGridDensity(nx, ny, nz, values, min, max), nil
+}
+
+// gridCoord returns the grid cell containing v along one axis of n points
+// from lo to hi, and the position of v within the cell.
+func gridCoord(v, lo, hi float64, n int) (int, float64, bool) {
+	if v < lo || v > hi {
+		return 0, 0.0, false
+	}
+	if n == 1 || hi <= lo {
+		return 0, 0.0, true
+	}
+	x := (v - lo) / (hi - lo) * float64(n-1)
+	i := min(int(x), n-2)
+	return i, x - float64(i), true
+}
+
+func (g GridDensity) MaxDensity(box AABB) float64 {
+	return g.max
+}
+
+// NoiseDensity is patchy like smoke: Perlin turbulence at the given scale,
+// cut off at density.
+type NoiseDensity struct {
+	noise   perlin
+	scale   float64
+	density float64
+}
+
+// NewNoiseDensity creates a turbulent density field of at most density.
+// Larger scales give finer detail.
+func NewNoiseDensity(scale, density float64) NoiseDensity {
+	return NoiseDensity{noise: perlinNoise, scale: scale, density: density}
+}
+
+func (n NoiseDensity) Density(p Vec3d) float64 {
+	return n.density * math.Min(1.0, n.noise.Turbulence(p.MultiplyScalar(n.scale)))
+}
+
+func (n NoiseDensity) MaxDensity(box AABB) float64 {
+	return n.density
+}
+
+// HeightFog thins out exponentially with height, like mist gathering near the
+// ground.
+type HeightFog struct {
+	density, height, falloff float64
+}
+
+// NewHeightFog creates fog of the given density at height that drops by a
+// factor of e every 1/falloff units higher up. falloff must not be negative.
+func NewHeightFog(density, height, falloff float64) HeightFog {
+	return HeightFog{density: density, height: height, falloff: falloff}
+}
+
+func (f HeightFog) Density(p Vec3d) float64 {
+	return f.density * math.Exp(-f.falloff*(p.Y()-f.height))
+}
+
+func (f HeightFog) MaxDensity(box AABB) float64 {
+	return f.Density(box.Min)
+}
@@ -0,0 +1,95 @@
+package rendim
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGridDensity(t *testing.T) {
+	// Value x + 10y + 100z at each grid point, which trilinear
+	// interpolation reproduces exactly.
+	values := make([]float64, 0, 3*2*2)
+	for z := 0; z < 2; z++ {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				values = append(values, float64(x+10*y+100*z))
+			}
+		}
+	}
+	g := NewGridDensity(3, 2, 2, values, NewVec3d(0.0, 0.0, 0.0), NewVec3d(2.0, 1.0, 1.0))
+
+	tests := []struct {
+		p    Vec3d
+		want float64
+	}{
+		{NewVec3d(0.0, 0.0, 0.0), 0.0},
+		{NewVec3d(2.0, 1.0, 1.0), 112.0},
+		{NewVec3d(1.5, 0.25, 0.5), 1.5 + 2.5 + 50.0},
+		{NewVec3d(2.5, 0.5, 0.5), 0.0},
+		{NewVec3d(1.0, -0.1, 0.5), 0.0},
+	}
+	for _, tt := range tests {
+		if got := g.Density(tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Density(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := g.MaxDensity(AABB{}); got != 112.0 {
+		t.Errorf("MaxDensity() = %v, want 112", got)
+	}
+}
+
+func TestLoadGridDensity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grid.raw")
+	var data []byte
+	for _, v := range []float32{0.0, 1.0, 2.0, 3.0} {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := LoadGridDensity(path, 2, 2, 1, NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 1.0, 0.0))
+	if err != nil {
+		t.Fatalf("LoadGridDensity() error = %v", err)
+	}
+	if got := g.Density(NewVec3d(0.5, 0.5, 0.0)); math.Abs(got-1.5) > 1e-9 {
+		t.Errorf("Density() = %v, want 1.5", got)
+	}
+	if _, err := LoadGridDensity(path, 2, 2, 2, NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 1.0, 1.0)); err == nil {
+		t.Error("LoadGridDensity() with the wrong size should fail")
+	}
+}
+
+func TestHeightFog(t *testing.T) {
+	f := NewHeightFog(2.0, 1.0, 0.5)
+	if got := f.Density(NewVec3d(3.0, 1.0, -2.0)); got != 2.0 {
+		t.Errorf("Density() at the fog height = %v, want 2", got)
+	}
+	if got, want := f.Density(NewVec3d(0.0, 3.0, 0.0)), 2.0*math.Exp(-1.0); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Density() higher up = %v, want %v", got, want)
+	}
+	box := AABB{Min: NewVec3d(-1.0, -1.0, -1.0), Max: NewVec3d(1.0, 5.0, 1.0)}
+	if got, want := f.MaxDensity(box), 2.0*math.E; math.Abs(got-want) > 1e-12 {
+		t.Errorf("MaxDensity() = %v, want %v", got, want)
+	}
+}
+
+func TestNoiseDensityBounded(t *testing.T) {
+	n := NewNoiseDensity(4.0, 0.7)
+	rng := NewRNG(1)
+	varies := false
+	for i := 0; i < 1000; i++ {
+		p := NewVec3d(rng.Float64(), rng.Float64(), rng.Float64()).MultiplyScalar(10.0)
+		d := n.Density(p)
+		if d < 0.0 || d > n.MaxDensity(AABB{}) {
+			t.Fatalf("Density(%v) = %v, outside [0, 0.7]", p, d)
+		}
+		varies = varies || d != n.Density(NewVec3d(0.5, 0.5, 0.5))
+	}
+	if !varies {
+		t.Error("NoiseDensity should vary in space")
+	}
+}
@@ -0,0 +1,567 @@
+package rendim
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A distributed render runs RenderRemote as the coordinator and a WorkerServer
+// in every worker process. The coordinator uploads the scene bundle to each
+// worker once with PUT /scenes/{id}, then sends buckets to POST /render, each
+// of which the worker renders with RenderRegion and answers with the
+// radiance of its pixels in binary, which keeps them exact even when they are
+// not finite. A worker that has lost the scene, for having been
+// restarted or having failed to read its files, answers 404 and is sent the
+// bundle again.
+
+// regionRequest asks a worker to render one bucket of an uploaded scene.
+type regionRequest struct {
+	Scene    string          `json:"scene"` // SceneBundle.ID
+	Settings RenderSettings  `json:"settings"`
+	Region   image.Rectangle `json:"region"`
+}
+
+// regionResult is a worker's answer to a regionRequest.
+type regionResult struct {
+	Pixels         []float32 // R, G, B per pixel, rows top to bottom
+	Samples        []int     // samples taken per pixel
+	Rays           uint64
+	NodeVisits     uint64
+	PrimitiveTests uint64
+}
+
+// writeRegionResult writes the counters, pixels and sample counts of result
+// in little-endian binary.
+func writeRegionResult(w io.Writer, result *regionResult) error {
+	samples := make([]uint32, len(result.Samples))
+	for i, n := range result.Samples {
+		samples[i] = uint32(n) //nolint:gosec // G115: sample counts are validated settings
+	}
+	for _, v := range []any{[3]uint64{result.Rays, result.NodeVisits, result.PrimitiveTests}, result.Pixels, samples} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRegionResult reads what writeRegionResult wrote for a region of n
+// pixels.
+func readRegionResult(r io.Reader, n int) (*regionResult, error) {
+	var counters [3]uint64
+	result := &regionResult{Pixels: make([]float32, 3*n), Samples: make([]int, n)}
+	samples := make([]uint32, n)
+	for _, v := range []any{&counters, result.Pixels, samples} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return nil, fmt.Errorf("answered with less than a region of %d pixels: %w", n, err)
+		}
+	}
+	if extra, _ := io.Copy(io.Discard, io.LimitReader(r, 1)); extra > 0 {
+		return nil, fmt.Errorf("answered with more than a region of %d pixels", n)
+	}
+	for i, s := range samples {
+		result.Samples[i] = int(s)
+	}
+	result.Rays, result.NodeVisits, result.PrimitiveTests = counters[0], counters[1], counters[2]
+	return result, nil
+}
+
+const (
+	// maxBundleSize bounds the scene bundles a worker accepts.
+	maxBundleSize = 1 << 30
+	// maxRegionRequest bounds the render requests a worker accepts.
+	maxRegionRequest = 1 << 20
+	// maxWorkerBundles is the number of scenes a worker keeps.
+	maxWorkerBundles = 4
+	// regionBucketSize is the edge length of the buckets a worker splits a
+	// region into for its own workers.
+	regionBucketSize = 8
+	// remoteRequestTimeout bounds every request to a worker, so that one that
+	// hangs is given up on like one that fails.
+	remoteRequestTimeout = 10 * time.Minute
+)
+
+// WorkerServer renders buckets for RenderRemote over HTTP. It unpacks the
+// scene bundles it is sent into a directory and keeps the last few scenes.
+type WorkerServer struct {
+	dir     string
+	workers int
+	mux     *http.ServeMux
+
+	mu      sync.Mutex
+	bundles []*workerBundle // least recently used first
+	uploads int             // bundles unpacked so far, numbering their directories
+}
+
+// workerBundle is an unpacked scene bundle and the scenes built from it.
+type workerBundle struct {
+	id     string
+	dir    string
+	sf     *SceneFile
+	scenes map[string]*builtScene // by image size and BVH builder
+	// builds is the number of requests building scenes, which read the
+	// files in dir; those of a dropped bundle are removed once it is zero.
+	builds  int
+	dropped bool
+}
+
+// builtScene is built once by the first request that needs it.
+type builtScene struct {
+	once  sync.Once
+	scene Scene
+	err   error
+}
+
+// NewWorkerServer creates a worker that unpacks bundles into dir and renders
+// every bucket with the given number of workers.
+func NewWorkerServer(dir string, workers int) *WorkerServer {
+	s := &WorkerServer{dir: dir, workers: max(workers, 1), mux: http.NewServeMux()}
+	s.mux.HandleFunc("PUT /scenes/{id}", s.putScene)
+	s.mux.HandleFunc("POST /render", s.render)
+	return s
+}
+
+// ServeHTTP answers the requests of RenderRemote.
+func (s *WorkerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *WorkerServer) putScene(w http.ResponseWriter, r *http.Request) {
+	var b SceneBundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleSize)).Decode(&b); err != nil {
+		http.Error(w, "scene bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	if b.ID() != id {
+		http.Error(w, "scene bundle does not match its ID", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lookup(id) != nil {
+		return
+	}
+	// Every upload gets a directory of its own, as a dropped bundle of the
+	// same ID may still be being read.
+	s.uploads++
+	dir := filepath.Join(s.dir, fmt.Sprintf("%s-%d", id, s.uploads))
+	sf, err := LoadSceneBundle(&b, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		status := http.StatusBadRequest
+		if isFileError(err) {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.bundles = append(s.bundles, &workerBundle{id: id, dir: dir, sf: sf, scenes: map[string]*builtScene{}})
+	if len(s.bundles) > maxWorkerBundles {
+		s.drop(s.bundles[0])
+	}
+}
+
+// drop forgets b and removes its files once no build reads them. s.mu must
+// be held.
+func (s *WorkerServer) drop(b *workerBundle) {
+	s.bundles = slices.DeleteFunc(s.bundles, func(o *workerBundle) bool { return o == b })
+	b.dropped = true
+	if b.builds == 0 {
+		os.RemoveAll(b.dir)
+	}
+}
+
+// release ends a build on b that returned err. A build that failed to read
+// the files of b drops it, so that the coordinator uploads it again.
+func (s *WorkerServer) release(b *workerBundle, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.builds--
+	switch {
+	case isFileError(err):
+		s.drop(b)
+	case b.dropped && b.builds == 0:
+		os.RemoveAll(b.dir)
+	}
+}
+
+// isFileError reports whether err is a failure to read a file, as opposed to
+// a scene that does not build anywhere.
+func isFileError(err error) bool {
+	return errors.As(err, new(*fs.PathError))
+}
+
+// lookup returns the bundle with the given ID, marking it the most recently
+// used, or nil. s.mu must be held.
+func (s *WorkerServer) lookup(id string) *workerBundle {
+	for i, b := range s.bundles {
+		if b.id == id {
+			s.bundles = append(append(s.bundles[:i:i], s.bundles[i+1:]...), b)
+			return b
+		}
+	}
+	return nil
+}
+
+// scene returns the scene of the bundle with the given ID built for settings,
+// or nil if the worker does not have the bundle.
+func (s *WorkerServer) scene(id string, settings RenderSettings) (*builtScene, error) {
+	s.mu.Lock()
+	b := s.lookup(id)
+	if b == nil {
+		s.mu.Unlock()
+		return nil, nil
+	}
+	key := fmt.Sprintf("%dx%d/%s", settings.Width, settings.Height, settings.BVH)
+	built, ok := b.scenes[key]
+	if !ok {
+		built = &builtScene{}
+		b.scenes[key] = built
+	}
+	b.builds++
+	s.mu.Unlock()
+
+	built.once.Do(func() {
+		built.scene, built.err = b.sf.Build(settings)
+	})
+	s.release(b, built.err)
+	return built, built.err
+}
+
+func (s *WorkerServer) render(w http.ResponseWriter, r *http.Request) {
+	var req regionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRegionRequest)).Decode(&req); err != nil {
+		http.Error(w, "render request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings := req.Settings
+	settings.BucketSize, settings.Workers = regionBucketSize, s.workers
+	if err := settings.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	built, err := s.scene(req.Scene, settings)
+	if err != nil {
+		status := http.StatusBadRequest
+		if isFileError(err) {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if built == nil {
+		http.Error(w, "unknown scene "+req.Scene, http.StatusNotFound)
+		return
+	}
+
+	samples := NewSampleMap(req.Region.Dx(), req.Region.Dy())
+	fb, stats, err := RenderRegion(r.Context(), built.scene, settings, req.Region, RenderOptions{SampleMap: samples})
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var body bytes.Buffer
+	if err := writeRegionResult(&body, &regionResult{
+		Pixels:         fb.pix,
+		Samples:        samples.counts,
+		Rays:           stats.Rays,
+		NodeVisits:     stats.NodeVisits,
+		PrimitiveTests: stats.PrimitiveTests,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = body.WriteTo(w)
+}
+
+// remoteWorkerFailures is the number of failures in a row after which
+// RenderRemote gives up on a worker.
+const remoteWorkerFailures = 3
+
+// remoteRequestsPerWorker is the number of buckets RenderRemote keeps in
+// flight on every worker, so that the next is ready when one finishes.
+const remoteRequestsPerWorker = 2
+
+// RenderRemote renders the scene of bundle like RenderScene, but hands its
+// buckets to the WorkerServers at addrs, given as host:port, and assembles
+// their pixels, which come out exactly as RenderScene renders them. A bucket
+// a worker fails to render is handed to another; a worker that fails
+// several times in a row is given up on, and the render fails only when
+// every worker has been. Of opts only Pixels, Progress and SampleMap apply.
+func RenderRemote(ctx context.Context, bundle *SceneBundle, settings RenderSettings, addrs []string, opts RenderOptions) (*Framebuffer, Stats, error) {
+	return renderRemote(ctx, bundle, settings, addrs, opts, remoteRequestTimeout)
+}
+
+// renderRemote is RenderRemote with every request to a worker bounded by
+// timeout.
+func renderRemote(ctx context.Context, bundle *SceneBundle, settings RenderSettings, addrs []string, opts RenderOptions, timeout time.Duration) (*Framebuffer, Stats, error) {
+	if len(addrs) == 0 {
+		return nil, Stats{}, errors.New("no workers to render on")
+	}
+	r := &remoteRender{
+		bundle:   bundle,
+		id:       bundle.ID(),
+		settings: settings,
+		opts:     opts,
+		fb:       NewFramebuffer(settings.Width, settings.Height),
+		stats:    newRenderStats(settings.Width * settings.Height),
+		toneMap:  settings.ToneMapping(),
+		client:   &http.Client{Timeout: timeout},
+	}
+	var buckets []image.Rectangle
+	for _, b := range getBuckets(image.Rect(0, 0, settings.Width, settings.Height), settings.BucketSize) {
+		// Regions exclude their Max edges, unlike buckets.
+		if region := (image.Rectangle{Min: b.Min, Max: b.Max.Add(image.Point{X: 1, Y: 1})}); !region.Empty() {
+			buckets = append(buckets, region)
+		}
+	}
+	r.queue = make(chan image.Rectangle, len(buckets))
+	for _, b := range buckets {
+		r.queue <- b
+	}
+	r.remaining.Store(int64(len(buckets)))
+
+	stop := startProgress(r.stats, opts)
+	renderCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		worker := &remoteWorker{id: i, url: "http://" + addr}
+		for n := 0; n < remoteRequestsPerWorker; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.run(renderCtx, cancel, worker)
+			}()
+		}
+	}
+	wg.Wait()
+
+	stats := stop()
+	switch {
+	case ctx.Err() != nil:
+		return r.fb, stats, ctx.Err()
+	case context.Cause(renderCtx) != nil:
+		return r.fb, stats, context.Cause(renderCtx)
+	case r.remaining.Load() > 0:
+		return r.fb, stats, fmt.Errorf("every worker failed, the last with: %w", r.lastError())
+	}
+	return r.fb, stats, nil
+}
+
+// remoteRender is the state of one RenderRemote.
+type remoteRender struct {
+	bundle   *SceneBundle
+	id       string
+	settings RenderSettings
+	opts     RenderOptions
+	fb       *Framebuffer
+	stats    *renderStats
+	toneMap  ToneMap
+	client   *http.Client
+
+	queue     chan image.Rectangle
+	remaining atomic.Int64 // buckets not yet rendered
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// remoteWorker is a worker process shared by the goroutines sending it
+// buckets.
+type remoteWorker struct {
+	id  int
+	url string
+
+	mu       sync.Mutex
+	failures int // in a row
+}
+
+// failed records a failure of w and reports whether to give up on it.
+func (w *remoteWorker) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failures++
+	return w.failures >= remoteWorkerFailures
+}
+
+func (w *remoteWorker) succeeded() {
+	w.mu.Lock()
+	w.failures = 0
+	w.mu.Unlock()
+}
+
+func (w *remoteWorker) dead() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failures >= remoteWorkerFailures
+}
+
+// fatalError is an error that would happen on every worker, such as a scene
+// that does not build.
+type fatalError struct{ error }
+
+// run sends buckets to worker until none are left, the render is cancelled
+// or the worker is given up on.
+func (r *remoteRender) run(ctx context.Context, cancel context.CancelCauseFunc, worker *remoteWorker) {
+	for !worker.dead() {
+		var bucket image.Rectangle
+		select {
+		case b, ok := <-r.queue:
+			if !ok {
+				return
+			}
+			bucket = b
+		case <-ctx.Done():
+			return
+		}
+
+		start := time.Now()
+		result, err := r.renderBucket(ctx, worker, bucket)
+		if err == nil {
+			worker.succeeded()
+			r.finish(ctx, bucket, result, BucketStats{Bounds: bucket, Worker: worker.id, Duration: time.Since(start)})
+			continue
+		}
+		r.queue <- bucket
+		var fatal fatalError
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.As(err, &fatal):
+			cancel(fatal.error)
+			return
+		}
+		r.mu.Lock()
+		r.lastErr = err
+		r.mu.Unlock()
+		if worker.failed() {
+			return
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *remoteRender) lastError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// finish stores the pixels of a rendered bucket.
+func (r *remoteRender) finish(ctx context.Context, bucket image.Rectangle, result *regionResult, stats BucketStats) {
+	i, samples := 0, 0
+	for py := bucket.Min.Y; py < bucket.Max.Y; py++ {
+		for px := bucket.Min.X; px < bucket.Max.X; px++ {
+			c := Color{R: float64(result.Pixels[3*i]), G: float64(result.Pixels[3*i+1]), B: float64(result.Pixels[3*i+2])}
+			r.fb.Set(px, py, c)
+			if r.opts.SampleMap != nil {
+				r.opts.SampleMap.Set(px, py, result.Samples[i])
+			}
+			samples += result.Samples[i]
+			r.stats.addPixel()
+			if r.opts.Pixels != nil {
+				sendPixel(ctx, r.opts.Pixels, px, py, r.toneMap.Apply(c))
+			}
+			i++
+		}
+	}
+	r.stats.addSamples(samples, &TraceContext{rays: result.Rays, nodeVisits: result.NodeVisits, primitiveTests: result.PrimitiveTests})
+	r.stats.addBucket(stats)
+	if r.remaining.Add(-1) == 0 {
+		close(r.queue)
+	}
+}
+
+// renderBucket has worker render bucket, uploading the scene first if the
+// worker does not have it.
+func (r *remoteRender) renderBucket(ctx context.Context, worker *remoteWorker, bucket image.Rectangle) (*regionResult, error) {
+	body, err := json.Marshal(regionRequest{Scene: r.id, Settings: r.settings, Region: bucket})
+	if err != nil {
+		return nil, fatalError{err}
+	}
+	for uploaded := false; ; uploaded = true {
+		resp, err := r.post(ctx, http.MethodPost, worker.url+"/render", body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound && !uploaded {
+			resp.Body.Close()
+			if err := r.upload(ctx, worker); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if err := responseError(worker, resp); err != nil {
+			return nil, err
+		}
+		result, err := readRegionResult(resp.Body, bucket.Dx()*bucket.Dy())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", worker.url, err)
+		}
+		return result, nil
+	}
+}
+
+// upload sends the scene bundle to worker.
+func (r *remoteRender) upload(ctx context.Context, worker *remoteWorker) error {
+	body, err := json.Marshal(r.bundle)
+	if err != nil {
+		return fatalError{err}
+	}
+	resp, err := r.post(ctx, http.MethodPut, worker.url+"/scenes/"+r.id, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return responseError(worker, resp)
+}
+
+func (r *remoteRender) post(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fatalError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return r.client.Do(req)
+}
+
+// responseError returns the error a worker answered with, if any. A bad
+// request would be just as bad on any other worker, so it is fatal.
+func responseError(worker *remoteWorker, resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	err := fmt.Errorf("%s: %s: %s", worker.url, resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode == http.StatusBadRequest {
+		return fatalError{err}
+	}
+	return err
+}
@@ -0,0 +1,298 @@
+package rendim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRenderRegionMatchesRenderScene(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 4, BucketSize: 4, Workers: 2, Seed: 5}
+	want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	region := image.Rect(3, 5, 14, 9)
+	samples := NewSampleMap(region.Dx(), region.Dy())
+	fb, stats, err := RenderRegion(context.Background(), scene, settings, region, RenderOptions{SampleMap: samples})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Width() != 11 || fb.Height() != 4 {
+		t.Fatalf("Framebuffer size = %dx%d, want 11x4", fb.Width(), fb.Height())
+	}
+	for y := 0; y < region.Dy(); y++ {
+		for x := 0; x < region.Dx(); x++ {
+			if got, want := fb.At(x, y), want.At(region.Min.X+x, region.Min.Y+y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", region.Min.X+x, region.Min.Y+y, got, want)
+			}
+			if samples.At(x, y) != 4 {
+				t.Fatalf("pixel (%d, %d) took %d samples, want 4", region.Min.X+x, region.Min.Y+y, samples.At(x, y))
+			}
+		}
+	}
+	if stats.Pixels != 44 || stats.TotalPixels != 44 {
+		t.Errorf("Pixels = %d of %d, want 44 of 44", stats.Pixels, stats.TotalPixels)
+	}
+
+	if _, _, err := RenderRegion(context.Background(), scene, settings, image.Rect(10, 10, 20, 20), RenderOptions{}); err == nil {
+		t.Error("rendering a region outside the image succeeded")
+	}
+}
+
+// remoteTestRender renders the bundle test scene locally and returns it with
+// its bundle and settings.
+func remoteTestRender(t *testing.T) (*SceneBundle, RenderSettings, *Framebuffer) {
+	t.Helper()
+	sf, err := LoadSceneFile(writeBundleTestScene(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := sf.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := DefaultRenderSettings.Merge(RenderSettings{Width: 24, Height: 16, Samples: 16, MinSamples: 4, AdaptiveThreshold: 0.3, BucketSize: 8, Workers: 2, Seed: 2})
+	scene, err := sf.Build(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bundle, settings, want
+}
+
+// startWorker starts a WorkerServer, or handler in front of one, and returns
+// its address.
+func startWorker(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, worker http.Handler)) string {
+	t.Helper()
+	worker := NewWorkerServer(t.TempDir(), 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler == nil {
+			worker.ServeHTTP(w, r)
+			return
+		}
+		handler(w, r, worker)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestRenderRemoteMatchesRenderScene(t *testing.T) {
+	bundle, settings, want := remoteTestRender(t)
+	addrs := []string{startWorker(t, nil), startWorker(t, nil)}
+
+	samples := NewSampleMap(settings.Width, settings.Height)
+	pixels := make(chan Pixel, settings.Width*settings.Height)
+	fb, stats, err := RenderRemote(context.Background(), bundle, settings, addrs, RenderOptions{SampleMap: samples, Pixels: pixels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fb.pix, want.pix) {
+		t.Error("the distributed render differs from the local one")
+	}
+	if stats.Pixels != 24*16 || len(pixels) != 24*16 {
+		t.Errorf("Pixels = %d with %d sent, want 384", stats.Pixels, len(pixels))
+	}
+	if stats.Samples == 0 || stats.Rays == 0 {
+		t.Errorf("Samples = %d, Rays = %d, want the work of the workers", stats.Samples, stats.Rays)
+	}
+	if samples.Max() != settings.Samples {
+		t.Errorf("most samples in a pixel = %d, want %d", samples.Max(), settings.Samples)
+	}
+	workers := map[int]bool{}
+	for _, b := range stats.Buckets {
+		workers[b.Worker] = true
+	}
+	if len(stats.Buckets) != 6 || len(workers) != 2 {
+		t.Errorf("%d buckets rendered by %d workers, want 6 by 2", len(stats.Buckets), len(workers))
+	}
+}
+
+func TestRenderRemoteRetriesFailedWorkers(t *testing.T) {
+	bundle, settings, want := remoteTestRender(t)
+
+	var dying atomic.Int64
+	addrs := []string{
+		// A worker that always fails.
+		startWorker(t, func(w http.ResponseWriter, _ *http.Request, _ http.Handler) {
+			http.Error(w, "out of memory", http.StatusInternalServerError)
+		}),
+		// A worker that dies after its first bucket, dropping the connection.
+		startWorker(t, func(w http.ResponseWriter, r *http.Request, worker http.Handler) {
+			if r.URL.Path == "/render" && dying.Add(1) > 1 {
+				panic(http.ErrAbortHandler)
+			}
+			worker.ServeHTTP(w, r)
+		}),
+		startWorker(t, nil),
+	}
+
+	fb, stats, err := RenderRemote(context.Background(), bundle, settings, addrs, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fb.pix, want.pix) {
+		t.Error("the distributed render differs from the local one")
+	}
+	for _, b := range stats.Buckets {
+		if b.Worker == 0 {
+			t.Errorf("bucket %v was rendered by the failing worker", b.Bounds)
+		}
+	}
+}
+
+func TestRenderRemoteTimesOutHungWorkers(t *testing.T) {
+	bundle, settings, want := remoteTestRender(t)
+	hang := func(_ http.ResponseWriter, r *http.Request, _ http.Handler) {
+		// The connection is only watched for closing once the body is read.
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}
+	fb, stats, err := renderRemote(context.Background(), bundle, settings, []string{startWorker(t, hang), startWorker(t, nil)}, RenderOptions{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(fb.pix, want.pix) {
+		t.Error("the distributed render differs from the local one")
+	}
+	for _, b := range stats.Buckets {
+		if b.Worker == 0 {
+			t.Errorf("bucket %v was rendered by the hung worker", b.Bounds)
+		}
+	}
+}
+
+func TestRegionResultNonFinite(t *testing.T) {
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	result := &regionResult{Pixels: []float32{nan, inf, -inf, 0.5, 1, 2}, Samples: []int{3, 4}, Rays: 7, NodeVisits: 8, PrimitiveTests: 9}
+	var buf bytes.Buffer
+	if err := writeRegionResult(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	got, err := readRegionResult(bytes.NewReader(data), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range result.Pixels {
+		if math.Float32bits(got.Pixels[i]) != math.Float32bits(v) {
+			t.Errorf("Pixels[%d] = %v, want %v", i, got.Pixels[i], v)
+		}
+	}
+	if !slices.Equal(got.Samples, result.Samples) || got.Rays != 7 || got.NodeVisits != 8 || got.PrimitiveTests != 9 {
+		t.Errorf("read %+v, want %+v", got, result)
+	}
+
+	for _, n := range []int{1, 3} {
+		if _, err := readRegionResult(bytes.NewReader(data), n); err == nil {
+			t.Errorf("reading the result of 2 pixels as %d succeeded", n)
+		}
+	}
+}
+
+func TestRenderRemoteReuploadsLostFiles(t *testing.T) {
+	bundle, settings, want := remoteTestRender(t)
+	dir := t.TempDir()
+	worker := NewWorkerServer(dir, 2)
+	var uploaded, lost atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The unpacked files go missing before the first scene is built.
+		if r.URL.Path == "/render" && uploaded.Load() && lost.CompareAndSwap(false, true) {
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				os.RemoveAll(filepath.Join(dir, e.Name()))
+			}
+		}
+		worker.ServeHTTP(w, r)
+		if r.Method == http.MethodPut {
+			uploaded.Store(true)
+		}
+	}))
+	defer server.Close()
+
+	fb, _, err := RenderRemote(context.Background(), bundle, settings, []string{strings.TrimPrefix(server.URL, "http://")}, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lost.Load() {
+		t.Fatal("the files were never removed")
+	}
+	if !slices.Equal(fb.pix, want.pix) {
+		t.Error("the distributed render differs from the local one")
+	}
+}
+
+func TestWorkerServerKeepsFilesOfRunningBuilds(t *testing.T) {
+	bundle, _, _ := remoteTestRender(t)
+	worker := NewWorkerServer(t.TempDir(), 1)
+	upload := func(b *SceneBundle) {
+		t.Helper()
+		body, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		worker.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/scenes/"+b.ID(), bytes.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PUT /scenes: %d: %s", rec.Code, rec.Body)
+		}
+	}
+	upload(bundle)
+	first := worker.bundles[0]
+	first.builds++ // a build in progress
+
+	// Uploading more scenes evicts the first, but leaves its files to the
+	// build until it ends.
+	for i := 1; i <= maxWorkerBundles; i++ {
+		other := *bundle
+		other.Scene = append(slices.Clone(bundle.Scene), strings.Repeat(" ", i)...)
+		upload(&other)
+	}
+	if !first.dropped || slices.Contains(worker.bundles, first) {
+		t.Fatal("the first bundle was not evicted")
+	}
+	if _, err := os.Stat(first.dir); err != nil {
+		t.Fatalf("the files of a bundle being built were removed: %v", err)
+	}
+	worker.release(first, nil)
+	if _, err := os.Stat(first.dir); !os.IsNotExist(err) {
+		t.Errorf("the files of an evicted bundle were kept after its build: %v", err)
+	}
+}
+
+func TestRenderRemoteEveryWorkerFails(t *testing.T) {
+	bundle, settings, _ := remoteTestRender(t)
+	fail := func(w http.ResponseWriter, _ *http.Request, _ http.Handler) {
+		http.Error(w, "out of memory", http.StatusInternalServerError)
+	}
+	_, _, err := RenderRemote(context.Background(), bundle, settings, []string{startWorker(t, fail), startWorker(t, fail)}, RenderOptions{})
+	if err == nil || !strings.Contains(err.Error(), "out of memory") {
+		t.Errorf("error = %v, want the workers' error", err)
+	}
+}
+
+func TestRenderRemoteSceneError(t *testing.T) {
+	bundle := &SceneBundle{Scene: []byte(strings.Replace(minimalScene, "%s", "", 1))}
+	settings := DefaultRenderSettings.Merge(RenderSettings{Width: 8, Height: 8, Samples: 1})
+	_, _, err := RenderRemote(context.Background(), bundle, settings, []string{startWorker(t, nil)}, RenderOptions{})
+	if err == nil || !strings.Contains(err.Error(), "scene has no objects") {
+		t.Errorf("error = %v, want the scene error", err)
+	}
+}
@@ -0,0 +1,230 @@
+package rendim
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Environment is light arriving from infinitely far away, seen by every ray
+// that leaves the scene. The MIS integrator samples it like the other lights.
+type Environment interface {
+	// Radiance returns the light arriving from direction.
+	Radiance(direction Vec3d) Color
+	// SampleDirection returns a random direction and the density of picking
+	// it with respect to solid angle.
+	SampleDirection(rng *RNG) (Vec3d, float64)
+	// PDFValue returns the density with which SampleDirection picks direction.
+	PDFValue(direction Vec3d) float64
+}
+
+const uniformSpherePDF = 1.0 / (4.0 * math.Pi)
+
+// ConstantSky is the same colour in every direction.
+type ConstantSky struct {
+	color Color
+}
+
+// NewConstantSky creates an environment of uniform radiance c.
+func NewConstantSky(c Color) ConstantSky {
+	return ConstantSky{color: c}
+}
+
+func (s ConstantSky) Radiance(direction Vec3d) Color {
+	return s.color
+}
+
+func (s ConstantSky) SampleDirection(rng *RNG) (Vec3d, float64) {
+	return randomUnitVector(rng), uniformSpherePDF
+}
+
+func (s ConstantSky) PDFValue(direction Vec3d) float64 {
+	return uniformSpherePDF
+}
+
+// GradientSky blends from bottom straight down to top straight up, like the
+// background of the first book.
+type GradientSky struct {
+	bottom, top Color
+}
+
+// NewGradientSky creates a sky that fades from bottom to top with the height
+// of the direction.
+func NewGradientSky(bottom, top Color) GradientSky {
+	return GradientSky{bottom: bottom, top: top}
+}
+
+func (s GradientSky) Radiance(direction Vec3d) Color {
+	t := 0.5 * (direction.UnitVector().Y() + 1.0)
+	return s.bottom.MultiplyScalar(1.0 - t).Add(s.top.MultiplyScalar(t))
+}
+
+func (s GradientSky) SampleDirection(rng *RNG) (Vec3d, float64) {
+	return randomUnitVector(rng), uniformSpherePDF
+}
+
+func (s GradientSky) PDFValue(direction Vec3d) float64 {
+	return uniformSpherePDF
+}
+
+// EnvironmentMap is an equirectangular image around the scene: the columns
+// cover the full circle around the y axis and the rows run from straight up
+// to straight down. Directions are sampled in proportion to the luminance of
+// the pixels, so that small bright areas such as the sun are found quickly.
+type EnvironmentMap struct {
+	image     *Framebuffer
+	rotation  float64 // radians around the y axis
+	intensity float64
+	marginal  distribution1D   // over rows
+	rows      []distribution1D // over the columns of each row
+}
+
+// NewEnvironmentMap creates an environment from an equirectangular image,
+// turned by rotation degrees around the y axis in the same sense as RotateY
+// and with its radiance scaled by intensity.
+func NewEnvironmentMap(image *Framebuffer, rotation, intensity float64) EnvironmentMap {
+	w, h := image.Width(), image.Height()
+	e := EnvironmentMap{image: image, rotation: rotation * math.Pi / 180.0, intensity: intensity, rows: make([]distribution1D, h)}
+
+	weights := make([]float64, w)
+	rowWeights := make([]float64, h)
+	for y := 0; y < h; y++ {
+		// Rows near the poles cover less solid angle.
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(h))
+		for x := 0; x < w; x++ {
+			weights[x] = math.Max(0.0, luminance(image.At(x, y))) * sinTheta
+		}
+		e.rows[y] = newDistribution1D(weights)
+		rowWeights[y] = e.rows[y].total
+	}
+	e.marginal = newDistribution1D(rowWeights)
+	return e
+}
+
+// LoadEnvironmentMap reads a Radiance HDR (.hdr) or PFM (.pfm) image and
+// creates an EnvironmentMap from it.
+func LoadEnvironmentMap(path string, rotation, intensity float64) (EnvironmentMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return EnvironmentMap{}, err
+	}
+	defer f.Close()
+
+	var image *Framebuffer
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".hdr":
+		image, err = ReadHDR(f)
+	case ".pfm":
+		image, err = ReadPFM(f)
+	default:
+		return EnvironmentMap{}, fmt.Errorf("unsupported environment map format %q (want .hdr or .pfm)", ext)
+	}
+	if err != nil {
+		return EnvironmentMap{}, fmt.Errorf("cannot decode %s: %w", path, err)
+	}
+	return NewEnvironmentMap(image, rotation, intensity), nil
+}
+
+func (e EnvironmentMap) Radiance(direction Vec3d) Color {
+	x, y, _ := e.pixel(direction)
+	return e.image.At(x, y).MultiplyScalar(e.intensity)
+}
+
+func (e EnvironmentMap) SampleDirection(rng *RNG) (Vec3d, float64) {
+	if e.marginal.total <= 0.0 {
+		return Vec3d{}, 0.0
+	}
+	u1, u2 := rng.Float64Pair()
+	v, y, rowPDF := e.marginal.sample(u1)
+	u, _, columnPDF := e.rows[y].sample(u2)
+
+	theta := math.Pi * v / float64(e.image.Height())
+	phi := math.Pi - 2.0*math.Pi*u/float64(e.image.Width()) - e.rotation
+	sinTheta := math.Sin(theta)
+	if sinTheta <= 0.0 {
+		return Vec3d{}, 0.0
+	}
+	direction := NewVec3d(sinTheta*math.Cos(phi), math.Cos(theta), sinTheta*math.Sin(phi))
+	return direction, rowPDF * columnPDF / (2.0 * math.Pi * math.Pi * sinTheta)
+}
+
+func (e EnvironmentMap) PDFValue(direction Vec3d) float64 {
+	if e.marginal.total <= 0.0 {
+		return 0.0
+	}
+	x, y, sinTheta := e.pixel(direction)
+	if sinTheta <= 0.0 {
+		return 0.0
+	}
+	return e.marginal.pdf(y) * e.rows[y].pdf(x) / (2.0 * math.Pi * math.Pi * sinTheta)
+}
+
+// pixel returns the pixel seen in direction, using the same longitude
+// convention as sphere texture coordinates.
+func (e EnvironmentMap) pixel(direction Vec3d) (x, y int, sinTheta float64) {
+	d := direction.UnitVector()
+	cosTheta := math.Max(-1.0, math.Min(1.0, d.Y()))
+	phi := math.Atan2(d.Z(), d.X()) + e.rotation
+	u := 0.5 - phi/(2.0*math.Pi)
+	u -= math.Floor(u)
+	v := math.Acos(cosTheta) / math.Pi
+
+	w, h := e.image.Width(), e.image.Height()
+	x = min(int(u*float64(w)), w-1)
+	y = min(int(v*float64(h)), h-1)
+	return x, y, math.Sqrt(1.0 - cosTheta*cosTheta)
+}
+
+// distribution1D is a piecewise constant density over [0, n) proportional to
+// n non-negative weights.
+type distribution1D struct {
+	weights []float64
+	cdf     []float64 // n+1 entries from 0 to 1
+	total   float64
+}
+
+func newDistribution1D(weights []float64) distribution1D {
+	d := distribution1D{weights: append([]float64(nil), weights...), cdf: make([]float64, len(weights)+1)}
+	for i, w := range weights {
+		d.cdf[i+1] = d.cdf[i] + w
+	}
+	d.total = d.cdf[len(weights)]
+	if d.total > 0.0 {
+		for i := range d.cdf {
+			d.cdf[i] /= d.total
+		}
+	}
+	return d
+}
+
+// sample maps u in [0, 1) to a position in [0, n), returning also the index
+// of its segment and the density there.
+func (d distribution1D) sample(u float64) (float64, int, float64) {
+	// The last segment whose start is not above u.
+	i := sort.SearchFloat64s(d.cdf, u)
+	if i > 0 && (i == len(d.cdf) || d.cdf[i] > u) {
+		i--
+	}
+	// Skip empty segments, which can share their start with the next one.
+	for i < len(d.weights)-1 && d.weights[i] == 0.0 {
+		i++
+	}
+	i = min(i, len(d.weights)-1)
+
+	offset := 0.0
+	if width := d.cdf[i+1] - d.cdf[i]; width > 0.0 {
+		offset = math.Min((u-d.cdf[i])/width, math.Nextafter(1.0, 0.0))
+	}
+	return float64(i) + math.Max(0.0, offset), i, d.pdf(i)
+}
+
+// pdf returns the density of segment i relative to a uniform distribution.
+func (d distribution1D) pdf(i int) float64 {
+	if d.total <= 0.0 {
+		return 0.0
+	}
+	return d.weights[i] * float64(len(d.weights)) / d.total
+}
@@ -0,0 +1,225 @@
+package rendim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sunMap returns a dim equirectangular map with one very bright pixel above
+// the horizon.
+func sunMap() *Framebuffer {
+	fb := NewFramebuffer(64, 32)
+	for y := 0; y < fb.Height(); y++ {
+		for x := 0; x < fb.Width(); x++ {
+			fb.Set(x, y, Color{R: 0.1, G: 0.1, B: 0.1})
+		}
+	}
+	fb.Set(40, 6, Color{R: 2000.0, G: 2000.0, B: 2000.0})
+	return fb
+}
+
+func TestDistribution1D(t *testing.T) {
+	d := newDistribution1D([]float64{1.0, 0.0, 3.0, 0.0})
+	if d.total != 4.0 {
+		t.Fatalf("total = %v, want 4", d.total)
+	}
+	if got := d.pdf(2); got != 3.0 {
+		t.Errorf("pdf(2) = %v, want 3", got)
+	}
+
+	counts := make([]int, 4)
+	const n = 10000
+	for i := 0; i < n; i++ {
+		x, idx, pdf := d.sample((float64(i) + 0.5) / n)
+		if int(x) != idx || pdf != d.pdf(idx) {
+			t.Fatalf("sample() = %v, %d, %v, inconsistent", x, idx, pdf)
+		}
+		counts[idx]++
+	}
+	if counts[1] != 0 || counts[3] != 0 || counts[0] != n/4 || counts[2] != 3*n/4 {
+		t.Errorf("counts = %v, want [%d 0 %d 0]", counts, n/4, 3*n/4)
+	}
+}
+
+func TestEnvironmentMapPDF(t *testing.T) {
+	env := NewEnvironmentMap(testFramebuffer(16, 8), 30.0, 1.0)
+	rng := NewRNG(5)
+
+	// Sampled directions must report the same density as PDFValue.
+	for i := 0; i < 1000; i++ {
+		d, pdf := env.SampleDirection(rng)
+		if pdf <= 0.0 {
+			t.Fatalf("SampleDirection() pdf = %v", pdf)
+		}
+		if got := env.PDFValue(d); math.Abs(got-pdf) > 1e-6*pdf {
+			t.Fatalf("PDFValue(%v) = %v, SampleDirection() said %v", d, got, pdf)
+		}
+	}
+
+	// The density integrates to one over the sphere.
+	const n = 200000
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		sum += env.PDFValue(randomUnitVector(rng)) / uniformSpherePDF
+	}
+	if mean := sum / n; math.Abs(mean-1.0) > 0.05 {
+		t.Errorf("integral of PDFValue = %v, want 1", mean)
+	}
+}
+
+func TestEnvironmentMapFindsSun(t *testing.T) {
+	env := NewEnvironmentMap(sunMap(), 0.0, 1.0)
+	rng := NewRNG(9)
+	bright := 0
+	for i := 0; i < 1000; i++ {
+		d, _ := env.SampleDirection(rng)
+		if env.Radiance(d).G > 1.0 {
+			bright++
+		}
+	}
+	// The sun carries about 90% of the weighted luminance.
+	if bright < 850 {
+		t.Errorf("%d of 1000 samples found the sun, want most", bright)
+	}
+}
+
+func TestEnvironmentMapRotation(t *testing.T) {
+	fb := NewFramebuffer(4, 2)
+	fb.Set(0, 0, Color{R: 1.0})
+	fb.Set(1, 0, Color{G: 1.0})
+	env := NewEnvironmentMap(fb, 0.0, 2.0)
+	rotated := NewEnvironmentMap(fb, 90.0, 2.0)
+
+	d := NewVec3d(1.0, 0.5, 0.2)
+	// Turning the map by 90 degrees shows at d what it showed 90 degrees
+	// further round before.
+	turned := NewVec3d(-d.Z(), d.Y(), d.X())
+	if got, want := rotated.Radiance(d), env.Radiance(turned); got != want {
+		t.Errorf("rotated Radiance() = %v, want %v", got, want)
+	}
+	if got := env.Radiance(NewVec3d(-1.0, 1.0, 0.1)); got != (Color{R: 2.0}) {
+		t.Errorf("Radiance() = %v, want the top left pixel scaled by the intensity", got)
+	}
+}
+
+func TestGradientSky(t *testing.T) {
+	sky := NewGradientSky(Color{R: 1.0, G: 1.0, B: 1.0}, Color{R: 0.5, G: 0.7, B: 1.0})
+	if got := sky.Radiance(NewVec3d(0.0, 3.0, 0.0)); !colorEqual(got, Color{R: 0.5, G: 0.7, B: 1.0}) {
+		t.Errorf("Radiance(up) = %v, want the top colour", got)
+	}
+	if got := sky.Radiance(NewVec3d(2.0, 0.0, 0.0)); !colorEqual(got, Color{R: 0.75, G: 0.85, B: 1.0}) {
+		t.Errorf("Radiance(horizon) = %v, want the mid colour", got)
+	}
+}
+
+// TestEnvironmentFurnace checks that a convex diffuse object under uniform
+// light reflects exactly its albedo, with every kind of environment.
+func TestEnvironmentFurnace(t *testing.T) {
+	uniform := NewFramebuffer(8, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			uniform.Set(x, y, Color{R: 0.5, G: 0.5, B: 0.5})
+		}
+	}
+	envs := map[string]Environment{
+		"constant": NewConstantSky(Color{R: 1.0, G: 1.0, B: 1.0}),
+		"gradient": NewGradientSky(Color{R: 1.0, G: 1.0, B: 1.0}, Color{R: 1.0, G: 1.0, B: 1.0}),
+		"map":      NewEnvironmentMap(uniform, 45.0, 2.0),
+	}
+	sphere := NewSphere(NewVec3d(0.0, 0.0, 0.0), 1.0, NewLambertian(NewConstantTexture(Color{R: 0.6, G: 0.6, B: 0.6})))
+	ray := NewRay(NewVec3d(0.0, 0.0, -5.0), NewVec3d(0.05, 0.1, 1.0), 0.0)
+
+	for name, env := range envs {
+		scene := NewScene(Camera{}, HitableList{sphere}).WithEnvironment(env)
+		for _, integratorName := range []string{IntegratorPath, IntegratorMIS} {
+			radiance := integrator(integratorName)
+			rng := NewRNG(2)
+			const samples = 4000
+			sum := 0.0
+			for i := 0; i < samples; i++ {
+				sum += radiance(ray, &scene, rng, nil).G
+			}
+			if mean := sum / samples; math.Abs(mean-0.6) > 0.02 {
+				t.Errorf("%s/%s: mean radiance = %v, want 0.6", name, integratorName, mean)
+			}
+		}
+	}
+}
+
+func TestEnvironmentMapSunConverges(t *testing.T) {
+	const albedo = 0.5
+	fb := sunMap()
+	env := NewEnvironmentMap(fb, 0.0, 1.0)
+	floor := NewXZRect(-100.0, 100.0, -100.0, 100.0, 0.0, NewLambertian(NewConstantTexture(Color{R: albedo, G: albedo, B: albedo})))
+	scene := NewScene(Camera{}, HitableList{floor}).WithEnvironment(env)
+
+	// Irradiance at the floor from the upper half of the map, integrating
+	// cos(theta) over each row analytically.
+	w, h := fb.Width(), fb.Height()
+	irradiance := 0.0
+	for y := 0; y < h/2; y++ {
+		s0 := math.Sin(math.Pi * float64(y) / float64(h))
+		s1 := math.Sin(math.Pi * float64(y+1) / float64(h))
+		for x := 0; x < w; x++ {
+			irradiance += fb.At(x, y).G * 2.0 * math.Pi / float64(w) * (s1*s1 - s0*s0) / 2.0
+		}
+	}
+	want := albedo / math.Pi * irradiance
+
+	ray := NewRay(NewVec3d(0.0, 1.0, -1.0), NewVec3d(0.0, -1.0, 1.0), 0.0)
+	stats := func(radiance radianceFunc) (float64, float64) {
+		rng := NewRNG(4)
+		const samples = 5000
+		sum, sumSquares := 0.0, 0.0
+		for i := 0; i < samples; i++ {
+			l := radiance(ray, &scene, rng, nil).G
+			sum += l
+			sumSquares += l * l
+		}
+		mean := sum / samples
+		return mean, sumSquares/samples - mean*mean
+	}
+
+	mean, misVariance := stats(misRadiance)
+	if math.Abs(mean-want) > 0.03*want {
+		t.Errorf("MIS mean radiance = %v, want %v", mean, want)
+	}
+	if _, pathVariance := stats(pathRadiance); misVariance >= pathVariance/10 {
+		t.Errorf("MIS variance = %v, want far below path tracing variance %v", misVariance, pathVariance)
+	}
+}
+
+func TestLoadEnvironmentMap(t *testing.T) {
+	dir := t.TempDir()
+	fb := testFramebuffer(6, 3)
+	for _, name := range []string{"sky.hdr", "sky.pfm"} {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "sky.hdr" {
+			err = WriteHDR(f, fb)
+		} else {
+			err = WritePFM(f, fb)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		env, err := LoadEnvironmentMap(path, 0.0, 1.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.image.Width() != 6 || env.image.Height() != 3 {
+			t.Errorf("%s: size = %dx%d, want 6x3", name, env.image.Width(), env.image.Height())
+		}
+	}
+
+	if _, err := LoadEnvironmentMap(filepath.Join(dir, "sky.png"), 0.0, 1.0); err == nil {
+		t.Error("LoadEnvironmentMap() should reject unsupported formats")
+	}
+}
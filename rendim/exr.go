@@ -0,0 +1,182 @@
+package rendim
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// EXRCompression selects how WriteEXR compresses the pixel data.
+type EXRCompression int
+
+const (
+	// EXRNone stores the scanlines uncompressed.
+	EXRNone EXRCompression = iota
+	// EXRZIP deflates blocks of 16 scanlines.
+	EXRZIP
+)
+
+// Values from the OpenEXR file layout.
+const (
+	exrMagic          = 20000630
+	exrVersion        = 2
+	exrCodeNone       = 0
+	exrCodeZIP        = 3
+	exrPixelTypeFloat = 2
+)
+
+func (c EXRCompression) code() byte {
+	if c == EXRZIP {
+		return exrCodeZIP
+	}
+	return exrCodeNone
+}
+
+func (c EXRCompression) linesPerBlock() int {
+	if c == EXRZIP {
+		return 16
+	}
+	return 1
+}
+
+// WriteEXR writes fb as a single-part scanline OpenEXR image with 32-bit
+// float R, G and B channels.
+func WriteEXR(w io.Writer, fb *Framebuffer, compression EXRCompression) error {
+	if compression != EXRNone && compression != EXRZIP {
+		return fmt.Errorf("unsupported EXR compression %d", compression)
+	}
+
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	writeInt32 := func(v int32) { _ = binary.Write(&buf, le, v) }
+	attribute := func(name, typ string, size int) {
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		buf.WriteString(typ)
+		buf.WriteByte(0)
+		writeInt32(int32(size)) //nolint:gosec // G115: attribute sizes are small constants
+	}
+
+	writeInt32(exrMagic)
+	writeInt32(exrVersion)
+
+	// Channels must be listed in alphabetical order.
+	channels := []string{"B", "G", "R"}
+	attribute("channels", "chlist", len(channels)*18+1)
+	for _, ch := range channels {
+		buf.WriteString(ch)
+		buf.WriteByte(0)
+		writeInt32(exrPixelTypeFloat)
+		buf.Write([]byte{0, 0, 0, 0}) // pLinear and reserved
+		writeInt32(1)                 // x sampling
+		writeInt32(1)                 // y sampling
+	}
+	buf.WriteByte(0)
+
+	attribute("compression", "compression", 1)
+	buf.WriteByte(compression.code())
+
+	maxX, maxY := int32(fb.width-1), int32(fb.height-1) //nolint:gosec // G115: image sizes fit in int32
+	for _, name := range []string{"dataWindow", "displayWindow"} {
+		attribute(name, "box2i", 16)
+		writeInt32(0)
+		writeInt32(0)
+		writeInt32(maxX)
+		writeInt32(maxY)
+	}
+
+	attribute("lineOrder", "lineOrder", 1)
+	buf.WriteByte(0) // increasing y
+
+	attribute("pixelAspectRatio", "float", 4)
+	_ = binary.Write(&buf, le, float32(1.0))
+
+	attribute("screenWindowCenter", "v2f", 8)
+	_ = binary.Write(&buf, le, [2]float32{0.0, 0.0})
+
+	attribute("screenWindowWidth", "float", 4)
+	_ = binary.Write(&buf, le, float32(1.0))
+
+	buf.WriteByte(0) // end of header
+
+	blockHeight := compression.linesPerBlock()
+	blocks := (fb.height + blockHeight - 1) / blockHeight
+
+	// The offset table is filled in once the block sizes are known.
+	tableStart := buf.Len()
+	buf.Write(make([]byte, 8*blocks))
+
+	raw := make([]byte, 0, 4*3*fb.width*blockHeight)
+	for b := 0; b < blocks; b++ {
+		le.PutUint64(buf.Bytes()[tableStart+8*b:], uint64(buf.Len())) //nolint:gosec // G115: offsets are positive
+
+		y0 := b * blockHeight
+		y1 := min(y0+blockHeight, fb.height)
+		raw = raw[:0]
+		for y := y0; y < y1; y++ {
+			row := fb.pix[3*y*fb.width : 3*(y+1)*fb.width]
+			// B, G, R planes, matching the channel list.
+			for c := 2; c >= 0; c-- {
+				for x := 0; x < fb.width; x++ {
+					raw = le.AppendUint32(raw, math.Float32bits(row[3*x+c]))
+				}
+			}
+		}
+
+		data := raw
+		if compression == EXRZIP {
+			compressed, err := exrZIP(raw)
+			if err != nil {
+				return err
+			}
+			// Blocks that do not shrink are stored as they are.
+			if len(compressed) < len(raw) {
+				data = compressed
+			}
+		}
+
+		writeInt32(int32(y0))        //nolint:gosec // G115: image sizes fit in int32
+		writeInt32(int32(len(data))) //nolint:gosec // G115: block sizes fit in int32
+		buf.Write(data)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// exrZIP applies the OpenEXR ZIP predictor: the bytes are split into even and
+// odd halves, delta encoded and then deflated with a zlib wrapper.
+func exrZIP(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	tmp := make([]byte, len(raw))
+	half := (len(raw) + 1) / 2
+	for i, v := range raw {
+		if i%2 == 0 {
+			tmp[i/2] = v
+		} else {
+			tmp[half+i/2] = v
+		}
+	}
+
+	prev := tmp[0]
+	for i := 1; i < len(tmp); i++ {
+		d := int(tmp[i]) - int(prev) + (128 + 256)
+		prev = tmp[i]
+		tmp[i] = byte(d)
+	}
+
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	if _, err := zw.Write(tmp); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
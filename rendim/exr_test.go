@@ -0,0 +1,148 @@
+package rendim
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// readEXR decodes the single-part scanline files that WriteEXR produces and
+// returns the header attributes by name.
+func readEXR(t *testing.T, data []byte) (map[string][]byte, [][]Color) {
+	t.Helper()
+	le := binary.LittleEndian
+	if le.Uint32(data) != exrMagic || le.Uint32(data[4:]) != exrVersion {
+		t.Fatalf("magic and version = %x %x", data[:4], data[4:8])
+	}
+
+	pos := 8
+	readString := func() string {
+		end := bytes.IndexByte(data[pos:], 0)
+		s := string(data[pos : pos+end])
+		pos += end + 1
+		return s
+	}
+	attrs := map[string][]byte{}
+	for {
+		name := readString()
+		if name == "" {
+			break
+		}
+		readString() // type
+		size := int(le.Uint32(data[pos:]))
+		attrs[name] = data[pos+4 : pos+4+size]
+		pos += 4 + size
+	}
+
+	window := attrs["dataWindow"]
+	width := int(int32(le.Uint32(window[8:]))) + 1
+	height := int(int32(le.Uint32(window[12:]))) + 1
+	linesPerBlock := 1
+	if attrs["compression"][0] == exrCodeZIP {
+		linesPerBlock = 16
+	}
+	blocks := (height + linesPerBlock - 1) / linesPerBlock
+
+	image := make([][]Color, height)
+	for b := 0; b < blocks; b++ {
+		offset := int(le.Uint64(data[pos+8*b:]))
+		y0 := int(int32(le.Uint32(data[offset:])))
+		size := int(le.Uint32(data[offset+4:]))
+		block := data[offset+8 : offset+8+size]
+		lines := min(linesPerBlock, height-y0)
+
+		if rawSize := 4 * 3 * width * lines; size < rawSize {
+			zr, err := zlib.NewReader(bytes.NewReader(block))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmp, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i < len(tmp); i++ {
+				tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+			}
+			block = make([]byte, len(tmp))
+			half := (len(tmp) + 1) / 2
+			for i := range block {
+				if i%2 == 0 {
+					block[i] = tmp[i/2]
+				} else {
+					block[i] = tmp[half+i/2]
+				}
+			}
+		}
+
+		for l := 0; l < lines; l++ {
+			row := make([]Color, width)
+			plane := func(c int) []byte { return block[4*width*(3*l+c):] }
+			for x := range row {
+				row[x] = Color{
+					B: float64(math.Float32frombits(le.Uint32(plane(0)[4*x:]))),
+					G: float64(math.Float32frombits(le.Uint32(plane(1)[4*x:]))),
+					R: float64(math.Float32frombits(le.Uint32(plane(2)[4*x:]))),
+				}
+			}
+			image[y0+l] = row
+		}
+	}
+	return attrs, image
+}
+
+func TestWriteEXR(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression EXRCompression
+		code        byte
+	}{
+		{"none", EXRNone, exrCodeNone},
+		{"zip", EXRZIP, exrCodeZIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 37 rows leave a partial block at the end for ZIP.
+			fb := testFramebuffer(23, 37)
+			var buf bytes.Buffer
+			if err := WriteEXR(&buf, fb, tt.compression); err != nil {
+				t.Fatal(err)
+			}
+
+			attrs, image := readEXR(t, buf.Bytes())
+			for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow", "lineOrder", "pixelAspectRatio", "screenWindowCenter", "screenWindowWidth"} {
+				if _, ok := attrs[name]; !ok {
+					t.Errorf("required attribute %q is missing", name)
+				}
+			}
+			if got := attrs["compression"][0]; got != tt.code {
+				t.Errorf("compression = %d, want %d", got, tt.code)
+			}
+
+			for y := 0; y < fb.Height(); y++ {
+				for x := 0; x < fb.Width(); x++ {
+					if got, want := image[y][x], fb.At(x, y); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteEXRZIPIsSmaller(t *testing.T) {
+	fb := NewFramebuffer(64, 64)
+	var plain, zipped bytes.Buffer
+	if err := WriteEXR(&plain, fb, EXRNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEXR(&zipped, fb, EXRZIP); err != nil {
+		t.Fatal(err)
+	}
+	if zipped.Len() >= plain.Len()/4 {
+		t.Errorf("ZIP file is %d bytes, uncompressed %d; want much smaller for a black image", zipped.Len(), plain.Len())
+	}
+}
@@ -0,0 +1,50 @@
+package rendim
+
+import "image"
+
+// Framebuffer holds the linear radiance of every pixel of a render, without
+// any clamping, gamma or quantisation. Rows are stored top to bottom.
+type Framebuffer struct {
+	width, height int
+	pix           []float32 // R, G, B per pixel
+}
+
+// NewFramebuffer creates a black framebuffer of the given size.
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{width: width, height: height, pix: make([]float32, 3*width*height)}
+}
+
+// Width returns the width in pixels.
+func (fb *Framebuffer) Width() int {
+	return fb.width
+}
+
+// Height returns the height in pixels.
+func (fb *Framebuffer) Height() int {
+	return fb.height
+}
+
+// At returns the radiance of pixel (x, y).
+func (fb *Framebuffer) At(x, y int) Color {
+	i := 3 * (y*fb.width + x)
+	return Color{R: float64(fb.pix[i]), G: float64(fb.pix[i+1]), B: float64(fb.pix[i+2])}
+}
+
+// Set stores the radiance of pixel (x, y).
+func (fb *Framebuffer) Set(x, y int, c Color) {
+	i := 3 * (y*fb.width + x)
+	fb.pix[i] = float32(c.R)
+	fb.pix[i+1] = float32(c.G)
+	fb.pix[i+2] = float32(c.B)
+}
+
+// Image converts the framebuffer to 8-bit sRGB colour with tm.
+func (fb *Framebuffer) Image(tm ToneMap) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, fb.width, fb.height))
+	for y := 0; y < fb.height; y++ {
+		for x := 0; x < fb.width; x++ {
+			img.SetRGBA(x, y, tm.Apply(fb.At(x, y)))
+		}
+	}
+	return img
+}
@@ -0,0 +1,42 @@
+package rendim
+
+import "testing"
+
+// testFramebuffer returns a framebuffer with distinct, partly very bright
+// values in every pixel.
+func testFramebuffer(width, height int) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fb.Set(x, y, Color{R: float64(x) * 0.25, G: float64(y) * 10.0, B: 0.001 * float64(x+y)})
+		}
+	}
+	return fb
+}
+
+func TestFramebufferKeepsHighDynamicRange(t *testing.T) {
+	fb := NewFramebuffer(3, 2)
+	fb.Set(2, 1, Color{R: 15.0, G: 0.5, B: 1e-4})
+	if got := fb.At(2, 1); got != (Color{R: 15.0, G: 0.5, B: float64(float32(1e-4))}) {
+		t.Errorf("At() = %v, want the stored radiance", got)
+	}
+	if got := fb.At(0, 0); got != (Color{}) {
+		t.Errorf("At() of an unset pixel = %v, want black", got)
+	}
+}
+
+func TestFramebufferImage(t *testing.T) {
+	fb := NewFramebuffer(2, 1)
+	fb.Set(0, 0, Color{R: 0.25, G: 4.0, B: 0.0})
+	img := fb.Image(ToneMap{})
+
+	c := img.RGBAAt(0, 0)
+	if c.R != 137 || c.G != 255 || c.B != 0 || c.A != 255 {
+		t.Errorf("Image() pixel = %v, want sRGB-encoded and clamped {137 255 0 255}", c)
+	}
+
+	img = fb.Image(ToneMap{Exposure: -1.0})
+	if c := img.RGBAAt(0, 0); c.R != 99 {
+		t.Errorf("Image() red at exposure -1 = %d, want 99", c.R)
+	}
+}
@@ -3,7 +3,7 @@ package rendim
 import "math"
 
 type Hitable interface {
-	Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord)
+	Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord)
 	BoundingBox(t0, t1 float64, box *AABB) bool
 }
 
@@ -17,12 +17,12 @@ type HitRecord struct {
 
 type HitableList []Hitable
 
-func (hl HitableList) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (hl HitableList) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
 	hitAnything := false
 	closestSoFar := tMax
 	rec := HitRecord{}
 	for _, h := range hl {
-		if isHit, hr := h.Hit(r, tMin, closestSoFar); isHit {
+		if isHit, hr := h.Hit(r, tMin, closestSoFar, tc); isHit {
 			hitAnything = true
 			closestSoFar = hr.t
 
@@ -38,6 +38,26 @@ func (hl HitableList) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	return hitAnything, rec
 }
 
+// BoundingBox returns the box around every object in the list. It reports
+// false if the list is empty or any object has no box.
+func (hl HitableList) BoundingBox(t0, t1 float64, box *AABB) bool {
+	if len(hl) == 0 {
+		return false
+	}
+	var objectBox AABB
+	for i, h := range hl {
+		if !h.BoundingBox(t0, t1, &objectBox) {
+			return false
+		}
+		if i == 0 {
+			*box = objectBox
+		} else {
+			*box = surroundingBox(*box, objectBox)
+		}
+	}
+	return true
+}
+
 func (hl HitableList) Len() int {
 	return len(hl)
 }
@@ -46,8 +66,13 @@ type FlipNormals struct {
 	hitable Hitable
 }
 
-func (f FlipNormals) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
-	if isHit, rec := f.hitable.Hit(r, tMin, tMax); isHit {
+// NewFlipNormals wraps h so that its surface normals point the other way.
+func NewFlipNormals(h Hitable) FlipNormals {
+	return FlipNormals{hitable: h}
+}
+
+func (f FlipNormals) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	if isHit, rec := f.hitable.Hit(r, tMin, tMax, tc); isHit {
 		rec.Normal = rec.Normal.MultiplyScalar(-1.0)
 		return true, rec
 	}
@@ -63,9 +88,14 @@ type Translate struct {
 	offset  Vec3d
 }
 
-func (t Translate) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+// NewTranslate moves h by offset.
+func NewTranslate(h Hitable, offset Vec3d) Translate {
+	return Translate{hitable: h, offset: offset}
+}
+
+func (t Translate) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
 	movedRay := NewRay(r.Origin().Subtract(t.offset), r.Direction(), r.Time())
-	if isHit, rec := t.hitable.Hit(movedRay, tMin, tMax); isHit {
+	if isHit, rec := t.hitable.Hit(movedRay, tMin, tMax, tc); isHit {
 		rec.P = rec.P.Add(t.offset)
 		return true, rec
 	}
@@ -87,6 +117,8 @@ type RotateY struct {
 	bbox               AABB
 }
 
+// NewRotateY rotates h by angle degrees about the y axis. h must have a
+// bounding box.
 func NewRotateY(h Hitable, angle float64) RotateY {
 	ry := RotateY{hitable: h}
 	radians := (math.Pi / 180.0) * angle
@@ -121,7 +153,7 @@ func NewRotateY(h Hitable, angle float64) RotateY {
 	return ry
 }
 
-func (ry RotateY) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (ry RotateY) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
 	origin := r.Origin()
 	direction := r.Direction()
 
@@ -131,7 +163,7 @@ func (ry RotateY) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	direction.e[2] = ry.sinTheta*r.Direction().e[0] + ry.cosTheta*r.Direction().e[2]
 
 	rotatedRay := NewRay(origin, direction, r.Time())
-	if isHit, rec := ry.hitable.Hit(rotatedRay, tMin, tMax); isHit {
+	if isHit, rec := ry.hitable.Hit(rotatedRay, tMin, tMax, tc); isHit {
 		p := rec.P
 		normal := rec.Normal
 		p.e[0] = ry.cosTheta*rec.P.e[0] + ry.sinTheta*rec.P.e[2]
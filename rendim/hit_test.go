@@ -13,7 +13,7 @@ func TestHitableListHit(t *testing.T) {
 	hl := HitableList{sphere1, sphere2}
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, rec := hl.Hit(ray, 0.0, 10.0)
+	hit, rec := hl.Hit(ray, 0.0, 10.0, nil)
 	
 	if !hit {
 		t.Error("HitableList should detect hit on first sphere")
@@ -32,7 +32,7 @@ func TestHitableListNoHit(t *testing.T) {
 	hl := HitableList{sphere1, sphere2}
 	
 	ray := NewRay(NewVec3d(-5.0, 10.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, _ := hl.Hit(ray, 0.0, 100.0)
+	hit, _ := hl.Hit(ray, 0.0, 100.0, nil)
 	
 	if hit {
 		t.Error("HitableList should not detect hit when ray misses all objects")
@@ -57,8 +57,8 @@ func TestFlipNormalsHit(t *testing.T) {
 	flipped := FlipNormals{hitable: sphere}
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hitOriginal, recOriginal := sphere.Hit(ray, 0.0, 10.0)
-	hitFlipped, recFlipped := flipped.Hit(ray, 0.0, 10.0)
+	hitOriginal, recOriginal := sphere.Hit(ray, 0.0, 10.0, nil)
+	hitFlipped, recFlipped := flipped.Hit(ray, 0.0, 10.0, nil)
 	
 	if !hitOriginal || !hitFlipped {
 		t.Fatal("Both should hit")
@@ -91,7 +91,7 @@ func TestTranslateHit(t *testing.T) {
 	translated := Translate{hitable: sphere, offset: offset}
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, rec := translated.Hit(ray, 0.0, 10.0)
+	hit, rec := translated.Hit(ray, 0.0, 10.0, nil)
 	
 	if !hit {
 		t.Error("Translated sphere should be hit")
@@ -142,7 +142,7 @@ func TestRotateYHit(t *testing.T) {
 	rotated := NewRotateY(sphere, 90.0)
 	
 	ray := NewRay(NewVec3d(0.0, 0.0, -5.0), NewVec3d(0.0, 0.0, 1.0), 0.0)
-	hit, _ := rotated.Hit(ray, 0.0, 10.0)
+	hit, _ := rotated.Hit(ray, 0.0, 10.0, nil)
 	
 	if !hit {
 		t.Error("Rotated sphere should be hit from the new position")
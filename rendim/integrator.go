@@ -0,0 +1,198 @@
+package rendim
+
+import "math"
+
+// Names of the integrators that can be selected in RenderSettings.
+const (
+	// IntegratorPath follows random bounces only and finds lights when a
+	// bounce happens to hit them, scattering diffusely as the original
+	// renderer did. It is kept as a reference.
+	IntegratorPath = "path"
+	// IntegratorMIS also samples the scene lights at every diffuse bounce and
+	// combines both strategies with multiple importance sampling.
+	IntegratorMIS = "mis"
+)
+
+const maxDepth = 50
+
+// radianceFunc estimates the light arriving along r.
+type radianceFunc func(r Ray, scene *Scene, rng *RNG, tc *TraceContext) Color
+
+func integrator(name string) radianceFunc {
+	if name == IntegratorPath {
+		return pathRadiance
+	}
+	return misRadiance
+}
+
+func pathRadiance(r Ray, scene *Scene, rng *RNG, tc *TraceContext) Color {
+	return rayColor(r, scene, 0, rng, tc)
+}
+
+// misRadiance is a path tracer with next event estimation. At every bounce off
+// a PDFMaterial it traces one ray towards a sampled light or the environment
+// and one in a direction sampled from the material, and weighs the light
+// either of them finds with the power heuristic. Other materials scatter as in pathRadiance.
+func misRadiance(r Ray, scene *Scene, rng *RNG, tc *TraceContext) Color {
+	var radiance Color
+	throughput := Color{R: 1.0, G: 1.0, B: 1.0}
+	// specular is set while the last bounce could not have sampled the lights,
+	// in which case emitters that are hit count in full.
+	specular := true
+	var scatterPDF float64
+
+	for depth := 0; ; depth++ {
+		tc.countRays(1)
+		isHit, rec, weight := scene.trace(r, rng, tc)
+		throughput = throughput.Multiply(weight)
+		if isBlack(throughput) {
+			return radiance
+		}
+
+		var emitted Color
+		if isHit {
+			emitted = rec.material.Emitted(rec.u, rec.v, rec.P)
+		} else {
+			emitted = scene.background(r.Direction())
+		}
+		if !isBlack(emitted) {
+			weight := 1.0
+			if !specular && scene.lightCount() > 0 {
+				weight = powerHeuristic(scatterPDF, scene.lightPDF(r.Origin(), r.Direction()))
+			}
+			radiance = radiance.Add(throughput.Multiply(emitted).MultiplyScalar(weight))
+		}
+		if !isHit {
+			return radiance
+		}
+		if depth >= maxDepth {
+			return radiance
+		}
+
+		pm, ok := rec.material.(PDFMaterial)
+		if !ok {
+			attenuation := &Color{}
+			isScattered, scattered := rec.material.Scatter(r, rec, attenuation, rng)
+			if !isScattered {
+				return radiance
+			}
+			throughput = throughput.Multiply(*attenuation)
+			specular = true
+			r = scattered
+			continue
+		}
+
+		if scene.lightCount() > 0 {
+			radiance = radiance.Add(throughput.Multiply(sampleDirectLight(r, rec, pm, scene, rng, tc)))
+		}
+
+		sample, ok := pm.SampleScatter(r, rec, rng)
+		if !ok || sample.PDF <= 0.0 {
+			return radiance
+		}
+		throughput = throughput.Multiply(sample.Weight)
+		specular = false
+		scatterPDF = sample.PDF
+		r = NewRay(rec.P, sample.Direction, r.Time())
+	}
+}
+
+// sampleDirectLight traces one ray from rec towards a sampled light and
+// returns the light it carries back along rIn, weighted for MIS.
+func sampleDirectLight(rIn Ray, rec HitRecord, pm PDFMaterial, scene *Scene, rng *RNG, tc *TraceContext) Color {
+	direction, lightPDF := scene.sampleLight(rec.P, rng)
+	if lightPDF <= 0.0 {
+		return Color{}
+	}
+	f, scatterPDF := pm.EvalScatter(rIn, rec, direction)
+	if isBlack(f) {
+		return Color{}
+	}
+
+	tc.countRays(1)
+	if tc == nil {
+		tc = &TraceContext{rng: rng}
+	}
+	shadow := NewRay(rec.P, direction, rIn.Time())
+	isHit, lightRec := scene.hit(shadow, tc)
+	var emitted Color
+	tEnd := math.MaxFloat64
+	if isHit {
+		emitted = lightRec.material.Emitted(lightRec.u, lightRec.v, lightRec.P)
+		tEnd = lightRec.t
+	} else {
+		emitted = scene.background(direction)
+	}
+	if isBlack(emitted) {
+		return Color{}
+	}
+	if len(tc.media) > 0 {
+		emitted = emitted.Multiply(transmittance(shadow, tc.media, tEnd, rng))
+	}
+	weight := powerHeuristic(lightPDF, scatterPDF)
+	return f.Multiply(emitted).MultiplyScalar(weight / lightPDF)
+}
+
+// hit returns the surface r hits first, or where it scatters in a
+// ConstantMedium before reaching it, recording the other media it crosses on
+// the way in tc.
+func (s *Scene) hit(r Ray, tc *TraceContext) (bool, HitRecord) {
+	tc.media = tc.media[:0]
+	tc.constantMedia = tc.constantMedia[:0]
+	tc.collectMedia = true
+	isHit, rec := s.world.Hit(r, 0.001, math.MaxFloat64, tc)
+	tc.collectMedia = false
+	if len(tc.constantMedia) == 0 {
+		return isHit, rec
+	}
+
+	tEnd := math.MaxFloat64
+	if isHit {
+		tEnd = rec.t
+	}
+	if scattered, mediumRec := scatterConstantMedia(r, tc.constantMedia, tEnd, tc.rng); scattered {
+		return true, mediumRec
+	}
+	return isHit, rec
+}
+
+// trace returns where r first interacts with the scene: the surface it hits
+// or, in participating media, the point where it scatters, whose material is
+// then the phase function of the medium. The light arriving from there is
+// scaled by the returned weight, which is zero if the media absorb the ray.
+func (s *Scene) trace(r Ray, rng *RNG, tc *TraceContext) (bool, HitRecord, Color) {
+	if tc == nil {
+		tc = &TraceContext{rng: rng}
+	}
+	isHit, rec := s.hit(r, tc)
+	if len(tc.media) == 0 {
+		return isHit, rec, Color{R: 1.0, G: 1.0, B: 1.0}
+	}
+
+	tEnd := math.MaxFloat64
+	if isHit {
+		tEnd = rec.t
+	}
+	event, mediumRec, weight := trackMedia(r, tc.media, tEnd, rng)
+	switch event {
+	case mediumScattered:
+		return true, mediumRec, weight
+	case mediumAbsorbed:
+		return false, HitRecord{}, Color{}
+	}
+	return isHit, rec, weight
+}
+
+// powerHeuristic returns the MIS weight of a sample drawn with density pdf
+// when another strategy could have drawn it with density other.
+func powerHeuristic(pdf, other float64) float64 {
+	a, b := pdf*pdf, other*other
+	if a+b == 0.0 {
+		return 0.0
+	}
+	return a / (a + b)
+}
+
+func isBlack(c Color) bool {
+	return c.R == 0.0 && c.G == 0.0 && c.B == 0.0
+}
@@ -0,0 +1,87 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+// lightOverFloor returns a scene with a square light hovering over a large
+// diffuse floor and the radiance the floor reflects at the origin, found by
+// integrating over the light.
+func lightOverFloor() (Scene, float64) {
+	const (
+		albedo   = 0.5
+		emission = 4.0
+		height   = 2.0
+		half     = 1.0
+	)
+	floor := NewXZRect(-100.0, 100.0, -100.0, 100.0, 0.0, NewLambertian(NewConstantTexture(Color{R: albedo, G: albedo, B: albedo})))
+	light := NewFlipNormals(NewXZRect(-half, half, -half, half, height, NewDiffuseLight(NewConstantTexture(Color{R: emission, G: emission, B: emission}))))
+	scene := NewScene(Camera{}, HitableList{floor, light})
+
+	const n = 400
+	irradiance := 0.0
+	cell := 2.0 * half / n
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			x := -half + (float64(i)+0.5)*cell
+			z := -half + (float64(j)+0.5)*cell
+			d2 := x*x + height*height + z*z
+			cosine := height / math.Sqrt(d2)
+			irradiance += emission * cosine * cosine / d2 * cell * cell
+		}
+	}
+	return scene, albedo / math.Pi * irradiance
+}
+
+// The path integrator is left out: it keeps the original diffuse scattering,
+// which is not cosine-weighted and so converges to another answer.
+func TestMISConverges(t *testing.T) {
+	scene, want := lightOverFloor()
+	ray := NewRay(NewVec3d(0.0, 1.0, -1.0), NewVec3d(0.0, -1.0, 1.0), 0.0)
+
+	radiance := integrator(IntegratorMIS)
+	rng := NewRNG(11)
+	const samples = 20000
+	sum := 0.0
+	for i := 0; i < samples; i++ {
+		sum += radiance(ray, &scene, rng, nil).G
+	}
+	if mean := sum / samples; math.Abs(mean-want) > 0.06*want {
+		t.Errorf("mean radiance = %v, want %v", mean, want)
+	}
+}
+
+func TestMISReducesVariance(t *testing.T) {
+	scene, _ := lightOverFloor()
+	ray := NewRay(NewVec3d(0.0, 1.0, -1.0), NewVec3d(0.0, -1.0, 1.0), 0.0)
+
+	variance := func(radiance radianceFunc) float64 {
+		rng := NewRNG(3)
+		const samples = 5000
+		sum, sumSquares := 0.0, 0.0
+		for i := 0; i < samples; i++ {
+			l := radiance(ray, &scene, rng, nil).G
+			sum += l
+			sumSquares += l * l
+		}
+		mean := sum / samples
+		return sumSquares/samples - mean*mean
+	}
+
+	if path, mis := variance(pathRadiance), variance(misRadiance); mis >= path/4 {
+		t.Errorf("MIS variance = %v, want well below path tracing variance %v", mis, path)
+	}
+}
+
+func TestPowerHeuristic(t *testing.T) {
+	if w := powerHeuristic(1.0, 0.0); w != 1.0 {
+		t.Errorf("powerHeuristic(1, 0) = %v, want 1", w)
+	}
+	if w := powerHeuristic(0.0, 0.0); w != 0.0 {
+		t.Errorf("powerHeuristic(0, 0) = %v, want 0", w)
+	}
+	if a, b := powerHeuristic(2.0, 3.0), powerHeuristic(3.0, 2.0); math.Abs(a+b-1.0) > 1e-12 {
+		t.Errorf("weights sum to %v, want 1", a+b)
+	}
+}
@@ -0,0 +1,524 @@
+package rendim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+)
+
+// JobState is the stage a render job is at.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// ErrJobQueueFull is returned by Submit when as many jobs as the queue holds
+// are already waiting.
+var ErrJobQueueFull = errors.New("the job queue is full")
+
+// keptJobs is the number of finished jobs a JobQueue keeps for their status
+// and image.
+const keptJobs = 32
+
+// JobQueue renders the jobs submitted to it a few at a time, in the order
+// they came in, so that many users can share one machine.
+type JobQueue struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	jobs       []*Job // oldest first
+	waiting    []*Job
+	running    int
+	maxRunning int
+	maxWaiting int
+	runners    sync.WaitGroup
+}
+
+// NewJobQueue creates a queue that renders up to running jobs at once and
+// holds up to waiting more.
+func NewJobQueue(running, waiting int) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobQueue{ctx: ctx, cancel: cancel, maxRunning: max(running, 1), maxWaiting: max(waiting, 0)}
+}
+
+// Job is a render submitted to a JobQueue.
+type Job struct {
+	ID       string
+	Scene    string // the name the job was submitted with
+	Settings RenderSettings
+
+	queue   *JobQueue
+	sf      *SceneFile
+	opts    RenderOptions
+	created time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu                sync.Mutex
+	state             JobState
+	started, finished time.Time
+	stats             Stats
+	err               error
+	fb                *Framebuffer
+	// preview holds the display colour of every pixel rendered so far, with
+	// the others transparent.
+	preview  *image.RGBA
+	watchers map[*JobWatcher]struct{}
+}
+
+// Submit queues a render of sf with settings, which must be valid, and
+// returns the new job. Of opts only the checkpoint fields apply. It returns
+// ErrJobQueueFull if the queue holds no more jobs.
+func (q *JobQueue) Submit(scene string, sf *SceneFile, settings RenderSettings, opts RenderOptions) (*Job, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ctx.Err() != nil {
+		return nil, errors.New("the job queue is closed")
+	}
+	if len(q.waiting) >= q.maxWaiting && q.running >= q.maxRunning {
+		return nil, ErrJobQueueFull
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	j := &Job{
+		ID:       hex.EncodeToString(id),
+		Scene:    scene,
+		Settings: settings,
+		queue:    q,
+		sf:       sf,
+		opts:     RenderOptions{Checkpoint: opts.Checkpoint, CheckpointInterval: opts.CheckpointInterval, Resume: opts.Resume},
+		created:  time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		state:    JobQueued,
+		stats:    Stats{TotalPixels: settings.Width * settings.Height},
+		watchers: map[*JobWatcher]struct{}{},
+	}
+	q.jobs = append(q.jobs, j)
+	q.waiting = append(q.waiting, j)
+	q.dispatch()
+	return j, nil
+}
+
+// Get returns the job with the given ID, or nil if the queue has none.
+func (q *JobQueue) Get(id string) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// Jobs returns every job the queue keeps, oldest first.
+func (q *JobQueue) Jobs() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*Job(nil), q.jobs...)
+}
+
+// Close cancels every job and waits for the running ones to stop.
+func (q *JobQueue) Close() {
+	q.cancel()
+	q.mu.Lock()
+	for _, j := range q.waiting {
+		j.finish(JobCancelled, nil, Stats{}, context.Canceled)
+	}
+	q.waiting = nil
+	q.mu.Unlock()
+	q.runners.Wait()
+}
+
+// dispatch starts waiting jobs while fewer than maxRunning run. q.mu must be
+// held.
+func (q *JobQueue) dispatch() {
+	for q.running < q.maxRunning && len(q.waiting) > 0 {
+		j := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		j.mu.Lock()
+		j.state = JobRunning
+		j.started = time.Now()
+		j.mu.Unlock()
+		q.runners.Add(1)
+		go q.run(j)
+	}
+}
+
+// run renders j and starts the next job when it is done.
+func (q *JobQueue) run(j *Job) {
+	defer q.runners.Done()
+	fb, stats, err := j.render()
+
+	state := JobDone
+	switch {
+	case j.ctx.Err() != nil:
+		state, err = JobCancelled, j.ctx.Err()
+	case err != nil:
+		state = JobFailed
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.finish(state, fb, stats, err)
+	q.running--
+	q.forget()
+	q.dispatch()
+}
+
+// forget drops the oldest finished jobs beyond keptJobs. q.mu must be held.
+func (q *JobQueue) forget() {
+	finished := 0
+	for _, j := range q.jobs {
+		if j.isDone() {
+			finished++
+		}
+	}
+	kept := q.jobs[:0]
+	for _, j := range q.jobs {
+		if finished > keptJobs && j.isDone() {
+			finished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	q.jobs = kept
+}
+
+// render builds the scene of j and renders it, keeping the pixels for the
+// job's watchers.
+func (j *Job) render() (*Framebuffer, Stats, error) {
+	scene, err := j.sf.Build(j.Settings)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	pixels := make(chan Pixel, 1024)
+	var forwarder sync.WaitGroup
+	forwarder.Add(1)
+	go func() {
+		defer forwarder.Done()
+		for p := range pixels {
+			j.addPixel(p)
+		}
+	}()
+
+	opts := j.opts
+	opts.Pixels = pixels
+	opts.ProgressInterval = 500 * time.Millisecond
+	opts.Progress = func(s Stats) {
+		j.mu.Lock()
+		j.stats = s
+		j.mu.Unlock()
+	}
+	fb, stats, err := RenderScene(j.ctx, scene, j.Settings, opts)
+	close(pixels)
+	forwarder.Wait()
+	return fb, stats, err
+}
+
+// finish records the end of j.
+func (j *Job) finish(state JobState, fb *Framebuffer, stats Stats, err error) {
+	j.mu.Lock()
+	j.state = state
+	j.finished = time.Now()
+	j.fb = fb
+	if fb != nil {
+		j.stats = stats
+	}
+	j.err = err
+	j.mu.Unlock()
+	j.cancel()
+	close(j.done)
+}
+
+func (j *Job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Cancel stops j, or takes it off the queue if it has not started yet.
+func (j *Job) Cancel() {
+	q := j.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiting {
+		if w == j {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			j.finish(JobCancelled, nil, Stats{}, context.Canceled)
+			return
+		}
+	}
+	j.cancel()
+}
+
+// Done is closed once j has finished, failed or been cancelled.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Framebuffer returns the image of a finished job, which is partial if the
+// job was cancelled, or nil while it runs or if it rendered nothing.
+func (j *Job) Framebuffer() *Framebuffer {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.fb
+}
+
+// Stats returns the stats of j so far.
+func (j *Job) Stats() Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stats
+}
+
+// JobStatus describes a job at one moment.
+type JobStatus struct {
+	ID       string         `json:"id"`
+	Scene    string         `json:"scene"`
+	Settings RenderSettings `json:"settings"`
+	State    JobState       `json:"state"`
+	// Position is the place of a queued job in the queue, from 1.
+	Position int       `json:"position,omitempty"`
+	Progress float64   `json:"progress"`
+	Stats    JobStats  `json:"stats"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// JobStats are the figures of Stats that describe a job's progress.
+type JobStats struct {
+	Pixels         int     `json:"pixels"`
+	TotalPixels    int     `json:"totalPixels"`
+	Samples        uint64  `json:"samples"`
+	Rays           uint64  `json:"rays"`
+	NodeVisits     uint64  `json:"nodeVisits"`
+	PrimitiveTests uint64  `json:"primitiveTests"`
+	Buckets        int     `json:"buckets"`
+	Passes         int     `json:"passes"`
+	TotalPasses    int     `json:"totalPasses"`
+	Seconds        float64 `json:"seconds"`
+}
+
+// Status returns the state, progress and stats of j.
+func (j *Job) Status() JobStatus {
+	q := j.queue
+	q.mu.Lock()
+	position := 0
+	for i, w := range q.waiting {
+		if w == j {
+			position = i + 1
+		}
+	}
+	q.mu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.stats
+	status := JobStatus{
+		ID:       j.ID,
+		Scene:    j.Scene,
+		Settings: j.Settings,
+		State:    j.state,
+		Position: position,
+		Progress: s.Progress(),
+		Stats: JobStats{
+			Pixels:         s.Pixels,
+			TotalPixels:    s.TotalPixels,
+			Samples:        s.Samples,
+			Rays:           s.Rays,
+			NodeVisits:     s.NodeVisits,
+			PrimitiveTests: s.PrimitiveTests,
+			Buckets:        len(s.Buckets),
+			Passes:         s.Passes,
+			TotalPasses:    s.TotalPasses,
+			Seconds:        s.Elapsed.Seconds(),
+		},
+		Created:  j.created,
+		Started:  j.started,
+		Finished: j.finished,
+	}
+	if j.state == JobDone {
+		status.Progress = 1.0
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+	return status
+}
+
+// addPixel stores a rendered pixel and passes it on to the watchers of j.
+func (j *Job) addPixel(p Pixel) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.preview == nil {
+		j.preview = image.NewRGBA(image.Rect(0, 0, j.Settings.Width, j.Settings.Height))
+	}
+	j.preview.SetRGBA(p.X, p.Y, color.RGBA{R: p.R, G: p.G, B: p.B, A: 255})
+	for w := range j.watchers {
+		w.add(p)
+	}
+}
+
+// JobWatcher follows the pixels of a job as they are rendered.
+type JobWatcher struct {
+	job     *Job
+	pending []Pixel
+	// resync hands over every pixel rendered so far instead of pending,
+	// which the watcher starts with and falls back to if it falls behind.
+	resync bool
+}
+
+// Watch returns a watcher of the pixels of j, starting with those rendered
+// so far.
+func (j *Job) Watch() *JobWatcher {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w := &JobWatcher{job: j, resync: true}
+	j.watchers[w] = struct{}{}
+	return w
+}
+
+// add queues p for the watcher. j.mu must be held.
+func (w *JobWatcher) add(p Pixel) {
+	if w.resync {
+		return
+	}
+	w.pending = append(w.pending, p)
+	if len(w.pending) > w.job.Settings.Width*w.job.Settings.Height {
+		w.pending, w.resync = nil, true
+	}
+}
+
+// Pixels returns the pixels rendered since the last call. A watcher takes
+// either Pixels or Tiles.
+func (w *JobWatcher) Pixels() []Pixel {
+	j := w.job
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !w.resync {
+		pixels := w.pending
+		w.pending = nil
+		return pixels
+	}
+
+	w.resync = false
+	if j.preview == nil {
+		return nil
+	}
+	var pixels []Pixel
+	for y := 0; y < j.Settings.Height; y++ {
+		for x := 0; x < j.Settings.Width; x++ {
+			if c := j.preview.RGBAAt(x, y); c.A != 0 {
+				pixels = append(pixels, Pixel{image.Point{X: x, Y: y}, c.R, c.G, c.B})
+			}
+		}
+	}
+	return pixels
+}
+
+// Tiles returns the buckets of the image finished since the last call, or
+// changed since by a later pass, with the display colours of their pixels. A
+// bucket is held back until every pixel of it has been rendered, as tiles
+// carry no transparency to leave the others out, so the buckets a stopped
+// job left unfinished are never returned.
+func (w *JobWatcher) Tiles() []*image.RGBA {
+	j := w.job
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.preview == nil {
+		w.pending, w.resync = nil, false
+		return nil
+	}
+
+	size := j.Settings.BucketSize
+	bucket := func(x, y int) image.Rectangle {
+		return image.Rect(x, y, x+size, y+size).Intersect(j.preview.Rect)
+	}
+	var rects []image.Rectangle
+	if w.resync {
+		for y := 0; y < j.Settings.Height; y += size {
+			for x := 0; x < j.Settings.Width; x += size {
+				if r := bucket(x, y); isRendered(j.preview, r) {
+					rects = append(rects, r)
+				}
+			}
+		}
+		w.pending = nil
+	} else {
+		// The finished buckets with new pixels, keeping the pixels of the
+		// others for when they are finished.
+		finished := map[image.Rectangle]bool{}
+		var held []Pixel
+		for _, p := range w.pending {
+			r := bucket(p.X/size*size, p.Y/size*size)
+			done, ok := finished[r]
+			if !ok {
+				done = isRendered(j.preview, r)
+				finished[r] = done
+				if done {
+					rects = append(rects, r)
+				}
+			}
+			if !done {
+				held = append(held, p)
+			}
+		}
+		w.pending = held
+	}
+	w.resync = false
+
+	tiles := make([]*image.RGBA, len(rects))
+	for i, r := range rects {
+		tiles[i] = image.NewRGBA(r)
+		draw.Draw(tiles[i], r, j.preview, r.Min, draw.Src)
+	}
+	return tiles
+}
+
+// isRendered reports whether every pixel of r has been rendered into img.
+func isRendered(img *image.RGBA, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if img.RGBAAt(x, y).A == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Close stops the watcher.
+func (w *JobWatcher) Close() {
+	w.job.mu.Lock()
+	delete(w.job.watchers, w)
+	w.job.mu.Unlock()
+}
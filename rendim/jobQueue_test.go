@@ -0,0 +1,262 @@
+package rendim
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"slices"
+	"testing"
+	"time"
+)
+
+func submitTestJob(t *testing.T, q *JobQueue, settings RenderSettings) *Job {
+	t.Helper()
+	job, err := q.Submit("cornell", loadTestSceneFile(t, "cornell"), DefaultRenderSettings.Merge(settings), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// longJob are the settings of a job that runs until it is cancelled.
+var longJob = RenderSettings{Width: 32, Height: 32, Samples: 1000000, Workers: 1}
+
+func waitForJob(t *testing.T, job *Job) {
+	t.Helper()
+	select {
+	case <-job.Done():
+	case <-time.After(time.Minute):
+		t.Fatalf("job %s did not finish", job.ID)
+	}
+}
+
+func TestJobQueueRendersJob(t *testing.T) {
+	q := NewJobQueue(1, 4)
+	defer q.Close()
+	settings := DefaultRenderSettings.Merge(RenderSettings{Width: 16, Height: 16, Samples: 4, BucketSize: 8, Workers: 2})
+	job := submitTestJob(t, q, settings)
+	waitForJob(t, job)
+
+	status := job.Status()
+	if status.State != JobDone || status.Progress != 1.0 || status.Error != "" {
+		t.Errorf("status = %s at %v with error %q, want done at 1", status.State, status.Progress, status.Error)
+	}
+	if status.Stats.Pixels != 256 || status.Stats.Samples != 1024 {
+		t.Errorf("stats = %+v, want 256 pixels and 1024 samples", status.Stats)
+	}
+	if status.Started.IsZero() || status.Finished.Before(status.Started) {
+		t.Errorf("started %v, finished %v", status.Started, status.Finished)
+	}
+
+	want, _, err := RenderScene(context.Background(), buildTestScene(t, "cornell", settings), settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb := job.Framebuffer(); fb == nil || !slices.Equal(fb.pix, want.pix) {
+		t.Error("the job's image differs from RenderScene's")
+	}
+	if q.Get(job.ID) != job || q.Get("missing") != nil {
+		t.Error("Get does not find jobs by ID")
+	}
+}
+
+func TestJobQueueBounded(t *testing.T) {
+	q := NewJobQueue(1, 1)
+	defer q.Close()
+	running := submitTestJob(t, q, longJob)
+	waiting := submitTestJob(t, q, longJob)
+	if _, err := q.Submit("cornell", loadTestSceneFile(t, "cornell"), DefaultRenderSettings, RenderOptions{}); !errors.Is(err, ErrJobQueueFull) {
+		t.Fatalf("submitting to a full queue gave %v, want ErrJobQueueFull", err)
+	}
+	if s := running.Status(); s.State != JobRunning {
+		t.Errorf("first job is %s, want running", s.State)
+	}
+	if s := waiting.Status(); s.State != JobQueued || s.Position != 1 {
+		t.Errorf("second job is %s at %d, want queued at 1", s.State, s.Position)
+	}
+
+	waiting.Cancel()
+	waitForJob(t, waiting)
+	if s := waiting.Status(); s.State != JobCancelled || waiting.Framebuffer() != nil {
+		t.Errorf("cancelled queued job is %s", s.State)
+	}
+
+	// The cancelled job freed its place in the queue.
+	next := submitTestJob(t, q, RenderSettings{Width: 4, Height: 4, Samples: 1})
+	running.Cancel()
+	waitForJob(t, running)
+	if s := running.Status(); s.State != JobCancelled || running.Framebuffer() == nil {
+		t.Errorf("cancelled running job is %s, want cancelled with a partial image", s.State)
+	}
+	waitForJob(t, next)
+	if s := next.Status(); s.State != JobDone {
+		t.Errorf("job after the cancelled one is %s, want done", s.State)
+	}
+	if !next.Status().Started.After(running.Status().Finished) {
+		t.Error("the next job started before the running one finished")
+	}
+}
+
+func TestJobQueueFailedJob(t *testing.T) {
+	q := NewJobQueue(1, 1)
+	defer q.Close()
+	sf, err := parseSceneFile([]byte(`{"camera": {"lookFrom": [0, 0, -5], "lookAt": [0, 0, 0], "vFov": 40}, "objects": []}`), "empty.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Submit("empty", sf, DefaultRenderSettings.Merge(RenderSettings{Width: 4, Height: 4}), RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, job)
+	if s := job.Status(); s.State != JobFailed || s.Error == "" {
+		t.Errorf("job of a scene without objects is %s with error %q, want failed", s.State, s.Error)
+	}
+}
+
+func TestJobQueueForgetsOldJobs(t *testing.T) {
+	q := NewJobQueue(1, keptJobs+8)
+	defer q.Close()
+	var jobs []*Job
+	for i := 0; i < keptJobs+5; i++ {
+		jobs = append(jobs, submitTestJob(t, q, RenderSettings{Width: 1, Height: 1, Samples: 1}))
+	}
+	waitForJob(t, jobs[len(jobs)-1])
+	if n := len(q.Jobs()); n != keptJobs {
+		t.Errorf("queue keeps %d jobs, want %d", n, keptJobs)
+	}
+	if q.Get(jobs[0].ID) != nil || q.Get(jobs[len(jobs)-1].ID) == nil {
+		t.Error("queue forgot the wrong jobs")
+	}
+}
+
+func TestJobWatcher(t *testing.T) {
+	q := NewJobQueue(1, 1)
+	defer q.Close()
+	job := submitTestJob(t, q, RenderSettings{Width: 16, Height: 16, Samples: 64, BucketSize: 4, Workers: 2})
+	w := job.Watch()
+	defer w.Close()
+
+	seen := map[[2]int]Pixel{}
+	collect := func(w *JobWatcher) {
+		for _, p := range w.Pixels() {
+			seen[[2]int{p.X, p.Y}] = p
+		}
+	}
+	for done := false; !done; {
+		select {
+		case <-job.Done():
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+		collect(w)
+	}
+	if len(seen) != 256 {
+		t.Fatalf("watcher saw %d pixels, want 256", len(seen))
+	}
+
+	// A watcher attached after the end gets every pixel at once.
+	late := job.Watch()
+	defer late.Close()
+	pixels := late.Pixels()
+	if len(pixels) != 256 {
+		t.Fatalf("late watcher got %d pixels, want 256", len(pixels))
+	}
+	img := job.Framebuffer().Image(job.Settings.ToneMapping())
+	for _, p := range pixels {
+		c := img.RGBAAt(p.X, p.Y)
+		if c.R != p.R || c.G != p.G || c.B != p.B || seen[[2]int{p.X, p.Y}] != p {
+			t.Fatalf("pixel %v = %v, want %v", p.Point, p, c)
+		}
+	}
+	if more := late.Pixels(); len(more) != 0 {
+		t.Errorf("late watcher got %d pixels again", len(more))
+	}
+}
+
+func TestJobWatcherTiles(t *testing.T) {
+	q := NewJobQueue(1, 1)
+	defer q.Close()
+	job := submitTestJob(t, q, RenderSettings{Width: 20, Height: 12, Samples: 64, BucketSize: 8, Workers: 2, Progressive: true})
+	w := job.Watch()
+	defer w.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 12))
+	collect := func(w *JobWatcher) int {
+		tiles := w.Tiles()
+		for _, tile := range tiles {
+			r := tile.Bounds()
+			if bucket := image.Rect(r.Min.X, r.Min.Y, r.Min.X+8, r.Min.Y+8).Intersect(img.Rect); r.Min.X%8 != 0 || r.Min.Y%8 != 0 || r != bucket {
+				t.Fatalf("tile %v is not a bucket", r)
+			}
+			if !isRendered(tile, r) {
+				t.Fatalf("tile %v has pixels not yet rendered", r)
+			}
+			draw.Draw(img, r, tile, r.Min, draw.Src)
+		}
+		return len(tiles)
+	}
+	for done := false; !done; {
+		select {
+		case <-job.Done():
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+		collect(w)
+	}
+	want := job.Framebuffer().Image(job.Settings.ToneMapping())
+	if !slices.Equal(img.Pix, want.Pix) {
+		t.Error("the tiles differ from the job's image")
+	}
+
+	// A watcher attached after the end gets every bucket.
+	late := job.Watch()
+	defer late.Close()
+	if n := collect(late); n != 6 {
+		t.Errorf("late watcher got %d tiles, want 6", n)
+	}
+	if n := collect(late); n != 0 {
+		t.Errorf("late watcher got %d tiles again", n)
+	}
+}
+
+func TestJobWatcherTilesHoldsUnfinishedBuckets(t *testing.T) {
+	job := &Job{Settings: RenderSettings{Width: 6, Height: 4, BucketSize: 4}, watchers: map[*JobWatcher]struct{}{}}
+	w := job.Watch()
+	defer w.Close()
+	render := func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				job.addPixel(Pixel{image.Point{X: x, Y: y}, 10, 20, 30})
+			}
+		}
+	}
+	tileBounds := func() []image.Rectangle {
+		var bounds []image.Rectangle
+		for _, tile := range w.Tiles() {
+			bounds = append(bounds, tile.Bounds())
+		}
+		return bounds
+	}
+
+	render(image.Rect(0, 0, 4, 2))
+	render(image.Rect(4, 0, 6, 1))
+	if got := tileBounds(); len(got) != 0 {
+		t.Fatalf("tiles of unfinished buckets: %v", got)
+	}
+	render(image.Rect(0, 2, 4, 4))
+	if got, want := tileBounds(), []image.Rectangle{image.Rect(0, 0, 4, 4)}; !slices.Equal(got, want) {
+		t.Fatalf("tiles = %v, want %v", got, want)
+	}
+	render(image.Rect(4, 1, 6, 4))
+	if got, want := tileBounds(), []image.Rectangle{image.Rect(4, 0, 6, 4)}; !slices.Equal(got, want) {
+		t.Fatalf("tiles = %v, want %v", got, want)
+	}
+
+	// A later pass over a finished bucket sends it again.
+	render(image.Rect(1, 1, 2, 2))
+	if got, want := tileBounds(), []image.Rectangle{image.Rect(0, 0, 4, 4)}; !slices.Equal(got, want) {
+		t.Errorf("tiles = %v, want %v", got, want)
+	}
+}
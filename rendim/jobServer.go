@@ -0,0 +1,187 @@
+package rendim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	// maxJobPixels bounds the image size of a job submitted over HTTP.
+	maxJobPixels = 4096 * 4096
+	// maxJobWorkers bounds the workers of a job submitted over HTTP.
+	maxJobWorkers = 256
+	// maxJobSamples bounds the samples per pixel of a job submitted over
+	// HTTP, so that one job cannot hold the queue indefinitely.
+	maxJobSamples = 1 << 16
+	// maxJobRequest bounds the body of a job submission.
+	maxJobRequest = 1 << 16
+)
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	// Scene names a scene file in the scenes directory, without ".json".
+	Scene string `json:"scene"`
+	// Settings override those of the scene file, including any given as
+	// zero.
+	Settings json.RawMessage `json:"settings"`
+}
+
+// JobServer serves a JobQueue over HTTP:
+//
+//	POST   /jobs            queue a render of {"scene": name, "settings": {...}}
+//	GET    /jobs            the status of every job
+//	GET    /jobs/{id}       the status of a job
+//	GET    /jobs/{id}/image the image of a finished job, ?format=png or exr
+//	DELETE /jobs/{id}       cancel a job
+type JobServer struct {
+	queue  *JobQueue
+	scenes string
+	mux    *http.ServeMux
+}
+
+// NewJobServer creates a server for the jobs of queue, which renders the
+// scene files in the directory scenes.
+func NewJobServer(queue *JobQueue, scenes string) *JobServer {
+	s := &JobServer{queue: queue, scenes: scenes, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /jobs", s.submit)
+	s.mux.HandleFunc("GET /jobs", s.list)
+	s.mux.HandleFunc("GET /jobs/{id}", s.status)
+	s.mux.HandleFunc("GET /jobs/{id}/image", s.image)
+	s.mux.HandleFunc("DELETE /jobs/{id}", s.cancel)
+	return s
+}
+
+// ServeHTTP answers the requests under /jobs.
+func (s *JobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *JobServer) submit(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequest))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "job request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !sceneNamePattern.MatchString(req.Scene) {
+		http.Error(w, fmt.Sprintf("invalid scene name %q", req.Scene), http.StatusBadRequest)
+		return
+	}
+	var requested RenderSettings
+	var err error
+	if len(req.Settings) > 0 {
+		err = decodeStrict(req.Settings, &requested, "settings")
+	}
+	if err == nil {
+		err = requested.validate("settings")
+	}
+	if err != nil {
+		var se *SceneError
+		if errors.As(err, &se) {
+			se.File = "job request"
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sf, err := LoadSceneFile(filepath.Join(s.scenes, req.Scene+".json"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unknown scene %q", req.Scene), http.StatusNotFound)
+		return
+	}
+	settings := DefaultRenderSettings.Merge(sf.Settings).Merge(requested.WithSet(settingsIn(req.Settings)...))
+	if err := settings.ValidateJob(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.queue.Submit(req.Scene, sf, settings, RenderOptions{})
+	if errors.Is(err, ErrJobQueueFull) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusCreated, job.Status())
+}
+
+// ValidateJob checks s like Validate, and that it stays within the bounds on
+// the size, samples and workers of a job submitted over HTTP.
+func (s RenderSettings) ValidateJob() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Width > maxJobPixels || s.Height > maxJobPixels || s.Width*s.Height > maxJobPixels ||
+		s.Samples > maxJobSamples || s.MinSamples > maxJobSamples || s.Workers > maxJobWorkers {
+		return fmt.Errorf("a job may have at most %d pixels, %d samples per pixel and %d workers", maxJobPixels, maxJobSamples, maxJobWorkers)
+	}
+	return nil
+}
+
+func (s *JobServer) list(w http.ResponseWriter, _ *http.Request) {
+	statuses := []JobStatus{}
+	for _, job := range s.queue.Jobs() {
+		statuses = append(statuses, job.Status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// job returns the job named by the request, answering 404 if there is none.
+func (s *JobServer) job(w http.ResponseWriter, r *http.Request) *Job {
+	job := s.queue.Get(r.PathValue("id"))
+	if job == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+	}
+	return job
+}
+
+func (s *JobServer) status(w http.ResponseWriter, r *http.Request) {
+	if job := s.job(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.Status())
+	}
+}
+
+func (s *JobServer) image(w http.ResponseWriter, r *http.Request) {
+	job := s.job(w, r)
+	if job == nil {
+		return
+	}
+	fb := job.Framebuffer()
+	if fb == nil {
+		http.Error(w, fmt.Sprintf("job is %s and has no image yet", job.Status().State), http.StatusConflict)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "png":
+		w.Header().Set("Content-Type", "image/png")
+		_ = png.Encode(w, fb.Image(job.Settings.ToneMapping()))
+	case "exr":
+		w.Header().Set("Content-Type", "image/x-exr")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.ID+".exr"))
+		_ = WriteEXR(w, fb, EXRZIP)
+	default:
+		http.Error(w, fmt.Sprintf("unknown image format %q (want png or exr)", format), http.StatusBadRequest)
+	}
+}
+
+func (s *JobServer) cancel(w http.ResponseWriter, r *http.Request) {
+	if job := s.job(w, r); job != nil {
+		job.Cancel()
+		writeJSON(w, http.StatusAccepted, job.Status())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,172 @@
+package rendim
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startJobServer(t *testing.T, running, waiting int) *httptest.Server {
+	t.Helper()
+	q := NewJobQueue(running, waiting)
+	server := httptest.NewServer(NewJobServer(q, "../scenes"))
+	t.Cleanup(func() {
+		server.Close()
+		q.Close()
+	})
+	return server
+}
+
+func doJobRequest(t *testing.T, method, url, body string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	return resp, data.Bytes()
+}
+
+func decodeJobStatus(t *testing.T, data []byte) JobStatus {
+	t.Helper()
+	var status JobStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("%v in %s", err, data)
+	}
+	return status
+}
+
+func TestJobServer(t *testing.T) {
+	server := startJobServer(t, 1, 2)
+	resp, data := doJobRequest(t, http.MethodPost, server.URL+"/jobs",
+		`{"scene": "cornell", "settings": {"width": 16, "height": 8, "samples": 2, "workers": 2}}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /jobs: %s: %s", resp.Status, data)
+	}
+	created := decodeJobStatus(t, data)
+	if resp.Header.Get("Location") != "/jobs/"+created.ID || created.Scene != "cornell" || created.Settings.Width != 16 {
+		t.Errorf("created job %+v at %s", created, resp.Header.Get("Location"))
+	}
+
+	var status JobStatus
+	for deadline := time.Now().Add(time.Minute); status.State != JobDone; {
+		if time.Now().After(deadline) {
+			t.Fatalf("job is still %s", status.State)
+		}
+		resp, data = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+created.ID, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /jobs/%s: %s", created.ID, resp.Status)
+		}
+		status = decodeJobStatus(t, data)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Progress != 1.0 || status.Stats.Pixels != 128 || status.Stats.Samples != 256 {
+		t.Errorf("finished job status = %+v", status)
+	}
+
+	resp, data = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+created.ID+"/image", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("GET image: %s, %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 8 {
+		t.Errorf("image is %dx%d, want 16x8", b.Dx(), b.Dy())
+	}
+	resp, data = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+created.ID+"/image?format=exr", "")
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(data, []byte{0x76, 0x2f, 0x31, 0x01}) {
+		t.Errorf("GET EXR image: %s with %d bytes", resp.Status, len(data))
+	}
+
+	resp, data = doJobRequest(t, http.MethodGet, server.URL+"/jobs", "")
+	var list []JobStatus
+	if err := json.Unmarshal(data, &list); err != nil || resp.StatusCode != http.StatusOK || len(list) != 1 {
+		t.Errorf("GET /jobs: %s: %s", resp.Status, data)
+	}
+}
+
+func TestJobServerCancel(t *testing.T) {
+	server := startJobServer(t, 1, 0)
+	resp, data := doJobRequest(t, http.MethodPost, server.URL+"/jobs",
+		`{"scene": "cornell", "settings": {"width": 32, "height": 32, "samples": 65536, "workers": 1}}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /jobs: %s: %s", resp.Status, data)
+	}
+	id := decodeJobStatus(t, data).ID
+
+	// The only place is taken.
+	resp, _ = doJobRequest(t, http.MethodPost, server.URL+"/jobs", `{"scene": "cornell"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("POST /jobs to a full queue: %s, want 503", resp.Status)
+	}
+	resp, _ = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+id+"/image", "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("GET image of a running job: %s, want 409", resp.Status)
+	}
+
+	resp, _ = doJobRequest(t, http.MethodDelete, server.URL+"/jobs/"+id, "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("DELETE /jobs/%s: %s", id, resp.Status)
+	}
+	var status JobStatus
+	for deadline := time.Now().Add(time.Minute); status.State != JobCancelled; {
+		if time.Now().After(deadline) {
+			t.Fatalf("job is still %s", status.State)
+		}
+		_, data = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+id, "")
+		status = decodeJobStatus(t, data)
+	}
+	resp, _ = doJobRequest(t, http.MethodGet, server.URL+"/jobs/"+id+"/image", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET image of a cancelled job: %s, want the partial image", resp.Status)
+	}
+}
+
+func TestJobServerRejectsBadRequests(t *testing.T) {
+	server := startJobServer(t, 1, 1)
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"scene": "cornell", "settings": {"samples": -1}}`, http.StatusBadRequest, "settings.samples"},
+		{`{"scene": "cornell", "settings": {"sampler": "magic"}}`, http.StatusBadRequest, "magic"},
+		{`{"scene": "cornell", "settings": {"width": 100000, "height": 100000}}`, http.StatusBadRequest, "at most"},
+		{`{"scene": "cornell", "settings": {"samples": 2000000000}}`, http.StatusBadRequest, "at most"},
+		{`{"scene": "cornell", "settings": {"minSamples": 2000000000}}`, http.StatusBadRequest, "at most"},
+		{`{"scene": "cornell", "colour": "red"}`, http.StatusBadRequest, "colour"},
+		{`{"scene": "cornell", "settings": {"workers": 0}}`, http.StatusBadRequest, "workers must be positive"},
+		{`{"scene": "cornell", "settings": {"colour": "red"}}`, http.StatusBadRequest, "colour"},
+		{`{"scene": "cornell", "settings": {"samples": "many"}}`, http.StatusBadRequest, "settings.samples"},
+		{`{"scene": "../main"}`, http.StatusBadRequest, "invalid scene name"},
+		{`{"scene": "missing"}`, http.StatusNotFound, "unknown scene"},
+		{`not json`, http.StatusBadRequest, "job request"},
+	}
+	for _, tt := range tests {
+		resp, data := doJobRequest(t, http.MethodPost, server.URL+"/jobs", tt.body)
+		if resp.StatusCode != tt.status || !strings.Contains(string(data), tt.want) {
+			t.Errorf("POST %s: %s: %s, want %d mentioning %q", tt.body, resp.Status, data, tt.status, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/jobs/missing", "/jobs/missing/image"} {
+		if resp, _ := doJobRequest(t, http.MethodGet, server.URL+path, ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: %s, want 404", path, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package rendim
+
+import "math"
+
+// Light is an emitting object that can be sampled directly. Rectangles and
+// spheres with a DiffuseLight material are lights; NewScene finds them in the
+// world.
+type Light interface {
+	Hitable
+	// SampleDirection returns the direction from p to a random point on the
+	// light and the density of picking it with respect to solid angle. The
+	// density is zero if the light cannot be sampled from p.
+	SampleDirection(p Vec3d, rng *RNG) (Vec3d, float64)
+	// PDFValue returns the density with which SampleDirection picks direction
+	// from p.
+	PDFValue(p, direction Vec3d) float64
+}
+
+// collectLights appends the lights in h to lights, looking through lists,
+// hierarchies and flipped normals. Lights under other transforms are not
+// found; the MIS integrator still picks up their light when paths hit them.
+func collectLights(h Hitable, lights []Light) []Light {
+	switch n := h.(type) {
+	case HitableList:
+		for _, o := range n {
+			lights = collectLights(o, lights)
+		}
+	case BVHNode:
+		lights = collectLights(*n.left, lights)
+		if n.right != n.left {
+			lights = collectLights(*n.right, lights)
+		}
+	case LinearBVH:
+		for _, o := range n.objects {
+			lights = collectLights(o, lights)
+		}
+	case FlipNormals:
+		lights = collectLights(n.hitable, lights)
+	case XYRect:
+		if isEmitter(n.material) {
+			lights = append(lights, n)
+		}
+	case XZRect:
+		if isEmitter(n.material) {
+			lights = append(lights, n)
+		}
+	case YZRect:
+		if isEmitter(n.material) {
+			lights = append(lights, n)
+		}
+	case Sphere:
+		if isEmitter(n.material) {
+			lights = append(lights, n)
+		}
+	}
+	return lights
+}
+
+func isEmitter(m Material) bool {
+	_, ok := m.(DiffuseLight)
+	return ok
+}
+
+// lightCount returns the number of lights sampleLight chooses from, counting
+// the environment as one.
+func (s *Scene) lightCount() int {
+	if s.environment != nil {
+		return len(s.lights) + 1
+	}
+	return len(s.lights)
+}
+
+// sampleLight picks one of the lights or the environment uniformly and a
+// direction towards it. The returned density is that of the whole light set,
+// so that it can be weighed against BSDF sampling whichever light the
+// direction ends up hitting.
+func (s *Scene) sampleLight(p Vec3d, rng *RNG) (Vec3d, float64) {
+	var direction Vec3d
+	var pdf float64
+	if i := rng.Intn(s.lightCount()); i < len(s.lights) {
+		direction, pdf = s.lights[i].SampleDirection(p, rng)
+	} else {
+		direction, pdf = s.environment.SampleDirection(rng)
+	}
+	if pdf <= 0.0 {
+		return direction, 0.0
+	}
+	return direction, s.lightPDF(p, direction)
+}
+
+// lightPDF returns the density with which sampleLight picks direction from p.
+func (s *Scene) lightPDF(p, direction Vec3d) float64 {
+	sum := 0.0
+	for _, l := range s.lights {
+		sum += l.PDFValue(p, direction)
+	}
+	if s.environment != nil {
+		sum += s.environment.PDFValue(direction)
+	}
+	return sum / float64(s.lightCount())
+}
+
+// rectSample returns the direction from p to a uniformly chosen point of the
+// rectangle [a0, a1] x [b0, b1] at k along axis, and its solid angle density.
+func rectSample(p Vec3d, axis int, a0, a1, b0, b1, k float64, rng *RNG) (Vec3d, float64) {
+	a, b := (axis+1)%3, (axis+2)%3
+	var point Vec3d
+	point.e[axis] = k
+	u1, u2 := rng.Float64Pair()
+	point.e[a] = a0 + u1*(a1-a0)
+	point.e[b] = b0 + u2*(b1-b0)
+	direction := point.Subtract(p)
+	return direction, rectPDF(direction, 1.0, axis, (a1-a0)*(b1-b0))
+}
+
+// rectPDF converts the area density of a rectangle to solid angle for a
+// direction that reaches it at parameter t.
+func rectPDF(direction Vec3d, t float64, axis int, area float64) float64 {
+	length := direction.Length()
+	cosine := math.Abs(direction.e[axis]) / length
+	if cosine < 1e-8 || area <= 0.0 {
+		return 0.0
+	}
+	distance := t * length
+	return distance * distance / (cosine * area)
+}
@@ -0,0 +1,60 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLightSampleDirectionMatchesPDFValue(t *testing.T) {
+	emit := NewDiffuseLight(NewConstantTexture(Color{R: 1.0, G: 1.0, B: 1.0}))
+	lights := map[string]Light{
+		"XYRect": NewXYRect(-1.0, 1.0, -1.0, 2.0, 3.0, emit),
+		"XZRect": NewXZRect(-1.0, 1.0, -1.0, 2.0, 3.0, emit),
+		"YZRect": NewYZRect(-1.0, 1.0, -1.0, 2.0, 3.0, emit),
+		"Sphere": NewSphere(NewVec3d(3.0, 2.0, 1.0), 0.5, emit),
+	}
+	p := NewVec3d(0.1, 0.2, -0.3)
+
+	for name, l := range lights {
+		t.Run(name, func(t *testing.T) {
+			rng := NewRNG(4)
+			for i := 0; i < 50; i++ {
+				direction, pdf := l.SampleDirection(p, rng)
+				if pdf <= 0.0 {
+					t.Fatalf("SampleDirection() pdf = %v, want positive", pdf)
+				}
+				if got := l.PDFValue(p, direction); math.Abs(got-pdf) > 1e-6*pdf {
+					t.Fatalf("PDFValue() = %v, want %v", got, pdf)
+				}
+			}
+			direction, _ := l.SampleDirection(p, rng)
+			if got := l.PDFValue(p, direction.MultiplyScalar(-1.0)); got != 0.0 {
+				t.Errorf("PDFValue() away from the light = %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestSpherePDFValueInside(t *testing.T) {
+	s := NewSphere(NewVec3d(0.0, 0.0, 0.0), 2.0, mockMaterial{})
+	if _, pdf := s.SampleDirection(NewVec3d(0.5, 0.0, 0.0), NewRNG(1)); pdf != 0.0 {
+		t.Errorf("SampleDirection() from inside pdf = %v, want 0", pdf)
+	}
+}
+
+func TestNewSceneCollectsLights(t *testing.T) {
+	emit := NewDiffuseLight(NewConstantTexture(Color{R: 1.0, G: 1.0, B: 1.0}))
+	white := NewLambertian(NewConstantTexture(Color{R: 0.5, G: 0.5, B: 0.5}))
+	world := HitableList{
+		NewFlipNormals(NewXZRect(-1.0, 1.0, -1.0, 1.0, 2.0, emit)),
+		NewSphere(NewVec3d(0.0, 5.0, 0.0), 1.0, emit),
+		NewSphere(NewVec3d(0.0, 0.0, 0.0), 1.0, white),
+		NewBox(NewVec3d(2.0, 0.0, 0.0), NewVec3d(3.0, 1.0, 1.0), white),
+	}
+	bvh := NewLinearBVH(NewBVHNode(world, 0.0, 1.0, NewRNG(1)), 0.0, 1.0)
+
+	scene := NewScene(Camera{}, HitableList{bvh})
+	if len(scene.Lights()) != 2 {
+		t.Errorf("len(Lights()) = %d, want 2", len(scene.Lights()))
+	}
+}
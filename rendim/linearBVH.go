@@ -0,0 +1,152 @@
+package rendim
+
+// LinearBVH is a bounding volume hierarchy stored as one array of nodes in
+// depth-first order, with the objects of all leaves in a second array. The
+// first child of an interior node directly follows it; the node records where
+// the second child is. Traversal visits the child nearer to the ray origin
+// first and skips every node beyond the closest hit found so far.
+//
+// It finds the same closest hits as the tree it was made from, and renders
+// the same images: media only draw their random numbers once the closest
+// surface is known, see Scene.hit.
+type LinearBVH struct {
+	nodes   []linearNode
+	objects []Hitable
+}
+
+type linearNode struct {
+	box    AABB
+	offset int // first object for leaves, second child for interior nodes
+	count  int // number of objects for leaves, 0 for interior nodes
+	axis   int // axis along which the first child lies below the second
+}
+
+// NewLinearBVH flattens the hierarchy below h into a LinearBVH. Nested
+// BVHNode and LinearBVH trees are inlined, lists in leaves are expanded and
+// anything else becomes a leaf object. Every object must have a bounding box
+// for the interval [time0, time1].
+func NewLinearBVH(h Hitable, time0, time1 float64) LinearBVH {
+	l := LinearBVH{}
+	l.flatten(h, time0, time1)
+	return l
+}
+
+func (l *LinearBVH) flatten(h Hitable, time0, time1 float64) {
+	switch n := h.(type) {
+	case BVHNode:
+		if n.left == n.right {
+			l.flatten(*n.left, time0, time1)
+			return
+		}
+		l.interior(n.box, *n.left, *n.right, time0, time1)
+	case LinearBVH:
+		l.inline(n, 0)
+	case HitableList:
+		box := AABB{}
+		if !n.BoundingBox(time0, time1, &box) {
+			panic("No bounding box in NewLinearBVH.\n")
+		}
+		l.nodes = append(l.nodes, linearNode{box: box, offset: len(l.objects), count: len(n)})
+		l.objects = append(l.objects, n...)
+	default:
+		box := AABB{}
+		if !h.BoundingBox(time0, time1, &box) {
+			panic("No bounding box in NewLinearBVH.\n")
+		}
+		l.nodes = append(l.nodes, linearNode{box: box, offset: len(l.objects), count: 1})
+		l.objects = append(l.objects, h)
+	}
+}
+
+// interior appends a node for the children a and b, ordering them so that the
+// first child is the one lower along the axis that separates them most.
+func (l *LinearBVH) interior(box AABB, a, b Hitable, time0, time1 float64) {
+	boxA, boxB := AABB{}, AABB{}
+	if !a.BoundingBox(time0, time1, &boxA) || !b.BoundingBox(time0, time1, &boxB) {
+		panic("No bounding box in NewLinearBVH.\n")
+	}
+	delta := boxB.Min.Add(boxB.Max).Subtract(boxA.Min.Add(boxA.Max))
+	axis := longestAxis(NewVec3d(delta.X()*delta.X(), delta.Y()*delta.Y(), delta.Z()*delta.Z()))
+	if delta.e[axis] < 0.0 {
+		a, b = b, a
+	}
+
+	idx := len(l.nodes)
+	l.nodes = append(l.nodes, linearNode{box: box, axis: axis})
+	l.flatten(a, time0, time1)
+	l.nodes[idx].offset = len(l.nodes)
+	l.flatten(b, time0, time1)
+}
+
+// inline copies the subtree of src rooted at node i.
+func (l *LinearBVH) inline(src LinearBVH, i int) {
+	n := src.nodes[i]
+	if n.count > 0 {
+		n.offset = len(l.objects)
+		l.objects = append(l.objects, src.objects[src.nodes[i].offset:src.nodes[i].offset+n.count]...)
+		l.nodes = append(l.nodes, n)
+		return
+	}
+
+	idx := len(l.nodes)
+	l.nodes = append(l.nodes, n)
+	l.inline(src, i+1)
+	l.nodes[idx].offset = len(l.nodes)
+	l.inline(src, n.offset)
+}
+
+func (l LinearBVH) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	if len(l.nodes) == 0 {
+		return false, HitRecord{}
+	}
+
+	var buf [64]int
+	stack := append(buf[:0], 0)
+
+	hitAnything := false
+	closestSoFar := tMax
+	rec := HitRecord{}
+
+	for len(stack) > 0 {
+		idx := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &l.nodes[idx]
+		tc.countNodeVisits(1)
+		if !node.box.hit(r, tMin, closestSoFar) {
+			continue
+		}
+
+		if node.count > 0 {
+			for _, h := range l.objects[node.offset : node.offset+node.count] {
+				if isHit, hr := h.Hit(r, tMin, closestSoFar, tc); isHit {
+					hitAnything = true
+					closestSoFar = hr.t
+					rec = hr
+				}
+			}
+			continue
+		}
+
+		// Visit the child nearer to the ray origin first.
+		if r.Direction().e[node.axis] < 0.0 {
+			stack = append(stack, idx+1, node.offset)
+		} else {
+			stack = append(stack, node.offset, idx+1)
+		}
+	}
+
+	return hitAnything, rec
+}
+
+func (l LinearBVH) BoundingBox(t0, t1 float64, box *AABB) bool {
+	if len(l.nodes) == 0 {
+		return false
+	}
+	*box = l.nodes[0].box
+	return true
+}
+
+// NodeCount returns the number of nodes in the hierarchy.
+func (l LinearBVH) NodeCount() int {
+	return len(l.nodes)
+}
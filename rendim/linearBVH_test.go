@@ -0,0 +1,155 @@
+package rendim
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func randomRays(n int, rng *RNG) []Ray {
+	rays := make([]Ray, n)
+	for i := range rays {
+		origin := NewVec3d(20*rng.Float64()-10, 20*rng.Float64()-10, 20*rng.Float64()-10)
+		target := NewVec3d(10*rng.Float64()-5, 10*rng.Float64()-5, 10*rng.Float64()-5)
+		rays[i] = NewRay(origin, target.Subtract(origin), 0.0)
+	}
+	return rays
+}
+
+func TestLinearBVHMatchesTree(t *testing.T) {
+	builders := map[string]func(HitableList) Hitable{
+		BVHMedian: func(l HitableList) Hitable { return NewBVHNode(l, 0.0, 1.0, NewRNG(1)) },
+		BVHSAH:    func(l HitableList) Hitable { return NewSAHBVH(l, 0.0, 1.0) },
+	}
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			tree := build(randomSpheres(300, NewRNG(5)))
+			linear := NewLinearBVH(tree, 0.0, 1.0)
+
+			for i, ray := range randomRays(1000, NewRNG(9)) {
+				wantHit, want := tree.Hit(ray, 0.001, math.MaxFloat64, nil)
+				gotHit, got := linear.Hit(ray, 0.001, math.MaxFloat64, nil)
+				if gotHit != wantHit || got.t != want.t || got.P != want.P || got.Normal != want.Normal {
+					t.Fatalf("ray %d: Hit() = %v %+v, want %v %+v", i, gotHit, got, wantHit, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLinearBVHInlinesNestedHierarchies(t *testing.T) {
+	inner := NewLinearBVH(NewBVHNode(randomSpheres(20, NewRNG(1)), 0.0, 1.0, NewRNG(1)), 0.0, 1.0)
+	outer := NewBVHNode(append(randomSpheres(20, NewRNG(2)), inner), 0.0, 1.0, NewRNG(1))
+	linear := NewLinearBVH(outer, 0.0, 1.0)
+
+	for _, o := range linear.objects {
+		if _, ok := o.(LinearBVH); ok {
+			t.Fatal("nested LinearBVH should be inlined, not kept as a leaf")
+		}
+	}
+	if len(linear.objects) != 40 {
+		t.Errorf("len(objects) = %d, want 40", len(linear.objects))
+	}
+
+	for i, ray := range randomRays(500, NewRNG(4)) {
+		wantHit, want := outer.Hit(ray, 0.001, math.MaxFloat64, nil)
+		gotHit, got := linear.Hit(ray, 0.001, math.MaxFloat64, nil)
+		if gotHit != wantHit || got.t != want.t {
+			t.Fatalf("ray %d: Hit() = %v, t=%v, want %v, t=%v", i, gotHit, got.t, wantHit, want.t)
+		}
+	}
+}
+
+func TestLinearBVHEmpty(t *testing.T) {
+	var l LinearBVH
+	if hit, _ := l.Hit(NewRay(NewVec3d(0, 0, 0), NewVec3d(0, 0, 1), 0), 0.0, 10.0, nil); hit {
+		t.Error("empty LinearBVH should not be hit")
+	}
+	var box AABB
+	if l.BoundingBox(0.0, 1.0, &box) {
+		t.Error("empty LinearBVH should not have a bounding box")
+	}
+}
+
+func TestLinearBVHRendersIdenticalImage(t *testing.T) {
+	// cornell and final contain constant media, whose samples must not
+	// depend on the order in which the hierarchy is visited.
+	for _, name := range []string{"simpleLight", "cornell", "final"} {
+		t.Run(name, func(t *testing.T) {
+			settings := RenderSettings{Width: 24, Height: 24, Samples: 4, BucketSize: 8, Workers: 1, Seed: 3}
+			sf := loadTestSceneFile(t, name)
+			tree, err := sf.build(settings, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			flat, err := sf.build(settings, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := flat.world[0].(LinearBVH); !ok {
+				t.Fatalf("Build() world = %T, want LinearBVH", flat.world[0])
+			}
+
+			want, _, err := RenderScene(context.Background(), tree, settings, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := RenderScene(context.Background(), flat, settings, RenderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < settings.Height; y++ {
+				for x := 0; x < settings.Width; x++ {
+					if got.At(x, y) != want.At(x, y) {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got.At(x, y), want.At(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLinearBVH compares tracing the primary rays of a 64x64 image and
+// rendering a small image through the pointer-based tree and the flattened
+// hierarchy.
+func BenchmarkLinearBVH(b *testing.B) {
+	const size = 64
+	for _, name := range []string{"cornell", "final"} {
+		for _, flat := range []bool{false, true} {
+			layout := "tree"
+			if flat {
+				layout = "linear"
+			}
+			settings := RenderSettings{Width: size, Height: size, Samples: 2, BucketSize: 16, Workers: 1}
+			scene, err := loadTestSceneFile(b, name).build(settings, flat)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(fmt.Sprintf("traverse/%s/%s", name, layout), func(b *testing.B) {
+				rng := NewRNG(1)
+				rays := make([]Ray, 0, size*size)
+				for y := 0; y < size; y++ {
+					for x := 0; x < size; x++ {
+						rays = append(rays, scene.camera.GetRay((float64(x)+0.5)/size, (float64(y)+0.5)/size, rng))
+					}
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for _, r := range rays {
+						scene.world.Hit(r, 0.001, math.MaxFloat64, nil)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("render/%s/%s", name, layout), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
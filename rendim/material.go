@@ -9,10 +9,34 @@ type Material interface {
 	Emitted(u, v float64, p Vec3d) Color
 }
 
+// PDFMaterial is a Material whose scattering can be evaluated for any pair of
+// directions and sampled with a known density. The MIS integrator samples
+// lights directly from such materials; all others are treated as specular.
+type PDFMaterial interface {
+	Material
+	// SampleScatter picks a scattered direction for a ray arriving at rec.
+	SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool)
+	// EvalScatter returns the BSDF times the cosine term for scattering
+	// towards direction and the density with which SampleScatter picks it.
+	EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64)
+}
+
+// ScatterSample is a direction chosen by a PDFMaterial.
+type ScatterSample struct {
+	Direction Vec3d
+	Weight    Color   // BSDF times the cosine term, divided by PDF
+	PDF       float64 // density with respect to solid angle
+}
+
 type Lambertian struct {
 	albedo Texture
 }
 
+// NewLambertian creates a diffuse material with the given albedo.
+func NewLambertian(albedo Texture) Lambertian {
+	return Lambertian{albedo: albedo}
+}
+
 func (l Lambertian) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
 	target := rec.P.Add(rec.Normal).Add(randomInUnitSphere(rng))
 	scattered = NewRay(rec.P, target.Subtract(rec.P), 0.0)
@@ -20,6 +44,25 @@ func (l Lambertian) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *R
 	return true, scattered
 }
 
+// SampleScatter picks a cosine-weighted direction about the normal.
+func (l Lambertian) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	d := randomCosineDirection(rng)
+	direction := newONB(rec.Normal).local(d.X(), d.Y(), d.Z())
+	return ScatterSample{
+		Direction: direction,
+		Weight:    l.albedo.Value(rec.u, rec.v, rec.P),
+		PDF:       d.Z() / math.Pi,
+	}, d.Z() > 0.0
+}
+
+func (l Lambertian) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	cosine := rec.Normal.UnitVector().Dot(direction.UnitVector())
+	if cosine <= 0.0 {
+		return Color{}, 0.0
+	}
+	return l.albedo.Value(rec.u, rec.v, rec.P).MultiplyScalar(cosine / math.Pi), cosine / math.Pi
+}
+
 func (l Lambertian) Emitted(u, v float64, p Vec3d) Color {
 	return Color{0, 0, 0}
 }
@@ -28,21 +71,104 @@ type Isotropic struct {
 	albedo Texture
 }
 
+// NewIsotropic creates a phase function that scatters uniformly in all
+// directions, for use inside participating media.
+func NewIsotropic(albedo Texture) Isotropic {
+	return Isotropic{albedo: albedo}
+}
+
 func (i Isotropic) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
 	scattered = NewRay(rec.P, randomInUnitSphere(rng), 0.0)
 	*attenuation = i.albedo.Value(rec.u, rec.v, rec.P)
 	return true, scattered
 }
 
+// SampleScatter picks a uniformly distributed direction.
+func (i Isotropic) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	return ScatterSample{
+		Direction: randomUnitVector(rng),
+		Weight:    i.albedo.Value(rec.u, rec.v, rec.P),
+		PDF:       1.0 / (4.0 * math.Pi),
+	}, true
+}
+
+func (i Isotropic) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	const pdf = 1.0 / (4.0 * math.Pi)
+	return i.albedo.Value(rec.u, rec.v, rec.P).MultiplyScalar(pdf), pdf
+}
+
 func (i Isotropic) Emitted(u, v float64, p Vec3d) Color {
 	return Color{0, 0, 0}
 }
 
+// HenyeyGreenstein is the phase function of a HeterogeneousMedium. Its
+// anisotropy g is the mean cosine of the scattering angle: positive values
+// scatter forwards like fog and clouds, negative values backwards and zero
+// in all directions alike. It does not absorb; the medium's coefficients do.
+type HenyeyGreenstein struct {
+	g float64
+}
+
+// NewHenyeyGreenstein creates a phase function with anisotropy g in (-1, 1).
+func NewHenyeyGreenstein(g float64) HenyeyGreenstein {
+	return HenyeyGreenstein{g: g}
+}
+
+// phase returns the density of scattering by an angle whose cosine is
+// cosTheta.
+func (h HenyeyGreenstein) phase(cosTheta float64) float64 {
+	denom := 1.0 + h.g*h.g - 2.0*h.g*cosTheta
+	return (1.0 - h.g*h.g) / (4.0 * math.Pi * denom * math.Sqrt(denom))
+}
+
+func (h HenyeyGreenstein) sample(rayIn Ray, rng *RNG) (Vec3d, float64) {
+	var cosTheta float64
+	u, v := rng.Float64Pair()
+	if math.Abs(h.g) < 1e-3 {
+		cosTheta = 1.0 - 2.0*u
+	} else {
+		s := (1.0 - h.g*h.g) / (1.0 - h.g + 2.0*h.g*u)
+		cosTheta = math.Max(-1.0, math.Min(1.0, (1.0+h.g*h.g-s*s)/(2.0*h.g)))
+	}
+	sinTheta := math.Sqrt(math.Max(0.0, 1.0-cosTheta*cosTheta))
+	phi := 2.0 * math.Pi * v
+	direction := newONB(rayIn.Direction()).local(sinTheta*math.Cos(phi), sinTheta*math.Sin(phi), cosTheta)
+	return direction, h.phase(cosTheta)
+}
+
+func (h HenyeyGreenstein) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
+	direction, _ := h.sample(rayIn, rng)
+	*attenuation = Color{R: 1.0, G: 1.0, B: 1.0}
+	return true, NewRay(rec.P, direction, rayIn.Time())
+}
+
+// SampleScatter picks a direction in proportion to the phase function, so the
+// weight is always one.
+func (h HenyeyGreenstein) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	direction, pdf := h.sample(rayIn, rng)
+	return ScatterSample{Direction: direction, Weight: Color{R: 1.0, G: 1.0, B: 1.0}, PDF: pdf}, true
+}
+
+func (h HenyeyGreenstein) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	p := h.phase(rayIn.Direction().UnitVector().Dot(direction.UnitVector()))
+	return Color{R: p, G: p, B: p}, p
+}
+
+func (h HenyeyGreenstein) Emitted(u, v float64, p Vec3d) Color {
+	return Color{0, 0, 0}
+}
+
 type Metal struct {
 	albedo Texture
 	fuzz   float64
 }
 
+// NewMetal creates a reflective material. fuzz in [0, 1] perturbs the
+// reflected direction, with 0 giving a perfect mirror.
+func NewMetal(albedo Texture, fuzz float64) Metal {
+	return Metal{albedo: albedo, fuzz: fuzz}
+}
+
 func (m Metal) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
 	reflected := reflect(rayIn.Direction().UnitVector(), rec.Normal)
 	scattered = NewRay(rec.P, reflected.Add(randomInUnitSphere(rng).MultiplyScalar(m.fuzz)), 0.0)
@@ -58,6 +184,12 @@ type Dielectric struct {
 	refIdx float64
 }
 
+// NewDielectric creates a clear refractive material such as glass (1.5) or
+// water (1.33) from its index of refraction.
+func NewDielectric(refIdx float64) Dielectric {
+	return Dielectric{refIdx: refIdx}
+}
+
 func (d Dielectric) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
 	*attenuation = Color{R: 1.0, G: 1.0, B: 1.0}
 	var (
@@ -109,6 +241,11 @@ type DiffuseLight struct {
 	emit Texture
 }
 
+// NewDiffuseLight creates an emitter that radiates emit and scatters nothing.
+func NewDiffuseLight(emit Texture) DiffuseLight {
+	return DiffuseLight{emit: emit}
+}
+
 func (dl DiffuseLight) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
 	return false, Ray{}
 }
@@ -118,11 +255,24 @@ func (dl DiffuseLight) Emitted(u, v float64, p Vec3d) Color {
 }
 
 func randomInUnitSphere(rng *RNG) Vec3d {
-	p := NewVec3d(rng.Float64(), rng.Float64(), rng.Float64()).MultiplyScalar(2.0).Subtract(NewVec3d(1.0, 1.0, 1.0))
-	for p.Dot(p) >= 1.0 {
-		p = NewVec3d(rng.Float64(), rng.Float64(), rng.Float64()).MultiplyScalar(2.0).Subtract(NewVec3d(1.0, 1.0, 1.0))
-	}
-	return p
+	return randomUnitVector(rng).MultiplyScalar(math.Cbrt(rng.Float64()))
+}
+
+func randomUnitVector(rng *RNG) Vec3d {
+	u1, u2 := rng.Float64Pair()
+	z := 1.0 - 2.0*u1
+	r := math.Sqrt(math.Max(0.0, 1.0-z*z))
+	phi := 2.0 * math.Pi * u2
+	return NewVec3d(r*math.Cos(phi), r*math.Sin(phi), z)
+}
+
+// randomCosineDirection returns a direction in the +z hemisphere with density
+// cos(theta)/pi.
+func randomCosineDirection(rng *RNG) Vec3d {
+	r1, r2 := rng.Float64Pair()
+	phi := 2.0 * math.Pi * r1
+	r := math.Sqrt(r2)
+	return NewVec3d(r*math.Cos(phi), r*math.Sin(phi), math.Sqrt(1.0-r2))
 }
 
 func reflect(v, n Vec3d) Vec3d {
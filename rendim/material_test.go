@@ -237,3 +237,78 @@ func TestRandomInUnitSphere(t *testing.T) {
 		}
 	}
 }
+
+func TestLambertianSampleMatchesEval(t *testing.T) {
+	albedo := constantTexture{color: Color{R: 0.5, G: 0.25, B: 1.0}}
+	mat := Lambertian{albedo: albedo}
+	rng := NewRNG(2)
+	rayIn := NewRay(NewVec3d(0.0, 1.0, 0.0), NewVec3d(0.0, -1.0, 0.0), 0.0)
+	rec := HitRecord{P: NewVec3d(0.0, 0.0, 0.0), Normal: NewVec3d(0.0, 1.0, 0.0)}
+
+	for i := 0; i < 100; i++ {
+		sample, ok := mat.SampleScatter(rayIn, rec, rng)
+		if !ok {
+			continue
+		}
+		if sample.Direction.Dot(rec.Normal) <= 0.0 {
+			t.Fatalf("SampleScatter() direction %v is below the surface", sample.Direction)
+		}
+		f, pdf := mat.EvalScatter(rayIn, rec, sample.Direction)
+		if math.Abs(pdf-sample.PDF) > 1e-9 {
+			t.Fatalf("EvalScatter() pdf = %v, SampleScatter() pdf = %v", pdf, sample.PDF)
+		}
+		if want := sample.Weight.MultiplyScalar(pdf); math.Abs(f.R-want.R) > 1e-9 || math.Abs(f.B-want.B) > 1e-9 {
+			t.Fatalf("EvalScatter() = %v, want Weight*PDF = %v", f, want)
+		}
+	}
+
+	if f, pdf := mat.EvalScatter(rayIn, rec, NewVec3d(0.0, -1.0, 0.0)); pdf != 0.0 || f.R != 0.0 {
+		t.Errorf("EvalScatter() below the surface = %v, %v, want 0", f, pdf)
+	}
+}
+
+func TestIsotropicEvalScatter(t *testing.T) {
+	mat := Isotropic{albedo: constantTexture{color: Color{R: 1.0, G: 1.0, B: 1.0}}}
+	f, pdf := mat.EvalScatter(Ray{}, HitRecord{}, NewVec3d(0.0, 0.0, 1.0))
+	if want := 1.0 / (4.0 * math.Pi); math.Abs(pdf-want) > 1e-12 || math.Abs(f.G-want) > 1e-12 {
+		t.Errorf("EvalScatter() = %v, %v, want %v for both", f, pdf, want)
+	}
+}
+
+func TestHenyeyGreensteinNormalized(t *testing.T) {
+	for _, g := range []float64{-0.7, 0.0, 0.3, 0.9} {
+		h := NewHenyeyGreenstein(g)
+		// Integrate over the sphere, which for a function of cos(theta) alone
+		// is 2 pi times the integral over cos(theta) in [-1, 1].
+		const n = 200000
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += h.phase(-1.0 + 2.0*(float64(i)+0.5)/n)
+		}
+		if total := 2.0 * math.Pi * sum * 2.0 / n; math.Abs(total-1.0) > 1e-3 {
+			t.Errorf("g = %v: phase function integrates to %v, want 1", g, total)
+		}
+	}
+}
+
+func TestHenyeyGreensteinSampling(t *testing.T) {
+	rayIn := NewRay(NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 2.0, -0.5), 0.0)
+	for _, g := range []float64{-0.5, 0.0, 0.8} {
+		h := NewHenyeyGreenstein(g)
+		if w := checkSampling(t, "henyeyGreenstein", h, rayIn, HitRecord{}); math.Abs(w-1.0) > 1e-9 {
+			t.Errorf("g = %v: mean weight = %v, want 1", g, w)
+		}
+
+		// The anisotropy is the mean cosine of the scattering angle.
+		rng := NewRNG(5)
+		const n = 50000
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			s, _ := h.SampleScatter(rayIn, HitRecord{}, rng)
+			sum += s.Direction.UnitVector().Dot(rayIn.Direction().UnitVector())
+		}
+		if mean := sum / n; math.Abs(mean-g) > 0.01 {
+			t.Errorf("g = %v: mean cosine = %v", g, mean)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package rendim
+
+import "math"
+
+// HeterogeneousMedium fills a boundary with a participating medium whose
+// density varies from point to point. It absorbs and scatters each colour
+// channel by its own coefficients, so that for example the blue of a haze
+// scatters more than its red.
+//
+// Rays never hit a HeterogeneousMedium. Instead it records the stretch of the
+// ray inside its boundary in the TraceContext, and the integrators track the
+// ray through it: delta tracking picks where paths scatter, ratio tracking
+// estimates the transmittance of shadow rays. This leaves the rest of the
+// world unchanged, including transforms and hierarchies around the medium.
+type HeterogeneousMedium struct {
+	boundary Hitable
+	density  DensityField
+	sigmaA   Color // absorption per unit length at density one
+	sigmaS   Color // scattering per unit length at density one
+	phase    HenyeyGreenstein
+	majorant float64 // bounds the extinction of every channel inside the boundary
+}
+
+// NewHeterogeneousMedium fills boundary with a medium whose absorption and
+// scattering coefficients are sigmaA and sigmaS times density, scattering
+// light by the Henyey-Greenstein phase function with anisotropy g.
+func NewHeterogeneousMedium(boundary Hitable, density DensityField, sigmaA, sigmaS Color, g float64) HeterogeneousMedium {
+	var box AABB
+	if !boundary.BoundingBox(0.0, 1.0, &box) {
+		panic("No bounding box in NewHeterogeneousMedium.\n")
+	}
+	sigmaT := sigmaA.Add(sigmaS)
+	maxSigma := math.Max(sigmaT.R, math.Max(sigmaT.G, sigmaT.B))
+	return HeterogeneousMedium{
+		boundary: boundary,
+		density:  density,
+		sigmaA:   sigmaA,
+		sigmaS:   sigmaS,
+		phase:    NewHenyeyGreenstein(g),
+		majorant: maxSigma * density.MaxDensity(box),
+	}
+}
+
+func (m HeterogeneousMedium) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	if tc == nil || m.majorant <= 0.0 {
+		return false, HitRecord{}
+	}
+	isHit1, rec1 := m.boundary.Hit(r, -math.MaxFloat64, math.MaxFloat64, tc)
+	if !isHit1 {
+		return false, HitRecord{}
+	}
+	isHit2, rec2 := m.boundary.Hit(r, rec1.t+0.0001, math.MaxFloat64, tc)
+	if !isHit2 {
+		return false, HitRecord{}
+	}
+	t0, t1 := math.Max(rec1.t, tMin), math.Min(rec2.t, tMax)
+	if t0 < t1 {
+		tc.media = append(tc.media, mediumSegment{medium: m, ray: r, t0: t0, t1: t1})
+	}
+	return false, HitRecord{}
+}
+
+func (m HeterogeneousMedium) BoundingBox(t0, t1 float64, box *AABB) bool {
+	return m.boundary.BoundingBox(t0, t1, box)
+}
+
+// coefficients returns the absorption and scattering coefficients at p.
+func (m HeterogeneousMedium) coefficients(p Vec3d) (Color, Color) {
+	d := math.Max(0.0, m.density.Density(p))
+	return m.sigmaA.MultiplyScalar(d), m.sigmaS.MultiplyScalar(d)
+}
+
+// mediumSegment is the part of a ray from t0 to t1 that lies in a medium. The
+// ray is the one the medium saw, in the medium's own coordinates, and has the
+// same parameter as the ray traced through the world.
+type mediumSegment struct {
+	medium HeterogeneousMedium
+	ray    Ray
+	t0, t1 float64
+}
+
+// mediumEvent is what happens to a ray tracked through media.
+type mediumEvent int
+
+const (
+	mediumPassed mediumEvent = iota
+	mediumScattered
+	mediumAbsorbed
+)
+
+// clipMedia cuts the segments off at tEnd, dropping those that end up empty,
+// and returns what is left with its extent and combined majorant.
+func clipMedia(segments []mediumSegment, tEnd float64) ([]mediumSegment, float64, float64, float64) {
+	start, end, majorant := math.MaxFloat64, 0.0, 0.0
+	kept := segments[:0]
+	for _, s := range segments {
+		s.t1 = math.Min(s.t1, tEnd)
+		if s.t0 >= s.t1 {
+			continue
+		}
+		kept = append(kept, s)
+		start, end = math.Min(start, s.t0), math.Max(end, s.t1)
+		majorant += s.medium.majorant
+	}
+	return kept, start, end, majorant
+}
+
+// collisions returns the absorption, scattering and null coefficients at t
+// along the segments.
+func collisions(segments []mediumSegment, t, majorant float64) (Color, Color, Color) {
+	var sigmaA, sigmaS Color
+	for _, s := range segments {
+		if t < s.t0 || t > s.t1 {
+			continue
+		}
+		a, sc := s.medium.coefficients(s.ray.PointAt(t))
+		sigmaA, sigmaS = sigmaA.Add(a), sigmaS.Add(sc)
+	}
+	sigmaT := sigmaA.Add(sigmaS)
+	sigmaN := Color{
+		R: math.Max(0.0, majorant-sigmaT.R),
+		G: math.Max(0.0, majorant-sigmaT.G),
+		B: math.Max(0.0, majorant-sigmaT.B),
+	}
+	return sigmaA, sigmaS, sigmaN
+}
+
+func channelSum(c Color) float64 {
+	return c.R + c.G + c.B
+}
+
+// trackMedia follows r through the media segments up to tEnd by delta
+// tracking against their combined majorant. With coefficients that differ
+// per channel it uses spectral tracking (Kutz et al. 2017): collision types
+// are chosen by the coefficients weighted by the path so far, and the
+// returned weight corrects for that choice. It reports whether the ray passed
+// or where it scattered; an absorbed ray carries no light.
+func trackMedia(r Ray, segments []mediumSegment, tEnd float64, rng *RNG) (mediumEvent, HitRecord, Color) {
+	weight := Color{R: 1.0, G: 1.0, B: 1.0}
+	segments, start, end, majorant := clipMedia(segments, tEnd)
+	if len(segments) == 0 {
+		return mediumPassed, HitRecord{}, weight
+	}
+
+	speed := r.Direction().Length()
+	for t := start; ; {
+		t -= math.Log(1.0-rng.Float64()) / (majorant * speed)
+		if t >= end {
+			return mediumPassed, HitRecord{}, weight
+		}
+
+		sigmaA, sigmaS, sigmaN := collisions(segments, t, majorant)
+		pa := channelSum(weight.Multiply(sigmaA))
+		ps := channelSum(weight.Multiply(sigmaS))
+		pn := channelSum(weight.Multiply(sigmaN))
+		total := pa + ps + pn
+		if total <= 0.0 {
+			return mediumAbsorbed, HitRecord{}, Color{}
+		}
+
+		switch u := rng.Float64() * total; {
+		case u < pa:
+			return mediumAbsorbed, HitRecord{}, Color{}
+		case u < pa+ps:
+			return scatterInMedia(r, segments, t, majorant, total, weight, rng)
+		default:
+			weight = weight.Multiply(sigmaN).MultiplyScalar(total / (majorant * pn))
+		}
+	}
+}
+
+// scatterInMedia picks which of the media overlapping at t scatters the ray,
+// in proportion to their weighted scattering coefficients, and returns the
+// scattering event at t.
+func scatterInMedia(r Ray, segments []mediumSegment, t, majorant, total float64, weight Color, rng *RNG) (mediumEvent, HitRecord, Color) {
+	var picked *mediumSegment
+	var sigmaS Color
+	var p float64
+	sum := 0.0
+	for i := range segments {
+		s := &segments[i]
+		if t < s.t0 || t > s.t1 {
+			continue
+		}
+		_, sc := s.medium.coefficients(s.ray.PointAt(t))
+		ps := channelSum(weight.Multiply(sc))
+		if ps <= 0.0 {
+			continue
+		}
+		// Reservoir sampling keeps each medium with probability ps / sum.
+		sum += ps
+		if picked == nil || rng.Float64()*sum < ps {
+			picked, sigmaS, p = s, sc, ps
+		}
+	}
+	if picked == nil {
+		return mediumAbsorbed, HitRecord{}, Color{}
+	}
+
+	rec := HitRecord{t: t, P: r.PointAt(t), Normal: r.Direction().UnitVector().MultiplyScalar(-1.0), material: picked.medium.phase}
+	return mediumScattered, rec, weight.Multiply(sigmaS).MultiplyScalar(total / (majorant * p))
+}
+
+// transmittance estimates the fraction of light that crosses the media
+// segments up to tEnd by ratio tracking, stopping early by Russian roulette
+// once little light is left.
+func transmittance(r Ray, segments []mediumSegment, tEnd float64, rng *RNG) Color {
+	tr := Color{R: 1.0, G: 1.0, B: 1.0}
+	segments, start, end, majorant := clipMedia(segments, tEnd)
+	if len(segments) == 0 {
+		return tr
+	}
+
+	speed := r.Direction().Length()
+	for t := start; ; {
+		t -= math.Log(1.0-rng.Float64()) / (majorant * speed)
+		if t >= end {
+			return tr
+		}
+		_, _, sigmaN := collisions(segments, t, majorant)
+		tr = tr.Multiply(sigmaN).DivideScalar(majorant)
+
+		if q := math.Max(tr.R, math.Max(tr.G, tr.B)); q < 0.1 {
+			if rng.Float64() >= q {
+				return Color{}
+			}
+			tr = tr.DivideScalar(q)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+// unitBoxMedium fills the box from -1 to 1 with the given density.
+func unitBoxMedium(density DensityField, sigmaA, sigmaS Color, g float64) HeterogeneousMedium {
+	boundary := NewBox(NewVec3d(-1.0, -1.0, -1.0), NewVec3d(1.0, 1.0, 1.0), mockMaterial{})
+	return NewHeterogeneousMedium(boundary, density, sigmaA, sigmaS, g)
+}
+
+// segmentsAlong traces r through world and returns the media it crosses.
+func segmentsAlong(t *testing.T, world Hitable, r Ray) []mediumSegment {
+	t.Helper()
+	tc := &TraceContext{}
+	if isHit, _ := world.Hit(r, 0.001, math.MaxFloat64, tc); isHit {
+		t.Fatal("a medium should never be hit")
+	}
+	return tc.media
+}
+
+func TestHeterogeneousMediumRecordsSegment(t *testing.T) {
+	m := unitBoxMedium(NewUniformDensity(1.0), Color{}, Color{R: 1.0, G: 1.0, B: 1.0}, 0.0)
+	world := HitableList{NewTranslate(m, NewVec3d(5.0, 0.0, 0.0))}
+	r := NewRay(NewVec3d(0.0, 0.0, 0.0), NewVec3d(2.0, 0.0, 0.0), 0.0)
+
+	segments := segmentsAlong(t, world, r)
+	if len(segments) != 1 {
+		t.Fatalf("recorded %d segments, want 1", len(segments))
+	}
+	if s := segments[0]; math.Abs(s.t0-2.0) > 1e-6 || math.Abs(s.t1-3.0) > 1e-6 {
+		t.Errorf("segment = [%v, %v], want [2, 3]", s.t0, s.t1)
+	}
+	// The medium sees the ray in its own coordinates.
+	if p := segments[0].ray.PointAt(2.5); p.Length() > 1e-9 {
+		t.Errorf("centre of the segment is at %v in the medium, want the origin", p)
+	}
+
+	if isHit, _ := m.Hit(r, 0.0, math.MaxFloat64, nil); isHit {
+		t.Error("Hit() without a TraceContext should report no hit")
+	}
+	missed := NewRay(NewVec3d(0.0, 3.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+	if s := segmentsAlong(t, world, missed); len(s) != 0 {
+		t.Errorf("a ray missing the boundary recorded %v", s)
+	}
+}
+
+func TestMediaTransmittance(t *testing.T) {
+	sigmaA := Color{R: 0.2, G: 0.5, B: 1.0}
+	sigmaS := Color{R: 0.3, G: 0.3, B: 0.3}
+	tests := []struct {
+		name    string
+		density DensityField
+		r       Ray
+		depth   float64 // optical depth at density one
+	}{
+		{"uniform", NewUniformDensity(1.0), NewRay(NewVec3d(-3.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0), 2.0},
+		// Straight up through fog of density e^-y/2 from y = -1 to 1.
+		{"heightFog", NewHeightFog(1.0, 0.0, 0.5), NewRay(NewVec3d(0.0, -3.0, 0.0), NewVec3d(0.0, 1.0, 0.0), 0.0), 4.0 * math.Sinh(0.5)},
+	}
+
+	for _, tt := range tests {
+		m := unitBoxMedium(tt.density, sigmaA, sigmaS, 0.0)
+		segments := segmentsAlong(t, m, tt.r)
+		sigmaT := sigmaA.Add(sigmaS)
+		want := Color{R: math.Exp(-sigmaT.R * tt.depth), G: math.Exp(-sigmaT.G * tt.depth), B: math.Exp(-sigmaT.B * tt.depth)}
+
+		rng := NewRNG(7)
+		const n = 40000
+		var ratio, delta Color
+		for i := 0; i < n; i++ {
+			ratio = ratio.Add(transmittance(tt.r, append([]mediumSegment(nil), segments...), math.MaxFloat64, rng))
+			if event, _, w := trackMedia(tt.r, append([]mediumSegment(nil), segments...), math.MaxFloat64, rng); event == mediumPassed {
+				delta = delta.Add(w)
+			}
+		}
+		if got := ratio.DivideScalar(n); !colorEqualTol(got, want, 0.01) {
+			t.Errorf("%s: ratio tracking transmittance = %v, want %v", tt.name, got, want)
+		}
+		if got := delta.DivideScalar(n); !colorEqualTol(got, want, 0.01) {
+			t.Errorf("%s: delta tracking transmittance = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestMediaScatterWeight(t *testing.T) {
+	// Scattering and extinction differ per channel; the mean weight of the
+	// scattering events is the scattered fraction of each.
+	sigmaS := Color{R: 0.8, G: 0.5, B: 0.2}
+	sigmaA := Color{R: 0.2, G: 0.1, B: 0.6}
+	m := unitBoxMedium(NewUniformDensity(1.0), sigmaA, sigmaS, 0.3)
+	r := NewRay(NewVec3d(-3.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+	segments := segmentsAlong(t, m, r)
+
+	rng := NewRNG(9)
+	const n = 40000
+	var scattered Color
+	for i := 0; i < n; i++ {
+		event, rec, w := trackMedia(r, append([]mediumSegment(nil), segments...), math.MaxFloat64, rng)
+		if event != mediumScattered {
+			continue
+		}
+		if rec.P.X() < -1.0 || rec.P.X() > 1.0 {
+			t.Fatalf("scattered at %v, outside the medium", rec.P)
+		}
+		if _, ok := rec.material.(HenyeyGreenstein); !ok {
+			t.Fatalf("scattering material = %T, want HenyeyGreenstein", rec.material)
+		}
+		scattered = scattered.Add(w)
+	}
+
+	sigmaT := sigmaA.Add(sigmaS)
+	fraction := func(s, t float64) float64 { return s / t * (1.0 - math.Exp(-2.0*t)) }
+	want := Color{R: fraction(sigmaS.R, sigmaT.R), G: fraction(sigmaS.G, sigmaT.G), B: fraction(sigmaS.B, sigmaT.B)}
+	if got := scattered.DivideScalar(n); !colorEqualTol(got, want, 0.01) {
+		t.Errorf("mean scattering weight = %v, want %v", got, want)
+	}
+}
+
+func TestMediaStopAtSurface(t *testing.T) {
+	m := unitBoxMedium(NewUniformDensity(100.0), Color{}, Color{R: 1.0, G: 1.0, B: 1.0}, 0.0)
+	r := NewRay(NewVec3d(-3.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+	segments := segmentsAlong(t, m, r)
+	// A surface just in front of the medium hides it completely.
+	if got := transmittance(r, segments, 1.5, NewRNG(1)); got != (Color{R: 1.0, G: 1.0, B: 1.0}) {
+		t.Errorf("transmittance before the medium = %v, want 1", got)
+	}
+}
+
+func TestMediumFurnace(t *testing.T) {
+	sky := NewConstantSky(Color{R: 1.0, G: 1.0, B: 1.0})
+	ray := NewRay(NewVec3d(0.0, 0.0, -5.0), NewVec3d(0.05, 0.1, 1.0), 0.0)
+	sphere := NewSphere(NewVec3d(0.0, 0.0, 0.0), 1.0, mockMaterial{})
+
+	// A medium that only scatters passes all light on, whatever the colour
+	// of its coefficients.
+	scattering := NewHeterogeneousMedium(sphere, NewNoiseDensity(2.0, 3.0), Color{}, Color{R: 2.0, G: 1.0, B: 0.5}, 0.6)
+	scene := NewScene(Camera{}, HitableList{scattering}).WithEnvironment(sky)
+	for _, name := range []string{IntegratorPath, IntegratorMIS} {
+		radiance := integrator(name)
+		rng := NewRNG(4)
+		const n = 20000
+		var sum Color
+		for i := 0; i < n; i++ {
+			sum = sum.Add(radiance(ray, &scene, rng, &TraceContext{}))
+		}
+		if mean := sum.DivideScalar(n); !colorEqualTol(mean, Color{R: 1.0, G: 1.0, B: 1.0}, 0.03) {
+			t.Errorf("%s: mean radiance = %v, want 1", name, mean)
+		}
+	}
+
+	// One that only absorbs dims the sky seen through it.
+	sigmaA := Color{R: 0.1, G: 0.5, B: 1.0}
+	absorbing := NewHeterogeneousMedium(sphere, NewUniformDensity(1.0), sigmaA, Color{}, 0.0)
+	scene = NewScene(Camera{}, HitableList{absorbing}).WithEnvironment(sky)
+	straight := NewRay(NewVec3d(0.0, 0.0, -5.0), NewVec3d(0.0, 0.0, 1.0), 0.0)
+	want := Color{R: math.Exp(-0.2), G: math.Exp(-1.0), B: math.Exp(-2.0)}
+	for _, name := range []string{IntegratorPath, IntegratorMIS} {
+		radiance := integrator(name)
+		rng := NewRNG(4)
+		const n = 20000
+		var sum Color
+		for i := 0; i < n; i++ {
+			sum = sum.Add(radiance(straight, &scene, rng, nil))
+		}
+		if mean := sum.DivideScalar(n); !colorEqualTol(mean, want, 0.01) {
+			t.Errorf("%s: mean radiance = %v, want %v", name, mean, want)
+		}
+	}
+}
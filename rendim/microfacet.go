@@ -0,0 +1,328 @@
+package rendim
+
+import "math"
+
+// minAlpha keeps the GGX distribution finite on perfectly smooth surfaces.
+const minAlpha = 1e-3
+
+// ggx is the isotropic GGX (Trowbridge-Reitz) microfacet distribution. Its
+// methods work in a local frame with the macrosurface normal along +z.
+type ggx struct {
+	alpha float64
+}
+
+// newGGX maps a perceptual roughness in [0, 1] to the distribution width.
+func newGGX(roughness float64) ggx {
+	r := math.Max(0.0, math.Min(1.0, roughness))
+	return ggx{alpha: math.Max(r*r, minAlpha)}
+}
+
+// d returns the density of microfacet normals h per unit projected area.
+func (g ggx) d(h Vec3d) float64 {
+	if h.Z() <= 0.0 {
+		return 0.0
+	}
+	a2 := g.alpha * g.alpha
+	t := h.Z()*h.Z()*(a2-1.0) + 1.0
+	return a2 / (math.Pi * t * t)
+}
+
+// lambda is Smith's auxiliary function for the masking of direction w.
+func (g ggx) lambda(w Vec3d) float64 {
+	cos2 := w.Z() * w.Z()
+	if cos2 == 0.0 {
+		return math.Inf(1)
+	}
+	tan2 := (1.0 - cos2) / cos2
+	return 0.5 * (math.Sqrt(1.0+g.alpha*g.alpha*tan2) - 1.0)
+}
+
+// g1 returns the fraction of microfacets visible from w.
+func (g ggx) g1(w Vec3d) float64 {
+	return 1.0 / (1.0 + g.lambda(w))
+}
+
+// g2 is the height-correlated masking-shadowing term for wo and wi.
+func (g ggx) g2(wo, wi Vec3d) float64 {
+	return 1.0 / (1.0 + g.lambda(wo) + g.lambda(wi))
+}
+
+// sampleVisible picks a microfacet normal from those visible from wo, with
+// density visiblePDF (Heitz, "Sampling the GGX Distribution of Visible
+// Normals", 2018). wo must be above the surface.
+func (g ggx) sampleVisible(wo Vec3d, u1, u2 float64) Vec3d {
+	// Stretch the view direction so that the distribution becomes a
+	// hemisphere, then sample the projected disc it sees.
+	vh := NewVec3d(g.alpha*wo.X(), g.alpha*wo.Y(), wo.Z()).UnitVector()
+	t1 := NewVec3d(1.0, 0.0, 0.0)
+	if lenSq := vh.X()*vh.X() + vh.Y()*vh.Y(); lenSq > 0.0 {
+		t1 = NewVec3d(-vh.Y(), vh.X(), 0.0).DivideScalar(math.Sqrt(lenSq))
+	}
+	t2 := vh.Cross(t1)
+
+	r := math.Sqrt(u1)
+	phi := 2.0 * math.Pi * u2
+	p1 := r * math.Cos(phi)
+	p2 := r * math.Sin(phi)
+	s := 0.5 * (1.0 + vh.Z())
+	p2 = (1.0-s)*math.Sqrt(1.0-p1*p1) + s*p2
+
+	nh := t1.MultiplyScalar(p1).Add(t2.MultiplyScalar(p2)).Add(vh.MultiplyScalar(math.Sqrt(math.Max(0.0, 1.0-p1*p1-p2*p2))))
+	return NewVec3d(g.alpha*nh.X(), g.alpha*nh.Y(), math.Max(1e-6, nh.Z())).UnitVector()
+}
+
+// visiblePDF returns the density with which sampleVisible picks h.
+func (g ggx) visiblePDF(wo, h Vec3d) float64 {
+	return g.g1(wo) * math.Max(0.0, wo.Dot(h)) * g.d(h) / math.Abs(wo.Z())
+}
+
+// reflectionPDF returns the density of reflecting wo into wi about a sampled
+// visible normal.
+func (g ggx) reflectionPDF(wo, wi Vec3d) float64 {
+	h := wo.Add(wi).UnitVector()
+	return g.visiblePDF(wo, h) / (4.0 * wo.Dot(h))
+}
+
+// shadingFrame returns a basis around the normal of rec turned towards the
+// viewer, and the direction to the viewer in it.
+func shadingFrame(rayIn Ray, rec HitRecord) (onb, Vec3d) {
+	wo := rayIn.Direction().UnitVector().MultiplyScalar(-1.0)
+	n := rec.Normal
+	if wo.Dot(n) < 0.0 {
+		n = n.MultiplyScalar(-1.0)
+	}
+	frame := newONB(n)
+	return frame, frame.toLocal(wo)
+}
+
+// scalarValue reads a scalar parameter from the red channel of t.
+func scalarValue(t Texture, rec HitRecord) float64 {
+	return t.Value(rec.u, rec.v, rec.P).R
+}
+
+// schlickColor is Schlick's approximation of the Fresnel reflectance with a
+// coloured reflectance f0 at normal incidence.
+func schlickColor(f0 Color, cosine float64) Color {
+	w := math.Pow(1.0-math.Max(0.0, math.Min(1.0, cosine)), 5.0)
+	return f0.MultiplyScalar(1.0 - w).Add(Color{R: w, G: w, B: w})
+}
+
+// fresnelDielectric returns the exact reflectance of unpolarised light at a
+// dielectric boundary with relative index eta = n_t / n_i, or 1 on total
+// internal reflection.
+func fresnelDielectric(cosI, eta float64) float64 {
+	sin2T := (1.0 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1.0 {
+		return 1.0
+	}
+	cosT := math.Sqrt(1.0 - sin2T)
+	rs := (cosI - eta*cosT) / (cosI + eta*cosT)
+	rp := (eta*cosI - cosT) / (eta*cosI + cosT)
+	return 0.5 * (rs*rs + rp*rp)
+}
+
+// Microfacet is an opaque material in the metallic workflow: a GGX specular
+// lobe over a diffuse base. Metallic surfaces reflect with the base colour as
+// their reflectance and have no diffuse part; others reflect 4% at normal
+// incidence and scatter the rest diffusely. It is two-sided.
+type Microfacet struct {
+	baseColor Texture
+	roughness Texture
+	metallic  Texture
+}
+
+// NewMicrofacet creates a metallic-workflow GGX material. roughness and
+// metallic are read from the red channel of their textures and should be in
+// [0, 1].
+func NewMicrofacet(baseColor, roughness, metallic Texture) Microfacet {
+	return Microfacet{baseColor: baseColor, roughness: roughness, metallic: metallic}
+}
+
+// microfacetLobes are the parameters of a Microfacet at one point.
+type microfacetLobes struct {
+	dist     ggx
+	f0       Color
+	diffuse  Color
+	specProb float64 // probability of sampling the specular lobe
+}
+
+func (m Microfacet) lobes(rec HitRecord, cosO float64) microfacetLobes {
+	base := m.baseColor.Value(rec.u, rec.v, rec.P)
+	metallic := math.Max(0.0, math.Min(1.0, scalarValue(m.metallic, rec)))
+	dielectricF0 := Color{R: 0.04, G: 0.04, B: 0.04}
+	l := microfacetLobes{
+		dist:    newGGX(scalarValue(m.roughness, rec)),
+		f0:      dielectricF0.MultiplyScalar(1.0 - metallic).Add(base.MultiplyScalar(metallic)),
+		diffuse: base.MultiplyScalar(1.0 - metallic),
+	}
+	spec := luminance(schlickColor(l.f0, cosO))
+	diff := luminance(l.diffuse)
+	l.specProb = 1.0
+	if spec+diff > 0.0 {
+		l.specProb = math.Max(0.1, spec/(spec+diff))
+	}
+	return l
+}
+
+// eval returns the BSDF times the cosine term and the sampling density for
+// local directions wo and wi.
+func (l microfacetLobes) eval(wo, wi Vec3d) (Color, float64) {
+	if wo.Z() <= 0.0 || wi.Z() <= 0.0 {
+		return Color{}, 0.0
+	}
+	h := wo.Add(wi).UnitVector()
+	fresnel := schlickColor(l.f0, wi.Dot(h))
+	spec := fresnel.MultiplyScalar(l.dist.d(h) * l.dist.g2(wo, wi) / (4.0 * wo.Z()))
+	// The diffuse part receives the light the specular lobe lets through.
+	diffuse := l.diffuse.Multiply(Color{R: 1.0 - fresnel.R, G: 1.0 - fresnel.G, B: 1.0 - fresnel.B}).MultiplyScalar(wi.Z() / math.Pi)
+	pdf := l.specProb*l.dist.reflectionPDF(wo, wi) + (1.0-l.specProb)*wi.Z()/math.Pi
+	return spec.Add(diffuse), pdf
+}
+
+func (m Microfacet) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
+	return scatterBySampling(m, rayIn, rec, attenuation, rng)
+}
+
+// SampleScatter picks the specular or diffuse lobe and a direction from it,
+// sampling visible microfacet normals for the specular one.
+func (m Microfacet) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	frame, wo := shadingFrame(rayIn, rec)
+	l := m.lobes(rec, wo.Z())
+
+	var wi Vec3d
+	if rng.Float64() < l.specProb {
+		u1, u2 := rng.Float64Pair()
+		h := l.dist.sampleVisible(wo, u1, u2)
+		wi = reflect(wo.MultiplyScalar(-1.0), h)
+	} else {
+		wi = randomCosineDirection(rng)
+	}
+
+	f, pdf := l.eval(wo, wi)
+	if pdf <= 0.0 {
+		return ScatterSample{}, false
+	}
+	return ScatterSample{Direction: frame.local(wi.X(), wi.Y(), wi.Z()), Weight: f.DivideScalar(pdf), PDF: pdf}, true
+}
+
+func (m Microfacet) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	frame, wo := shadingFrame(rayIn, rec)
+	return m.lobes(rec, wo.Z()).eval(wo, frame.toLocal(direction.UnitVector()))
+}
+
+func (m Microfacet) Emitted(u, v float64, p Vec3d) Color {
+	return Color{0, 0, 0}
+}
+
+// RoughDielectric is a GGX microfacet boundary between air and a clear
+// medium, like frosted glass. It reflects and refracts according to the
+// exact Fresnel equations.
+type RoughDielectric struct {
+	refIdx    float64
+	roughness Texture
+}
+
+// NewRoughDielectric creates a rough refractive material from its index of
+// refraction and a roughness read from the red channel of roughness.
+func NewRoughDielectric(refIdx float64, roughness Texture) RoughDielectric {
+	return RoughDielectric{refIdx: refIdx, roughness: roughness}
+}
+
+func (d RoughDielectric) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
+	return scatterBySampling(d, rayIn, rec, attenuation, rng)
+}
+
+// SampleScatter picks a visible microfacet normal and then reflects or
+// refracts about it in proportion to its Fresnel reflectance.
+func (d RoughDielectric) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	frame, wo, eta := dielectricFrame(rayIn, rec, d.refIdx)
+	dist := newGGX(scalarValue(d.roughness, rec))
+	wi, ok := sampleDielectric(dist, wo, eta, rng)
+	if !ok {
+		return ScatterSample{}, false
+	}
+	f, pdf := evalDielectric(dist, wo, wi, eta)
+	if pdf <= 0.0 {
+		return ScatterSample{}, false
+	}
+	return ScatterSample{Direction: frame.local(wi.X(), wi.Y(), wi.Z()), Weight: f.DivideScalar(pdf), PDF: pdf}, true
+}
+
+func (d RoughDielectric) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	frame, wo, eta := dielectricFrame(rayIn, rec, d.refIdx)
+	return evalDielectric(newGGX(scalarValue(d.roughness, rec)), wo, frame.toLocal(direction.UnitVector()), eta)
+}
+
+// dielectricFrame returns the local frame around the normal on the side of
+// the viewer, the direction to the viewer in it and the relative index of
+// refraction n_t / n_i of a boundary with index refIdx seen from there.
+func dielectricFrame(rayIn Ray, rec HitRecord, refIdx float64) (onb, Vec3d, float64) {
+	wo := rayIn.Direction().UnitVector().MultiplyScalar(-1.0)
+	n, eta := rec.Normal, refIdx
+	if wo.Dot(n) < 0.0 {
+		n, eta = n.MultiplyScalar(-1.0), 1.0/refIdx
+	}
+	frame := newONB(n)
+	return frame, frame.toLocal(wo), eta
+}
+
+// sampleDielectric picks a visible microfacet normal and reflects or refracts
+// wo about it in proportion to its Fresnel reflectance.
+func sampleDielectric(dist ggx, wo Vec3d, eta float64, rng *RNG) (Vec3d, bool) {
+	u1, u2 := rng.Float64Pair()
+	h := dist.sampleVisible(wo, u1, u2)
+	cosO := wo.Dot(h)
+	if rng.Float64() < fresnelDielectric(cosO, eta) {
+		wi := reflect(wo.MultiplyScalar(-1.0), h)
+		return wi, wi.Z() > 0.0
+	}
+	cosT := math.Sqrt(1.0 - (1.0-cosO*cosO)/(eta*eta))
+	wi := wo.MultiplyScalar(-1.0 / eta).Add(h.MultiplyScalar(cosO/eta - cosT))
+	return wi, wi.Z() < 0.0
+}
+
+// evalDielectric returns the BSDF times the cosine term and the density of
+// sampleDielectric for local directions. Like Dielectric, transmitted
+// radiance is not scaled by the change in solid angle across the boundary.
+func evalDielectric(dist ggx, wo, wi Vec3d, eta float64) (Color, float64) {
+	if wo.Z() <= 0.0 || wi.Z() == 0.0 {
+		return Color{}, 0.0
+	}
+
+	if wi.Z() > 0.0 {
+		h := wo.Add(wi).UnitVector()
+		fresnel := fresnelDielectric(wo.Dot(h), eta)
+		f := fresnel * dist.d(h) * dist.g2(wo, wi) / (4.0 * wo.Z())
+		return Color{R: f, G: f, B: f}, fresnel * dist.reflectionPDF(wo, wi)
+	}
+
+	// The microfacet normal that refracts wo into wi.
+	h := wo.Add(wi.MultiplyScalar(eta)).UnitVector()
+	if h.Z() < 0.0 {
+		h = h.MultiplyScalar(-1.0)
+	}
+	cosO, cosI := wo.Dot(h), wi.Dot(h)
+	if cosO <= 0.0 || cosI >= 0.0 {
+		return Color{}, 0.0
+	}
+	denom := cosO + eta*cosI
+	jacobian := eta * eta * math.Abs(cosI) / (denom * denom)
+	transmitted := 1.0 - fresnelDielectric(cosO, eta)
+	f := transmitted * dist.d(h) * dist.g2(wo, wi) * cosO * jacobian / wo.Z()
+	return Color{R: f, G: f, B: f}, transmitted * dist.visiblePDF(wo, h) * jacobian
+}
+
+func (d RoughDielectric) Emitted(u, v float64, p Vec3d) Color {
+	return Color{0, 0, 0}
+}
+
+// scatterBySampling implements Material.Scatter for a PDFMaterial, for the
+// path integrator.
+func scatterBySampling(m PDFMaterial, rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (bool, Ray) {
+	sample, ok := m.SampleScatter(rayIn, rec, rng)
+	if !ok {
+		return false, Ray{}
+	}
+	*attenuation = sample.Weight
+	return true, NewRay(rec.P, sample.Direction, rayIn.Time())
+}
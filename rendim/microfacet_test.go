@@ -0,0 +1,195 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+func grey(v float64) ConstantTexture {
+	return NewConstantTexture(Color{R: v, G: v, B: v})
+}
+
+// upperHemisphere returns a direction with uniform density 1/(2 pi) above the
+// xy plane.
+func upperHemisphere(rng *RNG) Vec3d {
+	d := randomUnitVector(rng)
+	return NewVec3d(d.X(), d.Y(), math.Abs(d.Z()))
+}
+
+func TestGGXNormalized(t *testing.T) {
+	rng := NewRNG(1)
+	for _, roughness := range []float64{0.3, 0.6, 1.0} {
+		g := newGGX(roughness)
+		wo := NewVec3d(0.6, 0.0, 0.8)
+		const n = 200000
+		projected, visible := 0.0, 0.0
+		for i := 0; i < n; i++ {
+			h := upperHemisphere(rng)
+			projected += g.d(h) * h.Z() * 2.0 * math.Pi
+			visible += g.visiblePDF(wo, h) * 2.0 * math.Pi
+		}
+		if got := projected / n; math.Abs(got-1.0) > 0.03 {
+			t.Errorf("roughness %v: integral of D cos = %v, want 1", roughness, got)
+		}
+		if got := visible / n; math.Abs(got-1.0) > 0.03 {
+			t.Errorf("roughness %v: integral of visiblePDF = %v, want 1", roughness, got)
+		}
+	}
+}
+
+func TestGGXSampleVisible(t *testing.T) {
+	// The mean of D(h) over visible normals follows from the visible density.
+	g := newGGX(0.5)
+	wo := NewVec3d(0.0, 0.6, 0.8)
+	rng := NewRNG(2)
+	const n = 100000
+	sampled := 0.0
+	for i := 0; i < n; i++ {
+		h := g.sampleVisible(wo, rng.Float64(), rng.Float64())
+		if h.Z() <= 0.0 || wo.Dot(h) < 0.0 {
+			t.Fatalf("sampleVisible() = %v, not visible from %v", h, wo)
+		}
+		sampled += h.Z()
+	}
+	expected := 0.0
+	for i := 0; i < n; i++ {
+		h := upperHemisphere(rng)
+		expected += h.Z() * g.visiblePDF(wo, h) * 2.0 * math.Pi
+	}
+	if got, want := sampled/n, expected/n; math.Abs(got-want) > 0.01 {
+		t.Errorf("mean cos of sampled normals = %v, want %v", got, want)
+	}
+}
+
+func TestFresnelDielectric(t *testing.T) {
+	if got := fresnelDielectric(1.0, 1.5); math.Abs(got-0.04) > 1e-12 {
+		t.Errorf("fresnelDielectric(1, 1.5) = %v, want 0.04", got)
+	}
+	if got := fresnelDielectric(0.1, 1.0/1.5); got != 1.0 {
+		t.Errorf("fresnelDielectric() past the critical angle = %v, want 1", got)
+	}
+	if got := fresnelDielectric(0.0, 1.5); math.Abs(got-1.0) > 1e-12 {
+		t.Errorf("fresnelDielectric() at grazing incidence = %v, want 1", got)
+	}
+}
+
+// checkSampling checks that SampleScatter reports the density and weight
+// that EvalScatter gives for the same direction, and returns the mean weight.
+func checkSampling(t *testing.T, name string, m PDFMaterial, rayIn Ray, rec HitRecord) float64 {
+	t.Helper()
+	rng := NewRNG(3)
+	const n = 20000
+	sum := 0.0
+	for i := 0; i < n; i++ {
+		s, ok := m.SampleScatter(rayIn, rec, rng)
+		if !ok {
+			continue
+		}
+		f, pdf := m.EvalScatter(rayIn, rec, s.Direction)
+		if math.Abs(pdf-s.PDF) > 1e-6*pdf {
+			t.Fatalf("%s: EvalScatter() pdf = %v, SampleScatter() said %v", name, pdf, s.PDF)
+		}
+		if w := f.DivideScalar(pdf); !colorEqualTol(w, s.Weight, 1e-6*(1.0+s.Weight.G)) {
+			t.Fatalf("%s: EvalScatter() weight = %v, SampleScatter() said %v", name, w, s.Weight)
+		}
+		sum += s.Weight.G
+	}
+	return sum / n
+}
+
+func colorEqualTol(a, b Color, tol float64) bool {
+	return math.Abs(a.R-b.R) <= tol && math.Abs(a.G-b.G) <= tol && math.Abs(a.B-b.B) <= tol
+}
+
+func TestMicrofacetEnergy(t *testing.T) {
+	rec := HitRecord{P: NewVec3d(0.0, 0.0, 0.0), Normal: NewVec3d(0.0, 1.0, 0.0)}
+	rayIn := NewRay(NewVec3d(-1.0, 1.0, 0.0), NewVec3d(1.0, -1.0, 0.0), 0.0)
+
+	tests := []struct {
+		name                string
+		roughness, metallic float64
+		min                 float64
+	}{
+		{"Smooth metal", 0.05, 1.0, 0.99},
+		{"Rough metal", 0.5, 1.0, 0.85},
+		{"Very rough metal", 1.0, 1.0, 0.35},
+		{"Plastic", 0.4, 0.0, 0.9},
+	}
+	for _, tt := range tests {
+		m := NewMicrofacet(grey(1.0), grey(tt.roughness), grey(tt.metallic))
+		albedo := checkSampling(t, tt.name, m, rayIn, rec)
+		// Single-scattering GGX loses energy at high roughness, 60% at a
+		// roughness of 1, but must never create any.
+		if albedo > 1.01 || albedo < tt.min {
+			t.Errorf("%s: albedo = %v, want in [%v, 1]", tt.name, albedo, tt.min)
+		}
+	}
+}
+
+func TestMicrofacetTwoSided(t *testing.T) {
+	m := NewMicrofacet(grey(0.8), grey(0.3), grey(1.0))
+	rec := HitRecord{Normal: NewVec3d(0.0, 1.0, 0.0)}
+	rayIn := NewRay(NewVec3d(0.0, -1.0, 1.0), NewVec3d(0.0, 1.0, -1.0), 0.0)
+	s, ok := m.SampleScatter(rayIn, rec, NewRNG(1))
+	if !ok || s.Direction.Y() >= 0.0 {
+		t.Errorf("SampleScatter() from below = %v, %v, want a direction below the surface", s.Direction, ok)
+	}
+}
+
+func TestRoughDielectricEnergy(t *testing.T) {
+	rayIn := NewRay(NewVec3d(-1.0, 2.0, 0.0), NewVec3d(0.5, -1.0, 0.0), 0.0)
+	for _, roughness := range []float64{0.0, 0.2, 0.6} {
+		d := NewRoughDielectric(1.5, grey(roughness))
+		for _, normal := range []Vec3d{NewVec3d(0.0, 1.0, 0.0), NewVec3d(0.0, -1.0, 0.0)} {
+			rec := HitRecord{Normal: normal}
+			total := checkSampling(t, "rough dielectric", d, rayIn, rec)
+			if total > 1.01 || total < 0.8 {
+				t.Errorf("roughness %v, normal %v: reflected and transmitted = %v, want close to 1", roughness, normal, total)
+			}
+		}
+	}
+}
+
+func TestRoughDielectricSmoothRefracts(t *testing.T) {
+	d := NewRoughDielectric(1.5, grey(0.0))
+	rec := HitRecord{Normal: NewVec3d(0.0, 1.0, 0.0)}
+	rayIn := NewRay(NewVec3d(0.0, 1.0, 0.0), NewVec3d(0.0, -1.0, 0.0), 0.0)
+	rng := NewRNG(4)
+	transmitted := 0
+	for i := 0; i < 1000; i++ {
+		s, ok := d.SampleScatter(rayIn, rec, rng)
+		if !ok {
+			continue
+		}
+		if s.Direction.Y() < 0.0 {
+			transmitted++
+			if s.Direction.UnitVector().Y() > -0.999 {
+				t.Fatalf("transmitted direction = %v, want straight through", s.Direction)
+			}
+		}
+	}
+	// 4% is reflected at normal incidence.
+	if transmitted < 930 || transmitted > 985 {
+		t.Errorf("%d of 1000 samples transmitted, want about 960", transmitted)
+	}
+}
+
+func TestMicrofacetIntegratorsAgree(t *testing.T) {
+	scene, _ := lightOverFloor()
+	floor := NewXZRect(-100.0, 100.0, -100.0, 100.0, 0.0, NewMicrofacet(grey(0.7), grey(0.4), grey(0.5)))
+	scene = NewScene(Camera{}, HitableList{floor, scene.world[1]})
+	ray := NewRay(NewVec3d(0.0, 1.0, -1.0), NewVec3d(0.0, -1.0, 1.0), 0.0)
+
+	mean := func(radiance radianceFunc) float64 {
+		rng := NewRNG(6)
+		const samples = 40000
+		sum := 0.0
+		for i := 0; i < samples; i++ {
+			sum += radiance(ray, &scene, rng, nil).G
+		}
+		return sum / samples
+	}
+	if path, mis := mean(pathRadiance), mean(misRadiance); math.Abs(path-mis) > 0.06*mis {
+		t.Errorf("path tracing = %v, MIS = %v, want the same radiance", path, mis)
+	}
+}
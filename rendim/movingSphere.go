@@ -9,6 +9,8 @@ type MovingSphere struct {
 	material         Material
 }
 
+// NewMovingSphere creates a sphere whose center moves linearly from cen0 at
+// time t0 to cen1 at time t1, for motion blur.
 func NewMovingSphere(cen0, cen1 Vec3d, t0, t1, radius float64, material Material) MovingSphere {
 	return MovingSphere{center0: cen0, center1: cen1, time0: t0, time1: t1, Radius: radius, material: material}
 }
@@ -17,7 +19,8 @@ func (s MovingSphere) Center(time float64) Vec3d {
 	return s.center0.Add(s.center1.Subtract(s.center0).MultiplyScalar((time - s.time0) / (s.time1 - s.time0)))
 }
 
-func (s MovingSphere) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (s MovingSphere) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
 	oc := r.Origin().Subtract(s.Center(r.Time()))
 	a := r.Direction().Dot(r.Direction())
 	b := oc.Dot(r.Direction())
@@ -73,7 +73,7 @@ func TestMovingSphereHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, rec := ms.Hit(tt.ray, 0.0, 10.0)
+			hit, rec := ms.Hit(tt.ray, 0.0, 10.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
@@ -95,7 +95,7 @@ func TestMovingSphereHitMoving(t *testing.T) {
 	ms := NewMovingSphere(cen0, cen1, 0.0, 1.0, 1.0, mat)
 	
 	ray := NewRay(NewVec3d(5.0, 0.0, -5.0), NewVec3d(0.0, 0.0, 1.0), 0.5)
-	hit, _ := ms.Hit(ray, 0.0, 10.0)
+	hit, _ := ms.Hit(ray, 0.0, 10.0, nil)
 	
 	if !hit {
 		t.Error("Ray should hit moving sphere at its midpoint position")
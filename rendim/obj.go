@@ -0,0 +1,479 @@
+package rendim
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseError reports a malformed line in an OBJ or MTL file.
+type ParseError struct {
+	File string
+	Line int
+	Msg  string
+	Err  error // the error reading a referenced file, if any
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var defaultOBJMaterial = Lambertian{albedo: ConstantTexture{color: Color{R: 0.73, G: 0.73, B: 0.73}}}
+
+// LoadOBJ reads a Wavefront OBJ file and returns one TriangleMesh per group or
+// object. Polygons are triangulated as fans, materials are taken from the MTL
+// files named by mtllib and faces without a material, or with one the MTL
+// files lack, get a grey Lambertian.
+func LoadOBJ(path string) (HitableList, error) {
+	return loadOBJ(path, samePath)
+}
+
+// loadOBJ is LoadOBJ for the file with the given name, which resolve turns
+// into the path to open. The files it refers to are named relative to the
+// directory of name and opened through resolve as well.
+func loadOBJ(name string, resolve func(string) string) (HitableList, error) {
+	path := resolve(name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseOBJ(f, path, filepath.Dir(name), resolve)
+}
+
+// samePath is the resolve function of files opened by the names they are
+// given.
+func samePath(name string) string {
+	return name
+}
+
+type objGroup struct {
+	name      string
+	faces     []MeshFace
+	materials []Material
+	matIndex  map[string]int
+}
+
+func (g *objGroup) materialIndex(name string, mat Material) int {
+	if idx, ok := g.matIndex[name]; ok {
+		return idx
+	}
+	g.matIndex[name] = len(g.materials)
+	g.materials = append(g.materials, mat)
+	return len(g.materials) - 1
+}
+
+func parseOBJ(r io.Reader, name, dir string, resolve func(string) string) (HitableList, error) {
+	var (
+		vertices []Vec3d
+		normals  []Vec3d
+		uvs      []TexCoord
+		groups   []*objGroup
+	)
+
+	materials := map[string]Material{}
+	currentMaterial := ""
+	current := &objGroup{name: "default", matIndex: map[string]int{}}
+	groups = append(groups, current)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		fail := func(format string, args ...interface{}) error {
+			return &ParseError{File: name, Line: lineNo, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		switch fields[0] {
+		case "v":
+			v, err := parseFloats(fields[1:], 3, 4)
+			if err != nil {
+				return nil, fail("vertex: %v", err)
+			}
+			vertices = append(vertices, NewVec3d(v[0], v[1], v[2]))
+		case "vn":
+			v, err := parseFloats(fields[1:], 3, 3)
+			if err != nil {
+				return nil, fail("vertex normal: %v", err)
+			}
+			normals = append(normals, NewVec3d(v[0], v[1], v[2]))
+		case "vt":
+			v, err := parseFloats(fields[1:], 1, 3)
+			if err != nil {
+				return nil, fail("texture coordinate: %v", err)
+			}
+			uv := TexCoord{U: v[0]}
+			if len(v) > 1 {
+				uv.V = v[1]
+			}
+			uvs = append(uvs, uv)
+		case "f":
+			if len(fields) < 4 {
+				return nil, fail("face needs at least 3 vertices, got %d", len(fields)-1)
+			}
+			corners := make([]MeshFace, len(fields)-1)
+			for i, ref := range fields[1:] {
+				v, t, n, err := parseFaceVertex(ref, len(vertices), len(uvs), len(normals))
+				if err != nil {
+					return nil, fail("face vertex %q: %v", ref, err)
+				}
+				corners[i] = MeshFace{V: [3]int{v}, UV: [3]int{t}, N: [3]int{n}}
+			}
+
+			mat := Material(defaultOBJMaterial)
+			if currentMaterial != "" {
+				mat = materials[currentMaterial]
+			}
+			matIdx := current.materialIndex(currentMaterial, mat)
+
+			for i := 1; i+1 < len(corners); i++ {
+				a, b, c := corners[0], corners[i], corners[i+1]
+				current.faces = append(current.faces, MeshFace{
+					V:        [3]int{a.V[0], b.V[0], c.V[0]},
+					UV:       [3]int{a.UV[0], b.UV[0], c.UV[0]},
+					N:        [3]int{a.N[0], b.N[0], c.N[0]},
+					Material: matIdx,
+				})
+			}
+		case "g", "o":
+			groupName := strings.Join(fields[1:], " ")
+			if groupName == "" {
+				groupName = "default"
+			}
+			current = &objGroup{name: groupName, matIndex: map[string]int{}}
+			groups = append(groups, current)
+		case "usemtl":
+			if len(fields) != 2 {
+				return nil, fail("usemtl needs a material name")
+			}
+			// Exported files often name materials their MTL files lack,
+			// whose faces get the default material.
+			currentMaterial = ""
+			if _, ok := materials[fields[1]]; ok {
+				currentMaterial = fields[1]
+			}
+		case "mtllib":
+			if len(fields) < 2 {
+				return nil, fail("mtllib needs a file name")
+			}
+			for _, lib := range fields[1:] {
+				mats, err := loadMTL(filepath.Join(dir, lib), resolve)
+				if err != nil {
+					return nil, &ParseError{File: name, Line: lineNo, Msg: fmt.Sprintf("mtllib: %v", err), Err: err}
+				}
+				for k, m := range mats {
+					materials[k] = m
+				}
+			}
+		default:
+			// Smoothing groups, lines, points, free-form geometry and other
+			// display attributes are not rendered.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ParseError{File: name, Line: lineNo + 1, Msg: err.Error()}
+	}
+
+	meshes := HitableList{}
+	for _, g := range groups {
+		if len(g.faces) == 0 {
+			continue
+		}
+		mesh, err := NewTriangleMesh(vertices, normals, uvs, g.faces, g.materials)
+		if err != nil {
+			return nil, fmt.Errorf("%s: group %q: %w", name, g.name, err)
+		}
+		meshes = append(meshes, mesh)
+	}
+	if len(meshes) == 0 {
+		return nil, fmt.Errorf("%s: no faces", name)
+	}
+
+	return meshes, nil
+}
+
+// parseFaceVertex parses a v, v/vt, v//vn or v/vt/vn face reference into
+// zero-based indices, resolving negative (relative) indices. Missing texture
+// coordinates and normals are returned as -1.
+func parseFaceVertex(ref string, nv, nt, nn int) (v, t, n int, err error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) > 3 {
+		return 0, 0, 0, fmt.Errorf("too many components")
+	}
+
+	v, err = resolveOBJIndex(parts[0], nv)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	t, n = -1, -1
+	if len(parts) > 1 && parts[1] != "" {
+		if t, err = resolveOBJIndex(parts[1], nt); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if n, err = resolveOBJIndex(parts[2], nn); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return v, t, n, nil
+}
+
+func resolveOBJIndex(s string, count int) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", s)
+	}
+	switch {
+	case idx > 0 && idx <= count:
+		return idx - 1, nil
+	case idx < 0 && -idx <= count:
+		return count + idx, nil
+	default:
+		return 0, fmt.Errorf("index %d out of range (%d defined)", idx, count)
+	}
+}
+
+// LoadMTL reads a Wavefront MTL file and maps each material onto the closest
+// rendim material: emitters (Ke) become DiffuseLight, transparent materials
+// (d < 1 or illum 4, 6, 7) become Dielectric, mirror materials (illum 3 or 5)
+// become Metal with fuzz derived from Ns and everything else is Lambertian,
+// textured with map_Kd when present.
+func LoadMTL(path string) (map[string]Material, error) {
+	return loadMTL(path, samePath)
+}
+
+// loadMTL is LoadMTL for a file named like those loadOBJ opens.
+func loadMTL(name string, resolve func(string) string) (map[string]Material, error) {
+	path := resolve(name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMTL(f, path, filepath.Dir(name), resolve)
+}
+
+type mtlEntry struct {
+	name     string
+	line     int
+	kd, ks   Color
+	ke       Color
+	ns       float64
+	ni       float64
+	dissolve float64
+	illum    int
+	mapKd    Texture
+}
+
+func (m mtlEntry) material() Material {
+	switch {
+	case m.ke.R > 0.0 || m.ke.G > 0.0 || m.ke.B > 0.0:
+		return DiffuseLight{emit: ConstantTexture{color: m.ke}}
+	case m.dissolve < 1.0 || m.illum == 4 || m.illum == 6 || m.illum == 7:
+		refIdx := m.ni
+		if refIdx <= 1.0 {
+			refIdx = 1.5
+		}
+		return Dielectric{refIdx: refIdx}
+	case m.illum == 3 || m.illum == 5:
+		albedo := Texture(ConstantTexture{color: m.ks})
+		if m.mapKd != nil {
+			albedo = m.mapKd
+		}
+		return Metal{albedo: albedo, fuzz: phongExponentToFuzz(m.ns)}
+	default:
+		albedo := Texture(ConstantTexture{color: m.kd})
+		if m.mapKd != nil {
+			albedo = m.mapKd
+		}
+		return Lambertian{albedo: albedo}
+	}
+}
+
+// phongExponentToFuzz converts a Phong specular exponent into a Metal fuzz
+// factor using the usual Beckmann roughness approximation.
+func phongExponentToFuzz(ns float64) float64 {
+	if ns <= 0.0 {
+		return 1.0
+	}
+	fuzz := 2.0 / (ns + 2.0)
+	if fuzz > 1.0 {
+		fuzz = 1.0
+	}
+	return fuzz
+}
+
+func parseMTL(r io.Reader, name, dir string, resolve func(string) string) (map[string]Material, error) {
+	var entries []*mtlEntry
+	var current *mtlEntry
+	images := map[string]Texture{}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(stripComment(scanner.Text()))
+		if len(fields) == 0 {
+			continue
+		}
+		fail := func(format string, args ...interface{}) error {
+			return &ParseError{File: name, Line: lineNo, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		if fields[0] == "newmtl" {
+			if len(fields) != 2 {
+				return nil, fail("newmtl needs a material name")
+			}
+			current = &mtlEntry{name: fields[1], line: lineNo, kd: Color{R: 0.8, G: 0.8, B: 0.8}, dissolve: 1.0, illum: 2}
+			entries = append(entries, current)
+			continue
+		}
+		if current == nil {
+			return nil, fail("%q before newmtl", fields[0])
+		}
+
+		var err error
+		switch fields[0] {
+		case "Kd":
+			current.kd, err = parseColor(fields[1:])
+		case "Ks":
+			current.ks, err = parseColor(fields[1:])
+		case "Ke":
+			current.ke, err = parseColor(fields[1:])
+		case "Ns":
+			current.ns, err = parseFloat(fields[1:])
+		case "Ni":
+			current.ni, err = parseFloat(fields[1:])
+		case "d":
+			current.dissolve, err = parseFloat(fields[1:])
+		case "Tr":
+			var tr float64
+			tr, err = parseFloat(fields[1:])
+			current.dissolve = 1.0 - tr
+		case "illum":
+			if len(fields) != 2 {
+				err = fmt.Errorf("expected 1 value, got %d", len(fields)-1)
+				break
+			}
+			current.illum, err = strconv.Atoi(fields[1])
+		case "map_Kd":
+			if len(fields) < 2 {
+				err = fmt.Errorf("missing file name")
+				break
+			}
+			// Texture options such as -s or -o precede the file name and are ignored.
+			file := filepath.Join(dir, fields[len(fields)-1])
+			tex, ok := images[file]
+			if !ok {
+				if tex, err = LoadImageTexture(resolve(file)); err == nil {
+					images[file] = tex
+				}
+			}
+			current.mapKd = tex
+		default:
+			// Ambient colour, other texture maps and the like have no
+			// counterpart in the rendim materials.
+		}
+		if err != nil {
+			return nil, &ParseError{File: name, Line: lineNo, Msg: fmt.Sprintf("%s: %v", fields[0], err), Err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ParseError{File: name, Line: lineNo + 1, Msg: err.Error()}
+	}
+
+	materials := make(map[string]Material, len(entries))
+	for _, e := range entries {
+		if _, ok := materials[e.name]; ok {
+			return nil, &ParseError{File: name, Line: e.line, Msg: fmt.Sprintf("duplicate material %q", e.name)}
+		}
+		materials[e.name] = e.material()
+	}
+
+	return materials, nil
+}
+
+// objReferences returns the names of the files an OBJ or MTL file refers to:
+// the libraries of its mtllib lines and the textures of its map_Kd lines, as
+// the loaders read them.
+func objReferences(data []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(stripComment(scanner.Text()))
+		switch {
+		case len(fields) < 2:
+		case fields[0] == "mtllib":
+			names = append(names, fields[1:]...)
+		case fields[0] == "map_Kd":
+			names = append(names, fields[len(fields)-1])
+		}
+	}
+	return names
+}
+
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+func parseFloats(fields []string, minCount, maxCount int) ([]float64, error) {
+	if len(fields) < minCount || len(fields) > maxCount {
+		if minCount == maxCount {
+			return nil, fmt.Errorf("expected %d values, got %d", minCount, len(fields))
+		}
+		return nil, fmt.Errorf("expected %d to %d values, got %d", minCount, maxCount, len(fields))
+	}
+
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func parseFloat(fields []string) (float64, error) {
+	v, err := parseFloats(fields, 1, 1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+func parseColor(fields []string) (Color, error) {
+	// A single value is shorthand for a grey colour.
+	v, err := parseFloats(fields, 1, 3)
+	if err != nil {
+		return Color{}, err
+	}
+	if len(v) == 1 {
+		return Color{R: v[0], G: v[0], B: v[0]}, nil
+	}
+	if len(v) != 3 {
+		return Color{}, fmt.Errorf("expected 1 or 3 values, got %d", len(v))
+	}
+	return Color{R: v[0], G: v[1], B: v[2]}, nil
+}
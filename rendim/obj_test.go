@@ -0,0 +1,232 @@
+package rendim
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOBJ(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "quad.mtl", `
+newmtl red
+Kd 0.8 0.1 0.1
+
+newmtl lamp
+Ke 4 4 4
+`)
+	path := writeTestFile(t, dir, "quad.obj", `# a quad and a triangle
+mtllib quad.mtl
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 1
+o quad
+usemtl red
+f 1/1/1 2/2/1 3/3/1 4/4/1
+o lamp
+usemtl lamp
+f -4 -3 -2
+`)
+
+	meshes, err := LoadOBJ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meshes) != 2 {
+		t.Fatalf("LoadOBJ() returned %d meshes, want 2", len(meshes))
+	}
+
+	quad := meshes[0].(TriangleMesh)
+	if quad.FaceCount() != 2 {
+		t.Errorf("Quad has %d faces, want 2 after triangulation", quad.FaceCount())
+	}
+
+	ray := NewRay(NewVec3d(0.75, 0.25, 1.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+	hit, rec := quad.Hit(ray, 0.0, 10.0, nil)
+	if !hit {
+		t.Fatal("Ray should hit the quad")
+	}
+	if _, ok := rec.material.(Lambertian); !ok {
+		t.Errorf("Quad material = %T, want Lambertian", rec.material)
+	}
+	if rec.u < 0.74 || rec.u > 0.76 || rec.v < 0.24 || rec.v > 0.26 {
+		t.Errorf("UV = (%f, %f), want (0.75, 0.25)", rec.u, rec.v)
+	}
+
+	_, rec = meshes[1].Hit(ray, 0.0, 10.0, nil)
+	if _, ok := rec.material.(DiffuseLight); !ok {
+		t.Errorf("Lamp material = %T, want DiffuseLight", rec.material)
+	}
+}
+
+func TestLoadOBJUnknownMaterial(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "red.mtl", "newmtl red\nKd 0.8 0.1 0.1\n")
+	path := writeTestFile(t, dir, "tri.obj", `usemtl missing
+mtllib red.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+usemtl red
+f 1 3 2
+usemtl gone
+f 2 3 1
+`)
+
+	meshes, err := LoadOBJ(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := meshes[0].(TriangleMesh)
+	var defaults int
+	for _, f := range mesh.faces {
+		if mesh.materials[f.Material] == Material(defaultOBJMaterial) {
+			defaults++
+		}
+	}
+	if defaults != 2 {
+		t.Errorf("%d faces have the default material, want the 2 with unknown materials", defaults)
+	}
+}
+
+func TestLoadOBJErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		line int
+		msg  string
+	}{
+		{"Bad vertex", "v 0 0 0\nv 1 x 0\n", 2, "invalid number"},
+		{"Index out of range", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n", 4, "out of range"},
+		{"Too few vertices", "v 0 0 0\nv 1 0 0\nf 1 2\n", 3, "at least 3"},
+		{"Missing mtllib", "mtllib nothere.mtl\n", 1, "mtllib"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "bad.obj", tt.obj)
+			_, err := LoadOBJ(path)
+
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("LoadOBJ() error = %v, want *ParseError", err)
+			}
+			if perr.Line != tt.line {
+				t.Errorf("Line = %d, want %d", perr.Line, tt.line)
+			}
+			if !strings.Contains(perr.Error(), tt.msg) {
+				t.Errorf("Error() = %q, should mention %q", perr.Error(), tt.msg)
+			}
+		})
+	}
+}
+
+func TestLoadMTLMaterials(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	f, err := os.Create(filepath.Join(dir, "tex.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	path := writeTestFile(t, dir, "mats.mtl", `
+newmtl diffuse
+Kd 0.5 0.5 0.5
+
+newmtl textured
+map_Kd -s 1 1 1 tex.png
+
+newmtl mirror
+illum 3
+Ks 0.9 0.9 0.9
+Ns 1000
+
+newmtl glass
+illum 4
+Ni 1.33
+
+newmtl light
+Ke 1 1 1
+`)
+
+	mats, err := LoadMTL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mats["diffuse"].(Lambertian); !ok {
+		t.Errorf("diffuse = %T, want Lambertian", mats["diffuse"])
+	}
+	if l, ok := mats["textured"].(Lambertian); !ok {
+		t.Errorf("textured = %T, want Lambertian", mats["textured"])
+	} else if _, ok := l.albedo.(ImageTexture); !ok {
+		t.Errorf("textured albedo = %T, want ImageTexture", l.albedo)
+	}
+	if m, ok := mats["mirror"].(Metal); !ok {
+		t.Errorf("mirror = %T, want Metal", mats["mirror"])
+	} else if m.fuzz > 0.01 {
+		t.Errorf("mirror fuzz = %f, want close to 0", m.fuzz)
+	}
+	if d, ok := mats["glass"].(Dielectric); !ok {
+		t.Errorf("glass = %T, want Dielectric", mats["glass"])
+	} else if d.refIdx != 1.33 {
+		t.Errorf("glass refIdx = %f, want 1.33", d.refIdx)
+	}
+	if _, ok := mats["light"].(DiffuseLight); !ok {
+		t.Errorf("light = %T, want DiffuseLight", mats["light"])
+	}
+}
+
+func TestLoadMTLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		mtl  string
+		line int
+	}{
+		{"Statement before newmtl", "Kd 1 1 1\n", 1},
+		{"Bad colour", "newmtl a\nKd 1 1\n", 2},
+		{"Missing texture", "newmtl a\n\nmap_Kd missing.png\n", 3},
+		{"Duplicate material", "newmtl a\nnewmtl a\n", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, t.TempDir(), "bad.mtl", tt.mtl)
+			_, err := LoadMTL(path)
+
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("LoadMTL() error = %v, want *ParseError", err)
+			}
+			if perr.Line != tt.line {
+				t.Errorf("Line = %d, want %d", perr.Line, tt.line)
+			}
+		})
+	}
+}
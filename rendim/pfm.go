@@ -0,0 +1,76 @@
+package rendim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WritePFM writes fb as a little-endian colour Portable Float Map.
+func WritePFM(w io.Writer, fb *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", fb.width, fb.height); err != nil {
+		return err
+	}
+
+	// PFM stores rows from the bottom up.
+	row := make([]byte, 4*3*fb.width)
+	for y := fb.height - 1; y >= 0; y-- {
+		for i, v := range fb.pix[3*y*fb.width : 3*(y+1)*fb.width] {
+			binary.LittleEndian.PutUint32(row[4*i:], math.Float32bits(v))
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadPFM decodes a colour ("PF") or greyscale ("Pf") Portable Float Map of
+// either byte order. The absolute value of the scale is not applied.
+func ReadPFM(r io.Reader) (*Framebuffer, error) {
+	br := bufio.NewReader(r)
+	var magic string
+	var width, height int
+	var scale float64
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &scale); err != nil {
+		return nil, fmt.Errorf("invalid PFM header: %w", err)
+	}
+	channels := 3
+	switch magic {
+	case "PF":
+	case "Pf":
+		channels = 1
+	default:
+		return nil, fmt.Errorf("invalid PFM magic %q", magic)
+	}
+	if width <= 0 || height <= 0 || width > 1<<15 || height > 1<<15 {
+		return nil, fmt.Errorf("invalid PFM size %dx%d", width, height)
+	}
+	// A single whitespace character separates the header from the data.
+	if _, err := br.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder = binary.BigEndian
+	if scale < 0.0 {
+		order = binary.LittleEndian
+	}
+
+	fb := NewFramebuffer(width, height)
+	row := make([]byte, 4*channels*width)
+	for y := height - 1; y >= 0; y-- {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("truncated PFM data: %w", err)
+		}
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				v := math.Float32frombits(order.Uint32(row[4*(channels*x+c%channels):]))
+				fb.pix[3*(y*width+x)+c] = v
+			}
+		}
+	}
+	return fb, nil
+}
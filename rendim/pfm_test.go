@@ -0,0 +1,81 @@
+package rendim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestWritePFM(t *testing.T) {
+	fb := testFramebuffer(5, 3)
+	var buf bytes.Buffer
+	if err := WritePFM(&buf, fb); err != nil {
+		t.Fatal(err)
+	}
+
+	var width, height int
+	var scale float64
+	n, err := fmt.Fscanf(&buf, "PF\n%d %d\n%f\n", &width, &height, &scale)
+	if err != nil || n != 3 {
+		t.Fatalf("header: %v", err)
+	}
+	if width != 5 || height != 3 || scale >= 0 {
+		t.Fatalf("header = %d %d %v, want 5 3 and a negative (little-endian) scale", width, height, scale)
+	}
+
+	data := buf.Bytes()
+	if len(data) != 4*3*width*height {
+		t.Fatalf("pixel data is %d bytes, want %d", len(data), 4*3*width*height)
+	}
+	for row := 0; row < height; row++ {
+		y := height - 1 - row
+		for x := 0; x < width; x++ {
+			i := 4 * 3 * (row*width + x)
+			got := Color{
+				R: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i:]))),
+				G: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i+4:]))),
+				B: float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i+8:]))),
+			}
+			if want := fb.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestReadPFM(t *testing.T) {
+	fb := testFramebuffer(5, 3)
+	var buf bytes.Buffer
+	if err := WritePFM(&buf, fb); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPFM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			if got.At(x, y) != fb.At(x, y) {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got.At(x, y), fb.At(x, y))
+			}
+		}
+	}
+}
+
+func TestReadPFMGreyBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("Pf\n2 1\n1.0\n")
+	_ = binary.Write(&buf, binary.BigEndian, []float32{0.5, 8.0})
+	fb, err := ReadPFM(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fb.At(1, 0); got != (Color{R: 8.0, G: 8.0, B: 8.0}) {
+		t.Errorf("pixel = %v, want grey 8", got)
+	}
+	if _, err := ReadPFM(bytes.NewReader([]byte("PF\n2 2\n-1.0\n\x00"))); err == nil {
+		t.Error("ReadPFM() should fail on truncated data")
+	}
+}
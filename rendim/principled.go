@@ -0,0 +1,251 @@
+package rendim
+
+import "math"
+
+// PrincipledParams are the parameters of a Principled material. Scalar
+// parameters are read from the red channel of their textures and should be
+// in [0, 1]; nil textures take the defaults listed.
+type PrincipledParams struct {
+	BaseColor      Texture // 0.8 grey
+	Metallic       Texture // 0
+	Roughness      Texture // 0.5
+	Specular       Texture // 0.5, a reflectance of 4% at normal incidence
+	SpecularTint   Texture // 0, tints dielectric highlights with the base colour
+	Sheen          Texture // 0, extra reflection at grazing angles for cloth
+	SheenTint      Texture // 0.5
+	Clearcoat      Texture // 0, a second, colourless specular layer
+	ClearcoatGloss Texture // 1
+	Transmission   Texture // 0, the fraction refracted like rough glass
+	// IOR is the index of refraction of the transmissive part. Zero means
+	// 1.5.
+	IOR float64
+}
+
+// Principled is the Disney principled BSDF (Burley 2012 and 2015): a
+// diffuse base with retro-reflection and sheen, a GGX specular lobe in the
+// metallic workflow, a clearcoat layer and rough specular transmission, all
+// set by a handful of artist-friendly parameters.
+type Principled struct {
+	baseColor, metallic, roughness, specular, specularTint Texture
+	sheen, sheenTint, clearcoat, clearcoatGloss            Texture
+	transmission                                           Texture
+	ior                                                    float64
+}
+
+// NewPrincipled creates a principled material, filling in defaults for the
+// parameters that are not set.
+func NewPrincipled(p PrincipledParams) Principled {
+	param := func(t Texture, def float64) Texture {
+		if t == nil {
+			return NewConstantTexture(Color{R: def, G: def, B: def})
+		}
+		return t
+	}
+	ior := p.IOR
+	if ior <= 0.0 {
+		ior = 1.5
+	}
+	return Principled{
+		baseColor:      param(p.BaseColor, 0.8),
+		metallic:       param(p.Metallic, 0.0),
+		roughness:      param(p.Roughness, 0.5),
+		specular:       param(p.Specular, 0.5),
+		specularTint:   param(p.SpecularTint, 0.0),
+		sheen:          param(p.Sheen, 0.0),
+		sheenTint:      param(p.SheenTint, 0.5),
+		clearcoat:      param(p.Clearcoat, 0.0),
+		clearcoatGloss: param(p.ClearcoatGloss, 1.0),
+		transmission:   param(p.Transmission, 0.0),
+		ior:            ior,
+	}
+}
+
+// principledLobes are the parameters of a Principled material at one point,
+// in the frame of dielectricFrame.
+type principledLobes struct {
+	base         Color
+	roughness    float64
+	dist         ggx
+	eta          float64
+	specularF0   Color
+	sheen        Color
+	clearcoat    float64
+	clearcoatGTR float64 // alpha of the GTR1 clearcoat distribution
+
+	// Weights of the diffuse, specular reflection and transmission parts.
+	diffuseWeight, specularWeight, transmissionWeight float64
+	// Probabilities of sampling each lobe.
+	pDiffuse, pSpecular, pTransmission, pClearcoat float64
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0.0, math.Min(1.0, v))
+}
+
+func lerpColor(a, b Color, t float64) Color {
+	return a.MultiplyScalar(1.0 - t).Add(b.MultiplyScalar(t))
+}
+
+func (m Principled) lobes(rec HitRecord, wo Vec3d, eta float64) principledLobes {
+	value := func(t Texture) float64 { return clamp01(scalarValue(t, rec)) }
+	base := m.baseColor.Value(rec.u, rec.v, rec.P)
+	metallic := value(m.metallic)
+	transmission := value(m.transmission)
+	white := Color{R: 1.0, G: 1.0, B: 1.0}
+
+	// The hue of the base colour without its brightness.
+	tint := white
+	if lum := luminance(base); lum > 0.0 {
+		tint = base.DivideScalar(lum)
+	}
+
+	l := principledLobes{
+		base:         base,
+		roughness:    value(m.roughness),
+		eta:          eta,
+		clearcoat:    value(m.clearcoat),
+		clearcoatGTR: 0.1 + (0.001-0.1)*value(m.clearcoatGloss),
+	}
+	l.dist = newGGX(l.roughness)
+	dielectricF0 := lerpColor(white, tint, value(m.specularTint)).MultiplyScalar(0.08 * value(m.specular))
+	l.specularF0 = lerpColor(dielectricF0, base, metallic)
+	l.sheen = lerpColor(white, tint, value(m.sheenTint)).MultiplyScalar(value(m.sheen))
+
+	l.transmissionWeight = (1.0 - metallic) * transmission
+	l.diffuseWeight = (1.0 - metallic) * (1.0 - transmission)
+	l.specularWeight = 1.0 - l.transmissionWeight
+
+	// Sample each lobe roughly in proportion to the light it reflects.
+	diffuse := l.diffuseWeight * math.Max(luminance(base), luminance(l.sheen))
+	specular := l.specularWeight * luminance(schlickColor(l.specularF0, wo.Z()))
+	clearcoat := 0.25 * l.clearcoat * schlickColor(Color{R: 0.04, G: 0.04, B: 0.04}, wo.Z()).R
+	total := diffuse + specular + l.transmissionWeight + clearcoat
+	if total <= 0.0 {
+		l.pSpecular = 1.0
+		return l
+	}
+	l.pDiffuse = diffuse / total
+	l.pSpecular = specular / total
+	l.pTransmission = l.transmissionWeight / total
+	l.pClearcoat = clearcoat / total
+	return l
+}
+
+// eval returns the BSDF times the cosine term and the sampling density for
+// local directions wo and wi.
+func (l principledLobes) eval(wo, wi Vec3d) (Color, float64) {
+	if wo.Z() <= 0.0 || wi.Z() == 0.0 {
+		return Color{}, 0.0
+	}
+
+	var f Color
+	pdf := 0.0
+	if l.transmissionWeight > 0.0 {
+		ft, pt := evalDielectric(l.dist, wo, wi, l.eta)
+		if wi.Z() < 0.0 {
+			ft = ft.Multiply(l.base)
+		}
+		f = f.Add(ft.MultiplyScalar(l.transmissionWeight))
+		pdf += l.pTransmission * pt
+	}
+	if wi.Z() < 0.0 {
+		return f, pdf
+	}
+
+	h := wo.Add(wi).UnitVector()
+	cosD := wi.Dot(h)
+
+	if l.diffuseWeight > 0.0 {
+		// Burley's diffuse with retro-reflection at grazing angles, plus
+		// sheen.
+		fd90 := 0.5 + 2.0*l.roughness*cosD*cosD
+		fo := 1.0 + (fd90-1.0)*math.Pow(1.0-wo.Z(), 5.0)
+		fi := 1.0 + (fd90-1.0)*math.Pow(1.0-wi.Z(), 5.0)
+		diffuse := l.base.MultiplyScalar(fo * fi / math.Pi)
+		sheen := l.sheen.MultiplyScalar(math.Pow(1.0-cosD, 5.0))
+		f = f.Add(diffuse.Add(sheen).MultiplyScalar(l.diffuseWeight * wi.Z()))
+		pdf += l.pDiffuse * wi.Z() / math.Pi
+	}
+
+	if l.specularWeight > 0.0 {
+		fresnel := schlickColor(l.specularF0, cosD)
+		spec := fresnel.MultiplyScalar(l.dist.d(h) * l.dist.g2(wo, wi) / (4.0 * wo.Z()))
+		f = f.Add(spec.MultiplyScalar(l.specularWeight))
+		pdf += l.pSpecular * l.dist.reflectionPDF(wo, wi)
+	}
+
+	if l.clearcoat > 0.0 {
+		d := gtr1(h.Z(), l.clearcoatGTR)
+		coat := newGGX(0.5) // a fixed alpha of 0.25
+		g := coat.g1(wo) * coat.g1(wi)
+		fr := schlickColor(Color{R: 0.04, G: 0.04, B: 0.04}, cosD).R
+		c := 0.25 * l.clearcoat * d * fr * g / (4.0 * wo.Z())
+		f = f.Add(Color{R: c, G: c, B: c})
+		pdf += l.pClearcoat * d * h.Z() / (4.0 * cosD)
+	}
+	return f, pdf
+}
+
+// gtr1 is the generalised Trowbridge-Reitz distribution with exponent 1 used
+// for the clearcoat.
+func gtr1(cosH, alpha float64) float64 {
+	if cosH <= 0.0 {
+		return 0.0
+	}
+	a2 := alpha * alpha
+	return (a2 - 1.0) / (math.Pi * math.Log(a2) * (1.0 + (a2-1.0)*cosH*cosH))
+}
+
+// sampleGTR1 picks a microfacet normal with density gtr1(cosH) cosH.
+func sampleGTR1(alpha, u1, u2 float64) Vec3d {
+	a2 := alpha * alpha
+	cosH := math.Sqrt(math.Max(0.0, (1.0-math.Pow(a2, 1.0-u1))/(1.0-a2)))
+	sinH := math.Sqrt(math.Max(0.0, 1.0-cosH*cosH))
+	phi := 2.0 * math.Pi * u2
+	return NewVec3d(sinH*math.Cos(phi), sinH*math.Sin(phi), cosH)
+}
+
+func (m Principled) Scatter(rayIn Ray, rec HitRecord, attenuation *Color, rng *RNG) (isScattered bool, scattered Ray) {
+	return scatterBySampling(m, rayIn, rec, attenuation, rng)
+}
+
+// SampleScatter picks one lobe and a direction from it. The weight and
+// density account for every lobe that could have produced the direction.
+func (m Principled) SampleScatter(rayIn Ray, rec HitRecord, rng *RNG) (ScatterSample, bool) {
+	frame, wo, eta := dielectricFrame(rayIn, rec, m.ior)
+	l := m.lobes(rec, wo, eta)
+
+	var wi Vec3d
+	switch u := rng.Float64(); {
+	case u < l.pDiffuse:
+		wi = randomCosineDirection(rng)
+	case u < l.pDiffuse+l.pSpecular:
+		u1, u2 := rng.Float64Pair()
+		h := l.dist.sampleVisible(wo, u1, u2)
+		wi = reflect(wo.MultiplyScalar(-1.0), h)
+	case u < l.pDiffuse+l.pSpecular+l.pTransmission:
+		var ok bool
+		if wi, ok = sampleDielectric(l.dist, wo, eta, rng); !ok {
+			return ScatterSample{}, false
+		}
+	default:
+		u1, u2 := rng.Float64Pair()
+		h := sampleGTR1(l.clearcoatGTR, u1, u2)
+		wi = reflect(wo.MultiplyScalar(-1.0), h)
+	}
+
+	f, pdf := l.eval(wo, wi)
+	if pdf <= 0.0 {
+		return ScatterSample{}, false
+	}
+	return ScatterSample{Direction: frame.local(wi.X(), wi.Y(), wi.Z()), Weight: f.DivideScalar(pdf), PDF: pdf}, true
+}
+
+func (m Principled) EvalScatter(rayIn Ray, rec HitRecord, direction Vec3d) (Color, float64) {
+	frame, wo, eta := dielectricFrame(rayIn, rec, m.ior)
+	return m.lobes(rec, wo, eta).eval(wo, frame.toLocal(direction.UnitVector()))
+}
+
+func (m Principled) Emitted(u, v float64, p Vec3d) Color {
+	return Color{0, 0, 0}
+}
@@ -0,0 +1,81 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPrincipledSampling(t *testing.T) {
+	rec := HitRecord{P: NewVec3d(0.0, 0.0, 0.0), Normal: NewVec3d(0.0, 1.0, 0.0)}
+	rayIn := NewRay(NewVec3d(-1.0, 1.5, 0.0), NewVec3d(1.0, -1.5, 0.0), 0.0)
+
+	tests := []struct {
+		name   string
+		params PrincipledParams
+	}{
+		{"Default", PrincipledParams{}},
+		{"Metal", PrincipledParams{BaseColor: grey(0.9), Metallic: grey(1.0), Roughness: grey(0.3)}},
+		{"Plastic", PrincipledParams{BaseColor: NewConstantTexture(Color{R: 0.8, G: 0.1, B: 0.1}), Roughness: grey(0.2), SpecularTint: grey(0.5)}},
+		{"Cloth", PrincipledParams{BaseColor: grey(0.5), Roughness: grey(1.0), Sheen: grey(1.0)}},
+		{"Car paint", PrincipledParams{BaseColor: grey(0.3), Metallic: grey(0.5), Clearcoat: grey(1.0), ClearcoatGloss: grey(0.9)}},
+		{"Glass", PrincipledParams{BaseColor: grey(1.0), Roughness: grey(0.1), Transmission: grey(1.0)}},
+		{"Half transmissive", PrincipledParams{BaseColor: grey(0.7), Transmission: grey(0.5), IOR: 1.33}},
+	}
+	for _, tt := range tests {
+		m := NewPrincipled(tt.params)
+		for _, normal := range []Vec3d{NewVec3d(0.0, 1.0, 0.0), NewVec3d(0.0, -1.0, 0.0)} {
+			rec.Normal = normal
+			albedo := checkSampling(t, tt.name, m, rayIn, rec)
+			// Burley's diffuse is not quite energy conserving, but close.
+			if albedo > 1.05 || albedo <= 0.0 {
+				t.Errorf("%s, normal %v: albedo = %v, want in (0, 1]", tt.name, normal, albedo)
+			}
+		}
+	}
+}
+
+// TestPrincipledLimits checks that the principled material reduces to the
+// plain microfacet materials for pure metals and pure glass.
+func TestPrincipledLimits(t *testing.T) {
+	rec := HitRecord{Normal: NewVec3d(0.0, 1.0, 0.0)}
+	rayIn := NewRay(NewVec3d(-1.0, 1.0, 0.5), NewVec3d(1.0, -1.0, -0.5), 0.0)
+	gold := NewConstantTexture(Color{R: 1.0, G: 0.71, B: 0.29})
+
+	pairs := []struct {
+		name      string
+		principle PDFMaterial
+		reference PDFMaterial
+	}{
+		{"Metal", NewPrincipled(PrincipledParams{BaseColor: gold, Metallic: grey(1.0), Roughness: grey(0.4)}), NewMicrofacet(gold, grey(0.4), grey(1.0))},
+		{"Glass", NewPrincipled(PrincipledParams{BaseColor: grey(1.0), Roughness: grey(0.3), Transmission: grey(1.0), IOR: 1.5}), NewRoughDielectric(1.5, grey(0.3))},
+	}
+	rng := NewRNG(8)
+	for _, p := range pairs {
+		for i := 0; i < 200; i++ {
+			direction := randomUnitVector(rng)
+			got, _ := p.principle.EvalScatter(rayIn, rec, direction)
+			want, _ := p.reference.EvalScatter(rayIn, rec, direction)
+			if !colorEqualTol(got, want, 1e-9*(1.0+want.G)) {
+				t.Fatalf("%s: EvalScatter(%v) = %v, want %v", p.name, direction, got, want)
+			}
+		}
+	}
+}
+
+func TestGTR1Normalized(t *testing.T) {
+	rng := NewRNG(1)
+	for _, alpha := range []float64{0.1, 0.01} {
+		const n = 400000
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			h := upperHemisphere(rng)
+			sum += gtr1(h.Z(), alpha) * h.Z() * 2.0 * math.Pi
+		}
+		if got := sum / n; math.Abs(got-1.0) > 0.05 {
+			t.Errorf("alpha %v: integral of D cos = %v, want 1", alpha, got)
+		}
+		if h := sampleGTR1(alpha, 0.5, 0.25); math.Abs(h.Length()-1.0) > 1e-12 || h.Z() <= 0.0 {
+			t.Errorf("sampleGTR1() = %v, want a unit vector above the surface", h)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package rendim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// WriteHDR writes fb as a Radiance RGBE (.hdr) image with run-length encoded
+// scanlines.
+func WriteHDR(w io.Writer, fb *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", fb.height, fb.width); err != nil {
+		return err
+	}
+
+	rgbe := make([]byte, 4*fb.width)
+	channel := make([]byte, fb.width)
+	var encoded []byte
+	for y := 0; y < fb.height; y++ {
+		for x := 0; x < fb.width; x++ {
+			c := fb.At(x, y)
+			copy(rgbe[4*x:], toRGBE(c.R, c.G, c.B))
+		}
+
+		// Run-length encoding is only defined for widths in [8, 32767];
+		// other scanlines are stored flat.
+		if fb.width < 8 || fb.width > 0x7fff {
+			if _, err := bw.Write(rgbe); err != nil {
+				return err
+			}
+			continue
+		}
+
+		encoded = append(encoded[:0], 2, 2, byte(fb.width>>8), byte(fb.width))
+		for c := 0; c < 4; c++ {
+			for x := range channel {
+				channel[x] = rgbe[4*x+c]
+			}
+			encoded = appendRLE(encoded, channel)
+		}
+		if _, err := bw.Write(encoded); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadHDR decodes a Radiance RGBE (.hdr) image with flat, run-length encoded
+// or old-style run-length encoded scanlines. Only the standard "-Y h +X w"
+// orientation is supported.
+func ReadHDR(r io.Reader) (*Framebuffer, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return nil, errors.New("not a Radiance HDR file")
+	}
+	for {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid HDR header: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format, ok := strings.CutPrefix(line, "FORMAT="); ok && format != "32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported HDR format %q", format)
+		}
+	}
+
+	line, err = br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("invalid HDR resolution: %w", err)
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return nil, fmt.Errorf("unsupported HDR resolution %q", strings.TrimSpace(line))
+	}
+	if width <= 0 || height <= 0 || width > 1<<15 || height > 1<<15 {
+		return nil, fmt.Errorf("invalid HDR size %dx%d", width, height)
+	}
+
+	fb := NewFramebuffer(width, height)
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readScanline(br, scanline); err != nil {
+			return nil, fmt.Errorf("scanline %d: %w", y, err)
+		}
+		for x := 0; x < width; x++ {
+			fb.Set(x, y, fromRGBE(scanline[4*x:4*x+4]))
+		}
+	}
+	return fb, nil
+}
+
+// readScanline fills scanline with the RGBE pixels of the next scanline.
+func readScanline(br *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	if _, err := io.ReadFull(br, scanline[:4]); err != nil {
+		return err
+	}
+	if width < 8 || width > 0x7fff || scanline[0] != 2 || scanline[1] != 2 || scanline[2]&0x80 != 0 {
+		return readFlatScanline(br, scanline)
+	}
+	if got := int(scanline[2])<<8 | int(scanline[3]); got != width {
+		return fmt.Errorf("scanline width %d, want %d", got, width)
+	}
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			n, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			count := int(n)
+			if n > 128 {
+				count -= 128
+			}
+			if count == 0 || x+count > width {
+				return errors.New("invalid run length")
+			}
+			if n > 128 {
+				v, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				for k := 0; k < count; k++ {
+					scanline[4*(x+k)+c] = v
+				}
+			} else {
+				for k := 0; k < count; k++ {
+					if scanline[4*(x+k)+c], err = br.ReadByte(); err != nil {
+						return err
+					}
+				}
+			}
+			x += count
+		}
+	}
+	return nil
+}
+
+// readFlatScanline reads pixels one at a time, expanding the old-style runs in
+// which a pixel of 1, 1, 1 repeats the previous pixel.
+func readFlatScanline(br *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	shift := 0
+	for x := 0; x < width; {
+		p := scanline[4*x : 4*x+4]
+		// The first pixel has already been read.
+		if x > 0 {
+			if _, err := io.ReadFull(br, p); err != nil {
+				return err
+			}
+		}
+		if x > 0 && p[0] == 1 && p[1] == 1 && p[2] == 1 {
+			count := int(p[3]) << shift
+			if x+count > width {
+				return errors.New("invalid run length")
+			}
+			for k := 0; k < count; k++ {
+				copy(scanline[4*(x+k):], scanline[4*(x-1):4*x])
+			}
+			x += count
+			shift += 8
+			continue
+		}
+		shift = 0
+		x++
+	}
+	return nil
+}
+
+// fromRGBE decodes a pixel written by toRGBE.
+func fromRGBE(p []byte) Color {
+	if p[3] == 0 {
+		return Color{}
+	}
+	f := math.Ldexp(1.0, int(p[3])-(128+8))
+	return Color{R: (float64(p[0]) + 0.5) * f, G: (float64(p[1]) + 0.5) * f, B: (float64(p[2]) + 0.5) * f}
+}
+
+// toRGBE encodes a colour as three 8-bit mantissas sharing an exponent.
+func toRGBE(r, g, b float64) []byte {
+	v := math.Max(r, math.Max(g, b))
+	switch {
+	case math.IsNaN(v) || v <= 1e-32:
+		return []byte{0, 0, 0, 0}
+	case math.IsInf(v, 1) || v >= 0x1p127:
+		return []byte{255, 255, 255, 255}
+	}
+	m, e := math.Frexp(v)
+	scale := m * 256.0 / v
+	return []byte{
+		byte(math.Max(0.0, r) * scale),
+		byte(math.Max(0.0, g) * scale),
+		byte(math.Max(0.0, b) * scale),
+		byte(e + 128),
+	}
+}
+
+// appendRLE run-length encodes one channel of a scanline: a byte above 128
+// repeats the next byte (count-128) times, any other count is followed by that
+// many literal bytes.
+func appendRLE(dst, data []byte) []byte {
+	const minRun = 4
+	for i := 0; i < len(data); {
+		// Find the next run that is long enough to be worth encoding.
+		runStart, runLen := i, 0
+		for runStart < len(data) {
+			runLen = 1
+			for runStart+runLen < len(data) && runLen < 127 && data[runStart+runLen] == data[runStart] {
+				runLen++
+			}
+			if runLen >= minRun {
+				break
+			}
+			runStart += runLen
+		}
+		if runStart >= len(data) {
+			runLen = 0
+		}
+
+		for i < runStart {
+			n := min(runStart-i, 128)
+			dst = append(dst, byte(n))
+			dst = append(dst, data[i:i+n]...)
+			i += n
+		}
+		if runLen >= minRun {
+			dst = append(dst, byte(128+runLen), data[runStart])
+			i = runStart + runLen
+		}
+	}
+	return dst
+}
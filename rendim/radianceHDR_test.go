@@ -0,0 +1,170 @@
+package rendim
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+// readHDR decodes the subset of the Radiance format that WriteHDR produces.
+func readHDR(t *testing.T, data []byte) [][]Color {
+	t.Helper()
+	r := bufio.NewReader(bytes.NewReader(data))
+	var header []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("header: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		header = append(header, line)
+	}
+	if header[0] != "#?RADIANCE" || header[1] != "FORMAT=32-bit_rle_rgbe" {
+		t.Fatalf("header = %q", header)
+	}
+	var width, height int
+	if _, err := fmt.Fscanf(r, "-Y %d +X %d\n", &height, &width); err != nil {
+		t.Fatalf("resolution: %v", err)
+	}
+
+	image := make([][]Color, height)
+	scanline := make([]byte, 4*width)
+	for y := range image {
+		if _, err := io.ReadFull(r, scanline[:4]); err != nil {
+			t.Fatal(err)
+		}
+		if width >= 8 && scanline[0] == 2 && scanline[1] == 2 {
+			if got := int(scanline[2])<<8 | int(scanline[3]); got != width {
+				t.Fatalf("scanline width %d, want %d", got, width)
+			}
+			for c := 0; c < 4; c++ {
+				for x := 0; x < width; {
+					n, _ := r.ReadByte()
+					if n > 128 {
+						v, _ := r.ReadByte()
+						for k := 0; k < int(n)-128; k++ {
+							scanline[4*(x+k)+c] = v
+						}
+						x += int(n) - 128
+					} else {
+						for k := 0; k < int(n); k++ {
+							scanline[4*(x+k)+c], _ = r.ReadByte()
+						}
+						x += int(n)
+					}
+				}
+			}
+		} else if _, err := io.ReadFull(r, scanline[4:]); err != nil {
+			t.Fatal(err)
+		}
+
+		image[y] = make([]Color, width)
+		for x := range image[y] {
+			p := scanline[4*x : 4*x+4]
+			if p[3] == 0 {
+				continue
+			}
+			f := math.Ldexp(1.0, int(p[3])-(128+8))
+			image[y][x] = Color{R: (float64(p[0]) + 0.5) * f, G: (float64(p[1]) + 0.5) * f, B: (float64(p[2]) + 0.5) * f}
+		}
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Error("trailing data after the last scanline")
+	}
+	return image
+}
+
+func TestWriteHDR(t *testing.T) {
+	for _, width := range []int{5, 40} {
+		t.Run(fmt.Sprintf("width %d", width), func(t *testing.T) {
+			fb := testFramebuffer(width, 4)
+			// A long run of identical pixels exercises the run encoding.
+			for x := 0; x < width; x++ {
+				fb.Set(x, 3, Color{R: 100.0, G: 100.0, B: 100.0})
+			}
+
+			var buf bytes.Buffer
+			if err := WriteHDR(&buf, fb); err != nil {
+				t.Fatal(err)
+			}
+			image := readHDR(t, buf.Bytes())
+
+			for y := 0; y < fb.Height(); y++ {
+				for x := 0; x < fb.Width(); x++ {
+					want, got := fb.At(x, y), image[y][x]
+					tolerance := math.Max(want.R, math.Max(want.G, want.B)) / 128.0
+					if math.Abs(got.R-want.R) > tolerance || math.Abs(got.G-want.G) > tolerance || math.Abs(got.B-want.B) > tolerance {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAppendRLE(t *testing.T) {
+	data := []byte{1, 2, 3, 7, 7, 7, 7, 7, 7, 4, 5, 5, 5}
+	got := appendRLE(nil, data)
+	want := []byte{3, 1, 2, 3, 128 + 6, 7, 4, 4, 5, 5, 5}
+	if !bytes.Equal(got, want) {
+		t.Errorf("appendRLE() = %v, want %v", got, want)
+	}
+}
+
+func TestReadHDR(t *testing.T) {
+	for _, width := range []int{5, 40} {
+		fb := testFramebuffer(width, 4)
+		var buf bytes.Buffer
+		if err := WriteHDR(&buf, fb); err != nil {
+			t.Fatal(err)
+		}
+		want := readHDR(t, buf.Bytes())
+		got, err := ReadHDR(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < fb.Height(); y++ {
+			for x := 0; x < width; x++ {
+				if c := got.At(x, y); !colorEqual(c, want[y][x]) {
+					t.Fatalf("width %d: pixel (%d, %d) = %v, want %v", width, x, y, c, want[y][x])
+				}
+			}
+		}
+	}
+}
+
+func TestReadHDROldRLE(t *testing.T) {
+	// An old-style run: 1, 1, 1, n repeats the previous pixel n times.
+	data := []byte("#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y 1 +X 4\n")
+	data = append(data, 128, 64, 0, 129, 1, 1, 1, 3)
+	fb, err := ReadHDR(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fromRGBE([]byte{128, 64, 0, 129})
+	for x := 0; x < 4; x++ {
+		if got := fb.At(x, 0); !colorEqual(got, want) {
+			t.Errorf("pixel %d = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestReadHDRErrors(t *testing.T) {
+	for _, data := range []string{
+		"P6\n",
+		"#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n\x00\x00\x00\x00",
+		"#?RADIANCE\n\n+Y 1 +X 1\n\x00\x00\x00\x00",
+		"#?RADIANCE\n\n-Y 2 +X 1\n\x00\x00\x00\x00",
+	} {
+		if _, err := ReadHDR(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadHDR(%q) should fail", data)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package rendim
 
+import "math"
+
 type XYRect struct {
 	x0, x1, y0, y1, k float64
 	material          Material
 }
 
-func (rect XYRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+// NewXYRect creates the rectangle [x0, x1] x [y0, y1] in the plane z = k,
+// facing +z.
+func NewXYRect(x0, x1, y0, y1, k float64, material Material) XYRect {
+	return XYRect{x0: x0, x1: x1, y0: y0, y1: y1, k: k, material: material}
+}
+
+func (rect XYRect) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
 	t := (rect.k - r.Origin().Z()) / r.Direction().Z()
 	if t < tMin || t > tMax {
 		return false, HitRecord{}
@@ -27,6 +36,18 @@ func (rect XYRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	return true, rec
 }
 
+func (rect XYRect) SampleDirection(p Vec3d, rng *RNG) (Vec3d, float64) {
+	return rectSample(p, 2, rect.x0, rect.x1, rect.y0, rect.y1, rect.k, rng)
+}
+
+func (rect XYRect) PDFValue(p, direction Vec3d) float64 {
+	isHit, rec := rect.Hit(NewRay(p, direction, 0.0), 0.001, math.MaxFloat64, nil)
+	if !isHit {
+		return 0.0
+	}
+	return rectPDF(direction, rec.t, 2, (rect.x1-rect.x0)*(rect.y1-rect.y0))
+}
+
 func (rect XYRect) BoundingBox(t0, t1 float64, box *AABB) bool {
 	boxMin := NewVec3d(rect.x0, rect.y0, rect.k-0.0001)
 	boxMax := NewVec3d(rect.x1, rect.y1, rect.k+0.0001)
@@ -39,7 +60,14 @@ type XZRect struct {
 	material          Material
 }
 
-func (rect XZRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+// NewXZRect creates the rectangle [x0, x1] x [z0, z1] in the plane y = k,
+// facing +y.
+func NewXZRect(x0, x1, z0, z1, k float64, material Material) XZRect {
+	return XZRect{x0: x0, x1: x1, z0: z0, z1: z1, k: k, material: material}
+}
+
+func (rect XZRect) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
 	t := (rect.k - r.Origin().Y()) / r.Direction().Y()
 	if t < tMin || t > tMax {
 		return false, HitRecord{}
@@ -61,6 +89,18 @@ func (rect XZRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	return true, rec
 }
 
+func (rect XZRect) SampleDirection(p Vec3d, rng *RNG) (Vec3d, float64) {
+	return rectSample(p, 1, rect.z0, rect.z1, rect.x0, rect.x1, rect.k, rng)
+}
+
+func (rect XZRect) PDFValue(p, direction Vec3d) float64 {
+	isHit, rec := rect.Hit(NewRay(p, direction, 0.0), 0.001, math.MaxFloat64, nil)
+	if !isHit {
+		return 0.0
+	}
+	return rectPDF(direction, rec.t, 1, (rect.z1-rect.z0)*(rect.x1-rect.x0))
+}
+
 func (rect XZRect) BoundingBox(t0, t1 float64, box *AABB) bool {
 	boxMin := NewVec3d(rect.x0, rect.k-0.0001, rect.z0)
 	boxMax := NewVec3d(rect.x1, rect.k+0.0001, rect.z1)
@@ -73,7 +113,14 @@ type YZRect struct {
 	material          Material
 }
 
-func (rect YZRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+// NewYZRect creates the rectangle [y0, y1] x [z0, z1] in the plane x = k,
+// facing +x.
+func NewYZRect(y0, y1, z0, z1, k float64, material Material) YZRect {
+	return YZRect{y0: y0, y1: y1, z0: z0, z1: z1, k: k, material: material}
+}
+
+func (rect YZRect) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
 	t := (rect.k - r.Origin().X()) / r.Direction().X()
 	if t < tMin || t > tMax {
 		return false, HitRecord{}
@@ -95,6 +142,18 @@ func (rect YZRect) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
 	return true, rec
 }
 
+func (rect YZRect) SampleDirection(p Vec3d, rng *RNG) (Vec3d, float64) {
+	return rectSample(p, 0, rect.y0, rect.y1, rect.z0, rect.z1, rect.k, rng)
+}
+
+func (rect YZRect) PDFValue(p, direction Vec3d) float64 {
+	isHit, rec := rect.Hit(NewRay(p, direction, 0.0), 0.001, math.MaxFloat64, nil)
+	if !isHit {
+		return 0.0
+	}
+	return rectPDF(direction, rec.t, 0, (rect.y1-rect.y0)*(rect.z1-rect.z0))
+}
+
 func (rect YZRect) BoundingBox(t0, t1 float64, box *AABB) bool {
 	boxMin := NewVec3d(rect.k-0.0001, rect.y0, rect.z0)
 	boxMax := NewVec3d(rect.k+0.0001, rect.y1, rect.z1)
@@ -38,7 +38,7 @@ func TestXYRectHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, rec := rect.Hit(tt.ray, 0.001, 10.0)
+			hit, rec := rect.Hit(tt.ray, 0.001, 10.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
@@ -101,7 +101,7 @@ func TestXZRectHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, rec := rect.Hit(tt.ray, 0.001, 10.0)
+			hit, rec := rect.Hit(tt.ray, 0.001, 10.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
@@ -164,7 +164,7 @@ func TestYZRectHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, rec := rect.Hit(tt.ray, 0.001, 10.0)
+			hit, rec := rect.Hit(tt.ray, 0.001, 10.0, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
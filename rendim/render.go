@@ -1,97 +1,367 @@
 package rendim
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
-	_ "image/jpeg"
 	"math"
-	"math/rand"
-	"os"
+	"math/rand/v2"
+	"slices"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
-// RNG is a per-worker random number generator to avoid lock contention on math/rand.
+// RNG is a per-worker random number generator to avoid lock contention on
+// math/rand. While a render takes a camera sample, its numbers come from the
+// render's Sampler instead, one dimension per call.
 type RNG struct {
-	source rand.Source64
-	rng    *rand.Rand
+	rng     *rand.Rand
+	sampler Sampler
 }
 
 // NewRNG creates a new RNG with a seeded source.
 func NewRNG(seed int64) *RNG {
-	source := rand.NewSource(seed).(rand.Source64)
+	source := rand.NewPCG(splitMix64(uint64(seed)), 0) //nolint:gosec // G115: any bit pattern is a fine seed
 	return &RNG{
-		source: source,
-		rng:    rand.New(source), //nolint:gosec // G404: math/rand is fine for graphics rendering
+		rng: rand.New(source), //nolint:gosec // G404: math/rand is fine for graphics rendering
 	}
 }
 
+// newSamplerRNG creates an RNG that hands out the dimensions of sampler.
+func newSamplerRNG(sampler Sampler) *RNG {
+	return &RNG{sampler: sampler}
+}
+
 // Float64 returns a random float64 in [0.0, 1.0).
 func (r *RNG) Float64() float64 {
+	if r.sampler != nil {
+		return r.sampler.Get1D()
+	}
 	return r.rng.Float64()
 }
 
+// Float64Pair returns two random float64s in [0.0, 1.0) that are used
+// together, such as the coordinates of a point on a light, which a sampler
+// spreads evenly as a pair.
+func (r *RNG) Float64Pair() (float64, float64) {
+	if r.sampler != nil {
+		return r.sampler.Get2D()
+	}
+	return r.rng.Float64(), r.rng.Float64()
+}
+
 // Intn returns a random int in [0, n).
 func (r *RNG) Intn(n int) int {
-	return r.rng.Intn(n)
+	if r.sampler != nil {
+		return min(int(r.sampler.Get1D()*float64(n)), n-1)
+	}
+	return r.rng.IntN(n)
 }
 
-var ops uint64
-
 type Pixel struct {
 	image.Point
 	R, G, B uint8
 }
 
-func Render(width, height int, pixels chan Pixel) image.Image {
-	scene := finalScene(width, height)
-	return renderBuckets(width, height, scene, 10000, 32, 4, pixels)
+// RenderOptions holds the optional outputs of a render.
+type RenderOptions struct {
+	// Pixels receives every finished pixel unless it is nil. A progressive
+	// render sends every pixel again after each pass.
+	Pixels chan Pixel
+	// Progress is called with the stats so far every ProgressInterval (one
+	// second by default) and once more when the render ends.
+	Progress         func(Stats)
+	ProgressInterval time.Duration
+	// SampleMap receives the number of samples taken in every pixel unless
+	// it is nil. It must have the size of the image.
+	SampleMap *SampleMap
+	// Pass is called after every pass of a progressive render with the
+	// samples per pixel reached and the framebuffer, which the render goes on
+	// to change once Pass returns.
+	Pass func(samples int, fb *Framebuffer)
+	// Checkpoint is called with the state of the render every
+	// CheckpointInterval (five minutes by default) and once more if the
+	// render is stopped before it finishes.
+	Checkpoint         func(*Checkpoint)
+	CheckpointInterval time.Duration
+	// Resume carries on from a checkpoint of a render with the same image
+	// settings instead of starting afresh.
+	Resume *Checkpoint
 }
 
-func RenderScene(width, height int, sceneType string, samples, bucketSize, workersCount int, pixels chan Pixel) image.Image {
-	var scene Scene
-	switch sceneType {
-	case "simpleLight":
-		scene = SimpleLightScene(width, height)
-	case "cornell":
-		scene = CornellBox(width, height)
-	default:
-		scene = finalScene(width, height)
+// RenderScene renders scene with the given settings, which must have every
+// size, sampling and worker field set, and returns the radiance of every pixel
+// together with statistics about the work done.
+//
+// Normally each bucket is rendered to completion in turn. With
+// settings.Progressive the whole image is refined in passes instead, each
+// doubling the samples per pixel, so that a rough version of every pixel is
+// ready within the first pass. Both give the same image in the end.
+//
+// The render stops promptly when ctx is cancelled or its deadline passes; the
+// framebuffer then holds the pixels finished so far, or the last pass of a
+// progressive render, and the context error is returned with it. With
+// opts.Checkpoint set it also hands over a checkpoint then, from which
+// opts.Resume carries on to the same image an uninterrupted render gives.
+func RenderScene(ctx context.Context, scene Scene, settings RenderSettings, opts RenderOptions) (*Framebuffer, Stats, error) {
+	job, err := newRenderJob(&scene, settings, image.Rect(0, 0, settings.Width, settings.Height), opts)
+	if err != nil {
+		return nil, Stats{}, err
 	}
+	stop := startProgress(job.stats, opts)
+	stopCheckpoints := job.startCheckpoints()
 
-	return renderBuckets(width, height, scene, samples, bucketSize, workersCount, pixels)
+	if job.sendRestored(ctx) {
+		if settings.Progressive {
+			job.renderProgressive(ctx)
+		} else {
+			job.renderPass(ctx, settings.Samples)
+		}
+	}
+
+	stopCheckpoints()
+	if ctx.Err() != nil && opts.Checkpoint != nil {
+		opts.Checkpoint(job.checkpoint())
+	}
+	return job.fb, stop(), ctx.Err()
 }
 
-func renderBuckets(width, height int, scene Scene, samples, bucketSize, workersCount int, pixels chan Pixel) image.Image {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+// RenderRegion renders the pixels of scene inside region, a rectangle of the
+// image settings describe, exactly as RenderScene renders them. The returned
+// framebuffer and opts.SampleMap cover just the region, with its top left
+// pixel at (0, 0), while opts.Pixels receives image coordinates. Progressive
+// rendering and checkpoints do not apply.
+func RenderRegion(ctx context.Context, scene Scene, settings RenderSettings, region image.Rectangle, opts RenderOptions) (*Framebuffer, Stats, error) {
+	if region.Empty() || !region.In(image.Rect(0, 0, settings.Width, settings.Height)) {
+		return nil, Stats{}, fmt.Errorf("region %v is not inside the %dx%d image", region, settings.Width, settings.Height)
+	}
+	settings.Progressive = false
+	opts.Pass, opts.Checkpoint, opts.Resume = nil, nil, nil
+	job, err := newRenderJob(&scene, settings, region, opts)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	stop := startProgress(job.stats, opts)
+	job.renderPass(ctx, settings.Samples)
+	return job.fb, stop(), ctx.Err()
+}
+
+// renderJob is the state the workers of one render share.
+type renderJob struct {
+	scene    *Scene
+	fb       *Framebuffer // covers region
+	settings RenderSettings
+	opts     RenderOptions
+	stats    *renderStats
+	region   image.Rectangle // the pixels rendered, all of the image but for RenderRegion
+	buckets  []image.Rectangle
+
+	// estimates carries the samples of every pixel, indexed by y*width+x,
+	// across passes and checkpoints. Without them each pixel starts afresh.
+	estimates []pixelEstimate
+	// mu is held for reading while a worker changes a pixel and for writing
+	// while a checkpoint is taken.
+	mu     sync.RWMutex
+	passes int // finished passes of a progressive render
+}
 
-	buckets := getBuckets(img.Bounds(), bucketSize)
-	bucketChan := make(chan image.Rectangle, len(buckets))
+func newRenderJob(scene *Scene, settings RenderSettings, region image.Rectangle, opts RenderOptions) (*renderJob, error) {
+	job := &renderJob{
+		scene:    scene,
+		fb:       NewFramebuffer(region.Dx(), region.Dy()),
+		settings: settings,
+		opts:     opts,
+		stats:    newRenderStats(region.Dx() * region.Dy()),
+		region:   region,
+		buckets:  getBuckets(image.Rect(0, 0, region.Dx(), region.Dy()), settings.BucketSize),
+	}
+	for i := range job.buckets {
+		job.buckets[i] = job.buckets[i].Add(region.Min)
+	}
+	if settings.Progressive {
+		job.stats.totalPasses = len(progressivePasses(settings.Samples))
+	}
+	if settings.Progressive || opts.Checkpoint != nil || opts.Resume != nil {
+		job.estimates = make([]pixelEstimate, settings.Width*settings.Height)
+	}
+	if opts.Resume != nil {
+		if err := job.restore(opts.Resume); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
 
-	done := make(chan bool)
-	go showProgress(width*height, samples, done)
+// restore carries on from checkpoint c.
+func (j *renderJob) restore(c *Checkpoint) error {
+	if err := c.compatible(j.settings); err != nil {
+		return err
+	}
+	copy(j.estimates, c.estimates)
+	j.passes = c.Passes
+	j.stats.passes.Store(int64(c.Passes))
+	for i := range j.estimates {
+		est := &j.estimates[i]
+		px, py := i%j.settings.Width, i/j.settings.Width
+		j.fb.Set(px, py, est.color())
+		if j.opts.SampleMap != nil {
+			j.opts.SampleMap.Set(px, py, est.samples)
+		}
+		if est.done {
+			j.stats.addPixel()
+		}
+	}
+	return nil
+}
 
-	var wg sync.WaitGroup
-	wg.Add(workersCount)
+// sendRestored sends the pixels restored from a checkpoint to opts.Pixels.
+// It reports false if the render was cancelled.
+func (j *renderJob) sendRestored(ctx context.Context) bool {
+	if j.opts.Resume == nil || j.opts.Pixels == nil {
+		return true
+	}
+	toneMap := j.settings.ToneMapping()
+	for i, est := range j.estimates {
+		if est.samples == 0 {
+			continue
+		}
+		px, py := i%j.settings.Width, i/j.settings.Width
+		if !sendPixel(ctx, j.opts.Pixels, px, py, toneMap.Apply(j.fb.At(px, py))) {
+			return false
+		}
+	}
+	return true
+}
 
-	for w := 0; w < workersCount; w++ {
-		go renderBucket(bucketChan, &scene, img, samples, &wg, pixels, int64(w))
+// progressivePasses returns the samples per pixel reached after each pass of
+// a progressive render: 1, 2, 4 and so on up to samples.
+func progressivePasses(samples int) []int {
+	var passes []int
+	for n := 1; n < samples; n *= 2 {
+		passes = append(passes, n)
 	}
+	return append(passes, samples)
+}
 
-	for _, b := range buckets {
-		bucketChan <- b
+// renderProgressive renders the passes not yet finished.
+func (j *renderJob) renderProgressive(ctx context.Context) {
+	for i, samples := range progressivePasses(j.settings.Samples) {
+		if i < j.passes {
+			continue
+		}
+		j.renderPass(ctx, samples)
+		if ctx.Err() != nil {
+			return
+		}
+		j.mu.Lock()
+		j.passes++
+		j.mu.Unlock()
+		j.stats.addPass()
+		if j.opts.Pass != nil {
+			j.opts.Pass(samples, j.fb)
+		}
 	}
+}
 
+// renderPass renders every bucket with settings.Workers workers, taking every
+// pixel to the given number of samples.
+func (j *renderJob) renderPass(ctx context.Context, samples int) {
+	bucketChan := make(chan image.Rectangle, len(j.buckets))
+	for _, b := range j.buckets {
+		bucketChan <- b
+	}
 	close(bucketChan)
+
+	var wg sync.WaitGroup
+	wg.Add(j.settings.Workers)
+	for w := 0; w < j.settings.Workers; w++ {
+		worker := newBucketWorker(w, j)
+		go worker.renderBuckets(ctx, bucketChan, samples, &wg)
+	}
 	wg.Wait()
+}
+
+// checkpoint copies the state of the render.
+func (j *renderJob) checkpoint() *Checkpoint {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return &Checkpoint{Settings: j.settings, Passes: j.passes, estimates: slices.Clone(j.estimates)}
+}
+
+// startCheckpoints hands a checkpoint to opts.Checkpoint every
+// CheckpointInterval until the returned function is called.
+func (j *renderJob) startCheckpoints() func() {
+	if j.opts.Checkpoint == nil {
+		return func() {}
+	}
+	interval := j.opts.CheckpointInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	done := make(chan struct{})
+	var saver sync.WaitGroup
+	saver.Add(1)
+	go func() {
+		defer saver.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.opts.Checkpoint(j.checkpoint())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		saver.Wait()
+	}
+}
 
-	done <- true
-	<-done
+// startProgress reports the progress of a render to opts.Progress until the
+// returned function is called, which returns the final stats.
+func startProgress(stats *renderStats, opts RenderOptions) func() Stats {
+	done := make(chan struct{})
+	var reporter sync.WaitGroup
+	if opts.Progress != nil {
+		reporter.Add(1)
+		go func() {
+			defer reporter.Done()
+			reportProgress(stats, opts.ProgressInterval, opts.Progress, done)
+		}()
+	}
+
+	return func() Stats {
+		close(done)
+		reporter.Wait()
+
+		final := stats.snapshot()
+		if opts.Progress != nil {
+			opts.Progress(final)
+		}
+		return final
+	}
+}
 
-	return img
+func reportProgress(stats *renderStats, interval time.Duration, progress func(Stats), done chan struct{}) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			progress(stats.snapshot())
+		case <-done:
+			return
+		}
+	}
 }
 
 func getBuckets(r image.Rectangle, bucketSize int) []image.Rectangle {
@@ -137,269 +407,216 @@ func clip(r *image.Rectangle, maxX, maxY int) {
 	r.Max.Y = int(math.Min(float64(r.Max.Y), float64(maxY)))
 }
 
-func renderBucket(buckets chan image.Rectangle, scene *Scene, img *image.RGBA, samples int, wg *sync.WaitGroup, pixels chan Pixel, workerID int64) {
-	defer wg.Done()
+// bucketWorker is one of the goroutines rendering buckets, with the state it
+// keeps to itself.
+type bucketWorker struct {
+	id       int
+	job      *renderJob
+	sampling adaptiveSampling
+	radiance radianceFunc
+	toneMap  ToneMap
+	sampler  Sampler
+	rng      *RNG
+	tc       *TraceContext
+}
 
-	width := img.Bounds().Max.X
-	height := img.Bounds().Max.Y
+func newBucketWorker(id int, job *renderJob) *bucketWorker {
+	sampler := NewSampler(job.settings.Sampler, job.settings.Seed, job.settings.Samples)
+	rng := newSamplerRNG(sampler)
+	return &bucketWorker{
+		id:       id,
+		job:      job,
+		sampling: newAdaptiveSampling(job.settings),
+		radiance: integrator(job.settings.Integrator),
+		toneMap:  job.settings.ToneMapping(),
+		sampler:  sampler,
+		rng:      rng,
+		tc:       &TraceContext{rng: rng},
+	}
+}
 
-	// Create a per-worker RNG with a unique seed
-	rng := NewRNG(workerID)
+// renderBuckets takes buckets until there are none left and renders their
+// pixels to the given number of samples.
+func (w *bucketWorker) renderBuckets(ctx context.Context, buckets chan image.Rectangle, samples int, wg *sync.WaitGroup) {
+	defer wg.Done()
 
+	var fresh pixelEstimate
 	for b := range buckets {
+		bucketStart := time.Now()
 		for py := b.Min.Y; py <= b.Max.Y; py++ {
 			for px := b.Min.X; px <= b.Max.X; px++ {
-				clr := pixelColor(px, py, width, height, samples, scene, rng)
-				img.Set(px, py, clr)
-				pixels <- Pixel{
-					image.Point{X: px, Y: py},
-					clr.R,
-					clr.G,
-					clr.B,
+				est := &fresh
+				if w.job.estimates != nil {
+					est = &w.job.estimates[(py-w.job.region.Min.Y)*w.job.fb.Width()+px-w.job.region.Min.X]
+				} else {
+					fresh = pixelEstimate{}
+				}
+				if !w.renderPixel(ctx, px, py, est, samples) {
+					w.job.stats.flush(w.tc)
+					return
 				}
 			}
 		}
+		w.job.stats.addBucket(BucketStats{Bounds: b, Worker: w.id, Duration: time.Since(bucketStart)})
 	}
 }
 
-func rayColor(r Ray, world *HitableList, depth int, rng *RNG) Color {
-	if isHit, rec := world.Hit(r, 0.001, math.MaxFloat64); isHit {
+// renderPixel adds samples to est up to the given number, stores the result
+// and sends it to opts.Pixels. It reports false if the render was cancelled.
+func (w *bucketWorker) renderPixel(ctx context.Context, px, py int, est *pixelEstimate, samples int) bool {
+	job := w.job
+	// Only this worker changes the pixel, so it samples a copy of est and
+	// holds the lock just to store it, letting checkpoints in meanwhile.
+	local := *est
+	if local.done {
+		return true
+	}
+	before := local.samples
+	finished := pixelColor(ctx.Done(), px, py, job.settings.Width, job.settings.Height, w.sampling, samples, &local, job.scene, w.radiance, w.sampler, w.rng, w.tc)
+	x, y := px-job.region.Min.X, py-job.region.Min.Y
+	job.mu.RLock()
+	*est = local
+	if finished {
+		job.fb.Set(x, y, local.color())
+		if job.opts.SampleMap != nil {
+			job.opts.SampleMap.Set(x, y, local.samples)
+		}
+		job.stats.addSamples(local.samples-before, w.tc)
+		if local.done {
+			job.stats.addPixel()
+		}
+	}
+	job.mu.RUnlock()
+
+	if !finished {
+		return false
+	}
+	if job.opts.Pixels == nil {
+		return true
+	}
+	return sendPixel(ctx, job.opts.Pixels, px, py, w.toneMap.Apply(job.fb.At(x, y)))
+}
+
+// sendPixel sends the display colour of pixel (px, py) to pixels. It reports
+// false if the render was cancelled first.
+func sendPixel(ctx context.Context, pixels chan Pixel, px, py int, display color.RGBA) bool {
+	select {
+	case pixels <- Pixel{
+		image.Point{X: px, Y: py},
+		display.R,
+		display.G,
+		display.B,
+	}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func rayColor(r Ray, scene *Scene, depth int, rng *RNG, tc *TraceContext) Color {
+	tc.countRays(1)
+	isHit, rec, weight := scene.trace(r, rng, tc)
+	if isBlack(weight) {
+		return Color{}
+	}
+	if isHit {
 		attenuation := &Color{}
 		emitted := rec.material.Emitted(rec.u, rec.v, rec.P)
 		if depth < 50 {
 			isScattered, scattered := rec.material.Scatter(r, rec, attenuation, rng)
 			if isScattered {
-				clr := rayColor(scattered, world, depth+1, rng)
-				return emitted.Add(attenuation.Multiply(clr))
+				clr := rayColor(scattered, scene, depth+1, rng, tc)
+				return weight.Multiply(emitted.Add(attenuation.Multiply(clr)))
 			}
 
-			return emitted
+			return weight.Multiply(emitted)
 		}
 
-		return emitted
+		return weight.Multiply(emitted)
 	}
 
-	return Color{}
+	return weight.Multiply(scene.background(r.Direction()))
 }
 
-func pixelColor(px, py, width, height, samples int, scene *Scene, rng *RNG) color.RGBA {
-	var rayClr Color
-	for s := 0; s < samples; s++ {
-		u := (float64(px) + rng.Float64()) / float64(width)
-		v := (float64(height-py) + rng.Float64()) / float64(height)
-		r := scene.camera.GetRay(u, v, rng)
-		rayClr = rayClr.Add(rayColor(r, &scene.world, 0, rng))
-	}
-	rayClr = rayClr.DivideScalar(float64(samples))
-	rayClrGamma := Color{
-		R: math.Sqrt(rayClr.R),
-		G: math.Sqrt(rayClr.G),
-		B: math.Sqrt(rayClr.B)}
-
-	atomic.AddUint64(&ops, uint64(samples)) //nolint:gosec // G115: samples is user-controlled but bounded
-
-	return rayClrGamma.ToRGBA()
+// adaptiveSampling decides how many samples a pixel takes.
+type adaptiveSampling struct {
+	minSamples, maxSamples int
+	threshold              float64
 }
 
-func showProgress(pixCount, samples int, done chan bool) {
-	const tickIntervalMs = 1000
-	ticker := time.NewTicker(time.Millisecond * tickIntervalMs)
-	elapsed := 0
-	for {
-		select {
-		case <-ticker.C:
-			progress := float64(atomic.LoadUint64(&ops)) / float64(pixCount*samples)
-			progressPercent := int(100.0 * progress)
-			if progressPercent > 100 {
-				progressPercent = 100
-			}
+// adaptiveLuminanceFloor is the smallest mean luminance the error of a pixel
+// is measured against, so that nearly black pixels do not sample forever.
+const adaptiveLuminanceFloor = 0.01
 
-			var progressBar bytes.Buffer
-			for i := 0; i < progressPercent/2; i++ {
-				progressBar.WriteString("=")
-			}
-			progressBar.WriteString(">")
-
-			elapsed += tickIntervalMs
-			elapsedDuration := time.Second * time.Duration(elapsed/1000)
-			fmt.Printf("\r[%-50s] %d %% %v", progressBar.String(), progressPercent, elapsedDuration)
-		case <-done:
-			ticker.Stop()
-			fmt.Printf("\r[%50s] Done                    \n", "==================================================")
-			fmt.Println("Image rendered in", time.Second*time.Duration(elapsed/1000))
-			done <- true
-			return
-		}
+func newAdaptiveSampling(settings RenderSettings) adaptiveSampling {
+	a := adaptiveSampling{maxSamples: settings.Samples, threshold: settings.AdaptiveThreshold}
+	a.minSamples = a.maxSamples
+	if a.threshold > 0.0 {
+		a.minSamples = min(max(settings.MinSamples, 2), a.maxSamples)
 	}
+	return a
 }
 
-func SimpleLightScene(width, height int) Scene {
-	perlinTexture := NoiseTexture{scale: 4.0}
-
-	world := HitableList{}
-	world = append(world, NewSphere(NewVec3d(0.0, -1000.0, 0.0), 1000, Lambertian{albedo: perlinTexture}))
-	world = append(world, NewSphere(NewVec3d(0.0, 2.0, 0.0), 2, Lambertian{albedo: perlinTexture}))
-
-	light := DiffuseLight{emit: ConstantTexture{color: Color{4.0, 4.0, 4.0}}}
-	world = append(world, NewSphere(NewVec3d(0.0, 7.0, 0.0), 2.0, light))
-	world = append(world, XYRect{x0: 3.0, x1: 5.0, y0: 1.0, y1: 3.0, k: -2.0, material: light})
-
-	lookFrom := NewVec3d(17.0, 2.0, 3.0)
-	lookAt := NewVec3d(0.0, 2.0, 0.0)
-
-	vUp := NewVec3d(0.0, 1.0, 0.0)
-	vFov := 30.0 // vertical field of view in degrees
-	aspectRatio := float64(width) / float64(height)
-
-	distToFocus := 10.0
-	aperture := 0.0
-	cam := NewCamera(lookFrom, lookAt, vUp, vFov, aspectRatio, aperture, distToFocus, 0.0, 1.0)
-
-	bvh := HitableList{}
-	bvh = append(bvh, NewBVHNode(world, 0.0, 1.0, NewRNG(0)))
-	return Scene{camera: cam, world: bvh}
-}
-
-func CornellBox(width, height int) Scene {
-	red := Lambertian{albedo: ConstantTexture{color: Color{R: 0.65, G: 0.05, B: 0.05}}}
-	white := Lambertian{albedo: ConstantTexture{color: Color{R: 0.73, G: 0.73, B: 0.73}}}
-	green := Lambertian{albedo: ConstantTexture{color: Color{R: 0.12, G: 0.45, B: 0.15}}}
-	light := DiffuseLight{emit: ConstantTexture{color: Color{R: 7, G: 7, B: 7}}}
-
-	sceneRng := NewRNG(1)
-
-	world := HitableList{}
-	world = append(world, FlipNormals{hitable: YZRect{y0: 0.0, y1: 555.0, z0: 0.0, z1: 555.0, k: 555.0, material: green}})
-	world = append(world, YZRect{y0: 0.0, y1: 555.0, z0: 0.0, z1: 555.0, k: 0.0, material: red})
-	world = append(world, XZRect{x0: 113.0, x1: 443.0, z0: 127.0, z1: 432.0, k: 554.0, material: light})
-	world = append(world, FlipNormals{hitable: XZRect{x0: 0.0, x1: 555.0, z0: 0.0, z1: 555.0, k: 555.0, material: white}})
-	world = append(world, XZRect{x0: 0.0, x1: 555.0, z0: 0.0, z1: 555.0, k: 0.0, material: white})
-	world = append(world, FlipNormals{hitable: XYRect{x0: 0.0, x1: 555.0, y0: 0.0, y1: 555.0, k: 555.0, material: white}})
-
-	b1 := Translate{
-		hitable: NewRotateY(
-			NewBox(
-				NewVec3d(0.0, 0.0, 0.0),
-				NewVec3d(165.0, 165.0, 165.0),
-				white),
-			-18.0),
-		offset: NewVec3d(130.0, 0.0, 65.0)}
-
-	b2 := Translate{
-		hitable: NewRotateY(
-			NewBox(
-				NewVec3d(0.0, 0.0, 0.0),
-				NewVec3d(165.0, 330.0, 165.0),
-				white),
-			15.0),
-		offset: NewVec3d(265.0, 0.0, 295.0)}
-
-	world = append(world, ConstantMedium{boundary: b1, density: 0.01, phaseFunction: Isotropic{albedo: ConstantTexture{color: Color{R: 1.0, G: 1.0, B: 1.0}}}, rng: sceneRng})
-	world = append(world, ConstantMedium{boundary: b2, density: 0.01, phaseFunction: Isotropic{albedo: ConstantTexture{color: Color{R: 0.0, G: 0.0, B: 0.0}}}, rng: sceneRng})
-
-	lookFrom := NewVec3d(278.0, 278.0, -800.0)
-	lookAt := NewVec3d(278.0, 278.0, 0.0)
-
-	vUp := NewVec3d(0.0, 1.0, 0.0)
-	vFov := 40.0 // vertical field of view in degrees
-	aspectRatio := float64(width) / float64(height)
-
-	distToFocus := 10.0
-	aperture := 0.0
-	cam := NewCamera(lookFrom, lookAt, vUp, vFov, aspectRatio, aperture, distToFocus, 0.0, 1.0)
-
-	bvh := HitableList{}
-	bvh = append(bvh, NewBVHNode(world, 0.0, 1.0, sceneRng))
-	return Scene{camera: cam, world: bvh}
-}
-
-func finalScene(width, height int) Scene {
-	sceneRng := NewRNG(3) // Fixed seed for deterministic scene generation
-	boxlist := HitableList{}
-	ground := Lambertian{albedo: ConstantTexture{color: Color{R: 0.48, G: 0.83, B: 0.53}}}
-
-	nb := 20
-	for i := 0; i < nb; i++ {
-		for j := 0; j < nb; j++ {
-			w := 100.0
-			x0 := -1000.0 + float64(i)*w
-			z0 := -1000.0 + float64(j)*w
-			y0 := 0.0
-			x1 := x0 + w
-			y1 := 100 * (sceneRng.Float64() + 0.01)
-			z1 := z0 + w
-			boxlist = append(boxlist, NewBox(
-				NewVec3d(x0, y0, z0),
-				NewVec3d(x1, y1, z1),
-				ground))
-		}
+// converged reports whether the pixel of est may stop taking samples.
+func (a adaptiveSampling) converged(est *pixelEstimate) bool {
+	n := est.samples
+	if n >= a.maxSamples {
+		return true
 	}
-
-	world := HitableList{}
-	world = append(world, NewBVHNode(boxlist, 0.0, 1.0, sceneRng))
-
-	light := DiffuseLight{emit: ConstantTexture{color: Color{R: 7, G: 7, B: 7}}}
-	world = append(world, XZRect{x0: 123.0, x1: 423.0, z0: 147.0, z1: 412.0, k: 554.0, material: light})
-
-	center := NewVec3d(400.0, 400.0, 200.0)
-	world = append(world, NewMovingSphere(center, center.Add(NewVec3d(30.0, 0.0, 0.0)), 0.0, 1.0, 50.0, Lambertian{albedo: ConstantTexture{color: Color{R: 0.7, G: 0.3, B: 0.1}}}))
-	world = append(world, NewSphere(NewVec3d(260.0, 150.0, 45.0), 50.0, Dielectric{refIdx: 1.5}))
-	world = append(world, NewSphere(NewVec3d(0.0, 150.0, 145.0), 50.0, Metal{albedo: ConstantTexture{color: Color{R: 0.8, G: 0.8, B: 0.9}}, fuzz: 1.0}))
-
-	bnd := NewSphere(NewVec3d(360.0, 150.0, 145.0), 70.0, Dielectric{refIdx: 1.5})
-	world = append(world, bnd)
-	world = append(world, ConstantMedium{boundary: bnd, density: 0.2, phaseFunction: Isotropic{albedo: ConstantTexture{color: Color{R: 0.2, G: 0.4, B: 0.9}}}, rng: sceneRng})
-	bnd2 := NewSphere(NewVec3d(0.0, 0.0, 0.0), 5000.0, Dielectric{refIdx: 1.5})
-	world = append(world, ConstantMedium{boundary: bnd2, density: 0.0001, phaseFunction: Isotropic{albedo: ConstantTexture{color: Color{R: 1.0, G: 1.0, B: 1.0}}}, rng: sceneRng})
-
-	f, err := os.Open("earthmap.jpg")
-	if err != nil {
-		panic("cannot find earthmap.jpg")
+	if n < a.minSamples {
+		return false
 	}
-	defer f.Close()
-
-	img, _, err := image.Decode(f)
-	if err != nil {
-		panic("cannot decode earthmap.jpg")
-	}
-
-	earth := Lambertian{
-		albedo: ImageTexture{
-			image: img,
-		}}
+	variance := est.m2 / float64(n-1)
+	halfWidth := 1.96 * math.Sqrt(variance/float64(n))
+	return halfWidth <= a.threshold*math.Max(est.mean, adaptiveLuminanceFloor)
+}
 
-	world = append(world, NewSphere(NewVec3d(400.0, 200.0, 400.0), 100.0, earth))
+// pixelEstimate accumulates the samples of one pixel, together with the mean
+// and the sum of squared differences from it of their luminance, which
+// Welford's algorithm keeps up to date.
+type pixelEstimate struct {
+	sum      Color
+	samples  int
+	mean, m2 float64
+	done     bool // converged or at the most samples
+}
 
-	perlinTexture := NoiseTexture{scale: 0.1}
-	world = append(world, NewSphere(NewVec3d(220.0, 280.0, 300.0), 80.0, Lambertian{albedo: perlinTexture}))
+func (e *pixelEstimate) add(c Color) {
+	e.sum = e.sum.Add(c)
+	e.samples++
+	l := luminance(c)
+	delta := l - e.mean
+	e.mean += delta / float64(e.samples)
+	e.m2 += delta * (l - e.mean)
+}
 
-	ns := 1000
-	sphereList := HitableList{}
-	white := Lambertian{albedo: ConstantTexture{color: Color{R: 0.73, G: 0.73, B: 0.73}}}
-	for j := 0; j < ns; j++ {
-		sphereList = append(sphereList, NewSphere(NewVec3d(165.0*sceneRng.Float64(), 165.0*sceneRng.Float64(), 165.0*sceneRng.Float64()), 10.0, white))
+// color returns the mean radiance of the samples.
+func (e *pixelEstimate) color() Color {
+	if e.samples == 0 {
+		return Color{}
 	}
+	return e.sum.DivideScalar(float64(e.samples))
+}
 
-	sphereBox := Translate{
-		hitable: NewRotateY(
-			NewBVHNode(sphereList, 0.0, 1.0, sceneRng),
-			15.0),
-		offset: NewVec3d(-100.0, 270.0, 395.0)}
-
-	world = append(world, sphereBox)
-
-	lookFrom := NewVec3d(478.0, 278.0, -600.0)
-	lookAt := NewVec3d(278.0, 278.0, 0.0)
-
-	vUp := NewVec3d(0.0, 1.0, 0.0)
-	vFov := 40.0 // vertical field of view in degrees
-	aspectRatio := float64(width) / float64(height)
-
-	distToFocus := 10.0
-	aperture := 0.0
-	cam := NewCamera(lookFrom, lookAt, vUp, vFov, aspectRatio, aperture, distToFocus, 0.0, 1.0)
+// pixelColor adds rays through one pixel to est until it has the given number
+// of samples or sampling says the pixel has converged, taking the random
+// numbers of each from sampler through rng. It gives up and reports false as
+// soon as done is closed.
+func pixelColor(done <-chan struct{}, px, py, width, height int, sampling adaptiveSampling, samples int, est *pixelEstimate, scene *Scene, radiance radianceFunc, sampler Sampler, rng *RNG, tc *TraceContext) bool {
+	for !est.done && est.samples < samples {
+		select {
+		case <-done:
+			return false
+		default:
+		}
 
-	bvh := HitableList{}
-	bvh = append(bvh, NewBVHNode(world, 0.0, 1.0, sceneRng))
-	return Scene{camera: cam, world: bvh}
+		sampler.StartSample(px, py, est.samples)
+		jx, jy := rng.Float64Pair()
+		u := (float64(px) + jx) / float64(width)
+		v := (float64(height-py) + jy) / float64(height)
+		r := scene.camera.GetRay(u, v, rng)
+		est.add(radiance(r, scene, rng, tc))
+		est.done = sampling.converged(est)
+	}
+	return true
 }
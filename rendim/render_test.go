@@ -0,0 +1,310 @@
+package rendim
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func loadTestScene(t testing.TB, name string, width, height int) Scene {
+	t.Helper()
+	return buildTestScene(t, name, RenderSettings{Width: width, Height: height})
+}
+
+func buildTestScene(t testing.TB, name string, settings RenderSettings) Scene {
+	t.Helper()
+	scene, err := loadTestSceneFile(t, name).Build(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scene
+}
+
+func loadTestSceneFile(t testing.TB, name string) *SceneFile {
+	t.Helper()
+	path, err := BuiltinScenePath(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := LoadSceneFile(filepath.Join("..", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sf
+}
+
+func TestRenderSceneCompletes(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 2, BucketSize: 8, Workers: 3}
+
+	fb, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Width() != 16 || fb.Height() != 16 {
+		t.Errorf("Framebuffer size = %dx%d, want 16x16", fb.Width(), fb.Height())
+	}
+}
+
+// TestRenderCornellBoxWorkers renders the Cornell box, whose boxes of smoke
+// are sampled while tracing, with several workers. Run it with -race to check
+// that the workers share no random state.
+func TestRenderCornellBoxWorkers(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 32, 32)
+	settings := RenderSettings{Width: 32, Height: 32, Samples: 4, BucketSize: 8, Workers: 4}
+
+	fb, stats, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pixels != 32*32 {
+		t.Errorf("rendered %d pixels, want %d", stats.Pixels, 32*32)
+	}
+	lit := false
+	for y := 0; y < fb.Height(); y++ {
+		for x := 0; x < fb.Width(); x++ {
+			c := fb.At(x, y)
+			if math.IsNaN(c.R+c.G+c.B) || math.IsInf(c.R+c.G+c.B, 0) {
+				t.Fatalf("pixel (%d, %d) = %v", x, y, c)
+			}
+			lit = lit || !isBlack(c)
+		}
+	}
+	if !lit {
+		t.Error("the rendered image is black")
+	}
+}
+
+func TestRenderReproducible(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 24, 24)
+	render := func(settings RenderSettings) *Framebuffer {
+		t.Helper()
+		settings.Width, settings.Height, settings.Samples = 24, 24, 3
+		fb, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb
+	}
+
+	want := render(RenderSettings{BucketSize: 24, Workers: 1, Seed: 5})
+	for _, settings := range []RenderSettings{
+		{BucketSize: 4, Workers: 4, Seed: 5},
+		{BucketSize: 7, Workers: 3, Seed: 5},
+		{BucketSize: 24, Workers: 1, Seed: 5},
+	} {
+		if got := render(settings); !slices.Equal(got.pix, want.pix) {
+			t.Errorf("%d workers with %dpx buckets rendered a different image", settings.Workers, settings.BucketSize)
+		}
+	}
+	if got := render(RenderSettings{BucketSize: 24, Workers: 1, Seed: 6}); slices.Equal(got.pix, want.pix) {
+		t.Error("another seed rendered the same image")
+	}
+
+	for _, name := range samplerNames {
+		want := render(RenderSettings{BucketSize: 24, Workers: 1, Seed: 5, Sampler: name})
+		if got := render(RenderSettings{BucketSize: 5, Workers: 3, Seed: 5, Sampler: name}); !slices.Equal(got.pix, want.pix) {
+			t.Errorf("%s sampler rendered a different image with other workers", name)
+		}
+	}
+}
+
+func TestRenderSceneCancelled(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 64, 64)
+	settings := RenderSettings{Width: 64, Height: 64, Samples: 100000, BucketSize: 16, Workers: 4}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	fb, _, err := RenderScene(ctx, scene, settings, RenderOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RenderScene() error = %v, want context.DeadlineExceeded", err)
+	}
+	if fb == nil {
+		t.Error("RenderScene() should return the partial framebuffer")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RenderScene() took %v after the deadline", elapsed)
+	}
+}
+
+func TestRenderSceneCancelDoesNotLeakWorkers(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 32, 32)
+	settings := RenderSettings{Width: 32, Height: 32, Samples: 1, BucketSize: 8, Workers: 4}
+	before := runtime.NumGoroutine()
+
+	// Nobody reads pixels after the first one, as when a viewer disconnects.
+	ctx, cancel := context.WithCancel(context.Background())
+	pixels := make(chan Pixel)
+	result := make(chan error)
+	go func() {
+		_, _, err := RenderScene(ctx, scene, settings, RenderOptions{Pixels: pixels})
+		result <- err
+	}()
+	<-pixels
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("RenderScene() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RenderScene() did not return after cancellation")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines still running, had %d before the render", n, before)
+	}
+}
+
+func TestAdaptiveSamplingConverged(t *testing.T) {
+	a := newAdaptiveSampling(RenderSettings{Samples: 100, MinSamples: 8, AdaptiveThreshold: 0.05})
+	if a.converged(&pixelEstimate{samples: 4, mean: 1.0}) {
+		t.Error("converged before the minimum samples")
+	}
+	if !a.converged(&pixelEstimate{samples: 8, mean: 1.0}) {
+		t.Error("a pixel without variance did not converge at the minimum samples")
+	}
+	if a.converged(&pixelEstimate{samples: 8, mean: 1.0, m2: 7.0}) {
+		t.Error("a noisy pixel converged")
+	}
+	if !a.converged(&pixelEstimate{samples: 100, mean: 1.0, m2: 99.0}) {
+		t.Error("a pixel did not stop at the maximum samples")
+	}
+
+	fixed := newAdaptiveSampling(RenderSettings{Samples: 100, MinSamples: 8})
+	if fixed.converged(&pixelEstimate{samples: 99, mean: 1.0}) {
+		t.Error("a pixel stopped early without an adaptive threshold")
+	}
+}
+
+func TestRenderAdaptiveSampling(t *testing.T) {
+	// A diffuse sphere in front of a uniform sky: sky pixels converge at
+	// once, pixels on the sphere need more samples.
+	camera := NewCamera(NewVec3d(0.0, 0.0, -4.0), NewVec3d(0.0, 0.0, 0.0), NewVec3d(0.0, 1.0, 0.0), 40.0, 1.0, 0.0, 4.0, 0.0, 1.0)
+	sphere := NewSphere(NewVec3d(0.0, 0.0, 0.0), 0.6, NewLambertian(NewConstantTexture(Color{R: 0.5, G: 0.5, B: 0.5})))
+	sky := NewGradientSky(Color{R: 0.1, G: 0.1, B: 0.1}, Color{R: 1.0, G: 1.0, B: 1.0})
+	scene := NewScene(camera, HitableList{sphere}).WithEnvironment(sky)
+
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 256, MinSamples: 8, AdaptiveThreshold: 0.02, BucketSize: 8, Workers: 2, Sampler: SamplerSobol}
+	sampleMap := NewSampleMap(16, 16)
+	_, stats, err := RenderScene(context.Background(), scene, settings, RenderOptions{SampleMap: sampleMap})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if corner := sampleMap.At(0, 0); corner != 8 {
+		t.Errorf("sky pixel took %d samples, want the minimum of 8", corner)
+	}
+	if centre := sampleMap.At(8, 8); centre <= 8 {
+		t.Errorf("pixel on the sphere took %d samples, want more than the minimum", centre)
+	}
+	total := uint64(0)
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			total += uint64(sampleMap.At(x, y))
+		}
+	}
+	if stats.Samples != total || total >= 256*16*16 {
+		t.Errorf("stats counted %d samples and the map %d, want the same and fewer than %d", stats.Samples, total, 256*16*16)
+	}
+}
+
+func TestProgressivePasses(t *testing.T) {
+	tests := []struct {
+		samples int
+		want    []int
+	}{
+		{1, []int{1}},
+		{2, []int{1, 2}},
+		{5, []int{1, 2, 4, 5}},
+		{8, []int{1, 2, 4, 8}},
+	}
+	for _, tt := range tests {
+		if got := progressivePasses(tt.samples); !slices.Equal(got, tt.want) {
+			t.Errorf("progressivePasses(%d) = %v, want %v", tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestRenderProgressiveMatchesBuckets(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	for _, settings := range []RenderSettings{
+		{Width: 16, Height: 16, Samples: 5, BucketSize: 8, Workers: 3, Seed: 2},
+		{Width: 16, Height: 16, Samples: 12, MinSamples: 3, AdaptiveThreshold: 0.2, BucketSize: 5, Workers: 2, Seed: 2},
+	} {
+		want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		settings.Progressive = true
+		var passes []int
+		pixels := make(chan Pixel, 16*16)
+		sent := make(chan int)
+		go func() {
+			n := 0
+			for range pixels {
+				n++
+			}
+			sent <- n
+		}()
+		got, stats, err := RenderScene(context.Background(), scene, settings, RenderOptions{
+			Pixels: pixels,
+			Pass:   func(samples int, fb *Framebuffer) { passes = append(passes, samples) },
+		})
+		close(pixels)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(got.pix, want.pix) {
+			t.Errorf("%d samples: the progressive render differs from the bucket render", settings.Samples)
+		}
+		if wantPasses := progressivePasses(settings.Samples); !slices.Equal(passes, wantPasses) {
+			t.Errorf("passes = %v, want %v", passes, wantPasses)
+		}
+		if stats.Passes != len(passes) || stats.TotalPasses != len(passes) || stats.Progress() != 1.0 || stats.Pixels != 16*16 {
+			t.Errorf("stats = %d/%d passes, %d pixels, want every pass and pixel finished", stats.Passes, stats.TotalPasses, stats.Pixels)
+		}
+		if n := <-sent; n < 16*16*2 {
+			t.Errorf("%d pixels were sent, want every pixel in more than one pass", n)
+		}
+	}
+}
+
+func TestRenderProgressiveCancelledKeepsLastPass(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 1, BucketSize: 8, Workers: 2, Progressive: true}
+	want, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	settings.Samples = 64
+	got, stats, err := RenderScene(ctx, scene, settings, RenderOptions{
+		Pass: func(samples int, fb *Framebuffer) { cancel() },
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if stats.Passes != 1 {
+		t.Errorf("Passes = %d, want 1", stats.Passes)
+	}
+	if !slices.Equal(got.pix, want.pix) {
+		t.Error("the framebuffer does not hold the first pass")
+	}
+}
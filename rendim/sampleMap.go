@@ -0,0 +1,79 @@
+package rendim
+
+import (
+	"image"
+	"image/color"
+)
+
+// SampleMap records how many samples each pixel of an adaptive render took.
+type SampleMap struct {
+	width, height int
+	counts        []int
+}
+
+// NewSampleMap creates a sample map of the given size with every count zero.
+func NewSampleMap(width, height int) *SampleMap {
+	return &SampleMap{width: width, height: height, counts: make([]int, width*height)}
+}
+
+// Width returns the width in pixels.
+func (m *SampleMap) Width() int {
+	return m.width
+}
+
+// Height returns the height in pixels.
+func (m *SampleMap) Height() int {
+	return m.height
+}
+
+// At returns the samples taken in pixel (x, y).
+func (m *SampleMap) At(x, y int) int {
+	return m.counts[y*m.width+x]
+}
+
+// Set stores the samples taken in pixel (x, y).
+func (m *SampleMap) Set(x, y, samples int) {
+	m.counts[y*m.width+x] = samples
+}
+
+// Max returns the largest count in the map.
+func (m *SampleMap) Max() int {
+	most := 0
+	for _, c := range m.counts {
+		most = max(most, c)
+	}
+	return most
+}
+
+// heatmapColors run from few samples to many.
+var heatmapColors = []Color{
+	{R: 0.0, G: 0.0, B: 0.0},
+	{R: 0.2, G: 0.0, B: 0.5},
+	{R: 0.8, G: 0.1, B: 0.3},
+	{R: 1.0, G: 0.6, B: 0.0},
+	{R: 1.0, G: 1.0, B: 1.0},
+}
+
+// Heatmap draws the map with black for pixels without samples, through
+// purple, red and orange to white for pixels that took maxSamples or more.
+func (m *SampleMap) Heatmap(maxSamples int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, m.width, m.height))
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			t := 1.0
+			if maxSamples > 0 {
+				t = min(float64(m.At(x, y))/float64(maxSamples), 1.0)
+			}
+			img.SetRGBA(x, y, heatmapColor(t))
+		}
+	}
+	return img
+}
+
+// heatmapColor interpolates heatmapColors at t in [0, 1].
+func heatmapColor(t float64) color.RGBA {
+	f := t * float64(len(heatmapColors)-1)
+	i := min(int(f), len(heatmapColors)-2)
+	c := lerpColor(heatmapColors[i], heatmapColors[i+1], f-float64(i))
+	return color.RGBA{R: uint8(255.0 * c.R), G: uint8(255.0 * c.G), B: uint8(255.0 * c.B), A: 255}
+}
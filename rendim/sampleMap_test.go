@@ -0,0 +1,32 @@
+package rendim
+
+import "testing"
+
+func TestSampleMap(t *testing.T) {
+	m := NewSampleMap(3, 2)
+	m.Set(2, 1, 64)
+	m.Set(0, 0, 16)
+	if got := m.At(2, 1); got != 64 {
+		t.Errorf("At() = %d, want 64", got)
+	}
+	if got := m.Max(); got != 64 {
+		t.Errorf("Max() = %d, want 64", got)
+	}
+}
+
+func TestSampleMapHeatmap(t *testing.T) {
+	m := NewSampleMap(3, 1)
+	m.Set(1, 0, 32)
+	m.Set(2, 0, 100)
+	img := m.Heatmap(64)
+
+	if c := img.RGBAAt(0, 0); c.R != 0 || c.G != 0 || c.B != 0 || c.A != 255 {
+		t.Errorf("pixel without samples = %v, want black", c)
+	}
+	if c := img.RGBAAt(2, 0); c.R != 255 || c.G != 255 || c.B != 255 {
+		t.Errorf("pixel over the maximum = %v, want white", c)
+	}
+	if c := img.RGBAAt(1, 0); c.R == 0 || c.R == 255 {
+		t.Errorf("pixel halfway = %v, want a colour in between", c)
+	}
+}
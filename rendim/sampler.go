@@ -0,0 +1,347 @@
+package rendim
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// Names of the samplers that can be selected in RenderSettings.
+const (
+	// SamplerIndependent draws every number independently at random.
+	SamplerIndependent = "independent"
+	// SamplerStratified jitters each sample inside its own stratum of every
+	// dimension.
+	SamplerStratified = "stratified"
+	// SamplerHalton uses the Halton sequence, shifted randomly per pixel.
+	SamplerHalton = "halton"
+	// SamplerSobol uses the Sobol sequence with Owen scrambling.
+	SamplerSobol = "sobol"
+)
+
+// Sampler produces the random numbers of camera samples. Each sample of a
+// pixel is a point in a unit hypercube of many dimensions, handed out one or
+// two dimensions at a time in the order they are asked for. Spreading the
+// samples of a pixel evenly over each dimension makes renders converge faster
+// than independent random numbers do, while every single sample stays
+// uniformly distributed, so the estimates remain unbiased.
+type Sampler interface {
+	// StartSample begins the sample with the given index of pixel (px, py).
+	StartSample(px, py, index int)
+	// Get1D returns the next dimension of the current sample.
+	Get1D() float64
+	// Get2D returns the next two dimensions of the current sample, which are
+	// spread evenly as a pair.
+	Get2D() (float64, float64)
+}
+
+// NewSampler creates the sampler named by RenderSettings.Sampler for renders
+// with the given seed and samples per pixel. Unknown names give an
+// independent sampler.
+func NewSampler(name string, seed int64, samples int) Sampler {
+	switch name {
+	case SamplerStratified:
+		return NewStratifiedSampler(seed, samples)
+	case SamplerHalton:
+		return NewHaltonSampler(seed)
+	case SamplerSobol:
+		return NewSobolSampler(seed)
+	default:
+		return NewIndependentSampler(seed)
+	}
+}
+
+// sampleState is what every sampler keeps about the current sample: where it
+// is, how many dimensions it has used and a generator seeded for it alone,
+// for jitter and for dimensions beyond what a sequence provides.
+type sampleState struct {
+	seed          int64
+	px, py, index int
+	dimension     int
+	source        *rand.PCG
+	rng           *rand.Rand
+}
+
+func newSampleState(seed int64) sampleState {
+	source := rand.NewPCG(0, 0)
+	return sampleState{seed: seed, source: source, rng: rand.New(source)} //nolint:gosec // G404: math/rand is fine for graphics rendering
+}
+
+// start seeds the generator from the render seed and the place of the
+// sample only, so that a render comes out the same whichever worker takes
+// each pixel.
+func (s *sampleState) start(px, py, index int) {
+	s.px, s.py, s.index, s.dimension = px, py, index, 0
+	s.source.Seed(hashSample(s.seed, px, py, uint64(index)), 0) //nolint:gosec // G115: only the bits matter
+}
+
+// next returns the index of the next dimension and reserves n dimensions.
+func (s *sampleState) next(n int) int {
+	d := s.dimension
+	s.dimension += n
+	return d
+}
+
+// pixelHash returns a hash of the render seed, the pixel and v, with which
+// the samplers scramble each dimension differently in every pixel.
+func (s *sampleState) pixelHash(v uint64) uint64 {
+	return hashSample(s.seed, s.px, s.py, v)
+}
+
+func hashSample(seed int64, px, py int, v uint64) uint64 {
+	pixel := uint64(uint32(px))<<32 | uint64(uint32(py))              //nolint:gosec // G115: only the bits matter
+	return splitMix64(uint64(seed) ^ splitMix64(pixel^splitMix64(v))) //nolint:gosec // G115: only the bits matter
+}
+
+// splitMix64 scrambles x so that nearby inputs give unrelated hashes.
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// IndependentSampler draws every dimension independently at random.
+type IndependentSampler struct {
+	sampleState
+}
+
+// NewIndependentSampler creates a sampler of independent random numbers.
+func NewIndependentSampler(seed int64) *IndependentSampler {
+	return &IndependentSampler{sampleState: newSampleState(seed)}
+}
+
+func (s *IndependentSampler) StartSample(px, py, index int) {
+	s.start(px, py, index)
+}
+
+func (s *IndependentSampler) Get1D() float64 {
+	return s.rng.Float64()
+}
+
+func (s *IndependentSampler) Get2D() (float64, float64) {
+	return s.rng.Float64(), s.rng.Float64()
+}
+
+// StratifiedSampler splits every dimension of a pixel into as many strata as
+// the pixel has samples, and every pair of dimensions into a square grid of
+// at least that many cells, and puts each sample at a random place in a
+// stratum of its own. The strata are shuffled independently per dimension.
+type StratifiedSampler struct {
+	sampleState
+	samples int
+	side    int // cells along each side of the grid of a pair of dimensions
+}
+
+// NewStratifiedSampler creates a stratified sampler for pixels of samples
+// samples. Further samples start over in new strata.
+func NewStratifiedSampler(seed int64, samples int) *StratifiedSampler {
+	samples = max(samples, 1)
+	return &StratifiedSampler{
+		sampleState: newSampleState(seed),
+		samples:     samples,
+		side:        int(math.Ceil(math.Sqrt(float64(samples)))),
+	}
+}
+
+func (s *StratifiedSampler) StartSample(px, py, index int) {
+	s.start(px, py, index)
+}
+
+// stratum returns the stratum out of n of the current sample in dimension d.
+func (s *StratifiedSampler) stratum(d, n int) int {
+	round := s.index / s.samples
+	p := s.pixelHash(uint64(d)<<32 | uint64(round)) //nolint:gosec // G115: d and round are never negative
+	return permuteIndex(s.index%s.samples, n, uint32(p))
+}
+
+func (s *StratifiedSampler) Get1D() float64 {
+	d := s.next(1)
+	return (float64(s.stratum(d, s.samples)) + s.rng.Float64()) / float64(s.samples)
+}
+
+func (s *StratifiedSampler) Get2D() (float64, float64) {
+	d := s.next(2)
+	cell := s.stratum(d, s.side*s.side)
+	x, y := cell%s.side, cell/s.side
+	return (float64(x) + s.rng.Float64()) / float64(s.side), (float64(y) + s.rng.Float64()) / float64(s.side)
+}
+
+// permuteIndex maps i in [0, n) to its place in a pseudo-random permutation
+// of [0, n) chosen by p, without storing the permutation (Kensler 2013).
+func permuteIndex(i, n int, p uint32) int {
+	l := uint32(n) //nolint:gosec // G115: n is a positive sample count
+	w := l - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	x := uint32(i) //nolint:gosec // G115: 0 <= i < n
+	for {
+		x ^= p
+		x *= 0xe170893d
+		x ^= p >> 16
+		x ^= (x & w) >> 4
+		x ^= p >> 8
+		x *= 0x0929eb3f
+		x ^= p >> 23
+		x ^= (x & w) >> 1
+		x *= 1 | p>>27
+		x *= 0x6935fa69
+		x ^= (x & w) >> 11
+		x *= 0x74dcb303
+		x ^= (x & w) >> 2
+		x *= 0x9e501cc3
+		x ^= (x & w) >> 2
+		x *= 0xc860a3df
+		x &= w
+		x ^= x >> 5
+		if x < l {
+			return int((x + p) % l)
+		}
+	}
+}
+
+// haltonPrimes are the bases of the dimensions of the Halton sequence.
+// Dimensions beyond them are filled in at random.
+var haltonPrimes = firstPrimes(128)
+
+func firstPrimes(n int) []int {
+	primes := make([]int, 0, n)
+	for c := 2; len(primes) < n; c++ {
+		isPrime := true
+		for _, p := range primes {
+			if p*p > c {
+				break
+			}
+			if c%p == 0 {
+				isPrime = false
+				break
+			}
+		}
+		if isPrime {
+			primes = append(primes, c)
+		}
+	}
+	return primes
+}
+
+// HaltonSampler takes the samples of every pixel from the Halton sequence,
+// whose dimension d is the radical inverse of the sample index in the dth
+// prime base. Each pixel shifts every dimension by its own random offset
+// (Cranley-Patterson rotation), so that pixels do not share their noise.
+type HaltonSampler struct {
+	sampleState
+}
+
+// NewHaltonSampler creates a Halton sampler.
+func NewHaltonSampler(seed int64) *HaltonSampler {
+	return &HaltonSampler{sampleState: newSampleState(seed)}
+}
+
+func (s *HaltonSampler) StartSample(px, py, index int) {
+	s.start(px, py, index)
+}
+
+func (s *HaltonSampler) dimensionValue(d int) float64 {
+	if d >= len(haltonPrimes) {
+		return s.rng.Float64()
+	}
+	shift := float64(s.pixelHash(uint64(d))>>11) * 0x1p-53 //nolint:gosec // G115: d is never negative
+	v := radicalInverse(s.index, haltonPrimes[d]) + shift
+	if v >= 1.0 {
+		v -= 1.0
+	}
+	return v
+}
+
+func (s *HaltonSampler) Get1D() float64 {
+	return s.dimensionValue(s.next(1))
+}
+
+func (s *HaltonSampler) Get2D() (float64, float64) {
+	d := s.next(2)
+	return s.dimensionValue(d), s.dimensionValue(d + 1)
+}
+
+// radicalInverse mirrors the digits of i in the given base around the
+// radix point.
+func radicalInverse(i, base int) float64 {
+	inverse := 1.0 / float64(base)
+	factor := inverse
+	v := 0.0
+	for i > 0 {
+		v += float64(i%base) * factor
+		i /= base
+		factor *= inverse
+	}
+	return math.Min(v, math.Nextafter(1.0, 0.0))
+}
+
+// SobolSampler takes the samples of every pixel from the first two
+// dimensions of the Sobol sequence with hash-based Owen scrambling, padded
+// out to any number of dimensions by shuffling the sample order differently
+// for every request (Burley 2020). Power of two sample counts spread best.
+type SobolSampler struct {
+	sampleState
+}
+
+// NewSobolSampler creates a scrambled Sobol sampler.
+func NewSobolSampler(seed int64) *SobolSampler {
+	return &SobolSampler{sampleState: newSampleState(seed)}
+}
+
+func (s *SobolSampler) StartSample(px, py, index int) {
+	s.start(px, py, index)
+}
+
+// point returns the scrambled Sobol point of the current sample for the
+// request starting at dimension d.
+func (s *SobolSampler) point(d int) (uint32, uint32) {
+	h := s.pixelHash(uint64(d))                            //nolint:gosec // G115: d is never negative
+	i := nestedUniformScramble(uint32(s.index), uint32(h)) //nolint:gosec // G115: only the low bits of the index are used
+	x := nestedUniformScramble(sobol0(i), uint32(h>>32))
+	y := nestedUniformScramble(sobol1(i), uint32(splitMix64(h)))
+	return x, y
+}
+
+func (s *SobolSampler) Get1D() float64 {
+	x, _ := s.point(s.next(1))
+	return float64(x) * 0x1p-32
+}
+
+func (s *SobolSampler) Get2D() (float64, float64) {
+	x, y := s.point(s.next(2))
+	return float64(x) * 0x1p-32, float64(y) * 0x1p-32
+}
+
+// sobol0 is the first dimension of the Sobol sequence, the base two van der
+// Corput sequence.
+func sobol0(i uint32) uint32 {
+	return bits.Reverse32(i)
+}
+
+// sobol1 is the second dimension of the Sobol sequence.
+func sobol1(i uint32) uint32 {
+	r := uint32(0)
+	for v := uint32(1) << 31; i != 0; i >>= 1 {
+		if i&1 != 0 {
+			r ^= v
+		}
+		v ^= v >> 1
+	}
+	return r
+}
+
+// nestedUniformScramble applies a random Owen scramble chosen by seed to the
+// bits of x, read as a binary fraction.
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return bits.Reverse32(x)
+}
@@ -0,0 +1,173 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+var samplerNames = []string{SamplerIndependent, SamplerStratified, SamplerHalton, SamplerSobol}
+
+func TestSamplersInUnitInterval(t *testing.T) {
+	for _, name := range samplerNames {
+		sampler := NewSampler(name, 1, 64)
+		for i := 0; i < 200; i++ {
+			sampler.StartSample(3, 5, i)
+			for d := 0; d < 200; d++ {
+				v := sampler.Get1D()
+				x, y := sampler.Get2D()
+				if v < 0.0 || v >= 1.0 || x < 0.0 || x >= 1.0 || y < 0.0 || y >= 1.0 {
+					t.Fatalf("%s: sample %d gave %v, (%v, %v) at dimension %d", name, i, v, x, y, d)
+				}
+			}
+		}
+	}
+}
+
+func TestSamplersDeterministic(t *testing.T) {
+	draw := func(sampler Sampler, px, py, index int) [3]float64 {
+		sampler.StartSample(px, py, index)
+		v := sampler.Get1D()
+		x, y := sampler.Get2D()
+		return [3]float64{v, x, y}
+	}
+	for _, name := range samplerNames {
+		a, b := NewSampler(name, 7, 16), NewSampler(name, 7, 16)
+		want := draw(a, 4, 9, 3)
+		draw(b, 1, 1, 0)
+		if got := draw(b, 4, 9, 3); got != want {
+			t.Errorf("%s: sample = %v, want %v", name, got, want)
+		}
+		if got := draw(b, 5, 9, 3); got == want {
+			t.Errorf("%s: neighbouring pixels share their samples", name)
+		}
+		if got := draw(NewSampler(name, 8, 16), 4, 9, 3); got == want {
+			t.Errorf("%s: another seed gave the same samples", name)
+		}
+	}
+}
+
+func TestSamplersStratify(t *testing.T) {
+	const n = 16
+	for _, name := range []string{SamplerStratified, SamplerSobol} {
+		sampler := NewSampler(name, 3, n)
+		for d := 0; d < 6; d++ {
+			strata := make(map[int]bool)
+			cells := make(map[[2]int]bool)
+			for i := 0; i < n; i++ {
+				sampler.StartSample(2, 7, i)
+				for skip := 0; skip < d; skip++ {
+					sampler.Get1D()
+					sampler.Get2D()
+				}
+				strata[int(sampler.Get1D()*n)] = true
+				x, y := sampler.Get2D()
+				cells[[2]int{int(x * 4), int(y * 4)}] = true
+			}
+			if len(strata) != n {
+				t.Errorf("%s: %d samples hit %d of %d strata at dimension %d", name, n, len(strata), n, d)
+			}
+			if len(cells) != n {
+				t.Errorf("%s: %d samples hit %d of %d cells at dimension %d", name, n, len(cells), n, d)
+			}
+		}
+	}
+}
+
+func TestStratifiedSamplerStartsOver(t *testing.T) {
+	const n = 9
+	sampler := NewStratifiedSampler(1, n)
+	for round := 0; round < 3; round++ {
+		strata := make(map[int]bool)
+		for i := round * n; i < (round+1)*n; i++ {
+			sampler.StartSample(0, 0, i)
+			strata[int(sampler.Get1D()*n)] = true
+		}
+		if len(strata) != n {
+			t.Errorf("round %d hit %d of %d strata", round, len(strata), n)
+		}
+	}
+}
+
+func TestSamplersReduceError(t *testing.T) {
+	// Integrate a smooth function over the unit square in many pixels and
+	// compare the errors of the estimates with those of independent samples.
+	const (
+		samples = 64
+		pixels  = 200
+	)
+	want := 0.5 * (1.0 - math.Cos(1.0)) // the integral of x sin(y)
+	rmsError := func(name string) float64 {
+		sampler := NewSampler(name, 5, samples)
+		sum := 0.0
+		for p := 0; p < pixels; p++ {
+			estimate := 0.0
+			for i := 0; i < samples; i++ {
+				sampler.StartSample(p, 0, i)
+				sampler.Get1D()
+				x, y := sampler.Get2D()
+				estimate += x * math.Sin(y)
+			}
+			e := estimate/samples - want
+			sum += e * e
+		}
+		return math.Sqrt(sum / pixels)
+	}
+
+	independent := rmsError(SamplerIndependent)
+	for _, name := range []string{SamplerStratified, SamplerHalton, SamplerSobol} {
+		if e := rmsError(name); e >= independent/2.0 {
+			t.Errorf("%s: RMS error = %v, want well below %v for independent samples", name, e, independent)
+		}
+	}
+}
+
+func TestSamplersConverge(t *testing.T) {
+	scene, want := lightOverFloor()
+	ray := NewRay(NewVec3d(0.0, 1.0, -1.0), NewVec3d(0.0, -1.0, 1.0), 0.0)
+
+	for _, name := range samplerNames {
+		sampler := NewSampler(name, 11, 4096)
+		rng := newSamplerRNG(sampler)
+		const samples = 4096
+		sum := 0.0
+		for i := 0; i < samples; i++ {
+			sampler.StartSample(0, 0, i)
+			sum += misRadiance(ray, &scene, rng, nil).G
+		}
+		if mean := sum / samples; math.Abs(mean-want) > 0.06*want {
+			t.Errorf("%s: mean radiance = %v, want %v", name, mean, want)
+		}
+	}
+}
+
+func TestRadicalInverse(t *testing.T) {
+	tests := []struct {
+		i, base int
+		want    float64
+	}{
+		{0, 2, 0.0},
+		{1, 2, 0.5},
+		{2, 2, 0.25},
+		{3, 2, 0.75},
+		{1, 3, 1.0 / 3.0},
+		{5, 3, 7.0 / 9.0},
+	}
+	for _, tt := range tests {
+		if got := radicalInverse(tt.i, tt.base); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("radicalInverse(%d, %d) = %v, want %v", tt.i, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestPermuteIndexIsPermutation(t *testing.T) {
+	for _, n := range []int{1, 2, 7, 16, 100} {
+		seen := make([]bool, n)
+		for i := 0; i < n; i++ {
+			j := permuteIndex(i, n, 0x1234abcd)
+			if j < 0 || j >= n || seen[j] {
+				t.Fatalf("permuteIndex(%d, %d) = %d is out of range or repeated", i, n, j)
+			}
+			seen[j] = true
+		}
+	}
+}
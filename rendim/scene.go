@@ -1,6 +1,50 @@
 package rendim
 
 type Scene struct {
-	camera Camera
-	world  HitableList
+	camera      Camera
+	world       HitableList
+	lights      []Light
+	environment Environment
+}
+
+// NewScene creates a scene viewed through camera. Large worlds should be
+// wrapped in a BVH with NewBVHNode before being passed in. Emitting
+// rectangles and spheres in the world become the scene's lights.
+func NewScene(camera Camera, world HitableList) Scene {
+	return Scene{camera: camera, world: world, lights: collectLights(world, nil)}
+}
+
+// Camera returns the scene camera.
+func (s Scene) Camera() Camera {
+	return s.camera
+}
+
+// World returns the objects in the scene.
+func (s Scene) World() HitableList {
+	return s.world
+}
+
+// Lights returns the lights that are sampled directly by the MIS integrator.
+func (s Scene) Lights() []Light {
+	return s.lights
+}
+
+// WithEnvironment returns a copy of the scene lit by env wherever rays leave
+// it. Without an environment the background is black.
+func (s Scene) WithEnvironment(env Environment) Scene {
+	s.environment = env
+	return s
+}
+
+// Environment returns the light around the scene, or nil if there is none.
+func (s Scene) Environment() Environment {
+	return s.environment
+}
+
+// background returns the light arriving along a ray that hit nothing.
+func (s *Scene) background(direction Vec3d) Color {
+	if s.environment == nil {
+		return Color{}
+	}
+	return s.environment.Radiance(direction)
 }
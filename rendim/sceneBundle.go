@@ -0,0 +1,150 @@
+package rendim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SceneBundle is a scene file together with every file it refers to, so that
+// another machine can build exactly the same scene from it.
+type SceneBundle struct {
+	Scene []byte `json:"scene"`
+	// Files holds the contents of the referenced images, meshes and grids by
+	// the name the scene file gives them. The MTL files and textures that
+	// meshes refer to are named relative to the scene file's directory, as
+	// the mesh loader resolves them.
+	Files map[string][]byte `json:"files"`
+}
+
+// Bundle reads sf together with the files it refers to.
+func (sf *SceneFile) Bundle() (*SceneBundle, error) {
+	builder := &sceneBuilder{dir: filepath.Dir(sf.path), files: sf.files}
+	files, err := bundleFiles(sf.data, func(name string) ([]byte, error) {
+		return os.ReadFile(builder.resolvePath(name))
+	})
+	if err != nil {
+		return nil, sf.wrap(err)
+	}
+	return &SceneBundle{Scene: sf.data, Files: files}, nil
+}
+
+// ID returns a hash of the scene and its files, which is the same for equal
+// bundles.
+func (b *SceneBundle) ID() string {
+	h := sha256.New()
+	write := func(data []byte) {
+		_ = binary.Write(h, binary.LittleEndian, uint64(len(data)))
+		h.Write(data)
+	}
+	write(b.Scene)
+	for _, name := range sortedKeys(b.Files) {
+		write([]byte(name))
+		write(b.Files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LoadSceneBundle writes the files of b to the directory dir, creating it if
+// needed, and parses the scene so that it refers to them there.
+func LoadSceneBundle(b *SceneBundle, dir string) (*SceneFile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "scene.json")
+	if err := os.WriteFile(path, b.Scene, 0o644); err != nil { //nolint:gosec // G306: scene files are not secret
+		return nil, err
+	}
+	sf, err := parseSceneFile(b.Scene, path)
+	if err != nil {
+		return nil, err
+	}
+	files, err := bundleFiles(b.Scene, func(name string) ([]byte, error) {
+		data, ok := b.Files[name]
+		if !ok {
+			return nil, fmt.Errorf("the bundle lacks the referenced file %q", name)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, sf.wrap(err)
+	}
+
+	// Files are stored under numbered names, so that names reaching outside
+	// the scene directory cannot reach outside dir.
+	sf.files = make(map[string]string, len(files))
+	for i, name := range sortedKeys(files) {
+		file := filepath.Join(dir, strconv.Itoa(i)+filepath.Ext(name))
+		if err := os.WriteFile(file, files[name], 0o644); err != nil { //nolint:gosec // G306: scene files are not secret
+			return nil, err
+		}
+		sf.files[name] = file
+	}
+	return sf, nil
+}
+
+// bundleFiles reads the files a scene refers to with read: those named by the
+// scene file, and those that the OBJ and MTL files among them name in turn.
+func bundleFiles(scene []byte, read func(name string) ([]byte, error)) (map[string][]byte, error) {
+	names, err := referencedFiles(scene)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(names))
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		if _, ok := files[name]; ok {
+			continue
+		}
+		data, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+		if ext := strings.ToLower(filepath.Ext(name)); ext == ".obj" || ext == ".mtl" {
+			for _, ref := range objReferences(data) {
+				names = append(names, filepath.Join(filepath.Dir(name), ref))
+			}
+		}
+	}
+	return files, nil
+}
+
+// referencedFiles returns the sorted names of the files a scene refers to,
+// which are the values of its "file" fields.
+func referencedFiles(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if name, ok := value.(string); ok && key == "file" && name != "" {
+					names[name] = true
+				}
+				walk(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(doc)
+	return sortedKeys(names), nil
+}
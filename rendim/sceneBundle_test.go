@@ -0,0 +1,186 @@
+package rendim
+
+import (
+	"context"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeBundleTestScene writes a scene file that refers to an image texture and
+// an environment map outside its directory and returns its path.
+func writeBundleTestScene(t testing.TB) string {
+	t.Helper()
+	root := t.TempDir()
+	f, err := os.Create(filepath.Join(root, "sky.hdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHDR(f, testFramebuffer(8, 4)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f, err = os.Create(filepath.Join(root, "tex.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, testFramebuffer(4, 4).Image(ToneMap{})); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	scene := strings.Replace(minimalScene, "\"objects\"", `"environment": {"type": "map", "file": "../sky.hdr"}, "objects"`, 1)
+	scene = strings.Replace(scene, "%s", `{"type": "sphere", "center": [0, 0, 0], "radius": 1.5,
+     "material": {"type": "lambertian", "albedo": {"type": "image", "file": "../tex.png"}}}`, 1)
+	if err := os.Mkdir(filepath.Join(root, "scenes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "scenes", "test.json")
+	if err := os.WriteFile(path, []byte(scene), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSceneBundle(t *testing.T) {
+	sf, err := LoadSceneFile(writeBundleTestScene(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sf.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := sortedKeys(b.Files); !slices.Equal(names, []string{"../sky.hdr", "../tex.png"}) {
+		t.Fatalf("bundled files = %v, want ../sky.hdr and ../tex.png", names)
+	}
+
+	dir := filepath.Join(t.TempDir(), "unpacked")
+	unpacked, err := LoadSceneBundle(b, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range unpacked.files {
+		if filepath.Dir(path) != dir {
+			t.Errorf("file unpacked to %s, want it in %s", path, dir)
+		}
+	}
+
+	// The unpacked scene renders the same image as the original.
+	settings := RenderSettings{Width: 8, Height: 8, Samples: 2, BucketSize: 4, Workers: 2}
+	render := func(sf *SceneFile) []float32 {
+		scene, err := sf.Build(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fb, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.pix
+	}
+	if !slices.Equal(render(unpacked), render(sf)) {
+		t.Error("the unpacked scene renders differently")
+	}
+
+	// A bundle of the unpacked scene is the same bundle.
+	again, err := unpacked.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID() != b.ID() {
+		t.Errorf("ID of the bundle of the unpacked scene = %s, want %s", again.ID(), b.ID())
+	}
+}
+
+func TestSceneBundleMesh(t *testing.T) {
+	root := writeBundleTestScene(t)
+	root = filepath.Dir(filepath.Dir(root))
+	if err := os.MkdirAll(filepath.Join(root, "meshes", "mat"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "meshes"), "tri.obj", `mtllib mat/tri.mtl
+v -1 -1 0
+v 1 -1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 0.5 1
+usemtl textured
+f 1/1 2/2 3/3
+`)
+	writeTestFile(t, filepath.Join(root, "meshes", "mat"), "tri.mtl", `newmtl textured
+Kd 1 1 1
+map_Kd ../../tex.png
+`)
+	scene := strings.Replace(minimalScene, "%s", `{"type": "mesh", "file": "../meshes/tri.obj"}`, 1)
+	path := writeTestFile(t, filepath.Join(root, "scenes"), "mesh.json", scene)
+
+	sf, err := LoadSceneFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sf.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"../meshes/mat/tri.mtl", "../meshes/tri.obj", "../tex.png"}
+	if names := sortedKeys(b.Files); !slices.Equal(names, want) {
+		t.Fatalf("bundled files = %v, want %v", names, want)
+	}
+
+	unpacked, err := LoadSceneBundle(b, filepath.Join(t.TempDir(), "unpacked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := RenderSettings{Width: 8, Height: 8, Samples: 2, BucketSize: 4, Workers: 2}
+	render := func(sf *SceneFile) []float32 {
+		scene, err := sf.Build(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fb, _, err := RenderScene(context.Background(), scene, settings, RenderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fb.pix
+	}
+	if !slices.Equal(render(unpacked), render(sf)) {
+		t.Error("the unpacked mesh scene renders differently")
+	}
+
+	delete(b.Files, "../meshes/mat/tri.mtl")
+	if _, err := LoadSceneBundle(b, t.TempDir()); err == nil || !strings.Contains(err.Error(), "tri.mtl") {
+		t.Errorf("error = %v, want one naming the missing MTL file", err)
+	}
+}
+
+func TestSceneBundleID(t *testing.T) {
+	b := &SceneBundle{Scene: []byte(`{"objects": []}`), Files: map[string][]byte{"a.png": []byte("a")}}
+	id := b.ID()
+	if len(id) != 64 {
+		t.Errorf("ID() = %q, want 64 hex digits", id)
+	}
+	b.Files["a.png"] = []byte("b")
+	if b.ID() == id {
+		t.Error("changing a file kept the ID")
+	}
+}
+
+func TestLoadSceneBundleMissingFile(t *testing.T) {
+	sf, err := LoadSceneFile(writeBundleTestScene(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sf.Bundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(b.Files, "../tex.png")
+	if _, err := LoadSceneBundle(b, t.TempDir()); err == nil || !strings.Contains(err.Error(), "../tex.png") {
+		t.Errorf("error = %v, want one naming the missing file", err)
+	}
+}
@@ -0,0 +1,1651 @@
+package rendim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SceneError reports an invalid value in a scene file. Path locates the value
+// inside the document, e.g. "objects[3].boundary.radius".
+type SceneError struct {
+	File string
+	Path string
+	Msg  string
+	Err  error // the error reading a referenced file, if any
+}
+
+func (e *SceneError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.File, e.Path, e.Msg)
+}
+
+func (e *SceneError) Unwrap() error {
+	return e.Err
+}
+
+// RenderSettings controls the size and sampling of a render. Zero fields are
+// unset and can be filled in with Merge, unless they are marked with WithSet.
+type RenderSettings struct {
+	Width      int `json:"width"`
+	Height     int `json:"height"`
+	Samples    int `json:"samples"`
+	BucketSize int `json:"bucketSize"`
+	Workers    int `json:"workers"`
+	// Seed selects the random numbers of every sample. Renders with the same
+	// seed and settings are identical however the work is split up.
+	Seed int64 `json:"seed"`
+	// BVH selects how the scene hierarchy is built: BVHMedian or BVHSAH.
+	BVH string `json:"bvh"`
+	// Integrator selects the light transport algorithm: IntegratorMIS or
+	// IntegratorPath.
+	Integrator string `json:"integrator"`
+	// Sampler selects how the random numbers of the samples of a pixel are
+	// spread: SamplerSobol, SamplerHalton, SamplerStratified or
+	// SamplerIndependent.
+	Sampler string `json:"sampler"`
+	// MinSamples is the number of samples every pixel takes before adaptive
+	// sampling may stop it; Samples is the most any pixel takes.
+	MinSamples int `json:"minSamples"`
+	// AdaptiveThreshold stops sampling a pixel once the 95% confidence
+	// interval of its luminance lies within this fraction of the mean. Zero
+	// takes every pixel to Samples.
+	AdaptiveThreshold float64 `json:"adaptiveThreshold"`
+	// Progressive renders the whole image in passes of doubling samples per
+	// pixel instead of one bucket after another.
+	Progressive bool `json:"progressive"`
+	// ToneMap selects the operator that maps radiance to display colours:
+	// ToneMapLinear, ToneMapReinhard, ToneMapReinhardExtended, ToneMapACES or
+	// ToneMapHable.
+	ToneMap string `json:"toneMap"`
+	// Exposure scales the radiance by 2^Exposure before tone mapping.
+	Exposure float64 `json:"exposure"`
+	// WhitePoint is the luminance extended Reinhard maps to white.
+	WhitePoint float64 `json:"whitePoint"`
+
+	// set holds the fields marked with WithSet.
+	set settingSet
+}
+
+// DefaultRenderSettings are used for anything neither the scene file nor the
+// caller specifies.
+var DefaultRenderSettings = RenderSettings{
+	Width:      800,
+	Height:     800,
+	Samples:    10000,
+	MinSamples: 16,
+	BucketSize: 32,
+	Workers:    4,
+	BVH:        BVHMedian,
+	Integrator: IntegratorMIS,
+	Sampler:    SamplerSobol,
+	ToneMap:    ToneMapLinear,
+}
+
+// A Setting is a field of RenderSettings, for WithSet and Only.
+type Setting uint
+
+// The fields of RenderSettings.
+const (
+	SettingWidth Setting = iota
+	SettingHeight
+	SettingSamples
+	SettingMinSamples
+	SettingAdaptiveThreshold
+	SettingProgressive
+	SettingBucketSize
+	SettingWorkers
+	SettingSeed
+	SettingBVH
+	SettingIntegrator
+	SettingSampler
+	SettingToneMap
+	SettingExposure
+	SettingWhitePoint
+	numSettings
+)
+
+// settingNames are the JSON names of the settings.
+var settingNames = [numSettings]string{
+	SettingWidth:             "width",
+	SettingHeight:            "height",
+	SettingSamples:           "samples",
+	SettingMinSamples:        "minSamples",
+	SettingAdaptiveThreshold: "adaptiveThreshold",
+	SettingProgressive:       "progressive",
+	SettingBucketSize:        "bucketSize",
+	SettingWorkers:           "workers",
+	SettingSeed:              "seed",
+	SettingBVH:               "bvh",
+	SettingIntegrator:        "integrator",
+	SettingSampler:           "sampler",
+	SettingToneMap:           "toneMap",
+	SettingExposure:          "exposure",
+	SettingWhitePoint:        "whitePoint",
+}
+
+// settingSet is a set of settings, one bit per Setting.
+type settingSet uint32
+
+func newSettingSet(settings []Setting) settingSet {
+	var set settingSet
+	for _, st := range settings {
+		set |= 1 << st
+	}
+	return set
+}
+
+func (set settingSet) has(st Setting) bool {
+	return set&(1<<st) != 0
+}
+
+// Merge returns s with every field of o applied on top that is non-zero or
+// marked with WithSet. The result keeps the marks of s.
+func (s RenderSettings) Merge(o RenderSettings) RenderSettings {
+	return s.merge(o, ^settingSet(0))
+}
+
+// WithSet returns s with the given fields marked as given explicitly, so that
+// Merge applies them even when they are zero.
+func (s RenderSettings) WithSet(settings ...Setting) RenderSettings {
+	s.set |= newSettingSet(settings)
+	return s
+}
+
+// Only returns the given fields of s, and their marks, with the others unset.
+func (s RenderSettings) Only(settings ...Setting) RenderSettings {
+	only := newSettingSet(settings)
+	o := RenderSettings{}.merge(s, only)
+	o.set = s.set & only
+	return o
+}
+
+// merge is Merge for just the fields of o in fields.
+func (s RenderSettings) merge(o RenderSettings, fields settingSet) RenderSettings {
+	apply := func(st Setting, zero bool) bool {
+		return fields.has(st) && (!zero || o.set.has(st))
+	}
+	if apply(SettingWidth, o.Width == 0) {
+		s.Width = o.Width
+	}
+	if apply(SettingHeight, o.Height == 0) {
+		s.Height = o.Height
+	}
+	if apply(SettingSamples, o.Samples == 0) {
+		s.Samples = o.Samples
+	}
+	if apply(SettingMinSamples, o.MinSamples == 0) {
+		s.MinSamples = o.MinSamples
+	}
+	if apply(SettingAdaptiveThreshold, o.AdaptiveThreshold == 0) {
+		s.AdaptiveThreshold = o.AdaptiveThreshold
+	}
+	if apply(SettingProgressive, !o.Progressive) {
+		s.Progressive = o.Progressive
+	}
+	if apply(SettingBucketSize, o.BucketSize == 0) {
+		s.BucketSize = o.BucketSize
+	}
+	if apply(SettingWorkers, o.Workers == 0) {
+		s.Workers = o.Workers
+	}
+	if apply(SettingSeed, o.Seed == 0) {
+		s.Seed = o.Seed
+	}
+	if apply(SettingBVH, o.BVH == "") {
+		s.BVH = o.BVH
+	}
+	if apply(SettingIntegrator, o.Integrator == "") {
+		s.Integrator = o.Integrator
+	}
+	if apply(SettingSampler, o.Sampler == "") {
+		s.Sampler = o.Sampler
+	}
+	if apply(SettingToneMap, o.ToneMap == "") {
+		s.ToneMap = o.ToneMap
+	}
+	if apply(SettingExposure, o.Exposure == 0) {
+		s.Exposure = o.Exposure
+	}
+	if apply(SettingWhitePoint, o.WhitePoint == 0) {
+		s.WhitePoint = o.WhitePoint
+	}
+	return s
+}
+
+// settingsIn returns the settings given in raw, a JSON object of settings,
+// for WithSet. Keys that name no setting are left out.
+func settingsIn(raw []byte) []Setting {
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(raw, &fields)
+	var settings []Setting
+	for st := range numSettings {
+		if _, ok := fields[settingNames[st]]; ok {
+			settings = append(settings, st)
+		}
+	}
+	return settings
+}
+
+type settingsField struct {
+	name  string
+	value int
+}
+
+func (s RenderSettings) fields() []settingsField {
+	return []settingsField{
+		{"width", s.Width},
+		{"height", s.Height},
+		{"samples", s.Samples},
+		{"minSamples", s.MinSamples},
+		{"bucketSize", s.BucketSize},
+		{"workers", s.Workers},
+	}
+}
+
+type settingsChoice struct {
+	name    string
+	value   string
+	allowed []string
+}
+
+func (s RenderSettings) choices() []settingsChoice {
+	return []settingsChoice{
+		{"bvh", s.BVH, []string{BVHMedian, BVHSAH}},
+		{"integrator", s.Integrator, []string{IntegratorMIS, IntegratorPath}},
+		{"sampler", s.Sampler, []string{SamplerSobol, SamplerHalton, SamplerStratified, SamplerIndependent}},
+		{"toneMap", s.ToneMap, []string{ToneMapLinear, ToneMapReinhard, ToneMapReinhardExtended, ToneMapACES, ToneMapHable}},
+	}
+}
+
+// check reports whether c is unset or one of its allowed values.
+func (c settingsChoice) check() error {
+	if c.value == "" || slices.Contains(c.allowed, c.value) {
+		return nil
+	}
+	return fmt.Errorf("unknown %s %q (want one of %s)", c.name, c.value, strings.Join(c.allowed, ", "))
+}
+
+// checkDisplay reports whether the exposure is finite and the white point is
+// not negative, naming the offending field.
+func (s RenderSettings) checkDisplay() (string, error) {
+	if math.IsNaN(s.Exposure) || math.IsInf(s.Exposure, 0) {
+		return "exposure", fmt.Errorf("exposure must be finite, got %v", s.Exposure)
+	}
+	if !(s.WhitePoint >= 0.0) || math.IsInf(s.WhitePoint, 0) {
+		return "whitePoint", fmt.Errorf("whitePoint must be finite and not negative, got %v", s.WhitePoint)
+	}
+	return "", nil
+}
+
+// checkAdaptive reports whether the adaptive threshold is finite and not
+// negative.
+func (s RenderSettings) checkAdaptive() error {
+	if !(s.AdaptiveThreshold >= 0.0) || math.IsInf(s.AdaptiveThreshold, 0) {
+		return fmt.Errorf("adaptiveThreshold must be finite and not negative, got %v", s.AdaptiveThreshold)
+	}
+	return nil
+}
+
+// Validate checks that every size, sampling and worker field is positive,
+// that every named option is known and that the display settings are usable.
+func (s RenderSettings) Validate() error {
+	for _, f := range s.fields() {
+		if f.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", f.name, f.value)
+		}
+	}
+	for _, c := range s.choices() {
+		if err := c.check(); err != nil {
+			return err
+		}
+	}
+	if err := s.checkAdaptive(); err != nil {
+		return err
+	}
+	_, err := s.checkDisplay()
+	return err
+}
+
+func (s RenderSettings) validate(path string) error {
+	for _, f := range s.fields() {
+		if f.value < 0 {
+			return &SceneError{Path: joinPath(path, f.name), Msg: "must not be negative"}
+		}
+	}
+	for _, c := range s.choices() {
+		if err := c.check(); err != nil {
+			return &SceneError{Path: joinPath(path, c.name), Msg: err.Error()}
+		}
+	}
+	if err := s.checkAdaptive(); err != nil {
+		return &SceneError{Path: joinPath(path, "adaptiveThreshold"), Msg: err.Error()}
+	}
+	if name, err := s.checkDisplay(); err != nil {
+		return &SceneError{Path: joinPath(path, name), Msg: err.Error()}
+	}
+	return nil
+}
+
+// SceneFile is a parsed scene description. Build turns it into a Scene.
+type SceneFile struct {
+	Settings RenderSettings
+
+	path string
+	data []byte
+	doc  sceneDoc
+	// files maps the names of referenced files to where a bundle unpacked
+	// them, for scenes loaded by LoadSceneBundle.
+	files map[string]string
+}
+
+const sceneDir = "scenes"
+
+var sceneNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// BuiltinScenePath returns the path of a scene shipped in the scenes directory.
+func BuiltinScenePath(name string) (string, error) {
+	if !sceneNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid scene name %q", name)
+	}
+	return filepath.Join(sceneDir, name+".json"), nil
+}
+
+// LoadSceneFile reads and parses a JSON scene description. Syntax errors are
+// reported with line and column, invalid values with their document path.
+func LoadSceneFile(path string) (*SceneFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSceneFile(data, path)
+}
+
+func parseSceneFile(data []byte, path string) (*SceneFile, error) {
+	var syntaxErr *json.SyntaxError
+	if err := json.Unmarshal(data, new(json.RawMessage)); errors.As(err, &syntaxErr) {
+		line, col := offsetToLineCol(data, syntaxErr.Offset)
+		return nil, fmt.Errorf("%s:%d:%d: %s", path, line, col, syntaxErr.Error())
+	}
+
+	sf := &SceneFile{path: path, data: data}
+	if err := decodeStrict(data, &sf.doc, ""); err != nil {
+		return nil, sf.wrap(err)
+	}
+	if err := sf.doc.Settings.validate("settings"); err != nil {
+		return nil, sf.wrap(err)
+	}
+	var keys struct {
+		Settings json.RawMessage `json:"settings"`
+	}
+	_ = json.Unmarshal(data, &keys)
+	sf.Settings = sf.doc.Settings.WithSet(settingsIn(keys.Settings)...)
+
+	return sf, nil
+}
+
+// Build constructs the scene for the image size and BVH builder of settings,
+// loading any referenced images and meshes relative to the scene file.
+func (sf *SceneFile) Build(settings RenderSettings) (Scene, error) {
+	return sf.build(settings, true)
+}
+
+// build constructs the scene, with its hierarchies flattened into LinearBVHs
+// if flat is set or left as trees of BVHNodes otherwise.
+func (sf *SceneFile) build(settings RenderSettings, flat bool) (Scene, error) {
+	if settings.Width <= 0 || settings.Height <= 0 {
+		return Scene{}, fmt.Errorf("%s: image size must be positive, got %dx%d", sf.path, settings.Width, settings.Height)
+	}
+	if err := settings.validate(""); err != nil {
+		return Scene{}, sf.wrap(err)
+	}
+
+	b := &sceneBuilder{
+		dir:       filepath.Dir(sf.path),
+		files:     sf.files,
+		doc:       &sf.doc,
+		textures:  map[string]Texture{},
+		materials: map[string]Material{},
+		resolving: map[string]bool{},
+		rng:       NewRNG(1),
+		bvh:       settings.BVH,
+		flat:      flat,
+	}
+
+	cam, err := b.camera(float64(settings.Width) / float64(settings.Height))
+	if err != nil {
+		return Scene{}, sf.wrap(err)
+	}
+
+	// Resolve every named definition so that unused ones are validated too.
+	for _, name := range sortedKeys(sf.doc.Textures) {
+		if _, err := b.texture(json.RawMessage(strconv.Quote(name)), "textures"); err != nil {
+			return Scene{}, sf.wrap(err)
+		}
+	}
+	for _, name := range sortedKeys(sf.doc.Materials) {
+		if _, err := b.material(json.RawMessage(strconv.Quote(name)), "materials"); err != nil {
+			return Scene{}, sf.wrap(err)
+		}
+	}
+
+	world := HitableList{}
+	for i, raw := range sf.doc.Objects {
+		h, err := b.object(raw, fmt.Sprintf("objects[%d]", i))
+		if err != nil {
+			return Scene{}, sf.wrap(err)
+		}
+		world = append(world, h)
+	}
+	if len(world) == 0 {
+		return Scene{}, sf.wrap(&SceneError{Path: "objects", Msg: "scene has no objects"})
+	}
+
+	scene := NewScene(cam, HitableList{b.hierarchy(world)})
+	if sf.doc.Environment != nil {
+		env, err := b.environment(sf.doc.Environment, "environment")
+		if err != nil {
+			return Scene{}, sf.wrap(err)
+		}
+		scene = scene.WithEnvironment(env)
+	}
+	return scene, nil
+}
+
+func (sf *SceneFile) wrap(err error) error {
+	var se *SceneError
+	if errors.As(err, &se) {
+		se.File = sf.path
+		return se
+	}
+	return fmt.Errorf("%s: %w", sf.path, err)
+}
+
+type sceneDoc struct {
+	Settings    RenderSettings             `json:"settings"`
+	Camera      cameraDoc                  `json:"camera"`
+	Environment json.RawMessage            `json:"environment"`
+	Textures    map[string]json.RawMessage `json:"textures"`
+	Materials   map[string]json.RawMessage `json:"materials"`
+	Objects     []json.RawMessage          `json:"objects"`
+}
+
+type cameraDoc struct {
+	LookFrom  vecDoc  `json:"lookFrom"`
+	LookAt    vecDoc  `json:"lookAt"`
+	VUp       vecDoc  `json:"vUp"`
+	VFov      float64 `json:"vFov"`
+	Aperture  float64 `json:"aperture"`
+	FocusDist float64 `json:"focusDist"`
+	Time0     float64 `json:"time0"`
+	Time1     float64 `json:"time1"`
+}
+
+type typeDoc struct {
+	Type string `json:"type"`
+}
+
+type transformDoc struct {
+	Translate vecDoc   `json:"translate"`
+	RotateY   *float64 `json:"rotateY"`
+}
+
+type objectBase struct {
+	Type        string         `json:"type"`
+	Transform   []transformDoc `json:"transform"`
+	FlipNormals bool           `json:"flipNormals"`
+}
+
+type sphereDoc struct {
+	objectBase
+	Center   vecDoc          `json:"center"`
+	Radius   float64         `json:"radius"`
+	Material json.RawMessage `json:"material"`
+}
+
+type movingSphereDoc struct {
+	objectBase
+	Center0  vecDoc          `json:"center0"`
+	Center1  vecDoc          `json:"center1"`
+	Time0    float64         `json:"time0"`
+	Time1    float64         `json:"time1"`
+	Radius   float64         `json:"radius"`
+	Material json.RawMessage `json:"material"`
+}
+
+type rectDoc struct {
+	objectBase
+	X0       *float64        `json:"x0"`
+	X1       *float64        `json:"x1"`
+	Y0       *float64        `json:"y0"`
+	Y1       *float64        `json:"y1"`
+	Z0       *float64        `json:"z0"`
+	Z1       *float64        `json:"z1"`
+	K        float64         `json:"k"`
+	Material json.RawMessage `json:"material"`
+}
+
+type boxDoc struct {
+	objectBase
+	Min      vecDoc          `json:"min"`
+	Max      vecDoc          `json:"max"`
+	Material json.RawMessage `json:"material"`
+}
+
+type triangleDoc struct {
+	objectBase
+	Vertices []vecDoc        `json:"vertices"`
+	Normals  []vecDoc        `json:"normals"`
+	UVs      [][]float64     `json:"uvs"`
+	Material json.RawMessage `json:"material"`
+}
+
+type meshDoc struct {
+	objectBase
+	File string `json:"file"`
+}
+
+type mediumDoc struct {
+	objectBase
+	Boundary json.RawMessage `json:"boundary"`
+	Density  float64         `json:"density"`
+	Albedo   json.RawMessage `json:"albedo"`
+}
+
+type heterogeneousMediumDoc struct {
+	objectBase
+	Boundary json.RawMessage `json:"boundary"`
+	Density  json.RawMessage `json:"density"`
+	SigmaA   vecDoc          `json:"sigmaA"`
+	SigmaS   vecDoc          `json:"sigmaS"`
+	G        float64         `json:"g"`
+}
+
+type uniformDensityDoc struct {
+	Type    string  `json:"type"`
+	Density float64 `json:"density"`
+}
+
+type gridDensityDoc struct {
+	Type   string    `json:"type"`
+	Size   []int     `json:"size"`
+	Min    vecDoc    `json:"min"`
+	Max    vecDoc    `json:"max"`
+	Values []float64 `json:"values"`
+	File   string    `json:"file"`
+}
+
+type noiseDensityDoc struct {
+	Type    string  `json:"type"`
+	Scale   float64 `json:"scale"`
+	Density float64 `json:"density"`
+}
+
+type heightFogDoc struct {
+	Type    string  `json:"type"`
+	Density float64 `json:"density"`
+	Height  float64 `json:"height"`
+	Falloff float64 `json:"falloff"`
+}
+
+type groupDoc struct {
+	objectBase
+	Objects []json.RawMessage `json:"objects"`
+}
+
+type lambertianDoc struct {
+	Type   string          `json:"type"`
+	Albedo json.RawMessage `json:"albedo"`
+}
+
+type metalDoc struct {
+	Type   string          `json:"type"`
+	Albedo json.RawMessage `json:"albedo"`
+	Fuzz   float64         `json:"fuzz"`
+}
+
+type dielectricDoc struct {
+	Type   string  `json:"type"`
+	RefIdx float64 `json:"refIdx"`
+}
+
+type microfacetDoc struct {
+	Type      string          `json:"type"`
+	BaseColor json.RawMessage `json:"baseColor"`
+	Roughness json.RawMessage `json:"roughness"`
+	Metallic  json.RawMessage `json:"metallic"`
+}
+
+type roughDielectricDoc struct {
+	Type      string          `json:"type"`
+	RefIdx    float64         `json:"refIdx"`
+	Roughness json.RawMessage `json:"roughness"`
+}
+
+type principledDoc struct {
+	Type           string          `json:"type"`
+	BaseColor      json.RawMessage `json:"baseColor"`
+	Metallic       json.RawMessage `json:"metallic"`
+	Roughness      json.RawMessage `json:"roughness"`
+	Specular       json.RawMessage `json:"specular"`
+	SpecularTint   json.RawMessage `json:"specularTint"`
+	Sheen          json.RawMessage `json:"sheen"`
+	SheenTint      json.RawMessage `json:"sheenTint"`
+	Clearcoat      json.RawMessage `json:"clearcoat"`
+	ClearcoatGloss json.RawMessage `json:"clearcoatGloss"`
+	Transmission   json.RawMessage `json:"transmission"`
+	IOR            float64         `json:"ior"`
+}
+
+type diffuseLightDoc struct {
+	Type string          `json:"type"`
+	Emit json.RawMessage `json:"emit"`
+}
+
+type constantTextureDoc struct {
+	Type  string `json:"type"`
+	Color vecDoc `json:"color"`
+}
+
+type checkerTextureDoc struct {
+	Type string          `json:"type"`
+	Even json.RawMessage `json:"even"`
+	Odd  json.RawMessage `json:"odd"`
+}
+
+type noiseTextureDoc struct {
+	Type  string  `json:"type"`
+	Scale float64 `json:"scale"`
+}
+
+type imageTextureDoc struct {
+	Type string `json:"type"`
+	File string `json:"file"`
+}
+
+type constantSkyDoc struct {
+	Type  string `json:"type"`
+	Color vecDoc `json:"color"`
+}
+
+type gradientSkyDoc struct {
+	Type   string `json:"type"`
+	Bottom vecDoc `json:"bottom"`
+	Top    vecDoc `json:"top"`
+}
+
+type environmentMapDoc struct {
+	Type      string   `json:"type"`
+	File      string   `json:"file"`
+	Rotation  float64  `json:"rotation"`
+	Intensity *float64 `json:"intensity"`
+}
+
+// vecDoc is a three component vector or colour written as a JSON array.
+type vecDoc []float64
+
+func (v vecDoc) vec3(path string) (Vec3d, error) {
+	if v == nil {
+		return Vec3d{}, &SceneError{Path: path, Msg: "is required"}
+	}
+	if len(v) != 3 {
+		return Vec3d{}, &SceneError{Path: path, Msg: fmt.Sprintf("expected 3 numbers, got %d", len(v))}
+	}
+	return NewVec3d(v[0], v[1], v[2]), nil
+}
+
+func (v vecDoc) color(path string) (Color, error) {
+	p, err := v.vec3(path)
+	if err != nil {
+		return Color{}, err
+	}
+	return Color{R: p.X(), G: p.Y(), B: p.Z()}, nil
+}
+
+type sceneBuilder struct {
+	dir          string
+	files        map[string]string
+	doc          *sceneDoc
+	textures     map[string]Texture
+	materials    map[string]Material
+	resolving    map[string]bool
+	rng          *RNG
+	bvh          string
+	flat         bool
+	time0, time1 float64
+}
+
+// hierarchy wraps list in a BVH made by the selected builder.
+func (b *sceneBuilder) hierarchy(list HitableList) Hitable {
+	var tree Hitable
+	if b.bvh == BVHSAH {
+		tree = NewSAHBVH(list, b.time0, b.time1)
+	} else {
+		tree = NewBVHNode(list, b.time0, b.time1, b.rng)
+	}
+	if b.flat {
+		return NewLinearBVH(tree, b.time0, b.time1)
+	}
+	return tree
+}
+
+func (b *sceneBuilder) camera(aspect float64) (Camera, error) {
+	c := b.doc.Camera
+	lookFrom, err := c.LookFrom.vec3("camera.lookFrom")
+	if err != nil {
+		return Camera{}, err
+	}
+	lookAt, err := c.LookAt.vec3("camera.lookAt")
+	if err != nil {
+		return Camera{}, err
+	}
+	vUp := NewVec3d(0.0, 1.0, 0.0)
+	if c.VUp != nil {
+		if vUp, err = c.VUp.vec3("camera.vUp"); err != nil {
+			return Camera{}, err
+		}
+	}
+	if c.VFov <= 0.0 || c.VFov >= 180.0 {
+		return Camera{}, &SceneError{Path: "camera.vFov", Msg: "must be between 0 and 180 degrees"}
+	}
+	if c.Aperture < 0.0 {
+		return Camera{}, &SceneError{Path: "camera.aperture", Msg: "must not be negative"}
+	}
+	if c.Time1 < c.Time0 {
+		return Camera{}, &SceneError{Path: "camera.time1", Msg: "must not be before time0"}
+	}
+	focusDist := c.FocusDist
+	if focusDist == 0.0 {
+		focusDist = lookFrom.Subtract(lookAt).Length()
+	}
+
+	b.time0, b.time1 = c.Time0, c.Time1
+	return NewCamera(lookFrom, lookAt, vUp, c.VFov, aspect, c.Aperture, focusDist, c.Time0, c.Time1), nil
+}
+
+func (b *sceneBuilder) object(raw json.RawMessage, path string) (Hitable, error) {
+	var t typeDoc
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, &SceneError{Path: path, Msg: "expected an object"}
+	}
+
+	var (
+		h    Hitable
+		base objectBase
+		err  error
+	)
+	switch t.Type {
+	case "sphere":
+		var d sphereDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.sphere(d, path)
+	case "movingSphere":
+		var d movingSphereDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.movingSphere(d, path)
+	case "xyRect", "xzRect", "yzRect":
+		var d rectDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.rect(d, path)
+	case "box":
+		var d boxDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.box(d, path)
+	case "triangle":
+		var d triangleDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.triangle(d, path)
+	case "mesh":
+		var d meshDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.mesh(d, path)
+	case "constantMedium":
+		var d mediumDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.medium(d, path)
+	case "medium":
+		var d heterogeneousMediumDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.heterogeneousMedium(d, path)
+	case "group":
+		var d groupDoc
+		if err = decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		base = d.objectBase
+		h, err = b.group(d, path)
+	case "":
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: "is required"}
+	default:
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: fmt.Sprintf("unknown object type %q", t.Type)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if base.FlipNormals {
+		h = FlipNormals{hitable: h}
+	}
+	return b.transform(h, base.Transform, joinPath(path, "transform"))
+}
+
+func (b *sceneBuilder) transform(h Hitable, transforms []transformDoc, path string) (Hitable, error) {
+	for i, t := range transforms {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case t.Translate != nil && t.RotateY != nil:
+			return nil, &SceneError{Path: p, Msg: "must contain exactly one of translate or rotateY"}
+		case t.Translate != nil:
+			offset, err := t.Translate.vec3(joinPath(p, "translate"))
+			if err != nil {
+				return nil, err
+			}
+			h = Translate{hitable: h, offset: offset}
+		case t.RotateY != nil:
+			var box AABB
+			if !h.BoundingBox(b.time0, b.time1, &box) {
+				return nil, &SceneError{Path: p, Msg: "cannot rotate an object without a bounding box"}
+			}
+			h = NewRotateY(h, *t.RotateY)
+		default:
+			return nil, &SceneError{Path: p, Msg: "must contain exactly one of translate or rotateY"}
+		}
+	}
+	return h, nil
+}
+
+func (b *sceneBuilder) sphere(d sphereDoc, path string) (Hitable, error) {
+	center, err := d.Center.vec3(joinPath(path, "center"))
+	if err != nil {
+		return nil, err
+	}
+	if d.Radius <= 0.0 {
+		return nil, &SceneError{Path: joinPath(path, "radius"), Msg: "must be positive"}
+	}
+	mat, err := b.material(d.Material, joinPath(path, "material"))
+	if err != nil {
+		return nil, err
+	}
+	return NewSphere(center, d.Radius, mat), nil
+}
+
+func (b *sceneBuilder) movingSphere(d movingSphereDoc, path string) (Hitable, error) {
+	center0, err := d.Center0.vec3(joinPath(path, "center0"))
+	if err != nil {
+		return nil, err
+	}
+	center1, err := d.Center1.vec3(joinPath(path, "center1"))
+	if err != nil {
+		return nil, err
+	}
+	if d.Time1 <= d.Time0 {
+		return nil, &SceneError{Path: joinPath(path, "time1"), Msg: "must be after time0"}
+	}
+	if d.Radius <= 0.0 {
+		return nil, &SceneError{Path: joinPath(path, "radius"), Msg: "must be positive"}
+	}
+	mat, err := b.material(d.Material, joinPath(path, "material"))
+	if err != nil {
+		return nil, err
+	}
+	return NewMovingSphere(center0, center1, d.Time0, d.Time1, d.Radius, mat), nil
+}
+
+func (b *sceneBuilder) rect(d rectDoc, path string) (Hitable, error) {
+	// Each rectangle type uses two of the three axis ranges.
+	axes := map[string][2]string{"xyRect": {"x", "y"}, "xzRect": {"x", "z"}, "yzRect": {"y", "z"}}[d.Type]
+	ranges := [3][2]*float64{{d.X0, d.X1}, {d.Y0, d.Y1}, {d.Z0, d.Z1}}
+
+	var bounds [2][2]float64
+	for i, axis := range []string{"x", "y", "z"} {
+		r := ranges[i]
+		used := axis == axes[0] || axis == axes[1]
+		for j, v := range r {
+			name := fmt.Sprintf("%s%d", axis, j)
+			if used && v == nil {
+				return nil, &SceneError{Path: joinPath(path, name), Msg: "is required"}
+			}
+			if !used && v != nil {
+				return nil, &SceneError{Path: joinPath(path, name), Msg: fmt.Sprintf("not allowed for %s", d.Type)}
+			}
+		}
+		if !used {
+			continue
+		}
+		if *r[1] <= *r[0] {
+			return nil, &SceneError{Path: joinPath(path, axis+"1"), Msg: fmt.Sprintf("must be greater than %s0", axis)}
+		}
+		if axis == axes[0] {
+			bounds[0] = [2]float64{*r[0], *r[1]}
+		} else {
+			bounds[1] = [2]float64{*r[0], *r[1]}
+		}
+	}
+
+	mat, err := b.material(d.Material, joinPath(path, "material"))
+	if err != nil {
+		return nil, err
+	}
+
+	a, c := bounds[0], bounds[1]
+	switch d.Type {
+	case "xyRect":
+		return XYRect{x0: a[0], x1: a[1], y0: c[0], y1: c[1], k: d.K, material: mat}, nil
+	case "xzRect":
+		return XZRect{x0: a[0], x1: a[1], z0: c[0], z1: c[1], k: d.K, material: mat}, nil
+	default:
+		return YZRect{y0: a[0], y1: a[1], z0: c[0], z1: c[1], k: d.K, material: mat}, nil
+	}
+}
+
+func (b *sceneBuilder) box(d boxDoc, path string) (Hitable, error) {
+	pMin, err := d.Min.vec3(joinPath(path, "min"))
+	if err != nil {
+		return nil, err
+	}
+	pMax, err := d.Max.vec3(joinPath(path, "max"))
+	if err != nil {
+		return nil, err
+	}
+	for a := 0; a < 3; a++ {
+		if pMax.e[a] <= pMin.e[a] {
+			return nil, &SceneError{Path: joinPath(path, "max"), Msg: "must be greater than min on every axis"}
+		}
+	}
+	mat, err := b.material(d.Material, joinPath(path, "material"))
+	if err != nil {
+		return nil, err
+	}
+	return NewBox(pMin, pMax, mat), nil
+}
+
+func (b *sceneBuilder) triangle(d triangleDoc, path string) (Hitable, error) {
+	if len(d.Vertices) != 3 {
+		return nil, &SceneError{Path: joinPath(path, "vertices"), Msg: fmt.Sprintf("expected 3 vertices, got %d", len(d.Vertices))}
+	}
+	var v [3]Vec3d
+	for i := range v {
+		var err error
+		if v[i], err = d.Vertices[i].vec3(fmt.Sprintf("%s.vertices[%d]", path, i)); err != nil {
+			return nil, err
+		}
+	}
+	mat, err := b.material(d.Material, joinPath(path, "material"))
+	if err != nil {
+		return nil, err
+	}
+	if d.Normals == nil && d.UVs == nil {
+		return NewTriangle(v[0], v[1], v[2], mat), nil
+	}
+
+	if len(d.Normals) != 3 {
+		return nil, &SceneError{Path: joinPath(path, "normals"), Msg: fmt.Sprintf("expected 3 normals, got %d", len(d.Normals))}
+	}
+	var n [3]Vec3d
+	for i := range n {
+		if n[i], err = d.Normals[i].vec3(fmt.Sprintf("%s.normals[%d]", path, i)); err != nil {
+			return nil, err
+		}
+	}
+	var uv [3]TexCoord
+	if d.UVs != nil {
+		if len(d.UVs) != 3 {
+			return nil, &SceneError{Path: joinPath(path, "uvs"), Msg: fmt.Sprintf("expected 3 texture coordinates, got %d", len(d.UVs))}
+		}
+		for i := range uv {
+			if len(d.UVs[i]) != 2 {
+				return nil, &SceneError{Path: fmt.Sprintf("%s.uvs[%d]", path, i), Msg: fmt.Sprintf("expected 2 numbers, got %d", len(d.UVs[i]))}
+			}
+			uv[i] = TexCoord{U: d.UVs[i][0], V: d.UVs[i][1]}
+		}
+	} else {
+		uv = [3]TexCoord{{U: 0, V: 0}, {U: 1, V: 0}, {U: 0, V: 1}}
+	}
+	return NewSmoothTriangle(v[0], v[1], v[2], n[0], n[1], n[2], uv[0], uv[1], uv[2], mat), nil
+}
+
+func (b *sceneBuilder) mesh(d meshDoc, path string) (Hitable, error) {
+	if d.File == "" {
+		return nil, &SceneError{Path: joinPath(path, "file"), Msg: "is required"}
+	}
+	meshes, err := loadOBJ(d.File, b.resolvePath)
+	if err != nil {
+		return nil, &SceneError{Path: joinPath(path, "file"), Msg: err.Error(), Err: err}
+	}
+	if len(meshes) == 1 {
+		return meshes[0], nil
+	}
+	return b.hierarchy(meshes), nil
+}
+
+func (b *sceneBuilder) medium(d mediumDoc, path string) (Hitable, error) {
+	if d.Boundary == nil {
+		return nil, &SceneError{Path: joinPath(path, "boundary"), Msg: "is required"}
+	}
+	boundary, err := b.object(d.Boundary, joinPath(path, "boundary"))
+	if err != nil {
+		return nil, err
+	}
+	if d.Density <= 0.0 {
+		return nil, &SceneError{Path: joinPath(path, "density"), Msg: "must be positive"}
+	}
+	albedo, err := b.texture(d.Albedo, joinPath(path, "albedo"))
+	if err != nil {
+		return nil, err
+	}
+	return ConstantMedium{boundary: boundary, density: d.Density, phaseFunction: Isotropic{albedo: albedo}}, nil
+}
+
+func (b *sceneBuilder) heterogeneousMedium(d heterogeneousMediumDoc, path string) (Hitable, error) {
+	if d.Boundary == nil {
+		return nil, &SceneError{Path: joinPath(path, "boundary"), Msg: "is required"}
+	}
+	boundary, err := b.object(d.Boundary, joinPath(path, "boundary"))
+	if err != nil {
+		return nil, err
+	}
+	var box AABB
+	if !boundary.BoundingBox(b.time0, b.time1, &box) {
+		return nil, &SceneError{Path: joinPath(path, "boundary"), Msg: "must have a bounding box"}
+	}
+	if d.Density == nil {
+		return nil, &SceneError{Path: joinPath(path, "density"), Msg: "is required"}
+	}
+	density, err := b.density(d.Density, joinPath(path, "density"))
+	if err != nil {
+		return nil, err
+	}
+
+	coefficient := func(v vecDoc, name string, def float64) (Color, error) {
+		if v == nil {
+			return Color{R: def, G: def, B: def}, nil
+		}
+		c, err := v.color(joinPath(path, name))
+		if err != nil {
+			return Color{}, err
+		}
+		if c.R < 0.0 || c.G < 0.0 || c.B < 0.0 {
+			return Color{}, &SceneError{Path: joinPath(path, name), Msg: "must not be negative"}
+		}
+		return c, nil
+	}
+	sigmaA, err := coefficient(d.SigmaA, "sigmaA", 0.0)
+	if err != nil {
+		return nil, err
+	}
+	sigmaS, err := coefficient(d.SigmaS, "sigmaS", 1.0)
+	if err != nil {
+		return nil, err
+	}
+	if d.G <= -1.0 || d.G >= 1.0 {
+		return nil, &SceneError{Path: joinPath(path, "g"), Msg: "must be between -1 and 1"}
+	}
+	return NewHeterogeneousMedium(boundary, density, sigmaA, sigmaS, d.G), nil
+}
+
+// density resolves the density field of a medium.
+func (b *sceneBuilder) density(raw json.RawMessage, path string) (DensityField, error) {
+	var t typeDoc
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, &SceneError{Path: path, Msg: "expected an object"}
+	}
+	nonNegative := func(v float64, name string) error {
+		if v < 0.0 {
+			return &SceneError{Path: joinPath(path, name), Msg: "must not be negative"}
+		}
+		return nil
+	}
+
+	switch t.Type {
+	case "uniform":
+		var d uniformDensityDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if err := nonNegative(d.Density, "density"); err != nil {
+			return nil, err
+		}
+		return NewUniformDensity(d.Density), nil
+	case "grid":
+		var d gridDensityDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		return b.gridDensity(d, path)
+	case "noise":
+		var d noiseDensityDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.Scale <= 0.0 {
+			return nil, &SceneError{Path: joinPath(path, "scale"), Msg: "must be positive"}
+		}
+		if err := nonNegative(d.Density, "density"); err != nil {
+			return nil, err
+		}
+		return NewNoiseDensity(d.Scale, d.Density), nil
+	case "heightFog":
+		var d heightFogDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if err := nonNegative(d.Density, "density"); err != nil {
+			return nil, err
+		}
+		if err := nonNegative(d.Falloff, "falloff"); err != nil {
+			return nil, err
+		}
+		return NewHeightFog(d.Density, d.Height, d.Falloff), nil
+	case "":
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: "is required"}
+	default:
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: fmt.Sprintf("unknown density type %q", t.Type)}
+	}
+}
+
+func (b *sceneBuilder) gridDensity(d gridDensityDoc, path string) (DensityField, error) {
+	if len(d.Size) != 3 {
+		return nil, &SceneError{Path: joinPath(path, "size"), Msg: fmt.Sprintf("expected 3 numbers, got %d", len(d.Size))}
+	}
+	nx, ny, nz := d.Size[0], d.Size[1], d.Size[2]
+	if nx < 1 || ny < 1 || nz < 1 {
+		return nil, &SceneError{Path: joinPath(path, "size"), Msg: "must be positive"}
+	}
+	lo, err := d.Min.vec3(joinPath(path, "min"))
+	if err != nil {
+		return nil, err
+	}
+	hi, err := d.Max.vec3(joinPath(path, "max"))
+	if err != nil {
+		return nil, err
+	}
+	if hi.X() < lo.X() || hi.Y() < lo.Y() || hi.Z() < lo.Z() {
+		return nil, &SceneError{Path: joinPath(path, "max"), Msg: "must not be below min"}
+	}
+
+	switch {
+	case d.File != "" && d.Values != nil:
+		return nil, &SceneError{Path: path, Msg: "must contain exactly one of values or file"}
+	case d.File != "":
+		grid, err := LoadGridDensity(b.resolvePath(d.File), nx, ny, nz, lo, hi)
+		if err != nil {
+			return nil, &SceneError{Path: joinPath(path, "file"), Msg: err.Error(), Err: err}
+		}
+		return grid, nil
+	case d.Values != nil:
+		if len(d.Values) != nx*ny*nz {
+			return nil, &SceneError{Path: joinPath(path, "values"), Msg: fmt.Sprintf("expected %d numbers, got %d", nx*ny*nz, len(d.Values))}
+		}
+		for i, v := range d.Values {
+			if v < 0.0 {
+				return nil, &SceneError{Path: fmt.Sprintf("%s.values[%d]", path, i), Msg: "must not be negative"}
+			}
+		}
+		return NewGridDensity(nx, ny, nz, d.Values, lo, hi), nil
+	default:
+		return nil, &SceneError{Path: path, Msg: "must contain exactly one of values or file"}
+	}
+}
+
+func (b *sceneBuilder) group(d groupDoc, path string) (Hitable, error) {
+	if len(d.Objects) == 0 {
+		return nil, &SceneError{Path: joinPath(path, "objects"), Msg: "group has no objects"}
+	}
+	list := HitableList{}
+	for i, raw := range d.Objects {
+		h, err := b.object(raw, fmt.Sprintf("%s.objects[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return b.hierarchy(list), nil
+}
+
+// material resolves a material given either by name or inline.
+func (b *sceneBuilder) material(raw json.RawMessage, path string) (Material, error) {
+	if raw == nil {
+		return nil, &SceneError{Path: path, Msg: "is required"}
+	}
+
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		if m, ok := b.materials[name]; ok {
+			return m, nil
+		}
+		def, ok := b.doc.Materials[name]
+		if !ok {
+			return nil, &SceneError{Path: path, Msg: fmt.Sprintf("unknown material %q", name)}
+		}
+		m, err := b.materialDef(def, joinPath("materials", name))
+		if err != nil {
+			return nil, err
+		}
+		b.materials[name] = m
+		return m, nil
+	}
+
+	return b.materialDef(raw, path)
+}
+
+func (b *sceneBuilder) materialDef(raw json.RawMessage, path string) (Material, error) {
+	var t typeDoc
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, &SceneError{Path: path, Msg: "expected a material name or object"}
+	}
+
+	switch t.Type {
+	case "lambertian":
+		var d lambertianDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		albedo, err := b.texture(d.Albedo, joinPath(path, "albedo"))
+		if err != nil {
+			return nil, err
+		}
+		return Lambertian{albedo: albedo}, nil
+	case "metal":
+		var d metalDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		albedo, err := b.texture(d.Albedo, joinPath(path, "albedo"))
+		if err != nil {
+			return nil, err
+		}
+		if d.Fuzz < 0.0 || d.Fuzz > 1.0 {
+			return nil, &SceneError{Path: joinPath(path, "fuzz"), Msg: "must be between 0 and 1"}
+		}
+		return Metal{albedo: albedo, fuzz: d.Fuzz}, nil
+	case "dielectric":
+		var d dielectricDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.RefIdx <= 0.0 {
+			return nil, &SceneError{Path: joinPath(path, "refIdx"), Msg: "must be positive"}
+		}
+		return Dielectric{refIdx: d.RefIdx}, nil
+	case "microfacet":
+		var d microfacetDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		baseColor, err := b.texture(d.BaseColor, joinPath(path, "baseColor"))
+		if err != nil {
+			return nil, err
+		}
+		roughness, err := b.scalarTexture(d.Roughness, joinPath(path, "roughness"), 0.5)
+		if err != nil {
+			return nil, err
+		}
+		metallic, err := b.scalarTexture(d.Metallic, joinPath(path, "metallic"), 0.0)
+		if err != nil {
+			return nil, err
+		}
+		return NewMicrofacet(baseColor, roughness, metallic), nil
+	case "roughDielectric":
+		var d roughDielectricDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.RefIdx <= 0.0 {
+			return nil, &SceneError{Path: joinPath(path, "refIdx"), Msg: "must be positive"}
+		}
+		roughness, err := b.scalarTexture(d.Roughness, joinPath(path, "roughness"), 0.5)
+		if err != nil {
+			return nil, err
+		}
+		return NewRoughDielectric(d.RefIdx, roughness), nil
+	case "principled":
+		var d principledDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		return b.principled(d, path)
+	case "diffuseLight":
+		var d diffuseLightDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		emit, err := b.texture(d.Emit, joinPath(path, "emit"))
+		if err != nil {
+			return nil, err
+		}
+		return DiffuseLight{emit: emit}, nil
+	case "isotropic":
+		var d lambertianDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		albedo, err := b.texture(d.Albedo, joinPath(path, "albedo"))
+		if err != nil {
+			return nil, err
+		}
+		return Isotropic{albedo: albedo}, nil
+	case "":
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: "is required"}
+	default:
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: fmt.Sprintf("unknown material type %q", t.Type)}
+	}
+}
+
+func (b *sceneBuilder) principled(d principledDoc, path string) (Material, error) {
+	p := PrincipledParams{IOR: d.IOR}
+	if d.BaseColor != nil {
+		baseColor, err := b.texture(d.BaseColor, joinPath(path, "baseColor"))
+		if err != nil {
+			return nil, err
+		}
+		p.BaseColor = baseColor
+	}
+	if d.IOR < 0.0 {
+		return nil, &SceneError{Path: joinPath(path, "ior"), Msg: "must be positive"}
+	}
+
+	scalars := []struct {
+		name string
+		raw  json.RawMessage
+		def  float64
+		dst  *Texture
+	}{
+		{"metallic", d.Metallic, 0.0, &p.Metallic},
+		{"roughness", d.Roughness, 0.5, &p.Roughness},
+		{"specular", d.Specular, 0.5, &p.Specular},
+		{"specularTint", d.SpecularTint, 0.0, &p.SpecularTint},
+		{"sheen", d.Sheen, 0.0, &p.Sheen},
+		{"sheenTint", d.SheenTint, 0.5, &p.SheenTint},
+		{"clearcoat", d.Clearcoat, 0.0, &p.Clearcoat},
+		{"clearcoatGloss", d.ClearcoatGloss, 1.0, &p.ClearcoatGloss},
+		{"transmission", d.Transmission, 0.0, &p.Transmission},
+	}
+	for _, sc := range scalars {
+		t, err := b.scalarTexture(sc.raw, joinPath(path, sc.name), sc.def)
+		if err != nil {
+			return nil, err
+		}
+		*sc.dst = t
+	}
+	return NewPrincipled(p), nil
+}
+
+// texture resolves a texture given as an [r, g, b] colour, by name or inline.
+func (b *sceneBuilder) texture(raw json.RawMessage, path string) (Texture, error) {
+	if raw == nil {
+		return nil, &SceneError{Path: path, Msg: "is required"}
+	}
+
+	var clr vecDoc
+	if json.Unmarshal(raw, &clr) == nil {
+		c, err := clr.color(path)
+		if err != nil {
+			return nil, err
+		}
+		return ConstantTexture{color: c}, nil
+	}
+
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		if t, ok := b.textures[name]; ok {
+			return t, nil
+		}
+		def, ok := b.doc.Textures[name]
+		if !ok {
+			return nil, &SceneError{Path: path, Msg: fmt.Sprintf("unknown texture %q", name)}
+		}
+		if b.resolving[name] {
+			return nil, &SceneError{Path: path, Msg: fmt.Sprintf("texture %q refers to itself", name)}
+		}
+		b.resolving[name] = true
+		t, err := b.textureDef(def, joinPath("textures", name))
+		delete(b.resolving, name)
+		if err != nil {
+			return nil, err
+		}
+		b.textures[name] = t
+		return t, nil
+	}
+
+	return b.textureDef(raw, path)
+}
+
+// scalarTexture resolves a parameter given as a number in [0, 1] or as any
+// texture, whose red channel is used. A missing value becomes def.
+func (b *sceneBuilder) scalarTexture(raw json.RawMessage, path string, def float64) (Texture, error) {
+	if raw == nil {
+		return ConstantTexture{color: Color{R: def, G: def, B: def}}, nil
+	}
+	var v float64
+	if json.Unmarshal(raw, &v) == nil {
+		if v < 0.0 || v > 1.0 {
+			return nil, &SceneError{Path: path, Msg: "must be between 0 and 1"}
+		}
+		return ConstantTexture{color: Color{R: v, G: v, B: v}}, nil
+	}
+	return b.texture(raw, path)
+}
+
+func (b *sceneBuilder) textureDef(raw json.RawMessage, path string) (Texture, error) {
+	var t typeDoc
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, &SceneError{Path: path, Msg: "expected a colour, texture name or texture object"}
+	}
+
+	switch t.Type {
+	case "constant":
+		var d constantTextureDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		c, err := d.Color.color(joinPath(path, "color"))
+		if err != nil {
+			return nil, err
+		}
+		return ConstantTexture{color: c}, nil
+	case "checker":
+		var d checkerTextureDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		even, err := b.texture(d.Even, joinPath(path, "even"))
+		if err != nil {
+			return nil, err
+		}
+		odd, err := b.texture(d.Odd, joinPath(path, "odd"))
+		if err != nil {
+			return nil, err
+		}
+		return CheckerTexture{even: even, odd: odd}, nil
+	case "noise":
+		var d noiseTextureDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.Scale <= 0.0 {
+			return nil, &SceneError{Path: joinPath(path, "scale"), Msg: "must be positive"}
+		}
+		return NoiseTexture{scale: d.Scale}, nil
+	case "image":
+		var d imageTextureDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.File == "" {
+			return nil, &SceneError{Path: joinPath(path, "file"), Msg: "is required"}
+		}
+		tex, err := LoadImageTexture(b.resolvePath(d.File))
+		if err != nil {
+			return nil, &SceneError{Path: joinPath(path, "file"), Msg: err.Error(), Err: err}
+		}
+		return tex, nil
+	case "":
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: "is required"}
+	default:
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: fmt.Sprintf("unknown texture type %q", t.Type)}
+	}
+}
+
+func (b *sceneBuilder) environment(raw json.RawMessage, path string) (Environment, error) {
+	var t typeDoc
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, &SceneError{Path: path, Msg: "expected an environment object"}
+	}
+
+	switch t.Type {
+	case "constant":
+		var d constantSkyDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		c, err := d.Color.color(joinPath(path, "color"))
+		if err != nil {
+			return nil, err
+		}
+		return NewConstantSky(c), nil
+	case "gradient":
+		var d gradientSkyDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		bottom, err := d.Bottom.color(joinPath(path, "bottom"))
+		if err != nil {
+			return nil, err
+		}
+		top, err := d.Top.color(joinPath(path, "top"))
+		if err != nil {
+			return nil, err
+		}
+		return NewGradientSky(bottom, top), nil
+	case "map":
+		var d environmentMapDoc
+		if err := decodeStrict(raw, &d, path); err != nil {
+			return nil, err
+		}
+		if d.File == "" {
+			return nil, &SceneError{Path: joinPath(path, "file"), Msg: "is required"}
+		}
+		intensity := 1.0
+		if d.Intensity != nil {
+			intensity = *d.Intensity
+		}
+		if intensity < 0.0 {
+			return nil, &SceneError{Path: joinPath(path, "intensity"), Msg: "must not be negative"}
+		}
+		env, err := LoadEnvironmentMap(b.resolvePath(d.File), d.Rotation, intensity)
+		if err != nil {
+			return nil, &SceneError{Path: joinPath(path, "file"), Msg: err.Error(), Err: err}
+		}
+		return env, nil
+	case "":
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: "is required"}
+	default:
+		return nil, &SceneError{Path: joinPath(path, "type"), Msg: fmt.Sprintf("unknown environment type %q", t.Type)}
+	}
+}
+
+func (b *sceneBuilder) resolvePath(file string) string {
+	if path, ok := b.files[file]; ok {
+		return path
+	}
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(b.dir, file)
+}
+
+// decodeStrict decodes raw into v, rejecting unknown fields, and reports
+// problems relative to path.
+func decodeStrict(raw []byte, v interface{}, path string) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &SceneError{Path: joinPath(path, typeErr.Field), Msg: fmt.Sprintf("expected %s, got %s", jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value)}
+		}
+		return &SceneError{Path: path, Msg: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	return nil
+}
+
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "float64", "int":
+		return "number"
+	case "slice", "array":
+		return "array"
+	case "struct", "map":
+		return "object"
+	default:
+		return kind
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, field string) string {
+	switch {
+	case field == "":
+		return path
+	case path == "":
+		return field
+	default:
+		return path + "." + field
+	}
+}
+
+func offsetToLineCol(data []byte, offset int64) (line, col int) {
+	line, col = 1, 1
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -0,0 +1,318 @@
+package rendim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadBuiltinScenes(t *testing.T) {
+	for _, name := range []string{"cornell", "simpleLight", "final", "sky", "smoke"} {
+		t.Run(name, func(t *testing.T) {
+			path, err := BuiltinScenePath(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sf, err := LoadSceneFile(filepath.Join("..", path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sf.Settings.Samples != 10000 || sf.Settings.Width != 800 {
+				t.Errorf("Settings = %+v, want 800px wide with 10000 samples", sf.Settings)
+			}
+
+			scene, err := sf.Build(RenderSettings{Width: 40, Height: 40})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var box AABB
+			if !scene.world[0].BoundingBox(0.0, 1.0, &box) {
+				t.Error("Scene world should have a bounding box")
+			}
+		})
+	}
+}
+
+func TestBuiltinScenePathRejectsPaths(t *testing.T) {
+	for _, name := range []string{"", "../secret", "a/b", "final.json"} {
+		if _, err := BuiltinScenePath(name); err == nil {
+			t.Errorf("BuiltinScenePath(%q) should fail", name)
+		}
+	}
+}
+
+func TestRenderSettingsMerge(t *testing.T) {
+	base := RenderSettings{Width: 800, Height: 600, Samples: 100, BucketSize: 32, Workers: 4}
+	merged := base.Merge(RenderSettings{Samples: 10, Workers: 2})
+
+	want := RenderSettings{Width: 800, Height: 600, Samples: 10, BucketSize: 32, Workers: 2}
+	if merged != want {
+		t.Errorf("Merge() = %+v, want %+v", merged, want)
+	}
+}
+
+func TestRenderSettingsMergeZero(t *testing.T) {
+	data := `{"settings": {"seed": 5, "progressive": true, "adaptiveThreshold": 0.1, "exposure": 1},
+  "camera": {"lookFrom": [0, 0, -5], "lookAt": [0, 0, 0], "vFov": 40}, "objects": []}`
+	sf, err := parseSceneFile([]byte(data), "test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := DefaultRenderSettings.Merge(sf.Settings)
+	if base.Seed != 5 || !base.Progressive || base.AdaptiveThreshold != 0.1 || base.Exposure != 1 {
+		t.Fatalf("scene file settings were not applied: %+v", base)
+	}
+
+	// Zero fields override only when they are marked.
+	if merged := base.Merge(RenderSettings{Samples: 8}); merged.Seed != 5 || !merged.Progressive {
+		t.Errorf("unmarked zero fields overrode the scene file: %+v", merged)
+	}
+	merged := base.Merge(RenderSettings{}.WithSet(SettingSeed, SettingProgressive, SettingAdaptiveThreshold, SettingExposure))
+	if merged.Seed != 0 || merged.Progressive || merged.AdaptiveThreshold != 0 || merged.Exposure != 0 || merged.Samples != 10000 {
+		t.Errorf("marked zero fields did not override the scene file: %+v", merged)
+	}
+
+	// Only keeps the marks of the fields it keeps.
+	only := RenderSettings{Workers: 2}.WithSet(SettingSeed, SettingExposure).Only(SettingWorkers, SettingExposure)
+	if merged := base.Merge(only); merged.Workers != 2 || merged.Exposure != 0 || merged.Seed != 5 {
+		t.Errorf("Merge(Only()) = %+v, want 2 workers, exposure 0 and seed 5", merged)
+	}
+}
+
+func TestSettingNamesMatchJSON(t *testing.T) {
+	data, err := json.Marshal(DefaultRenderSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sortedKeys(fields), slices.Sorted(slices.Values(settingNames[:])); !slices.Equal(got, want) {
+		t.Errorf("settingNames = %v, want the JSON fields %v", want, got)
+	}
+	if got := settingsIn(data); len(got) != int(numSettings) {
+		t.Errorf("settingsIn found %d of the %d settings", len(got), numSettings)
+	}
+}
+
+const minimalScene = `{
+  "camera": {"lookFrom": [0, 0, -5], "lookAt": [0, 0, 0], "vFov": 40},
+  "materials": {"white": {"type": "lambertian", "albedo": [0.7, 0.7, 0.7]}},
+  "objects": [%s]
+}`
+
+func TestSceneFileBuild(t *testing.T) {
+	objects := `
+    {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"},
+    {"type": "box", "min": [2, 0, 0], "max": [3, 1, 1], "material": {"type": "metal", "albedo": [1, 1, 1], "fuzz": 0.2},
+     "transform": [{"rotateY": 45}, {"translate": [1, 0, 0]}]},
+    {"type": "triangle", "vertices": [[0, 0, 3], [1, 0, 3], [0, 1, 3]], "material": "white"},
+    {"type": "sphere", "center": [-3, 0, 0], "radius": 1,
+     "material": {"type": "microfacet", "baseColor": [0.9, 0.6, 0.2], "roughness": {"type": "noise", "scale": 2}, "metallic": 1}},
+    {"type": "sphere", "center": [-3, 3, 0], "radius": 1, "material": {"type": "roughDielectric", "refIdx": 1.5}},
+    {"type": "sphere", "center": [3, 3, 0], "radius": 1,
+     "material": {"type": "principled", "baseColor": [0.2, 0.3, 0.8], "roughness": 0.3, "clearcoat": 1, "sheen": {"type": "noise", "scale": 1}}},
+    {"type": "constantMedium", "density": 0.1, "albedo": {"type": "checker", "even": [1, 1, 1], "odd": [0, 0, 0]},
+     "boundary": {"type": "sphere", "center": [10, 10, 10], "radius": 2, "material": "white"}},
+    {"type": "medium", "sigmaA": [0.1, 0.1, 0.2], "sigmaS": [0.5, 0.6, 0.9], "g": 0.4,
+     "density": {"type": "grid", "size": [2, 1, 2], "min": [-12, 8, -12], "max": [-8, 12, -8], "values": [0, 1, 2, 3]},
+     "boundary": {"type": "box", "min": [-12, 8, -12], "max": [-8, 12, -8], "material": "white"}, "transform": [{"rotateY": 30}]},
+    {"type": "medium", "density": {"type": "heightFog", "density": 0.5, "height": -10, "falloff": 1},
+     "boundary": {"type": "box", "min": [-20, -12, -20], "max": [20, -10, 20], "material": "white"}},
+    {"type": "group", "objects": [{"type": "xyRect", "x0": 0, "x1": 1, "y0": 0, "y1": 1, "k": 5, "material": "white", "flipNormals": true}]}`
+
+	sf, err := parseSceneFile([]byte(strings.Replace(minimalScene, "%s", objects, 1)), "test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scene, err := sf.Build(RenderSettings{Width: 10, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ray := NewRay(NewVec3d(0.0, 0.0, -5.0), NewVec3d(0.0, 0.0, 1.0), 0.0)
+	hit, rec := scene.world.Hit(ray, 0.001, 100.0, nil)
+	if !hit {
+		t.Fatal("Ray should hit the sphere")
+	}
+	if math.Abs(rec.t-4.0) > 1e-9 {
+		t.Errorf("t = %f, want 4.0", rec.t)
+	}
+	if _, ok := rec.material.(Lambertian); !ok {
+		t.Errorf("Hit material = %T, want Lambertian", rec.material)
+	}
+}
+
+func TestSceneFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects string
+		path    string
+	}{
+		{"Missing type", `{"center": [0, 0, 0]}`, "objects[0].type"},
+		{"Unknown type", `{"type": "cone"}`, "objects[0].type"},
+		{"Bad radius", `{"type": "sphere", "center": [0, 0, 0], "radius": -1, "material": "white"}`, "objects[0].radius"},
+		{"Short vector", `{"type": "sphere", "center": [0, 0], "radius": 1, "material": "white"}`, "objects[0].center"},
+		{"Wrong field type", `{"type": "sphere", "center": [0, 0, 0], "radius": "big", "material": "white"}`, "objects[0].radius"},
+		{"Unknown field", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white", "colour": 1}`, "objects[0]"},
+		{"Unknown material", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "gold"}`, "objects[0].material"},
+		{"Nested boundary", `{"type": "constantMedium", "density": 1, "albedo": [1, 1, 1], "boundary": {"type": "sphere", "center": [0, 0, 0], "material": "white"}}`, "objects[0].boundary.radius"},
+		{"Rect missing range", `{"type": "xzRect", "x0": 0, "x1": 1, "z0": 0, "k": 0, "material": "white"}`, "objects[0].z1"},
+		{"Rect extra range", `{"type": "xzRect", "x0": 0, "x1": 1, "y0": 0, "z0": 0, "z1": 1, "k": 0, "material": "white"}`, "objects[0].y0"},
+		{"Bad transform", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white", "transform": [{}]}`, "objects[0].transform[0]"},
+		{"Bad roughness", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "microfacet", "baseColor": [1, 1, 1], "roughness": 2}}`, "objects[0].material.roughness"},
+		{"Bad metallic", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "microfacet", "baseColor": [1, 1, 1], "metallic": "rust"}}`, "objects[0].material.metallic"},
+		{"Rough dielectric refIdx", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "roughDielectric", "roughness": 0.2}}`, "objects[0].material.refIdx"},
+		{"Bad sheen", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "principled", "sheen": -0.5}}`, "objects[0].material.sheen"},
+		{"Bad ior", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "principled", "transmission": 1, "ior": -1}}`, "objects[0].material.ior"},
+		{"Medium density", `{"type": "medium", "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].density"},
+		{"Medium g", `{"type": "medium", "g": 1, "density": {"type": "uniform", "density": 1}, "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].g"},
+		{"Medium sigmaS", `{"type": "medium", "sigmaS": [1, -1, 1], "density": {"type": "uniform", "density": 1}, "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].sigmaS"},
+		{"Grid values", `{"type": "medium", "density": {"type": "grid", "size": [2, 2, 2], "min": [0, 0, 0], "max": [1, 1, 1], "values": [1, 2]}, "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].density.values"},
+		{"Noise scale", `{"type": "medium", "density": {"type": "noise", "density": 1}, "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].density.scale"},
+		{"Fog falloff", `{"type": "medium", "density": {"type": "heightFog", "density": 1, "falloff": -1}, "boundary": {"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}}`, "objects[0].density.falloff"},
+		{"Bad texture", `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "lambertian", "albedo": {"type": "noise"}}}`, "objects[0].material.albedo.scale"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := strings.Replace(minimalScene, "%s", tt.objects, 1)
+			sf, err := parseSceneFile([]byte(data), "test.json")
+			if err == nil {
+				_, err = sf.Build(RenderSettings{Width: 10, Height: 10})
+			}
+
+			var se *SceneError
+			if !errors.As(err, &se) {
+				t.Fatalf("error = %v, want *SceneError", err)
+			}
+			if se.Path != tt.path {
+				t.Errorf("Path = %q, want %q (%v)", se.Path, tt.path, se)
+			}
+			if se.File != "test.json" {
+				t.Errorf("File = %q, want test.json", se.File)
+			}
+		})
+	}
+}
+
+func TestSceneFileSyntaxError(t *testing.T) {
+	_, err := parseSceneFile([]byte("{\n  \"camera\": {,\n}"), "broken.json")
+	if err == nil {
+		t.Fatal("parseSceneFile() should fail")
+	}
+	if !strings.HasPrefix(err.Error(), "broken.json:2:") {
+		t.Errorf("error = %q, should point at line 2", err.Error())
+	}
+}
+
+func TestSceneFileUnusedMaterialValidated(t *testing.T) {
+	data := `{
+  "camera": {"lookFrom": [0, 0, -5], "lookAt": [0, 0, 0], "vFov": 40},
+  "materials": {"bad": {"type": "dielectric"}},
+  "objects": [{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": {"type": "dielectric", "refIdx": 1.5}}]
+}`
+	sf, err := parseSceneFile([]byte(data), "test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sf.Build(RenderSettings{Width: 10, Height: 10})
+
+	var se *SceneError
+	if !errors.As(err, &se) || se.Path != "materials.bad.refIdx" {
+		t.Errorf("error = %v, want materials.bad.refIdx", err)
+	}
+}
+
+func TestSceneFileEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	fb := testFramebuffer(8, 4)
+	f, err := os.Create(filepath.Join(dir, "sky.hdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHDR(f, fb); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	scene := func(env string) string {
+		return strings.Replace(minimalScene, "\"objects\"", `"environment": `+env+`, "objects"`, 1)
+	}
+	sphere := `{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "white"}`
+
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{`{"type": "constant", "color": [1, 1, 1]}`, "ConstantSky"},
+		{`{"type": "gradient", "bottom": [1, 1, 1], "top": [0.5, 0.7, 1]}`, "GradientSky"},
+		{`{"type": "map", "file": "sky.hdr", "rotation": 90, "intensity": 2}`, "EnvironmentMap"},
+	}
+	for _, tt := range tests {
+		data := strings.Replace(scene(tt.env), "%s", sphere, 1)
+		sf, err := parseSceneFile([]byte(data), filepath.Join(dir, "test.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		built, err := sf.Build(RenderSettings{Width: 10, Height: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.want, err)
+		}
+		if got := fmt.Sprintf("%T", built.Environment()); got != "rendim."+tt.want {
+			t.Errorf("Environment() = %s, want %s", got, tt.want)
+		}
+	}
+
+	errs := []struct {
+		env  string
+		path string
+	}{
+		{`{"type": "hdri"}`, "environment.type"},
+		{`{"type": "gradient", "bottom": [1, 1, 1]}`, "environment.top"},
+		{`{"type": "map"}`, "environment.file"},
+		{`{"type": "map", "file": "missing.hdr"}`, "environment.file"},
+		{`{"type": "map", "file": "sky.hdr", "intensity": -1}`, "environment.intensity"},
+	}
+	for _, tt := range errs {
+		data := strings.Replace(scene(tt.env), "%s", sphere, 1)
+		sf, err := parseSceneFile([]byte(data), filepath.Join(dir, "test.json"))
+		if err == nil {
+			_, err = sf.Build(RenderSettings{Width: 10, Height: 10})
+		}
+		var se *SceneError
+		if !errors.As(err, &se) || se.Path != tt.path {
+			t.Errorf("%s: error = %v, want *SceneError at %s", tt.env, err, tt.path)
+		}
+	}
+}
+
+func TestRenderSettingsUnknownBVH(t *testing.T) {
+	settings := DefaultRenderSettings.Merge(RenderSettings{BVH: "octree"})
+	if err := settings.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown BVH builder")
+	}
+
+	_, err := parseSceneFile([]byte(`{"settings": {"bvh": "octree"}}`), "test.json")
+	var se *SceneError
+	if !errors.As(err, &se) || se.Path != "settings.bvh" {
+		t.Errorf("error = %v, want *SceneError at settings.bvh", err)
+	}
+}
+
+func TestRenderSettingsUnknownSampler(t *testing.T) {
+	settings := DefaultRenderSettings.Merge(RenderSettings{Sampler: "random"})
+	if err := settings.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown sampler")
+	}
+	if settings := DefaultRenderSettings.Merge(RenderSettings{Sampler: SamplerHalton}); settings.Sampler != SamplerHalton {
+		t.Errorf("Merge() sampler = %q, want %q", settings.Sampler, SamplerHalton)
+	}
+}
@@ -0,0 +1,70 @@
+package rendim_test
+
+import (
+	"RendIm/rendim"
+	"context"
+	"testing"
+)
+
+// TestSceneFromPublicAPI builds a small Cornell box using only exported
+// constructors, as code outside the package would.
+func TestSceneFromPublicAPI(t *testing.T) {
+	red := rendim.NewLambertian(rendim.NewConstantTexture(rendim.Color{R: 0.65, G: 0.05, B: 0.05}))
+	white := rendim.NewLambertian(rendim.NewConstantTexture(rendim.Color{R: 0.73, G: 0.73, B: 0.73}))
+	green := rendim.NewLambertian(rendim.NewConstantTexture(rendim.Color{R: 0.12, G: 0.45, B: 0.15}))
+	light := rendim.NewDiffuseLight(rendim.NewConstantTexture(rendim.Color{R: 15, G: 15, B: 15}))
+	checker := rendim.NewCheckerTexture(
+		rendim.NewConstantTexture(rendim.Color{R: 1, G: 1, B: 1}),
+		rendim.NewNoiseTexture(0.5))
+
+	world := rendim.HitableList{
+		rendim.NewFlipNormals(rendim.NewYZRect(0, 555, 0, 555, 555, green)),
+		rendim.NewYZRect(0, 555, 0, 555, 0, red),
+		rendim.NewXZRect(213, 343, 227, 332, 554, light),
+		rendim.NewFlipNormals(rendim.NewXZRect(0, 555, 0, 555, 555, white)),
+		rendim.NewXZRect(0, 555, 0, 555, 0, rendim.NewLambertian(checker)),
+		rendim.NewFlipNormals(rendim.NewXYRect(0, 555, 0, 555, 555, white)),
+		rendim.NewTranslate(
+			rendim.NewRotateY(rendim.NewBox(rendim.NewVec3d(0, 0, 0), rendim.NewVec3d(165, 165, 165), white), -18),
+			rendim.NewVec3d(130, 0, 65)),
+		rendim.NewConstantMedium(
+			rendim.NewSphere(rendim.NewVec3d(370, 100, 350), 100, rendim.NewDielectric(1.5)),
+			0.01,
+			rendim.NewConstantTexture(rendim.Color{R: 0.2, G: 0.4, B: 0.9})),
+		rendim.NewSphere(rendim.NewVec3d(190, 250, 190), 50, rendim.NewMetal(rendim.NewConstantTexture(rendim.Color{R: 0.8, G: 0.8, B: 0.8}), 0.1)),
+		rendim.NewSphere(rendim.NewVec3d(400, 400, 200), 40, rendim.NewDielectric(1.5)),
+	}
+
+	cam := rendim.NewCamera(
+		rendim.NewVec3d(278, 278, -800), rendim.NewVec3d(278, 278, 0), rendim.NewVec3d(0, 1, 0),
+		40.0, 1.0, 0.0, 10.0, 0.0, 1.0)
+	scene := rendim.NewScene(cam, rendim.HitableList{rendim.NewBVHNode(world, 0.0, 1.0, rendim.NewRNG(0))})
+
+	if len(scene.World()) != 1 {
+		t.Errorf("World() has %d entries, want 1", len(scene.World()))
+	}
+
+	settings := rendim.RenderSettings{Width: 16, Height: 16, Samples: 4, BucketSize: 8, Workers: 2}
+	pixels := make(chan rendim.Pixel, settings.Width*settings.Height)
+	fb, _, err := rendim.RenderScene(context.Background(), scene, settings, rendim.RenderOptions{Pixels: pixels})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := fb.Image(settings.ToneMapping())
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 16 {
+		t.Errorf("Image size = %v, want 16x16", img.Bounds())
+	}
+
+	lit := 0
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if r, g, b, _ := img.At(x, y).RGBA(); r+g+b > 0 {
+				lit++
+			}
+		}
+	}
+	if lit == 0 {
+		t.Error("Rendered image should not be black")
+	}
+}
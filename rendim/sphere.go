@@ -10,11 +10,13 @@ type Sphere struct {
 	material Material
 }
 
+// NewSphere creates a sphere with the given center and radius.
 func NewSphere(center Vec3d, radius float64, material Material) Sphere {
 	return Sphere{Center: center, Radius: radius, material: material}
 }
 
-func (s Sphere) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
+func (s Sphere) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
 	oc := r.Origin().Subtract(s.Center)
 	a := r.Direction().Dot(r.Direction())
 	b := oc.Dot(r.Direction())
@@ -55,3 +57,34 @@ func (s Sphere) BoundingBox(t0, t1 float64, box *AABB) bool {
 	*box = AABB{Min: boxMin, Max: boxMax}
 	return true
 }
+
+// SampleDirection picks a direction uniformly from the cone that the sphere
+// subtends at p.
+func (s Sphere) SampleDirection(p Vec3d, rng *RNG) (Vec3d, float64) {
+	toCenter := s.Center.Subtract(p)
+	distanceSquared := toCenter.Dot(toCenter)
+	if distanceSquared <= s.Radius*s.Radius {
+		return toCenter, 0.0
+	}
+	cosThetaMax := math.Sqrt(1.0 - s.Radius*s.Radius/distanceSquared)
+
+	u1, u2 := rng.Float64Pair()
+	z := 1.0 + u1*(cosThetaMax-1.0)
+	phi := 2.0 * math.Pi * u2
+	r := math.Sqrt(math.Max(0.0, 1.0-z*z))
+	direction := newONB(toCenter).local(r*math.Cos(phi), r*math.Sin(phi), z)
+	return direction, 1.0 / (2.0 * math.Pi * (1.0 - cosThetaMax))
+}
+
+func (s Sphere) PDFValue(p, direction Vec3d) float64 {
+	if isHit, _ := s.Hit(NewRay(p, direction, 0.0), 0.001, math.MaxFloat64, nil); !isHit {
+		return 0.0
+	}
+	toCenter := s.Center.Subtract(p)
+	distanceSquared := toCenter.Dot(toCenter)
+	if distanceSquared <= s.Radius*s.Radius {
+		return 0.0
+	}
+	cosThetaMax := math.Sqrt(1.0 - s.Radius*s.Radius/distanceSquared)
+	return 1.0 / (2.0 * math.Pi * (1.0 - cosThetaMax))
+}
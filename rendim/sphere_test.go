@@ -75,7 +75,7 @@ func TestSphereHit(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hit, rec := sphere.Hit(tt.ray, tt.tMin, tt.tMax)
+			hit, rec := sphere.Hit(tt.ray, tt.tMin, tt.tMax, nil)
 			if hit != tt.shouldHit {
 				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
 			}
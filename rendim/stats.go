@@ -0,0 +1,155 @@
+package rendim
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes the work done by one render.
+type Stats struct {
+	Pixels         int    // finished pixels
+	TotalPixels    int    // pixels in the image
+	Samples        uint64 // camera samples taken
+	Rays           uint64 // rays traced, including scattered rays
+	NodeVisits     uint64 // BVH nodes visited
+	PrimitiveTests uint64 // ray/primitive intersection tests
+	Elapsed        time.Duration
+	Buckets        []BucketStats // finished buckets in completion order
+	Passes         int           // finished passes of a progressive render
+	TotalPasses    int           // passes of a progressive render, zero otherwise
+}
+
+// BucketStats records how long a worker took to render one bucket.
+type BucketStats struct {
+	Bounds   image.Rectangle
+	Worker   int
+	Duration time.Duration
+}
+
+// Progress returns the finished fraction of the image, or of the passes of a
+// progressive render.
+func (s Stats) Progress() float64 {
+	if s.TotalPasses > 0 {
+		return float64(s.Passes) / float64(s.TotalPasses)
+	}
+	if s.TotalPixels == 0 {
+		return 0
+	}
+	return float64(s.Pixels) / float64(s.TotalPixels)
+}
+
+// TraceContext carries per-worker state down the Hit call chain. A nil
+// *TraceContext is valid and records nothing.
+type TraceContext struct {
+	// rng is the worker's random number generator, from which stochastic
+	// primitives such as ConstantMedium draw. Without one they let every ray
+	// through.
+	rng *RNG
+
+	rays           uint64
+	nodeVisits     uint64
+	primitiveTests uint64
+
+	// media collects the stretches of the last traced ray that lie in
+	// participating media.
+	media []mediumSegment
+	// collectMedia is set while Scene.hit searches the world. ConstantMedium
+	// then records the stretch of the ray inside it in constantMedia instead
+	// of sampling a scattering distance right away.
+	collectMedia  bool
+	constantMedia []constantSegment
+}
+
+func (tc *TraceContext) countRays(n int) {
+	if tc != nil {
+		tc.rays += uint64(n) //nolint:gosec // G115: n is never negative
+	}
+}
+
+func (tc *TraceContext) countNodeVisits(n int) {
+	if tc != nil {
+		tc.nodeVisits += uint64(n) //nolint:gosec // G115: n is never negative
+	}
+}
+
+func (tc *TraceContext) countPrimitiveTests(n int) {
+	if tc != nil {
+		tc.primitiveTests += uint64(n) //nolint:gosec // G115: n is never negative
+	}
+}
+
+// renderStats gathers the counters of every worker of one render. Workers
+// count into their own TraceContext and flush it once per pixel, so the hot
+// path never touches shared memory.
+type renderStats struct {
+	start       time.Time
+	totalPixels int
+	totalPasses int
+
+	pixels         atomic.Uint64
+	samples        atomic.Uint64
+	rays           atomic.Uint64
+	nodeVisits     atomic.Uint64
+	primitiveTests atomic.Uint64
+	passes         atomic.Int64
+
+	mu      sync.Mutex
+	buckets []BucketStats
+}
+
+func newRenderStats(totalPixels int) *renderStats {
+	return &renderStats{start: time.Now(), totalPixels: totalPixels}
+}
+
+// addSamples records samples taken in a pixel and moves the counters of tc
+// into the render totals.
+func (rs *renderStats) addSamples(samples int, tc *TraceContext) {
+	rs.samples.Add(uint64(samples)) //nolint:gosec // G115: samples is never negative
+	rs.flush(tc)
+}
+
+// addPixel records a finished pixel.
+func (rs *renderStats) addPixel() {
+	rs.pixels.Add(1)
+}
+
+// addPass records a finished pass of a progressive render.
+func (rs *renderStats) addPass() {
+	rs.passes.Add(1)
+}
+
+func (rs *renderStats) flush(tc *TraceContext) {
+	rs.rays.Add(tc.rays)
+	rs.nodeVisits.Add(tc.nodeVisits)
+	rs.primitiveTests.Add(tc.primitiveTests)
+	tc.rays, tc.nodeVisits, tc.primitiveTests = 0, 0, 0
+}
+
+func (rs *renderStats) addBucket(b BucketStats) {
+	rs.mu.Lock()
+	rs.buckets = append(rs.buckets, b)
+	rs.mu.Unlock()
+}
+
+// snapshot copies the totals so far. It is safe to call while workers run.
+func (rs *renderStats) snapshot() Stats {
+	rs.mu.Lock()
+	buckets := make([]BucketStats, len(rs.buckets))
+	copy(buckets, rs.buckets)
+	rs.mu.Unlock()
+
+	return Stats{
+		Pixels:         int(rs.pixels.Load()), //nolint:gosec // G115: bounded by the image size
+		TotalPixels:    rs.totalPixels,
+		Samples:        rs.samples.Load(),
+		Rays:           rs.rays.Load(),
+		NodeVisits:     rs.nodeVisits.Load(),
+		PrimitiveTests: rs.primitiveTests.Load(),
+		Elapsed:        time.Since(rs.start),
+		Buckets:        buckets,
+		Passes:         int(rs.passes.Load()),
+		TotalPasses:    rs.totalPasses,
+	}
+}
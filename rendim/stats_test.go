@@ -0,0 +1,78 @@
+package rendim
+
+import (
+	"context"
+	"image"
+	"sync"
+	"testing"
+)
+
+func TestRenderSceneStats(t *testing.T) {
+	scene := loadTestScene(t, "cornell", 16, 16)
+	settings := RenderSettings{Width: 16, Height: 16, Samples: 3, BucketSize: 8, Workers: 2}
+
+	var calls []Stats
+	opts := RenderOptions{Progress: func(s Stats) { calls = append(calls, s) }}
+	_, stats, err := RenderScene(context.Background(), scene, settings, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Pixels != 256 || stats.TotalPixels != 256 {
+		t.Errorf("Pixels = %d/%d, want 256/256", stats.Pixels, stats.TotalPixels)
+	}
+	if stats.Progress() != 1.0 {
+		t.Errorf("Progress() = %v, want 1", stats.Progress())
+	}
+	if stats.Samples != 256*3 {
+		t.Errorf("Samples = %d, want %d", stats.Samples, 256*3)
+	}
+	if stats.Rays < stats.Samples {
+		t.Errorf("Rays = %d, want at least one per sample (%d)", stats.Rays, stats.Samples)
+	}
+	if stats.NodeVisits == 0 || stats.PrimitiveTests == 0 {
+		t.Errorf("NodeVisits = %d, PrimitiveTests = %d, want both non-zero", stats.NodeVisits, stats.PrimitiveTests)
+	}
+	if stats.Elapsed <= 0 {
+		t.Errorf("Elapsed = %v, want positive", stats.Elapsed)
+	}
+	if want := len(getBuckets(image.Rect(0, 0, 16, 16), 8)); len(stats.Buckets) != want {
+		t.Errorf("len(Buckets) = %d, want %d", len(stats.Buckets), want)
+	}
+
+	if len(calls) == 0 {
+		t.Fatal("Progress was never called")
+	}
+	if last := calls[len(calls)-1]; last.Pixels != stats.Pixels || last.Rays != stats.Rays {
+		t.Errorf("last Progress call = %+v, want the final stats", last)
+	}
+}
+
+func TestRenderSceneStatsArePerRender(t *testing.T) {
+	scene := loadTestScene(t, "simpleLight", 8, 8)
+	settings := RenderSettings{Width: 8, Height: 8, Samples: 2, BucketSize: 4, Workers: 2}
+
+	var wg sync.WaitGroup
+	results := make([]Stats, 3)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, results[i], _ = RenderScene(context.Background(), scene, settings, RenderOptions{})
+		}()
+	}
+	wg.Wait()
+
+	for i, s := range results {
+		if s.Pixels != 64 || s.Samples != 128 {
+			t.Errorf("render %d: Pixels = %d, Samples = %d, want 64 and 128", i, s.Pixels, s.Samples)
+		}
+	}
+}
+
+func TestNilTraceContext(t *testing.T) {
+	var tc *TraceContext
+	tc.countRays(1)
+	tc.countNodeVisits(1)
+	tc.countPrimitiveTests(1)
+}
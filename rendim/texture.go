@@ -1,9 +1,12 @@
 package rendim
 
 import (
+	"fmt"
 	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"math"
-	"math/rand"
+	"os"
 )
 
 type Texture interface {
@@ -14,6 +17,11 @@ type ConstantTexture struct {
 	color Color
 }
 
+// NewConstantTexture creates a texture with the same colour everywhere.
+func NewConstantTexture(c Color) ConstantTexture {
+	return ConstantTexture{color: c}
+}
+
 func (t ConstantTexture) Value(u, v float64, p Vec3d) Color {
 	return t.color
 }
@@ -22,6 +30,12 @@ type CheckerTexture struct {
 	even, odd Texture
 }
 
+// NewCheckerTexture creates a 3D checker pattern alternating between even
+// and odd.
+func NewCheckerTexture(even, odd Texture) CheckerTexture {
+	return CheckerTexture{even: even, odd: odd}
+}
+
 func (t CheckerTexture) Value(u, v float64, p Vec3d) Color {
 	sines := math.Sin(10*p.X()) * math.Sin(10*p.Y()) * math.Sin(10*p.Z())
 	if sines < 0.0 {
@@ -36,6 +50,12 @@ type NoiseTexture struct {
 	scale float64
 }
 
+// NewNoiseTexture creates a marble-like Perlin turbulence texture. Larger
+// scales give finer detail.
+func NewNoiseTexture(scale float64) NoiseTexture {
+	return NoiseTexture{scale: scale}
+}
+
 func (t NoiseTexture) Value(u, v float64, p Vec3d) Color {
 	clr := Color{R: 1.0, G: 1.0, B: 1.0}
 	t.noise = perlinNoise
@@ -100,40 +120,44 @@ func perlinInterp(c [2][2][2]Vec3d, u, v, w float64) float64 {
 	return accum
 }
 
-var perlinNoise = perlin{
-	ranVec: perlinGenerate(),
-	permX:  perlinGeneratePerm(),
-	permY:  perlinGeneratePerm(),
-	permZ:  perlinGeneratePerm(),
+// perlinSeed fixes the gradients and permutations of perlinNoise, so that
+// noise looks the same in every run and on every machine.
+const perlinSeed = 1
+
+var perlinNoise = newPerlin(NewRNG(perlinSeed))
+
+func newPerlin(rng *RNG) perlin {
+	return perlin{
+		ranVec: perlinGenerate(rng),
+		permX:  perlinGeneratePerm(rng),
+		permY:  perlinGeneratePerm(rng),
+		permZ:  perlinGeneratePerm(rng),
+	}
 }
 
-func perlinGenerate() []Vec3d {
+func perlinGenerate(rng *RNG) []Vec3d {
 	p := make([]Vec3d, 256)
 	for i := range p {
-		p[i] = NewVec3d(
-			-1.0+2.0*rand.Float64(), //nolint:gosec // G404: math/rand for Perlin noise generation
-			-1.0+2.0*rand.Float64(), //nolint:gosec // G404: math/rand for Perlin noise generation
-			-1.0+2.0*rand.Float64(), //nolint:gosec // G404: math/rand for Perlin noise generation
-		)
+		p[i] = NewVec3d(-1.0+2.0*rng.Float64(), -1.0+2.0*rng.Float64(), -1.0+2.0*rng.Float64())
 	}
 	return p
 }
 
-func permute(p []int) {
+func permute(p []int, rng *RNG) {
 	n := len(p)
 	for i := n - 1; i > 0; i-- {
-		target := int(rand.Float64() * float64(i+1)) //nolint:gosec // G404: math/rand for permutation
+		target := int(rng.Float64() * float64(i+1))
 		p[i], p[target] = p[target], p[i]
 	}
 }
 
-func perlinGeneratePerm() []int {
+func perlinGeneratePerm(rng *RNG) []int {
 	p := make([]int, 256)
 	for i := range p {
 		p[i] = i
 	}
 
-	permute(p)
+	permute(p, rng)
 	return p
 }
 
@@ -141,6 +165,27 @@ type ImageTexture struct {
 	image image.Image
 }
 
+// NewImageTexture creates a texture that maps img over the (u, v) unit square.
+func NewImageTexture(img image.Image) ImageTexture {
+	return ImageTexture{image: img}
+}
+
+// LoadImageTexture decodes a JPEG or PNG file into an ImageTexture.
+func LoadImageTexture(path string) (ImageTexture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageTexture{}, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return ImageTexture{}, fmt.Errorf("cannot decode %s: %w", path, err)
+	}
+
+	return ImageTexture{image: img}, nil
+}
+
 func (t ImageTexture) Value(u, v float64, p Vec3d) Color {
 	nx, ny := t.image.Bounds().Dx(), t.image.Bounds().Dy()
 	i := int(u * float64(nx))
@@ -80,7 +80,7 @@ func TestPerlinTurbulence(t *testing.T) {
 }
 
 func TestPerlinGenerate(t *testing.T) {
-	vectors := perlinGenerate()
+	vectors := perlinGenerate(NewRNG(1))
 	
 	if len(vectors) != 256 {
 		t.Errorf("perlinGenerate() returned %d vectors, want 256", len(vectors))
@@ -100,7 +100,7 @@ func TestPerlinGenerate(t *testing.T) {
 }
 
 func TestPerlinGeneratePerm(t *testing.T) {
-	perm := perlinGeneratePerm()
+	perm := perlinGeneratePerm(NewRNG(1))
 	
 	if len(perm) != 256 {
 		t.Errorf("perlinGeneratePerm() returned %d values, want 256", len(perm))
@@ -120,7 +120,7 @@ func TestPerlinGeneratePerm(t *testing.T) {
 
 func TestPermute(t *testing.T) {
 	p := []int{0, 1, 2, 3, 4, 5}
-	permute(p)
+	permute(p, NewRNG(1))
 	
 	seen := make(map[int]bool)
 	for _, val := range p {
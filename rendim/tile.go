@@ -0,0 +1,150 @@
+package rendim
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// TileProtocol is the websocket subprotocol of viewers that take finished
+// rectangles of the image as binary tiles instead of pixels as JSON.
+const TileProtocol = "rendim.tiles.v1"
+
+// TileEncoding selects how the colours of a tile are stored.
+type TileEncoding uint8
+
+const (
+	// TileRaw stores 8-bit R, G, B per pixel, rows top to bottom.
+	TileRaw TileEncoding = iota
+	// TileDeflate stores the TileRaw bytes compressed with zlib.
+	TileDeflate
+	// TilePNG stores a PNG image of the tile.
+	TilePNG
+)
+
+// tileMessage is the first byte of a tile message, leaving room for other
+// kinds of message.
+const tileMessage = 'T'
+
+// tileHeaderSize is the size of the header of a tile message: the message
+// kind, the encoding and the x, y, width and height of the tile as
+// little-endian uint16s.
+const tileHeaderSize = 10
+
+// ParseTileEncoding returns the encoding with the given name: raw, deflate or
+// png.
+func ParseTileEncoding(name string) (TileEncoding, error) {
+	switch name {
+	case "raw":
+		return TileRaw, nil
+	case "deflate":
+		return TileDeflate, nil
+	case "png":
+		return TilePNG, nil
+	default:
+		return 0, fmt.Errorf("unknown tile encoding %q (want raw, deflate or png)", name)
+	}
+}
+
+// EncodeTile encodes the pixels of tile, which keeps its place in the image
+// in its bounds, as a binary message.
+func EncodeTile(tile *image.RGBA, encoding TileEncoding) ([]byte, error) {
+	r := tile.Bounds()
+	if r.Min.X < 0 || r.Min.Y < 0 || r.Max.X > 0xffff || r.Max.Y > 0xffff {
+		return nil, fmt.Errorf("tile %v is outside the range of a tile message", r)
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{tileMessage, byte(encoding)})
+	_ = binary.Write(&buf, binary.LittleEndian, [4]uint16{uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy())}) //nolint:gosec // G115: checked above
+
+	switch encoding {
+	case TileRaw:
+		buf.Write(rgbBytes(tile))
+	case TileDeflate:
+		zw := zlib.NewWriter(&buf)
+		if _, err := zw.Write(rgbBytes(tile)); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	case TilePNG:
+		// Unrendered pixels are sent black, as with the other encodings.
+		opaque := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(opaque, opaque.Bounds(), image.Black, image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), tile, r.Min, draw.Over)
+		if err := png.Encode(&buf, opaque); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown tile encoding %d", encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// rgbBytes returns the R, G and B bytes of every pixel of img.
+func rgbBytes(img *image.RGBA) []byte {
+	r := img.Bounds()
+	rgb := make([]byte, 0, 3*r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			rgb = append(rgb, row[i], row[i+1], row[i+2])
+		}
+	}
+	return rgb
+}
+
+// DecodeTile decodes a message made by EncodeTile.
+func DecodeTile(data []byte) (*image.RGBA, error) {
+	if len(data) < tileHeaderSize || data[0] != tileMessage {
+		return nil, errors.New("not a tile message")
+	}
+	var head [4]uint16
+	_ = binary.Read(bytes.NewReader(data[2:tileHeaderSize]), binary.LittleEndian, &head)
+	r := image.Rect(int(head[0]), int(head[1]), int(head[0])+int(head[2]), int(head[1])+int(head[3]))
+	payload := data[tileHeaderSize:]
+
+	var rgb []byte
+	switch TileEncoding(data[1]) {
+	case TileRaw:
+		rgb = payload
+	case TileDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if rgb, err = io.ReadAll(io.LimitReader(zr, int64(3*r.Dx()*r.Dy()+1))); err != nil {
+			return nil, err
+		}
+	case TilePNG:
+		img, err := png.Decode(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		if img.Bounds().Dx() != r.Dx() || img.Bounds().Dy() != r.Dy() {
+			return nil, fmt.Errorf("PNG of %v in a tile of %v", img.Bounds(), r)
+		}
+		tile := image.NewRGBA(r)
+		draw.Draw(tile, r, img, img.Bounds().Min, draw.Src)
+		return tile, nil
+	default:
+		return nil, fmt.Errorf("unknown tile encoding %d", data[1])
+	}
+
+	if len(rgb) != 3*r.Dx()*r.Dy() {
+		return nil, fmt.Errorf("%d bytes of colour in a tile of %v", len(rgb), r)
+	}
+	tile := image.NewRGBA(r)
+	for i := 0; i < r.Dx()*r.Dy(); i++ {
+		copy(tile.Pix[4*i:4*i+3], rgb[3*i:3*i+3])
+		tile.Pix[4*i+3] = 255
+	}
+	return tile, nil
+}
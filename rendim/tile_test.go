@@ -0,0 +1,87 @@
+package rendim
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func testTile() *image.RGBA {
+	tile := image.NewRGBA(image.Rect(40, 8, 45, 11))
+	for y := 8; y < 11; y++ {
+		for x := 40; x < 45; x++ {
+			tile.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x * y), A: 255})
+		}
+	}
+	// A pixel not yet rendered.
+	tile.SetRGBA(44, 10, color.RGBA{})
+	return tile
+}
+
+func TestTileRoundTrip(t *testing.T) {
+	tile := testTile()
+	for _, name := range []string{"raw", "deflate", "png"} {
+		encoding, err := ParseTileEncoding(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := EncodeTile(tile, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeTile(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Bounds() != tile.Bounds() {
+			t.Fatalf("%s: bounds = %v, want %v", name, got.Bounds(), tile.Bounds())
+		}
+		for y := 8; y < 11; y++ {
+			for x := 40; x < 45; x++ {
+				want := tile.RGBAAt(x, y)
+				want.A = 255
+				if c := got.RGBAAt(x, y); c != want {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, c, want)
+				}
+			}
+		}
+	}
+}
+
+func TestTileRawSize(t *testing.T) {
+	data, err := EncodeTile(testTile(), TileRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != tileHeaderSize+3*15 {
+		t.Errorf("raw tile of 15 pixels is %d bytes, want %d", len(data), tileHeaderSize+3*15)
+	}
+	want := []byte{'T', byte(TileRaw), 40, 0, 8, 0, 5, 0, 3, 0}
+	for i, b := range want {
+		if data[i] != b {
+			t.Fatalf("header = %v, want %v", data[:tileHeaderSize], want)
+		}
+	}
+}
+
+func TestDecodeTileErrors(t *testing.T) {
+	raw, err := EncodeTile(testTile(), TileRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := [][]byte{
+		nil,
+		[]byte("[{\"X\": 1}]"),
+		raw[:len(raw)-1],
+		append([]byte{'T', 9}, raw[2:]...),
+		append([]byte{'T', byte(TileDeflate)}, raw[2:]...),
+	}
+	for _, data := range bad {
+		if _, err := DecodeTile(data); err == nil {
+			t.Errorf("DecodeTile(%q) succeeded", data)
+		}
+	}
+	if _, err := ParseTileEncoding("jpeg"); err == nil {
+		t.Error("ParseTileEncoding(jpeg) succeeded")
+	}
+}
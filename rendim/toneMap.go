@@ -0,0 +1,112 @@
+package rendim
+
+import (
+	"image/color"
+	"math"
+)
+
+// Names of the tone mapping operators that can be selected in RenderSettings.
+const (
+	ToneMapLinear           = "linear"
+	ToneMapReinhard         = "reinhard"
+	ToneMapReinhardExtended = "reinhardExtended"
+	ToneMapACES             = "aces"
+	ToneMapHable            = "hable"
+)
+
+// DefaultWhitePoint is the luminance that extended Reinhard maps to white when
+// no white point is given.
+const DefaultWhitePoint = 4.0
+
+// ToneMap turns linear radiance into display colours: it scales by the
+// exposure, compresses the range with Operator and encodes the result with
+// the sRGB transfer function. An empty Operator means ToneMapLinear, which
+// only clamps.
+type ToneMap struct {
+	Operator string
+	// Exposure is in stops; each stop doubles the brightness.
+	Exposure float64
+	// WhitePoint is the smallest luminance mapped to pure white by extended
+	// Reinhard. Zero means DefaultWhitePoint.
+	WhitePoint float64
+}
+
+// ToneMapping returns the display transform selected by the settings.
+func (s RenderSettings) ToneMapping() ToneMap {
+	return ToneMap{Operator: s.ToneMap, Exposure: s.Exposure, WhitePoint: s.WhitePoint}
+}
+
+// Apply maps c to an 8-bit sRGB colour.
+func (tm ToneMap) Apply(c Color) color.RGBA {
+	mapped := tm.mapColor(c.MultiplyScalar(math.Exp2(tm.Exposure)))
+	return color.RGBA{R: encodeSRGB(mapped.R), G: encodeSRGB(mapped.G), B: encodeSRGB(mapped.B), A: 255}
+}
+
+// mapColor compresses exposed radiance into [0, 1], before clamping.
+func (tm ToneMap) mapColor(c Color) Color {
+	switch tm.Operator {
+	case ToneMapReinhard:
+		return scaleLuminance(c, func(l float64) float64 { return l / (1.0 + l) })
+	case ToneMapReinhardExtended:
+		white := tm.WhitePoint
+		if white <= 0.0 {
+			white = DefaultWhitePoint
+		}
+		return scaleLuminance(c, func(l float64) float64 { return l * (1.0 + l/(white*white)) / (1.0 + l) })
+	case ToneMapACES:
+		return Color{R: acesFilmic(c.R), G: acesFilmic(c.G), B: acesFilmic(c.B)}
+	case ToneMapHable:
+		const exposureBias, white = 2.0, 11.2
+		scale := 1.0 / hablePartial(white)
+		return Color{
+			R: hablePartial(c.R*exposureBias) * scale,
+			G: hablePartial(c.G*exposureBias) * scale,
+			B: hablePartial(c.B*exposureBias) * scale,
+		}
+	default:
+		return c
+	}
+}
+
+func luminance(c Color) float64 {
+	return 0.2126*c.R + 0.7152*c.G + 0.0722*c.B
+}
+
+// scaleLuminance maps the luminance of c with f and scales the colour to
+// match, which keeps its hue.
+func scaleLuminance(c Color, f func(float64) float64) Color {
+	l := luminance(c)
+	if l <= 0.0 {
+		return Color{}
+	}
+	return c.MultiplyScalar(f(l) / l)
+}
+
+// acesFilmic is Krzysztof Narkowicz's fit of the ACES filmic curve.
+func acesFilmic(x float64) float64 {
+	const a, b, c, d, e = 2.51, 0.03, 2.43, 0.59, 0.14
+	return x * (a*x + b) / (x*(c*x+d) + e)
+}
+
+// hablePartial is John Hable's filmic curve from Uncharted 2.
+func hablePartial(x float64) float64 {
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	return (x*(a*x+c*b)+d*e)/(x*(a*x+b)+d*f) - e/f
+}
+
+// encodeSRGB applies the sRGB transfer function to a linear value, clamped to
+// [0, 1], and quantises it to 8 bits.
+func encodeSRGB(v float64) uint8 {
+	switch {
+	case !(v > 0.0):
+		// Also catches NaN.
+		return 0
+	case v >= 1.0:
+		return 255
+	case v <= 0.0031308:
+		v *= 12.92
+	default:
+		v = 1.055*math.Pow(v, 1.0/2.4) - 0.055
+	}
+	return uint8(math.Round(255.0 * v))
+}
@@ -0,0 +1,106 @@
+package rendim
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestEncodeSRGB(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want uint8
+	}{
+		{-1.0, 0},
+		{math.NaN(), 0},
+		{0.0, 0},
+		{0.001, 3},      // linear segment: 0.001 * 12.92 * 255
+		{0.0031308, 10}, // where the two segments meet
+		{0.18, 118},     // middle grey
+		{0.5, 188},
+		{1.0, 255},
+		{8.0, 255},
+	}
+	for _, tt := range tests {
+		if got := encodeSRGB(tt.v); got != tt.want {
+			t.Errorf("encodeSRGB(%v) = %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestToneMapOperators(t *testing.T) {
+	grey := func(v float64) Color { return Color{R: v, G: v, B: v} }
+	tests := []struct {
+		name string
+		tm   ToneMap
+		in   float64
+		want float64
+	}{
+		{"Linear", ToneMap{Operator: ToneMapLinear}, 0.3, 0.3},
+		{"Linear exposure", ToneMap{Operator: ToneMapLinear, Exposure: 2.0}, 0.1, 0.4},
+		{"Reinhard", ToneMap{Operator: ToneMapReinhard}, 1.0, 0.5},
+		{"Reinhard exposure", ToneMap{Operator: ToneMapReinhard, Exposure: -1.0}, 2.0, 0.5},
+		{"Extended Reinhard white", ToneMap{Operator: ToneMapReinhardExtended, WhitePoint: 2.0}, 2.0, 1.0},
+		{"Extended Reinhard default white", ToneMap{Operator: ToneMapReinhardExtended}, DefaultWhitePoint, 1.0},
+		{"ACES black", ToneMap{Operator: ToneMapACES}, 0.0, 0.0},
+		{"ACES", ToneMap{Operator: ToneMapACES}, 1.0, 2.54 / 3.16},
+		{"Hable white", ToneMap{Operator: ToneMapHable}, 11.2 / 2.0, 1.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.tm.mapColor(grey(tt.in).MultiplyScalar(math.Exp2(tt.tm.Exposure)))
+			if !colorEqual(got, grey(tt.want)) {
+				t.Errorf("mapColor(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToneMapMonotonic(t *testing.T) {
+	for _, op := range []string{ToneMapLinear, ToneMapReinhard, ToneMapReinhardExtended, ToneMapACES, ToneMapHable} {
+		tm := ToneMap{Operator: op}
+		prev := -1.0
+		for v := 0.0; v <= 20.0; v += 0.01 {
+			got := tm.mapColor(Color{R: v, G: v, B: v}).R
+			if got < prev {
+				t.Errorf("%s: mapColor(%v) = %v, below the previous %v", op, v, got, prev)
+				break
+			}
+			prev = got
+		}
+	}
+}
+
+func TestToneMapKeepsHue(t *testing.T) {
+	tm := ToneMap{Operator: ToneMapReinhard}
+	got := tm.mapColor(Color{R: 4.0, G: 2.0, B: 1.0})
+	if math.Abs(got.R/got.G-2.0) > 1e-9 || math.Abs(got.G/got.B-2.0) > 1e-9 {
+		t.Errorf("mapColor() = %v, want the channel ratios kept", got)
+	}
+}
+
+func TestRenderSettingsToneMap(t *testing.T) {
+	settings := DefaultRenderSettings.Merge(RenderSettings{ToneMap: ToneMapACES, Exposure: 1.5, WhitePoint: 3.0})
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := settings.ToneMapping(); got != (ToneMap{Operator: ToneMapACES, Exposure: 1.5, WhitePoint: 3.0}) {
+		t.Errorf("ToneMapping() = %+v", got)
+	}
+
+	for _, bad := range []RenderSettings{
+		{ToneMap: "filmic"},
+		{Exposure: math.Inf(1)},
+		{WhitePoint: -1.0},
+	} {
+		if err := DefaultRenderSettings.Merge(bad).Validate(); err == nil {
+			t.Errorf("Validate() should reject %+v", bad)
+		}
+	}
+
+	_, err := parseSceneFile([]byte(`{"settings": {"whitePoint": -2}}`), "test.json")
+	var se *SceneError
+	if !errors.As(err, &se) || se.Path != "settings.whitePoint" {
+		t.Errorf("error = %v, want *SceneError at settings.whitePoint", err)
+	}
+}
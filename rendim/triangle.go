@@ -0,0 +1,141 @@
+package rendim
+
+import "math"
+
+// TexCoord is a 2D texture coordinate.
+type TexCoord struct {
+	U, V float64
+}
+
+type Triangle struct {
+	v0, v1, v2    Vec3d
+	n0, n1, n2    Vec3d
+	uv0, uv1, uv2 TexCoord
+	hasNormals    bool
+	hasUVs        bool
+	material      Material
+}
+
+// NewTriangle creates a flat-shaded triangle. The geometric normal follows the
+// counter-clockwise winding of v0, v1, v2.
+func NewTriangle(v0, v1, v2 Vec3d, material Material) Triangle {
+	return Triangle{v0: v0, v1: v1, v2: v2, material: material}
+}
+
+// NewSmoothTriangle creates a triangle with per-vertex shading normals and
+// texture coordinates that are interpolated across its surface.
+func NewSmoothTriangle(v0, v1, v2, n0, n1, n2 Vec3d, uv0, uv1, uv2 TexCoord, material Material) Triangle {
+	return Triangle{
+		v0: v0, v1: v1, v2: v2,
+		n0: n0, n1: n1, n2: n2,
+		uv0: uv0, uv1: uv1, uv2: uv2,
+		hasNormals: true,
+		hasUVs:     true,
+		material:   material,
+	}
+}
+
+func (tr Triangle) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	tc.countPrimitiveTests(1)
+	t, b1, b2, isHit := intersectTriangle(r, tr.v0, tr.v1, tr.v2, tMin, tMax)
+	if !isHit {
+		return false, HitRecord{}
+	}
+
+	rec := HitRecord{}
+	rec.t = t
+	rec.P = r.PointAt(t)
+	rec.material = tr.material
+	b0 := 1.0 - b1 - b2
+
+	if tr.hasUVs {
+		rec.u = b0*tr.uv0.U + b1*tr.uv1.U + b2*tr.uv2.U
+		rec.v = b0*tr.uv0.V + b1*tr.uv1.V + b2*tr.uv2.V
+	} else {
+		rec.u = b1
+		rec.v = b2
+	}
+
+	if tr.hasNormals {
+		rec.Normal = interpolateNormal(tr.n0, tr.n1, tr.n2, b0, b1, b2)
+	} else {
+		rec.Normal = triangleNormal(tr.v0, tr.v1, tr.v2)
+	}
+
+	return true, rec
+}
+
+func (tr Triangle) BoundingBox(t0, t1 float64, box *AABB) bool {
+	*box = triangleBox(tr.v0, tr.v1, tr.v2)
+	return true
+}
+
+// intersectTriangle implements the Möller-Trumbore ray/triangle test. It
+// returns the ray parameter and the barycentric weights of v1 and v2.
+func intersectTriangle(r Ray, v0, v1, v2 Vec3d, tMin, tMax float64) (t, b1, b2 float64, isHit bool) {
+	const epsilon = 1e-12
+
+	e1 := v1.Subtract(v0)
+	e2 := v2.Subtract(v0)
+	pVec := r.Direction().Cross(e2)
+	det := e1.Dot(pVec)
+	if math.Abs(det) < epsilon {
+		return 0, 0, 0, false
+	}
+	invDet := 1.0 / det
+
+	tVec := r.Origin().Subtract(v0)
+	b1 = tVec.Dot(pVec) * invDet
+	if b1 < 0.0 || b1 > 1.0 {
+		return 0, 0, 0, false
+	}
+
+	qVec := tVec.Cross(e1)
+	b2 = r.Direction().Dot(qVec) * invDet
+	if b2 < 0.0 || b1+b2 > 1.0 {
+		return 0, 0, 0, false
+	}
+
+	t = e2.Dot(qVec) * invDet
+	if t < tMin || t > tMax {
+		return 0, 0, 0, false
+	}
+
+	return t, b1, b2, true
+}
+
+func triangleNormal(v0, v1, v2 Vec3d) Vec3d {
+	return v1.Subtract(v0).Cross(v2.Subtract(v0)).UnitVector()
+}
+
+func interpolateNormal(n0, n1, n2 Vec3d, b0, b1, b2 float64) Vec3d {
+	return n0.MultiplyScalar(b0).
+		Add(n1.MultiplyScalar(b1)).
+		Add(n2.MultiplyScalar(b2)).
+		UnitVector()
+}
+
+// triangleBox returns the bounds of a triangle, padded so that triangles lying
+// in an axis-aligned plane still have a box with non-zero thickness.
+func triangleBox(v0, v1, v2 Vec3d) AABB {
+	const pad = 0.0001
+
+	small := NewVec3d(
+		ffMin(v0.X(), ffMin(v1.X(), v2.X())),
+		ffMin(v0.Y(), ffMin(v1.Y(), v2.Y())),
+		ffMin(v0.Z(), ffMin(v1.Z(), v2.Z())),
+	)
+	big := NewVec3d(
+		ffMax(v0.X(), ffMax(v1.X(), v2.X())),
+		ffMax(v0.Y(), ffMax(v1.Y(), v2.Y())),
+		ffMax(v0.Z(), ffMax(v1.Z(), v2.Z())),
+	)
+	for a := 0; a < 3; a++ {
+		if big.e[a]-small.e[a] < pad {
+			small.e[a] -= pad
+			big.e[a] += pad
+		}
+	}
+
+	return AABB{Min: small, Max: big}
+}
@@ -0,0 +1,273 @@
+package rendim
+
+import (
+	"fmt"
+)
+
+// MeshFace indexes one triangle of a TriangleMesh. V holds vertex indices, N
+// and UV hold normal and texture coordinate indices (or -1 when the vertex has
+// none) and Material indexes the mesh's material list.
+type MeshFace struct {
+	V        [3]int
+	N        [3]int
+	UV       [3]int
+	Material int
+}
+
+// TriangleMesh is an indexed triangle mesh whose faces share vertex, normal
+// and texture coordinate arrays. It carries its own BVH so that a mesh of any
+// size is a single entry in a HitableList.
+type TriangleMesh struct {
+	vertices  []Vec3d
+	normals   []Vec3d
+	uvs       []TexCoord
+	faces     []MeshFace
+	materials []Material
+	nodes     []meshNode
+}
+
+type meshNode struct {
+	box    AABB
+	offset int // first face for leaves, right child for interior nodes
+	count  int // number of faces for leaves, 0 for interior nodes
+	axis   int
+}
+
+const meshLeafSize = 4
+
+// NewTriangleMesh validates the face indices and builds the mesh BVH.
+func NewTriangleMesh(vertices, normals []Vec3d, uvs []TexCoord, faces []MeshFace, materials []Material) (TriangleMesh, error) {
+	if len(faces) == 0 {
+		return TriangleMesh{}, fmt.Errorf("triangle mesh has no faces")
+	}
+	if len(materials) == 0 {
+		return TriangleMesh{}, fmt.Errorf("triangle mesh has no materials")
+	}
+
+	for i, f := range faces {
+		for k := 0; k < 3; k++ {
+			if f.V[k] < 0 || f.V[k] >= len(vertices) {
+				return TriangleMesh{}, fmt.Errorf("face %d: vertex index %d out of range", i, f.V[k])
+			}
+			if f.N[k] < -1 || f.N[k] >= len(normals) {
+				return TriangleMesh{}, fmt.Errorf("face %d: normal index %d out of range", i, f.N[k])
+			}
+			if f.UV[k] < -1 || f.UV[k] >= len(uvs) {
+				return TriangleMesh{}, fmt.Errorf("face %d: texture coordinate index %d out of range", i, f.UV[k])
+			}
+		}
+		if f.Material < 0 || f.Material >= len(materials) {
+			return TriangleMesh{}, fmt.Errorf("face %d: material index %d out of range", i, f.Material)
+		}
+	}
+
+	m := TriangleMesh{
+		vertices:  vertices,
+		normals:   normals,
+		uvs:       uvs,
+		materials: materials,
+	}
+	m.build(faces)
+	return m, nil
+}
+
+// FaceCount returns the number of triangles in the mesh.
+func (m TriangleMesh) FaceCount() int {
+	return len(m.faces)
+}
+
+func (m TriangleMesh) faceBox(f MeshFace) AABB {
+	return triangleBox(m.vertices[f.V[0]], m.vertices[f.V[1]], m.vertices[f.V[2]])
+}
+
+// build sorts the faces into BVH leaf order, splitting each node at the
+// centroid median along its longest axis.
+func (m *TriangleMesh) build(faces []MeshFace) {
+	order := make([]int, len(faces))
+	boxes := make([]AABB, len(faces))
+	centroids := make([]Vec3d, len(faces))
+	for i, f := range faces {
+		order[i] = i
+		boxes[i] = m.faceBox(f)
+		centroids[i] = boxes[i].Min.Add(boxes[i].Max).MultiplyScalar(0.5)
+	}
+
+	m.nodes = make([]meshNode, 0, 2*len(faces)/meshLeafSize+1)
+	m.buildNode(order, 0, len(order), boxes, centroids)
+
+	m.faces = make([]MeshFace, len(faces))
+	for i, idx := range order {
+		m.faces[i] = faces[idx]
+	}
+}
+
+func (m *TriangleMesh) buildNode(order []int, start, end int, boxes []AABB, centroids []Vec3d) int {
+	box := boxes[order[start]]
+	centroidMin, centroidMax := centroids[order[start]], centroids[order[start]]
+	for _, idx := range order[start+1 : end] {
+		box = surroundingBox(box, boxes[idx])
+		centroidMin, centroidMax = minVec(centroidMin, centroids[idx]), maxVec(centroidMax, centroids[idx])
+	}
+
+	nodeIdx := len(m.nodes)
+	m.nodes = append(m.nodes, meshNode{box: box})
+
+	if end-start <= meshLeafSize {
+		m.nodes[nodeIdx].offset = start
+		m.nodes[nodeIdx].count = end - start
+		return nodeIdx
+	}
+
+	axis := longestAxis(centroidMax.Subtract(centroidMin))
+	mid := (start + end) / 2
+	selectNth(order[start:end], mid-start, centroids, axis)
+
+	m.buildNode(order, start, mid, boxes, centroids)
+	right := m.buildNode(order, mid, end, boxes, centroids)
+	m.nodes[nodeIdx].offset = right
+	m.nodes[nodeIdx].axis = axis
+	return nodeIdx
+}
+
+func (m TriangleMesh) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	if len(m.nodes) == 0 {
+		return false, HitRecord{}
+	}
+	var stack [64]int
+	sp := 0
+	stack[sp] = 0
+	sp++
+
+	hitAnything := false
+	closestSoFar := tMax
+	var (
+		closestFace int
+		closestB1   float64
+		closestB2   float64
+	)
+
+	for sp > 0 {
+		sp--
+		node := &m.nodes[stack[sp]]
+		tc.countNodeVisits(1)
+		if !node.box.hit(r, tMin, closestSoFar) {
+			continue
+		}
+
+		if node.count > 0 {
+			tc.countPrimitiveTests(node.count)
+			for i := node.offset; i < node.offset+node.count; i++ {
+				f := &m.faces[i]
+				t, b1, b2, isHit := intersectTriangle(r, m.vertices[f.V[0]], m.vertices[f.V[1]], m.vertices[f.V[2]], tMin, closestSoFar)
+				if isHit {
+					hitAnything = true
+					closestSoFar = t
+					closestFace, closestB1, closestB2 = i, b1, b2
+				}
+			}
+			continue
+		}
+
+		// Visit the child nearer to the ray origin first.
+		left := stack[sp] + 1
+		if r.Direction().e[node.axis] < 0.0 {
+			stack[sp] = left
+			stack[sp+1] = node.offset
+		} else {
+			stack[sp] = node.offset
+			stack[sp+1] = left
+		}
+		sp += 2
+	}
+
+	if !hitAnything {
+		return false, HitRecord{}
+	}
+
+	return true, m.record(r, closestSoFar, closestFace, closestB1, closestB2)
+}
+
+func (m TriangleMesh) record(r Ray, t float64, face int, b1, b2 float64) HitRecord {
+	f := m.faces[face]
+	b0 := 1.0 - b1 - b2
+
+	rec := HitRecord{}
+	rec.t = t
+	rec.P = r.PointAt(t)
+	rec.material = m.materials[f.Material]
+
+	if f.UV[0] >= 0 && f.UV[1] >= 0 && f.UV[2] >= 0 {
+		uv0, uv1, uv2 := m.uvs[f.UV[0]], m.uvs[f.UV[1]], m.uvs[f.UV[2]]
+		rec.u = b0*uv0.U + b1*uv1.U + b2*uv2.U
+		rec.v = b0*uv0.V + b1*uv1.V + b2*uv2.V
+	} else {
+		rec.u = b1
+		rec.v = b2
+	}
+
+	if f.N[0] >= 0 && f.N[1] >= 0 && f.N[2] >= 0 {
+		rec.Normal = interpolateNormal(m.normals[f.N[0]], m.normals[f.N[1]], m.normals[f.N[2]], b0, b1, b2)
+	} else {
+		rec.Normal = triangleNormal(m.vertices[f.V[0]], m.vertices[f.V[1]], m.vertices[f.V[2]])
+	}
+
+	return rec
+}
+
+func (m TriangleMesh) BoundingBox(t0, t1 float64, box *AABB) bool {
+	if len(m.nodes) == 0 {
+		return false
+	}
+	*box = m.nodes[0].box
+	return true
+}
+
+// selectNth partially sorts order so that the element at k has the centroid
+// that would be there in a full sort along axis, with smaller ones before it.
+func selectNth(order []int, k int, centroids []Vec3d, axis int) {
+	lo, hi := 0, len(order)-1
+	for lo < hi {
+		pivot := centroids[order[(lo+hi)/2]].e[axis]
+		i, j := lo, hi
+		for i <= j {
+			for centroids[order[i]].e[axis] < pivot {
+				i++
+			}
+			for centroids[order[j]].e[axis] > pivot {
+				j--
+			}
+			if i <= j {
+				order[i], order[j] = order[j], order[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+func longestAxis(extent Vec3d) int {
+	axis := 0
+	if extent.e[1] > extent.e[axis] {
+		axis = 1
+	}
+	if extent.e[2] > extent.e[axis] {
+		axis = 2
+	}
+	return axis
+}
+
+func minVec(a, b Vec3d) Vec3d {
+	return NewVec3d(ffMin(a.X(), b.X()), ffMin(a.Y(), b.Y()), ffMin(a.Z(), b.Z()))
+}
+
+func maxVec(a, b Vec3d) Vec3d {
+	return NewVec3d(ffMax(a.X(), b.X()), ffMax(a.Y(), b.Y()), ffMax(a.Z(), b.Z()))
+}
@@ -0,0 +1,147 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+func gridMesh(n int) (TriangleMesh, error) {
+	var (
+		vertices []Vec3d
+		uvs      []TexCoord
+		faces    []MeshFace
+	)
+	for j := 0; j <= n; j++ {
+		for i := 0; i <= n; i++ {
+			vertices = append(vertices, NewVec3d(float64(i), float64(j), 0.0))
+			uvs = append(uvs, TexCoord{U: float64(i) / float64(n), V: float64(j) / float64(n)})
+		}
+	}
+	noNormals := [3]int{-1, -1, -1}
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			a := j*(n+1) + i
+			b := a + 1
+			c := a + n + 1
+			d := c + 1
+			faces = append(faces,
+				MeshFace{V: [3]int{a, b, d}, N: noNormals, UV: [3]int{a, b, d}},
+				MeshFace{V: [3]int{a, d, c}, N: noNormals, UV: [3]int{a, d, c}})
+		}
+	}
+	return NewTriangleMesh(vertices, nil, uvs, faces, []Material{mockMaterial{}})
+}
+
+func TestNewTriangleMeshInvalidIndex(t *testing.T) {
+	vertices := []Vec3d{NewVec3d(0, 0, 0), NewVec3d(1, 0, 0), NewVec3d(0, 1, 0)}
+	tests := []struct {
+		name string
+		face MeshFace
+	}{
+		{"Vertex out of range", MeshFace{V: [3]int{0, 1, 3}, N: [3]int{-1, -1, -1}, UV: [3]int{-1, -1, -1}}},
+		{"Normal out of range", MeshFace{V: [3]int{0, 1, 2}, N: [3]int{0, 0, 0}, UV: [3]int{-1, -1, -1}}},
+		{"Negative normal", MeshFace{V: [3]int{0, 1, 2}, N: [3]int{-2, -1, -1}, UV: [3]int{-1, -1, -1}}},
+		{"Negative texture coordinate", MeshFace{V: [3]int{0, 1, 2}, N: [3]int{-1, -1, -1}, UV: [3]int{-1, -5, -1}}},
+		{"Material out of range", MeshFace{V: [3]int{0, 1, 2}, N: [3]int{-1, -1, -1}, UV: [3]int{-1, -1, -1}, Material: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTriangleMesh(vertices, nil, nil, []MeshFace{tt.face}, []Material{mockMaterial{}})
+			if err == nil {
+				t.Error("NewTriangleMesh() should fail")
+			}
+		})
+	}
+}
+
+func TestTriangleMeshHit(t *testing.T) {
+	mesh, err := gridMesh(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mesh.FaceCount() != 2*16*16 {
+		t.Errorf("FaceCount() = %d, want %d", mesh.FaceCount(), 2*16*16)
+	}
+
+	rng := NewRNG(0)
+	for i := 0; i < 200; i++ {
+		x := rng.Float64() * 16.0
+		y := rng.Float64() * 16.0
+		ray := NewRay(NewVec3d(x, y, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+		hit, rec := mesh.Hit(ray, 0.0, 100.0, nil)
+		if !hit {
+			t.Fatalf("Ray at (%f, %f) should hit mesh", x, y)
+		}
+		if math.Abs(rec.u-x/16.0) > 1e-9 || math.Abs(rec.v-y/16.0) > 1e-9 {
+			t.Fatalf("UV = (%f, %f), want (%f, %f)", rec.u, rec.v, x/16.0, y/16.0)
+		}
+		if rec.Normal.Z() != 1.0 {
+			t.Fatalf("Normal Z = %f, want 1.0", rec.Normal.Z())
+		}
+	}
+
+	ray := NewRay(NewVec3d(20.0, 20.0, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+	if hit, _ := mesh.Hit(ray, 0.0, 100.0, nil); hit {
+		t.Error("Ray outside mesh should miss")
+	}
+}
+
+func TestTriangleMeshHitClosest(t *testing.T) {
+	vertices := []Vec3d{
+		NewVec3d(0, 0, 0), NewVec3d(1, 0, 0), NewVec3d(0, 1, 0),
+		NewVec3d(0, 0, 2), NewVec3d(1, 0, 2), NewVec3d(0, 1, 2),
+	}
+	noAttr := [3]int{-1, -1, -1}
+	faces := []MeshFace{
+		{V: [3]int{0, 1, 2}, N: noAttr, UV: noAttr},
+		{V: [3]int{3, 4, 5}, N: noAttr, UV: noAttr},
+	}
+	mesh, err := NewTriangleMesh(vertices, nil, nil, faces, []Material{mockMaterial{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ray := NewRay(NewVec3d(0.2, 0.2, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+	hit, rec := mesh.Hit(ray, 0.0, 100.0, nil)
+	if !hit {
+		t.Fatal("Ray should hit mesh")
+	}
+	if math.Abs(rec.P.Z()-2.0) > 1e-10 {
+		t.Errorf("Hit point Z = %f, want 2.0 (closest triangle)", rec.P.Z())
+	}
+}
+
+func TestTriangleMeshEmpty(t *testing.T) {
+	var mesh TriangleMesh
+	if hit, _ := mesh.Hit(NewRay(NewVec3d(0.0, 0.0, -1.0), NewVec3d(0.0, 0.0, 1.0), 0.0), 0.0, 100.0, nil); hit {
+		t.Error("an empty mesh was hit")
+	}
+	if mesh.BoundingBox(0.0, 1.0, &AABB{}) {
+		t.Error("an empty mesh has a bounding box")
+	}
+}
+
+func TestTriangleMeshBoundingBox(t *testing.T) {
+	mesh, err := gridMesh(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var box AABB
+	if !mesh.BoundingBox(0.0, 1.0, &box) {
+		t.Fatal("Mesh should have bounding box")
+	}
+	if box.Min.X() > 0.0 || box.Max.X() < 8.0 || box.Min.Y() > 0.0 || box.Max.Y() < 8.0 {
+		t.Errorf("Bounding box = %v, should contain the grid", box)
+	}
+}
+
+func BenchmarkTriangleMeshBuild(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := gridMesh(256); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
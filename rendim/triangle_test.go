@@ -0,0 +1,99 @@
+package rendim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTriangleHit(t *testing.T) {
+	mat := mockMaterial{}
+	tri := NewTriangle(NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), NewVec3d(0.0, 1.0, 0.0), mat)
+
+	tests := []struct {
+		name      string
+		ray       Ray
+		shouldHit bool
+	}{
+		{"Ray hits inside", NewRay(NewVec3d(0.25, 0.25, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0), true},
+		{"Ray hits from behind", NewRay(NewVec3d(0.25, 0.25, -5.0), NewVec3d(0.0, 0.0, 1.0), 0.0), true},
+		{"Ray misses outside hypotenuse", NewRay(NewVec3d(0.8, 0.8, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0), false},
+		{"Ray parallel to plane", NewRay(NewVec3d(-1.0, 0.25, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hit, _ := tri.Hit(tt.ray, 0.0, 100.0, nil)
+			if hit != tt.shouldHit {
+				t.Errorf("Hit() = %v, want %v", hit, tt.shouldHit)
+			}
+		})
+	}
+}
+
+func TestTriangleHitRecord(t *testing.T) {
+	mat := mockMaterial{}
+	tri := NewTriangle(NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), NewVec3d(0.0, 1.0, 0.0), mat)
+
+	ray := NewRay(NewVec3d(0.25, 0.5, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+	hit, rec := tri.Hit(ray, 0.0, 100.0, nil)
+	if !hit {
+		t.Fatal("Ray should hit triangle")
+	}
+
+	if math.Abs(rec.t-5.0) > 1e-10 {
+		t.Errorf("t = %f, want 5.0", rec.t)
+	}
+	if rec.Normal.Z() != 1.0 {
+		t.Errorf("Normal = (%f, %f, %f), want (0, 0, 1)", rec.Normal.X(), rec.Normal.Y(), rec.Normal.Z())
+	}
+	if math.Abs(rec.u-0.25) > 1e-10 || math.Abs(rec.v-0.5) > 1e-10 {
+		t.Errorf("Barycentric (u, v) = (%f, %f), want (0.25, 0.5)", rec.u, rec.v)
+	}
+	if rec.material == nil {
+		t.Error("Hit record should have material set")
+	}
+}
+
+func TestSmoothTriangleInterpolation(t *testing.T) {
+	mat := mockMaterial{}
+	n0 := NewVec3d(0.0, 0.0, 1.0)
+	n1 := NewVec3d(1.0, 0.0, 0.0)
+	n2 := NewVec3d(0.0, 1.0, 0.0)
+	tri := NewSmoothTriangle(
+		NewVec3d(0.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), NewVec3d(0.0, 1.0, 0.0),
+		n0, n1, n2,
+		TexCoord{U: 0.0, V: 0.0}, TexCoord{U: 1.0, V: 0.0}, TexCoord{U: 0.0, V: 1.0},
+		mat)
+
+	ray := NewRay(NewVec3d(0.5, 0.0, 5.0), NewVec3d(0.0, 0.0, -1.0), 0.0)
+	hit, rec := tri.Hit(ray, 0.0, 100.0, nil)
+	if !hit {
+		t.Fatal("Ray should hit triangle edge")
+	}
+
+	want := NewVec3d(0.5, 0.0, 0.5).UnitVector()
+	if rec.Normal.Subtract(want).Length() > 1e-10 {
+		t.Errorf("Normal = (%f, %f, %f), want (%f, %f, %f)",
+			rec.Normal.X(), rec.Normal.Y(), rec.Normal.Z(), want.X(), want.Y(), want.Z())
+	}
+	if math.Abs(rec.u-0.5) > 1e-10 || math.Abs(rec.v) > 1e-10 {
+		t.Errorf("UV = (%f, %f), want (0.5, 0.0)", rec.u, rec.v)
+	}
+}
+
+func TestTriangleBoundingBox(t *testing.T) {
+	mat := mockMaterial{}
+	tri := NewTriangle(NewVec3d(0.0, 0.0, 2.0), NewVec3d(1.0, 0.0, 2.0), NewVec3d(0.0, 3.0, 2.0), mat)
+
+	var box AABB
+	if !tri.BoundingBox(0.0, 1.0, &box) {
+		t.Fatal("Triangle should have bounding box")
+	}
+
+	if box.Min.X() != 0.0 || box.Max.X() != 1.0 || box.Max.Y() != 3.0 {
+		t.Errorf("Bounding box = %v, want x in [0, 1] and y in [0, 3]", box)
+	}
+	if box.Max.Z()-box.Min.Z() <= 0.0 {
+		t.Error("Bounding box of axis-aligned triangle should have thickness")
+	}
+}
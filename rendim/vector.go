@@ -75,3 +75,29 @@ func (v Vec3d) Cross(v2 Vec3d) Vec3d {
 func (v Vec3d) UnitVector() Vec3d {
 	return v.DivideScalar(v.Length())
 }
+
+// onb is an orthonormal basis whose w axis is a given direction.
+type onb struct {
+	u, v, w Vec3d
+}
+
+func newONB(n Vec3d) onb {
+	w := n.UnitVector()
+	a := NewVec3d(1.0, 0.0, 0.0)
+	if math.Abs(w.X()) > 0.9 {
+		a = NewVec3d(0.0, 1.0, 0.0)
+	}
+	v := w.Cross(a).UnitVector()
+	u := w.Cross(v)
+	return onb{u: u, v: v, w: w}
+}
+
+// local converts a vector from basis coordinates to world coordinates.
+func (o onb) local(a, b, c float64) Vec3d {
+	return o.u.MultiplyScalar(a).Add(o.v.MultiplyScalar(b)).Add(o.w.MultiplyScalar(c))
+}
+
+// toLocal converts a vector from world coordinates to basis coordinates.
+func (o onb) toLocal(d Vec3d) Vec3d {
+	return NewVec3d(d.Dot(o.u), d.Dot(o.v), d.Dot(o.w))
+}
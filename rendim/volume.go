@@ -1,22 +1,41 @@
 package rendim
 
 import (
+	"cmp"
 	"math"
+	"slices"
 )
 
 type ConstantMedium struct {
 	boundary      Hitable
 	density       float64
 	phaseFunction Material
-	rng           *RNG
 }
 
-func (cm ConstantMedium) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord) {
-	if isHit1, rec1 := cm.boundary.Hit(r, -math.MaxFloat64, math.MaxFloat64); isHit1 {
-		if isHit2, rec2 := cm.boundary.Hit(r, rec1.t+0.0001, math.MaxFloat64); isHit2 {
+// NewConstantMedium fills boundary with a uniform fog of the given density
+// that scatters isotropically with the given albedo. The scattering distance
+// is drawn from the RNG of the TraceContext passed to Hit; for rays traced
+// through a Scene it is drawn after the world has been searched, see
+// Scene.hit.
+func NewConstantMedium(boundary Hitable, density float64, albedo Texture) ConstantMedium {
+	return ConstantMedium{boundary: boundary, density: density, phaseFunction: Isotropic{albedo: albedo}}
+}
+
+func (cm ConstantMedium) Hit(r Ray, tMin float64, tMax float64, tc *TraceContext) (bool, HitRecord) {
+	if tc == nil || tc.rng == nil {
+		return false, HitRecord{}
+	}
+	if isHit1, rec1 := cm.boundary.Hit(r, -math.MaxFloat64, math.MaxFloat64, tc); isHit1 {
+		if isHit2, rec2 := cm.boundary.Hit(r, rec1.t+0.0001, math.MaxFloat64, tc); isHit2 {
 			if rec1.t < tMin {
 				rec1.t = tMin
 			}
+			if tc.collectMedia {
+				if t0 := math.Max(rec1.t, 0.0); t0 < rec2.t {
+					tc.constantMedia = append(tc.constantMedia, constantSegment{medium: cm, t0: t0, t1: rec2.t})
+				}
+				return false, HitRecord{}
+			}
 			if rec2.t > tMax {
 				rec2.t = tMax
 			}
@@ -29,7 +48,7 @@ func (cm ConstantMedium) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord
 
 			rec := HitRecord{}
 			distanceInsideBoundary := (rec2.t - rec1.t) * r.Direction().Length()
-			hitDistance := -(1.0 / cm.density) * math.Log(cm.rng.Float64())
+			hitDistance := -(1.0 / cm.density) * math.Log(tc.rng.Float64())
 			if hitDistance < distanceInsideBoundary {
 				rec.t = rec1.t + hitDistance/r.Direction().Length()
 				rec.P = r.PointAt(rec.t)
@@ -45,3 +64,35 @@ func (cm ConstantMedium) Hit(r Ray, tMin float64, tMax float64) (bool, HitRecord
 func (cm ConstantMedium) BoundingBox(t0, t1 float64, box *AABB) bool {
 	return cm.boundary.BoundingBox(t0, t1, box)
 }
+
+// constantSegment is the part of a traced ray from t0 to t1 that lies in a
+// ConstantMedium, before it is cut off at the closest surface.
+type constantSegment struct {
+	medium ConstantMedium
+	t0, t1 float64
+}
+
+// scatterConstantMedia returns where r, which first hits a surface at tEnd,
+// scatters in the constant media it crosses, if it does. The media are
+// sampled in order of distance, and only those that start before tEnd, so
+// the random numbers drawn do not depend on how the world was searched.
+func scatterConstantMedia(r Ray, segments []constantSegment, tEnd float64, rng *RNG) (bool, HitRecord) {
+	slices.SortFunc(segments, func(a, b constantSegment) int {
+		return cmp.Or(cmp.Compare(a.t0, b.t0), cmp.Compare(a.t1, b.t1), cmp.Compare(a.medium.density, b.medium.density))
+	})
+	speed := r.Direction().Length()
+	isHit, rec := false, HitRecord{}
+	for _, s := range segments {
+		if s.t0 >= tEnd {
+			break
+		}
+		distanceInsideBoundary := (math.Min(s.t1, tEnd) - s.t0) * speed
+		hitDistance := -(1.0 / s.medium.density) * math.Log(rng.Float64())
+		if hitDistance < distanceInsideBoundary {
+			tEnd = s.t0 + hitDistance/speed
+			isHit = true
+			rec = HitRecord{t: tEnd, P: r.PointAt(tEnd), Normal: NewVec3d(1.0, 0.0, 0.0), material: s.medium.phaseFunction}
+		}
+	}
+	return isHit, rec
+}
@@ -13,14 +13,14 @@ func TestConstantMediumHit(t *testing.T) {
 		boundary:      boundary,
 		density:       0.01,
 		phaseFunction: phaseFunction,
-		rng:           NewRNG(0),
 	}
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+	tc := &TraceContext{rng: NewRNG(0)}
 	
 	hitCount := 0
 	for i := 0; i < 100; i++ {
-		hit, _ := cm.Hit(ray, 0.0, 10.0)
+		hit, _ := cm.Hit(ray, 0.0, 10.0, tc)
 		if hit {
 			hitCount++
 		}
@@ -44,11 +44,10 @@ func TestConstantMediumNoHit(t *testing.T) {
 		boundary:      boundary,
 		density:       0.01,
 		phaseFunction: phaseFunction,
-		rng:           NewRNG(0),
 	}
 	
 	ray := NewRay(NewVec3d(5.0, 5.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
-	hit, _ := cm.Hit(ray, 0.0, 10.0)
+	hit, _ := cm.Hit(ray, 0.0, 10.0, &TraceContext{rng: NewRNG(0)})
 	
 	if hit {
 		t.Error("ConstantMedium should not scatter rays that miss boundary")
@@ -90,15 +89,30 @@ func TestConstantMediumHitWithinBounds(t *testing.T) {
 		boundary:      boundary,
 		density:       10.0,
 		phaseFunction: phaseFunction,
-		rng:           NewRNG(0),
 	}
 	
 	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+	tc := &TraceContext{rng: NewRNG(0)}
 	
 	for i := 0; i < 10; i++ {
-		hit, rec := cm.Hit(ray, 0.0, 10.0)
+		hit, rec := cm.Hit(ray, 0.0, 10.0, tc)
 		if hit && rec.material == nil {
 			t.Error("Hit record should have material set")
 		}
 	}
 }
+
+func TestConstantMediumNeedsRNG(t *testing.T) {
+	boundary := NewSphere(NewVec3d(0.0, 0.0, 0.0), 2.0, mockMaterial{})
+	cm := NewConstantMedium(boundary, 100.0, ConstantTexture{color: Color{R: 1.0, G: 1.0, B: 1.0}})
+	ray := NewRay(NewVec3d(-5.0, 0.0, 0.0), NewVec3d(1.0, 0.0, 0.0), 0.0)
+
+	for _, tc := range []*TraceContext{nil, {}} {
+		if hit, _ := cm.Hit(ray, 0.0, 10.0, tc); hit {
+			t.Error("ConstantMedium should let rays through without an RNG to sample with")
+		}
+	}
+	if hit, _ := cm.Hit(ray, 0.0, 10.0, &TraceContext{rng: NewRNG(0)}); !hit {
+		t.Error("A dense ConstantMedium should scatter a ray through its centre")
+	}
+}
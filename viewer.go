@@ -0,0 +1,269 @@
+package main
+
+import (
+	"RendIm/rendim"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// runningJobs is the number of jobs the server renders at once.
+	runningJobs = 1
+	// waitingJobs is the number of jobs the server queues behind them.
+	waitingJobs = 8
+)
+
+// submitViewerJob queues the render a viewer asked for with the query of its
+// websocket URL.
+func submitViewerJob(jobs *rendim.JobQueue, query url.Values) (*rendim.Job, error) {
+	sceneType := query.Get("scene")
+	if sceneType == "" {
+		sceneType = "final"
+	}
+
+	requested, err := viewerSettings(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// The viewer canvas has a fixed size.
+	requested.Width = width
+	requested.Height = height
+
+	path, err := rendim.BuiltinScenePath(sceneType)
+	if err != nil {
+		return nil, err
+	}
+
+	// A named checkpoint is saved every minute and when the client goes
+	// away; resume carries on from one.
+	var opts rendim.RenderOptions
+	if name := query.Get("resume"); name != "" {
+		resume, err := loadNamedCheckpoint(name)
+		if err != nil {
+			return nil, err
+		}
+		opts.Resume = resume
+		path = resume.Scene
+		requested = resumeSettings(resume, requested)
+	}
+	if name := query.Get("checkpoint"); name != "" {
+		checkpointFile, err := checkpointPath(name)
+		if err != nil {
+			return nil, err
+		}
+		opts.CheckpointInterval = time.Minute
+		opts.Checkpoint = func(c *rendim.Checkpoint) {
+			c.Scene = path
+			if err := rendim.SaveCheckpoint(checkpointFile, c); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+
+	sf, settings, err := loadSceneFile(path, requested)
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.ValidateJob(); err != nil {
+		return nil, err
+	}
+	job, err := jobs.Submit(sceneType, sf, settings, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Client initiated job %s (scene: %s, samples: %d, bucketSize: %d, workers: %d, progressive: %t)...\n",
+		job.ID, sceneType, settings.Samples, settings.BucketSize, settings.Workers, settings.Progressive)
+	return job, nil
+}
+
+// viewerSettings returns the render settings a viewer asked for with the
+// query of its websocket URL. Parameters given apply even when they are zero.
+func viewerSettings(query url.Values) (rendim.RenderSettings, error) {
+	var requested rendim.RenderSettings
+	var given []rendim.Setting
+	ints := []struct {
+		name    string
+		setting rendim.Setting
+		value   *int
+	}{
+		{"samples", rendim.SettingSamples, &requested.Samples},
+		{"minSamples", rendim.SettingMinSamples, &requested.MinSamples},
+		{"bucketSize", rendim.SettingBucketSize, &requested.BucketSize},
+		{"workers", rendim.SettingWorkers, &requested.Workers},
+	}
+	for _, p := range ints {
+		if v := query.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return rendim.RenderSettings{}, fmt.Errorf("%s must be an integer, got %q", p.name, v)
+			}
+			*p.value = n
+			given = append(given, p.setting)
+		}
+	}
+	floats := []struct {
+		name    string
+		setting rendim.Setting
+		value   *float64
+	}{
+		{"adaptiveThreshold", rendim.SettingAdaptiveThreshold, &requested.AdaptiveThreshold},
+		{"exposure", rendim.SettingExposure, &requested.Exposure},
+		{"whitePoint", rendim.SettingWhitePoint, &requested.WhitePoint},
+	}
+	for _, p := range floats {
+		if v := query.Get(p.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return rendim.RenderSettings{}, fmt.Errorf("%s must be a number, got %q", p.name, v)
+			}
+			*p.value = f
+			given = append(given, p.setting)
+		}
+	}
+	strs := []struct {
+		name    string
+		setting rendim.Setting
+		value   *string
+	}{
+		{"bvh", rendim.SettingBVH, &requested.BVH},
+		{"integrator", rendim.SettingIntegrator, &requested.Integrator},
+		{"sampler", rendim.SettingSampler, &requested.Sampler},
+		{"toneMap", rendim.SettingToneMap, &requested.ToneMap},
+	}
+	for _, p := range strs {
+		if v := query.Get(p.name); v != "" {
+			*p.value = v
+			given = append(given, p.setting)
+		}
+	}
+	if v := query.Get("progressive"); v != "" {
+		progressive, err := strconv.ParseBool(v)
+		if err != nil {
+			return rendim.RenderSettings{}, fmt.Errorf("progressive must be true or false, got %q", v)
+		}
+		requested.Progressive = progressive
+		given = append(given, rendim.SettingProgressive)
+	}
+	return requested.WithSet(given...), nil
+}
+
+// updateSender sends what watcher has seen rendered since the last call.
+type updateSender func(conn *websocket.Conn, watcher *rendim.JobWatcher) error
+
+// sendPixels sends the new pixels as JSON batches of rendim.Pixel.
+func sendPixels(conn *websocket.Conn, watcher *rendim.JobWatcher) error {
+	pixels := watcher.Pixels()
+	for len(pixels) > 0 {
+		n := min(len(pixels), 1000)
+		data, err := json.Marshal(pixels[:n])
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+		pixels = pixels[n:]
+	}
+	return nil
+}
+
+// sendTiles returns an updateSender that sends the new rectangles of every
+// bucket as binary tile messages with the given encoding.
+func sendTiles(encoding rendim.TileEncoding) updateSender {
+	return func(conn *websocket.Conn, watcher *rendim.JobWatcher) error {
+		for _, tile := range watcher.Tiles() {
+			data, err := rendim.EncodeTile(tile, encoding)
+			if err != nil {
+				return err
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// streamJob sends the progress of job to a viewer with send until the job
+// ends or the viewer goes away, cancelling the job then if cancel is set. The
+// first message names the job as JSON text: {"job": id}.
+func streamJob(conn *websocket.Conn, job *rendim.Job, cancel bool, send updateSender) {
+	// Reading is needed for the websocket library to notice a closed
+	// connection.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				stop()
+				return
+			}
+		}
+	}()
+
+	watcher := job.Watch()
+	defer watcher.Close()
+
+	if err := conn.WriteJSON(map[string]string{"job": job.ID}); err != nil {
+		fmt.Println(err)
+		_ = conn.Close()
+		return
+	}
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := send(conn, watcher); err != nil {
+				fmt.Println(err)
+				_ = conn.Close()
+				return
+			}
+		case <-job.Done():
+			if err := send(conn, watcher); err != nil {
+				fmt.Println(err)
+			}
+			status := job.Status()
+			if cancel {
+				if status.Error != "" {
+					fmt.Println("Render stopped:", status.Error)
+				}
+				printStats(job.Stats())
+			}
+			fmt.Printf("Job %s %s, closing connection.\n", job.ID, status.State)
+			time.Sleep(100 * time.Millisecond)
+			if err := conn.Close(); err != nil {
+				fmt.Println(err)
+			}
+			return
+		case <-ctx.Done():
+			if cancel {
+				job.Cancel()
+			}
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// closeWebsocket tells a viewer why its render did not start and closes the
+// connection.
+func closeWebsocket(conn *websocket.Conn, code int, reason string) {
+	fmt.Println(reason)
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		fmt.Println(err)
+	}
+	if err := conn.Close(); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"RendIm/rendim"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runWorker implements the worker command and returns the process exit code.
+func runWorker(args []string) int {
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	listen := fs.String("listen", ":4001", "`address` to accept buckets from \"rendim render -remote\" on")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of render workers")
+	dir := fs.String("dir", "", "`directory` to unpack scenes into (default a temporary one)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "rendim-worker-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           rendim.NewWorkerServer(*dir, *workers),
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	fmt.Printf("RendIm worker listening on %s with %d workers.\n", *listen, *workers)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}